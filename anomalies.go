@@ -0,0 +1,87 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Anomalies returns a human-readable description of every way in which the
+// certificate deviates from the standards, despite being parseable enough
+// to extract identifiers.  Returns an empty slice if no anomalies are found.
+func (cert *CertInfo) Anomalies() []string {
+	anomalies := []string{}
+
+	if cert.SerialNumberParseError != nil {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable serial number: %s", cert.SerialNumberParseError))
+	} else if cert.SerialNumber.Sign() < 0 {
+		anomalies = append(anomalies, "negative serial number")
+	} else if cert.SerialNumber.Sign() == 0 {
+		anomalies = append(anomalies, "zero serial number")
+	}
+
+	if cert.ValidityParseError != nil {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable validity: %s", cert.ValidityParseError))
+	} else if cert.Validity.NotAfter.Before(cert.Validity.NotBefore) {
+		anomalies = append(anomalies, fmt.Sprintf("not after (%s) is before not before (%s)", cert.Validity.NotAfter, cert.Validity.NotBefore))
+	}
+
+	if cert.SubjectParseError == nil {
+		anomalies = append(anomalies, rdnAnomalies("subject", cert.Subject)...)
+	} else {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable subject: %s", cert.SubjectParseError))
+	}
+	if cert.IssuerParseError == nil {
+		anomalies = append(anomalies, rdnAnomalies("issuer", cert.Issuer)...)
+	} else {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable issuer: %s", cert.IssuerParseError))
+	}
+
+	if cert.SANsParseError == nil {
+		for _, san := range cert.SANs {
+			switch san.Type {
+			case sanDNSName:
+				if len(san.Value) == 0 {
+					anomalies = append(anomalies, "empty DNS name SAN")
+				} else if !isASCIIString(san.Value) || !isSaneDNSLabel(string(san.Value)) {
+					anomalies = append(anomalies, fmt.Sprintf("DNS name SAN contains invalid characters: %q", san.Value))
+				}
+			case sanIPAddress:
+				if len(san.Value) != 4 && len(san.Value) != 16 {
+					anomalies = append(anomalies, fmt.Sprintf("IP address SAN has invalid length %d", len(san.Value)))
+				}
+			}
+		}
+	} else {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable SANs: %s", cert.SANsParseError))
+	}
+
+	if cert.IsCAParseError != nil {
+		anomalies = append(anomalies, fmt.Sprintf("unparsable basic constraints: %s", cert.IsCAParseError))
+	}
+
+	return anomalies
+}
+
+func rdnAnomalies(name string, rdns RDNSequence) []string {
+	var anomalies []string
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if atv.Value.Tag == asn1.TagUTF8String && !utf8.Valid(atv.Value.Bytes) {
+				anomalies = append(anomalies, fmt.Sprintf("%s %s attribute is not valid UTF-8", name, rdnLabel(atv.Type)))
+			} else if _, err := decodeASN1String(&atv.Value); err != nil {
+				anomalies = append(anomalies, fmt.Sprintf("%s %s attribute cannot be decoded: %s", name, rdnLabel(atv.Type), err))
+			}
+		}
+	}
+	return anomalies
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAnomalies(t *testing.T) {
+	notBefore := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	valid := func() *CertInfo {
+		return &CertInfo{
+			SerialNumber: big.NewInt(1),
+			Validity:     &CertValidity{NotBefore: notBefore, NotAfter: notAfter},
+			SANs:         []SubjectAltName{{Type: sanDNSName, Value: []byte("www.example.com")}, {Type: sanIPAddress, Value: []byte{192, 0, 2, 1}}},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*CertInfo)
+		want   []string
+	}{
+		{"valid", func(*CertInfo) {}, []string{}},
+		{"negative serial", func(info *CertInfo) { info.SerialNumber = big.NewInt(-1) }, []string{"negative serial number"}},
+		{"zero serial", func(info *CertInfo) { info.SerialNumber = big.NewInt(0) }, []string{"zero serial number"}},
+		{"unparsable serial", func(info *CertInfo) { info.SerialNumberParseError = errors.New("bad") }, []string{"unparsable serial number: bad"}},
+		{"unparsable validity", func(info *CertInfo) { info.ValidityParseError = errors.New("bad") }, []string{"unparsable validity: bad"}},
+		{"unparsable subject", func(info *CertInfo) { info.SubjectParseError = errors.New("bad") }, []string{"unparsable subject: bad"}},
+		{"unparsable issuer", func(info *CertInfo) { info.IssuerParseError = errors.New("bad") }, []string{"unparsable issuer: bad"}},
+		{"unparsable SANs", func(info *CertInfo) { info.SANsParseError = errors.New("bad") }, []string{"unparsable SANs: bad"}},
+		{"unparsable basic constraints", func(info *CertInfo) { info.IsCAParseError = errors.New("bad") }, []string{"unparsable basic constraints: bad"}},
+		{"empty DNS name", func(info *CertInfo) { info.SANs[0].Value = nil }, []string{"empty DNS name SAN"}},
+		{"bad IP address", func(info *CertInfo) { info.SANs[1].Value = []byte{1, 2, 3} }, []string{"IP address SAN has invalid length 3"}},
+		{
+			"invalid UTF-8 subject",
+			func(info *CertInfo) {
+				info.Subject = RDNSequence{{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte{0xff}}}}}
+			},
+			[]string{"subject CN attribute is not valid UTF-8"},
+		},
+		{
+			"all parse errors",
+			func(info *CertInfo) {
+				info.SubjectParseError = errors.New("subject")
+				info.IssuerParseError = errors.New("issuer")
+				info.SANsParseError = errors.New("sans")
+				info.IsCAParseError = errors.New("isca")
+			},
+			[]string{"unparsable subject: subject", "unparsable issuer: issuer", "unparsable SANs: sans", "unparsable basic constraints: isca"},
+		},
+	}
+	for _, test := range tests {
+		info := valid()
+		test.modify(info)
+		if got := info.Anomalies(); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
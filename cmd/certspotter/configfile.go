@@ -0,0 +1,168 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A configSetting is one line of a config file, which sets the flag named key
+// to each of values (more than one value is allowed only for flags which can
+// be repeated).
+type configSetting struct {
+	key    string
+	values []string
+	lineNo int
+}
+
+// readConfigFile reads a config file, which uses a subset of TOML: each
+// non-blank, non-comment line has the form KEY = VALUE, where KEY is the name
+// of a flag (without the leading dash), and VALUE is a quoted string, a bare
+// number or boolean, or an array of quoted strings (for repeatable flags).
+// Tables and multi-line values are not supported.
+func readConfigFile(filename string) ([]configSetting, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, simplifyError(err)
+	}
+	defer file.Close()
+
+	var settings []configSetting
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: must be of the form KEY = VALUE", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		values, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		settings = append(settings, configSetting{key: key, values: values, lineNo: lineNo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// parseConfigValue parses a value, followed by an optional comment.
+func parseConfigValue(str string) ([]string, error) {
+	if rest, isArray := strings.CutPrefix(str, "["); isArray {
+		var values []string
+		for {
+			rest = strings.TrimSpace(rest)
+			if after, found := strings.CutPrefix(rest, "]"); found {
+				return values, checkConfigComment(after)
+			}
+			value, after, err := parseConfigString(rest)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			after = strings.TrimSpace(after)
+			if next, found := strings.CutPrefix(after, ","); found {
+				rest = next
+			} else if strings.HasPrefix(after, "]") {
+				rest = after
+			} else {
+				return nil, errors.New("array elements must be separated by commas")
+			}
+		}
+	} else if strings.HasPrefix(str, `"`) || strings.HasPrefix(str, "'") {
+		value, after, err := parseConfigString(str)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, checkConfigComment(after)
+	} else {
+		value, _, _ := strings.Cut(str, "#")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, errors.New("missing value")
+		}
+		return []string{value}, nil
+	}
+}
+
+// parseConfigString parses the quoted string at the beginning of str, and
+// returns it along with the rest of str.  Double-quoted strings may contain
+// backslash escapes; single-quoted strings are literal.
+func parseConfigString(str string) (string, string, error) {
+	if rest, found := strings.CutPrefix(str, "'"); found {
+		value, after, found := strings.Cut(rest, "'")
+		if !found {
+			return "", "", errors.New("unterminated string")
+		}
+		return value, after, nil
+	}
+	if !strings.HasPrefix(str, `"`) {
+		return "", "", errors.New("strings must be quoted")
+	}
+	for i := 1; i < len(str); i++ {
+		if str[i] == '\\' {
+			i++
+		} else if str[i] == '"' {
+			value, err := strconv.Unquote(str[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", str[:i+1])
+			}
+			return value, str[i+1:], nil
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+func checkConfigComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after value", rest)
+	}
+	return nil
+}
+
+// applyConfigFile sets each flag in the config file which was not specified
+// on the command line, so that the command line takes precedence over the
+// config file, which takes precedence over environment variables and defaults.
+func applyConfigFile(flagSet *flag.FlagSet, filename string) error {
+	settings, err := readConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	onCommandLine := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+	for _, setting := range settings {
+		if setting.key == "config" || flagSet.Lookup(setting.key) == nil {
+			return fmt.Errorf("line %d: unknown setting %q", setting.lineNo, setting.key)
+		}
+		if onCommandLine[setting.key] {
+			continue
+		}
+		for _, value := range setting.values {
+			if err := flagSet.Set(setting.key, value); err != nil {
+				return fmt.Errorf("line %d: invalid value for %s: %w", setting.lineNo, setting.key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -11,22 +11,36 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"software.sslmate.com/src/certspotter/ct/client"
 	"software.sslmate.com/src/certspotter/loglist"
 	"software.sslmate.com/src/certspotter/monitor"
 )
@@ -36,6 +50,33 @@ var Version = ""
 
 const defaultLogList = "https://loglist.certspotter.org/monitor.json"
 
+// Exit statuses; documented in the EXIT STATUS section of certspotter(8)
+const (
+	exitOK           = 0
+	exitError        = 1 // any error not covered below
+	exitUsage        = 2 // invalid command line flags
+	exitWatchList    = 3 // watch list missing or invalid
+	exitLogList      = 4 // log list could not be loaded at startup
+	exitNotification = 5 // a notification could not be sent
+	exitState        = 6 // state directory could not be prepared
+	exitIncomplete   = 7 // with -once, a log could not be monitored up to its latest STH (never used as a daemon)
+)
+
+func exitStatus(err error) int {
+	switch {
+	case errors.Is(err, monitor.ErrNotification):
+		return exitNotification
+	case errors.Is(err, monitor.ErrLogList):
+		return exitLogList
+	case errors.Is(err, monitor.ErrState):
+		return exitState
+	case errors.Is(err, monitor.ErrIncomplete):
+		return exitIncomplete
+	default:
+		return exitError
+	}
+}
+
 func certspotterVersion() string {
 	if Version != "" {
 		return Version + "?"
@@ -117,7 +158,7 @@ func simplifyError(err error) error {
 	return err
 }
 
-func readWatchListFile(filename string) (monitor.WatchList, error) {
+func readWatchListFile(filename string) (*monitor.WatchList, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, simplifyError(err)
@@ -126,6 +167,175 @@ func readWatchListFile(filename string) (monitor.WatchList, error) {
 	return monitor.ReadWatchList(file)
 }
 
+func isWatchListURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+const maxWatchListSize = 64 * 1024 * 1024
+
+// How long to wait for a watch list to be downloaded; tests change it
+var watchListFetchTimeout = 1 * time.Minute
+
+// fetchWatchList downloads the watch list from url and, if it's valid, saves
+// a copy of it to cacheFile.  A watch list without any items is not valid,
+// since it's more likely to come from a misbehaving server than to be
+// intended.
+func fetchWatchList(ctx context.Context, url string, cacheFile string) (*monitor.WatchList, error) {
+	ctx, cancel := context.WithTimeout(ctx, watchListFetchTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", loglist.UserAgent)
+	response, err := (&http.Client{Transport: loglist.Transport, Timeout: watchListFetchTimeout}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxWatchListSize+1))
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", url, response.Status)
+	}
+	if len(content) > maxWatchListSize {
+		return nil, fmt.Errorf("%s: watchlist is larger than %d bytes", url, maxWatchListSize)
+	}
+	watchlist, err := monitor.ReadWatchList(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if len(watchlist.Items()) == 0 {
+		return nil, fmt.Errorf("%s: watchlist is empty", url)
+	}
+	if err := writeFileAtomically(cacheFile, content); err != nil {
+		zap.S().Warnf("error caching watchlist from %q: %s", url, err)
+	}
+	return watchlist, nil
+}
+
+func writeFileAtomically(filename string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+	tempname := filename + ".new"
+	if err := os.WriteFile(tempname, content, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tempname, filename)
+}
+
+// readWatchList reads the watch list from source, which is either a
+// filename or an HTTP(S) URL.  A watch list fetched from a URL is cached
+// in cacheFile.
+func readWatchList(ctx context.Context, source string, cacheFile string) (*monitor.WatchList, error) {
+	if isWatchListURL(source) {
+		return fetchWatchList(ctx, source, cacheFile)
+	}
+	return readWatchListFile(source)
+}
+
+// watchListCacheFile returns the file in which the watch list downloaded from
+// source is cached.  For backwards compatibility, a lone URL is cached in
+// watchlist_cache; otherwise, each URL has its own cache file.
+func watchListCacheFile(stateDir string, sources []string, source string) string {
+	if len(sources) == 1 {
+		return filepath.Join(stateDir, "watchlist_cache")
+	}
+	hash := sha256.Sum256([]byte(source))
+	return filepath.Join(stateDir, "watchlist_cache_"+hex.EncodeToString(hash[:8]))
+}
+
+// readWatchLists reads the watch lists from sources and merges them.  The
+// source "-" refers to standard in, which must already have been read into
+// stdinList.  If useCache is true, the cached copy of a watch list which
+// can't be downloaded is used instead.
+func readWatchLists(ctx context.Context, sources []string, stateDir string, stdinList *monitor.WatchList, useCache bool) (*monitor.WatchList, error) {
+	lists := make([]*monitor.WatchList, 0, len(sources))
+	for _, source := range sources {
+		if source == "-" {
+			lists = append(lists, stdinList)
+			continue
+		}
+		cacheFile := watchListCacheFile(stateDir, sources, source)
+		watchlist, err := readWatchList(ctx, source, cacheFile)
+		if err != nil && useCache && isWatchListURL(source) {
+			if cached, cacheErr := readWatchListFile(cacheFile); cacheErr == nil {
+				zap.S().Warnf("%s: error fetching watchlist from %q (using the copy cached at %s): %s", programName, source, cacheFile, err)
+				watchlist, err = cached, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading watchlist from %q: %w", source, err)
+		}
+		lists = append(lists, watchlist)
+	}
+	return monitor.MergeWatchLists(lists...), nil
+}
+
+// reloadOnHangup calls each of the reload functions whenever SIGHUP is
+// received, until ctx is done.
+func reloadOnHangup(ctx context.Context, reloads ...func(context.Context)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+			}
+			for _, reload := range reloads {
+				reload(ctx)
+			}
+		}
+	}()
+}
+
+// reloadWatchList returns a function for reloadOnHangup which re-reads the
+// watch lists from sources into watchlist.  If a watch list can't be read,
+// the error is reported and the previous watch list is kept.
+func reloadWatchList(sources []string, stateDir string, stdinList *monitor.WatchList, watchlist *monitor.WatchList, state monitor.StateProvider) func(context.Context) {
+	return func(ctx context.Context) {
+		newWatchlist, err := readWatchLists(ctx, sources, stateDir, stdinList, false)
+		if err != nil {
+			state.NotifyError(ctx, nil, fmt.Errorf("%w (continuing to use the previous watchlist)", err))
+			return
+		}
+		watchlist.Replace(newWatchlist)
+		zap.S().Infof("reloaded watchlist from %q", sources)
+	}
+}
+
+// reloadWebhookTLS returns a function for reloadOnHangup which reloads the
+// webhook's TLS client certificate and CA bundle.  If they can't be loaded,
+// the error is reported and the previous credentials are kept.
+func reloadWebhookTLS(fsstate *monitor.FilesystemState, state monitor.StateProvider) func(context.Context) {
+	return func(ctx context.Context) {
+		if err := fsstate.ReloadWebhookTLS(); err != nil {
+			state.NotifyError(ctx, nil, fmt.Errorf("error reloading webhook TLS credentials: %w (continuing to use the previous credentials)", err))
+			return
+		}
+		zap.S().Infof("reloaded webhook TLS credentials")
+	}
+}
+
+// readSecret reads a secret from filename if non-empty, or else from the
+// environment variable envVar.  Secrets must never be logged.
+func readSecret(filename string, envVar string) (string, error) {
+	if filename == "" {
+		return os.Getenv(envVar), nil
+	}
+	secret, err := os.ReadFile(filename)
+	if err != nil {
+		return "", simplifyError(err)
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
 func readEmailFile(filename string) ([]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -145,6 +355,64 @@ func readEmailFile(filename string) ([]string, error) {
 	return emails, err
 }
 
+func searchCerts(stateDir string, query string) error {
+	return monitor.SearchCertIndex(stateDir, query, func(record *monitor.CertIndexRecord) error {
+		fmt.Printf("%s\t%s\t%s\t%s\n", record.SHA256, strings.Join(append(record.DNSNames, record.IPAddresses...), ","), record.Issuer, record.Filename)
+		return nil
+	})
+}
+
+func isNtfyPriority(priority string) bool {
+	switch priority {
+	case "", "1", "2", "3", "4", "5", "min", "low", "default", "high", "max", "urgent":
+		return true
+	default:
+		return false
+	}
+}
+
+// printStatus prints a table of the monitoring progress of every log in the
+// log list, according to the state directory.
+func printStatus(ctx context.Context, stateDir string, logListSource string) error {
+	statuses, err := monitor.LoadStatus(ctx, &monitor.FilesystemState{StateDir: stateDir}, logListSource)
+	if err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Log\tPosition\tTree Size\tBacklog\tLast Success\n")
+	for _, status := range statuses {
+		var treeSize, backlog, lastSuccess string
+		if status.LatestSTH != nil {
+			treeSize = fmt.Sprint(status.LatestSTH.TreeSize)
+			backlog = fmt.Sprint(status.Backlog())
+		} else {
+			treeSize, backlog = "-", "-"
+		}
+		if status.State != nil {
+			lastSuccess = time.Since(status.State.LastSuccess).Round(time.Second).String() + " ago"
+		} else {
+			lastSuccess = "never"
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s\n", status.Log.URL, status.Position(), treeSize, backlog, lastSuccess)
+	}
+	return table.Flush()
+}
+
+// compactState removes unneeded STHs from the state directory, and prints
+// how many were removed from each log.
+func compactState(ctx context.Context, stateDir string, logListSource string) error {
+	results, err := monitor.CompactState(ctx, &monitor.FilesystemState{StateDir: stateDir}, logListSource)
+	if err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Log\tSTHs\tRemoved\n")
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%d\t%d\n", result.Log.URL, result.STHs, result.Removed)
+	}
+	return table.Flush()
+}
+
 func appendFunc(slice *[]string) func(string) error {
 	return func(value string) error {
 		*slice = append(*slice, value)
@@ -152,6 +420,121 @@ func appendFunc(slice *[]string) func(string) error {
 	}
 }
 
+func logProxyFunc(proxies *map[string]string) func(string) error {
+	return func(value string) error {
+		logURL, proxyURL, found := strings.Cut(value, "=")
+		if !found || logURL == "" || proxyURL == "" {
+			return fmt.Errorf("must be of the form LOG_URL=PROXY_URL")
+		}
+		if *proxies == nil {
+			*proxies = make(map[string]string)
+		}
+		(*proxies)[strings.TrimRight(logURL, "/")] = proxyURL
+		return nil
+	}
+}
+
+// checkVerificationMode returns an error if entries downloaded through a
+// -log_proxy would not be fully verified.  A proxy is only safe to use
+// without trusting it because every downloaded entry is verified against
+// every STH.
+func checkVerificationMode(mode monitor.VerificationMode, logProxies map[string]string) error {
+	if len(logProxies) > 0 && mode != monitor.VerifyFull {
+		return fmt.Errorf("must be full when -log_proxy is used, since entries served by a proxy must be verified")
+	}
+	return nil
+}
+
+// httpHeaderFunc parses -http_header values of the form HOST=NAME: VALUE
+// into headers, keyed by lower-case host name.
+func httpHeaderFunc(headers *map[string]http.Header) func(string) error {
+	return func(value string) error {
+		host, header, found := strings.Cut(value, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !found || host == "" || strings.ContainsAny(host, " \t:/") {
+			return fmt.Errorf("must be of the form HOST=NAME: VALUE")
+		}
+		name, headerValue, found := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("must be of the form HOST=NAME: VALUE")
+		}
+		if *headers == nil {
+			*headers = make(map[string]http.Header)
+		}
+		if (*headers)[host] == nil {
+			(*headers)[host] = make(http.Header)
+		}
+		(*headers)[host].Set(name, strings.TrimSpace(headerValue))
+		return nil
+	}
+}
+
+// pinFunc parses -webhook_pin_sha256 values, which are SHA-256 hashes
+// encoded in base64 (as in HTTP public key pinning) or hex.
+func pinFunc(pins *[][32]byte) func(string) error {
+	return func(value string) error {
+		hash, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(hash) != 32 {
+			hash, err = hex.DecodeString(value)
+		}
+		if err != nil || len(hash) != 32 {
+			return fmt.Errorf("must be a base64 or hex SHA-256 hash")
+		}
+		*pins = append(*pins, [32]byte(hash))
+		return nil
+	}
+}
+
+func operatorRateLimitFunc(limits *map[string]float64) func(string) error {
+	return func(value string) error {
+		operator, rateString, found := strings.Cut(value, "=")
+		if !found || operator == "" {
+			return fmt.Errorf("must be of the form OPERATOR=RATE")
+		}
+		rate, err := strconv.ParseFloat(rateString, 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("rate must be a non-negative number")
+		}
+		if *limits == nil {
+			*limits = make(map[string]float64)
+		}
+		(*limits)[operator] = rate
+		return nil
+	}
+}
+
+func proxyURLFunc(proxyURL **url.URL) func(string) error {
+	return func(value string) error {
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "socks5" {
+			return fmt.Errorf("must be an http://, https://, or socks5:// URL")
+		}
+		*proxyURL = parsed
+		return nil
+	}
+}
+
+// startTimeFunc parses a -start_at_time value, which is either an RFC 3339
+// timestamp or a date (taken to be midnight UTC).
+func startTimeFunc(startTime *time.Time) func(string) error {
+	return func(value string) error {
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			*startTime = parsed
+			return nil
+		}
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+		}
+		*startTime = parsed
+		return nil
+	}
+}
+
 func main() {
 	encoderCfg := zap.NewProductionEncoderConfig()
 	atom := zap.NewAtomicLevel()
@@ -165,41 +548,395 @@ func main() {
 	loglist.UserAgent = fmt.Sprintf("certspotter/%s (%s; %s; %s)", certspotterVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
 	var flags struct {
-		batchSize   int // TODO-4: respect this option
+		batchSize   int
+		certFields  string
+		certName    string
+		config      string
+		anomalies   bool
+		precerts    bool
+		checkRoots  bool
+		coalesce    time.Duration
+		compact     bool
+		dedupe      bool
+		digest      time.Duration
+		discordHook string
+		dryRun      bool
+		workers     int
 		email       []string
+		emailCset   string
+		emailHTML   bool
 		healthcheck time.Duration
+		headers     map[string]http.Header
+		pingURL     string
+		pingURLFile string
+		pingFail    bool
 		logs        string
+		logKey      string
+		logProxies  map[string]string
+		logURL      string
+		logsShrink  float64
+		logsKeep    bool
+		logsMaxAge  time.Duration
+		logsReload  time.Duration
+		metrics     string
+		maxRuntime  time.Duration
+		maxRespSize int64
+		maxBacklog  uint64
+		minSCTs     int
+		maxValidity int
+		noEmailFile bool
+		noMatchCN   bool
+		ndjson      bool
 		noSave      bool
+		notifyLogs  bool
+		notifyLife  bool
+		notifyList  bool
+		ntfyURL     string
+		ntfyTopic   string
+		ntfyTokFile string
+		gotifyURL   string
+		gotifyTok   string
+		gotifyPrio  int
+		gotifyWarn  int
+		snsTopic    string
+		snsRegion   string
+		snsEndpoint string
+		syslog      string
+		syslogFac   string
+		matrixHS    string
+		matrixRoom  string
+		matrixTok   string
+		matrixNoTLS bool
+		ntfyPrio    string
+		ntfyWarn    string
+		once        bool
+		opLimits    map[string]float64
+		pdKeyFile   string
+		pdURL       string
+		includeOps  []string
+		excludeOps  []string
+		output      string
+		rotate      string
+		rotateTZ    string
+		proxy       *url.URL
+		quiet       bool
+		rateLimit   float64
+		resolve     bool
+		resolveCN   bool
+		resolveTime time.Duration
+		retryBase   time.Duration
+		retryMax    time.Duration
+		retryNotify time.Duration
 		script      string
+		scriptConc  int
+		scriptTime  time.Duration
+		search      string
+		status      bool
+		slackHook   string
+		slackChan   string
+		slackUser   string
+		smtpServer  string
+		smtpUser    string
+		smtpPwFile  string
+		smtpTLS     bool
 		startAtEnd  bool
+		startAtTime time.Time
 		stateDir    string
+		telegramID  string
+		telegramTok string
+		teamsHook   string
 		stdout      bool
+		stdoutBuf   int
+		keyReuse    bool
 		jsonLog     bool
+		verify      string
+		verifyProof bool
+		userAgent   string
 		verbose     bool
 		version     bool
-		watchlist   string
+		watchlist   []string
+		webhook     string
+		webhookCA   string
+		webhookCert string
+		webhookKey  string
+		webhookPins [][32]byte
+		webhookType string
+		webhookTok  string
 	}
 	flag.IntVar(&flags.batchSize, "batch_size", 1000, "Max number of entries to request per call to get-entries (advanced)")
+	flag.StringVar(&flags.certFields, "cert_fields", "", "Comma-separated list of certificate fields to include in JSON files, JSON output, webhook payloads, and script environment (default: "+strings.Join(monitor.DefaultCertFields, ",")+")")
+	flag.StringVar(&flags.certName, "cert_filename_template", "", "Go template for the paths, relative to the certs directory, of saved certificates (advanced)")
+	flag.BoolVar(&flags.anomalies, "check_anomalies", false, "Check matching certificates for standards violations and include them in notifications")
+	flag.BoolVar(&flags.precerts, "check_precerts", false, "Check that matching precertificates are valid and that certificates correspond to a precertificate")
+	flag.BoolVar(&flags.checkRoots, "check_roots", false, "Notify when the set of root certificates accepted by a log changes")
+	flag.DurationVar(&flags.coalesce, "coalesce", 0, "Wait this long after discovering a certificate, then send one notification listing every log it was found in (0 to disable)")
+	flag.BoolVar(&flags.compact, "compact", false, "Remove unneeded STHs from the state directory, print how many were removed from each log, and exit")
+	flag.StringVar(&flags.config, "config", "", "File containing settings, as KEY = VALUE lines named after flags (command line flags take precedence)")
+	flag.BoolVar(&flags.dedupe, "dedupe_precerts", false, "Notify about only one of each precertificate and its corresponding certificate")
+	flag.DurationVar(&flags.digest, "digest", 0, "Instead of notifying about each matching certificate, send one notification listing the certificates discovered during this interval (0 to disable)")
+	flag.StringVar(&flags.discordHook, "discord_webhook_file", "", "File containing the Discord webhook URL to which notifications are posted (default: $CERTSPOTTER_DISCORD_WEBHOOK)")
+	flag.IntVar(&flags.workers, "download_workers", 1, "Number of concurrent get-entries requests to make to each log (advanced)")
+	flag.BoolVar(&flags.dryRun, "dry_run", false, "Download and match certificates, but log notifications and state changes instead of performing them")
 	flag.Func("email", "Email address to contact when matching certificate is discovered (repeatable)", appendFunc(&flags.email))
+	flag.StringVar(&flags.emailCset, "email_charset", "UTF-8", "Character set of email (UTF-8 or US-ASCII)")
+	flag.BoolVar(&flags.emailHTML, "email_html", false, "Include an HTML version of notifications, with links to crt.sh, in email")
+	flag.Func("exclude_operator", "Don't monitor the logs of this operator, as named in the log list (repeatable)", appendFunc(&flags.excludeOps))
+	flag.IntVar(&flags.gotifyPrio, "gotify_priority", 5, "Priority (0-10) of informational -gotify_url notifications")
+	flag.StringVar(&flags.gotifyTok, "gotify_token_file", "", "File containing the application token for -gotify_url (default: $CERTSPOTTER_GOTIFY_TOKEN)")
+	flag.StringVar(&flags.gotifyURL, "gotify_url", "", "URL of Gotify server to which notifications are posted")
+	flag.IntVar(&flags.gotifyWarn, "gotify_warn_priority", 8, "Priority (0-10) of -gotify_url notifications about problems, such as health check failures")
 	flag.DurationVar(&flags.healthcheck, "healthcheck", 24*time.Hour, "How frequently to perform a health check")
+	flag.BoolVar(&flags.pingFail, "healthcheck_ping_fail", false, "Ping -healthcheck_ping_url with /fail appended when a health check finds a problem")
+	flag.StringVar(&flags.pingURL, "healthcheck_ping_url", "", "URL to ping after each health check which finds no problems, e.g. for healthchecks.io (default: $CERTSPOTTER_HEALTHCHECK_PING_URL)")
+	flag.StringVar(&flags.pingURLFile, "healthcheck_ping_url_file", "", "File containing the URL to ping after each health check which finds no problems (alternative to -healthcheck_ping_url)")
+	flag.Func("http_header", "Header to send with requests to logs and the log list at HOST only, as 'HOST=NAME: VALUE' (repeatable)", httpHeaderFunc(&flags.headers))
+	flag.Func("include_operator", "Monitor only the logs of this operator, as named in the log list (repeatable)", appendFunc(&flags.includeOps))
 	flag.StringVar(&flags.logs, "logs", defaultLogList, "File path or URL of JSON list of logs to monitor")
+	flag.StringVar(&flags.logKey, "log_key", "", "Base64-encoded public key of the -log_url log, which is then monitored without consulting the log list")
+	flag.Func("log_proxy", "Download from a caching proxy instead of the log, as LOG_URL=PROXY_URL (repeatable)", logProxyFunc(&flags.logProxies))
+	flag.StringVar(&flags.logURL, "log_url", "", "Monitor only the log with this URL, for debugging (looked up in the log list unless -log_key is specified)")
+	flag.Float64Var(&flags.logsShrink, "logs_shrink_threshold", 0.25, "Notify if the log list shrinks by more than this fraction (0 to disable)")
+	flag.Uint64Var(&flags.maxBacklog, "max_backlog", 0, "Don't report a log as behind in health checks unless more than this many entries are yet to be downloaded")
+	flag.DurationVar(&flags.logsMaxAge, "max_loglist_age", 0, "Refuse to use a log list whose timestamp is older than this (0 to disable)")
+	flag.DurationVar(&flags.logsReload, "logs_reload_interval", 0, "How frequently to reload the log list (default: a random interval between 30 and 90 minutes)")
+	flag.BoolVar(&flags.logsKeep, "logs_keep_on_shrink", false, "Keep using the previous log list if it shrinks by more than -logs_shrink_threshold")
+	flag.StringVar(&flags.matrixHS, "matrix_homeserver", "", "URL of Matrix homeserver through which notifications are sent to -matrix_room_id")
+	flag.BoolVar(&flags.matrixNoTLS, "matrix_insecure_skip_verify", false, "Don't verify the TLS certificate of -matrix_homeserver (e.g. for a self-hosted homeserver with a private CA)")
+	flag.StringVar(&flags.matrixRoom, "matrix_room_id", "", "Matrix room to which notifications are sent (e.g. !abc123:example.com)")
+	flag.StringVar(&flags.matrixTok, "matrix_token_file", "", "File containing the access token for -matrix_homeserver (default: $CERTSPOTTER_MATRIX_ACCESS_TOKEN)")
+	flag.Int64Var(&flags.maxRespSize, "max_response_size", 0, "Max size, in bytes, of a response from a log (default: 64KiB per entry in a -batch_size response, and at least 64MiB)")
+	flag.DurationVar(&flags.maxRuntime, "max_runtime", 0, "Exit gracefully after running for this long (0 to run forever)")
+	flag.IntVar(&flags.maxValidity, "max_validity_days", 0, "Flag matching certificates valid for longer than this many days, e.g. 398 (0 to disable)")
+	flag.StringVar(&flags.metrics, "metrics_addr", "", "Serve Prometheus metrics over HTTP on this address (HOST:PORT)")
+	flag.IntVar(&flags.minSCTs, "min_scts", 0, "Flag matching certificates with fewer than this many embedded SCTs (0 to disable)")
+	flag.BoolVar(&flags.noEmailFile, "no_email_file", false, "Ignore the email recipients file ("+defaultEmailFile()+")")
+	flag.BoolVar(&flags.noMatchCN, "no_match_cn", false, "Match DNS names and IP addresses only in certificates' subject alternative names, not their subject CN")
+	flag.BoolVar(&flags.ndjson, "ndjson", false, "Write each matching certificate to stdout as one line of JSON, and log to stderr instead of stdout")
 	flag.BoolVar(&flags.noSave, "no_save", false, "Do not save a copy of matching certificates in state directory")
+	flag.BoolVar(&flags.notifyLogs, "notify_log_contact", false, "Send a notification the first time each log is successfully contacted")
+	flag.BoolVar(&flags.notifyLife, "notify_lifecycle", false, "Send a notification when certspotter starts and stops")
+	flag.BoolVar(&flags.notifyList, "notify_log_list_changes", false, "Send a notification when a reloaded log list adds or removes logs")
+	flag.StringVar(&flags.ntfyPrio, "ntfy_priority", "", "Priority of informational -ntfy_url notifications (default: the server's default)")
+	flag.StringVar(&flags.ntfyTokFile, "ntfy_token_file", "", "File containing an access token to send with -ntfy_url requests (default: $CERTSPOTTER_NTFY_TOKEN)")
+	flag.StringVar(&flags.ntfyTopic, "ntfy_topic", "", "ntfy topic to which notifications are published, if not included in -ntfy_url")
+	flag.StringVar(&flags.ntfyURL, "ntfy_url", "", "URL of ntfy server or topic to which notifications are published")
+	flag.StringVar(&flags.ntfyWarn, "ntfy_warn_priority", "high", "Priority of -ntfy_url notifications about problems, such as health check failures")
+	flag.BoolVar(&flags.once, "once", false, "Download every log up to its latest STH, send notifications, and exit")
+	flag.Func("operator_rate_limit", "Override -rate_limit for the logs of one operator, as OPERATOR=RATE (repeatable)", operatorRateLimitFunc(&flags.opLimits))
+	flag.StringVar(&flags.pdKeyFile, "pagerduty_routing_key_file", "", "File containing a PagerDuty Events API v2 routing key, to trigger alerts about health check failures (default: $CERTSPOTTER_PAGERDUTY_ROUTING_KEY)")
+	flag.StringVar(&flags.pdURL, "pagerduty_url", "", "URL of PagerDuty Events API v2 endpoint for -pagerduty_routing_key_file (default: https://events.pagerduty.com/v2/enqueue)")
+	flag.StringVar(&flags.output, "output", "", "Write JSON output to this file instead of stdout")
+	flag.StringVar(&flags.rotate, "output_rotate", "never", "Start a new, date-stamped -output file every day or hour (never, daily, or hourly)")
+	flag.StringVar(&flags.rotateTZ, "output_rotate_tz", "Local", "Time zone used to determine when to rotate the -output file")
+	flag.BoolVar(&flags.quiet, "quiet", false, "Log only warnings and errors (matching certificates are still written to stdout with -stdout or -jsonLog)")
+	flag.Func("proxy", "Send all outbound requests, including notifications, through this http://, https://, or socks5:// proxy (default: $HTTPS_PROXY)", proxyURLFunc(&flags.proxy))
+	flag.Float64Var(&flags.rateLimit, "rate_limit", 0, "Maximum number of requests per second to send to each log host (0 for unlimited)")
+	flag.BoolVar(&flags.resolve, "resolve_dns", false, "Look up the A and AAAA records of matching certificates' DNS names and include them in notifications")
+	flag.BoolVar(&flags.resolveCN, "resolve_cname", false, "With -resolve_dns, also look up CNAME records")
+	flag.DurationVar(&flags.resolveTime, "resolve_timeout", 5*time.Second, "Maximum time to spend on -resolve_dns lookups for each certificate")
+	flag.DurationVar(&flags.retryBase, "retry_base_delay", client.DefaultRetryPolicy.BaseDelay, "How long to wait before retrying a failed request to a log; doubles with each retry")
+	flag.DurationVar(&flags.retryMax, "retry_max_delay", client.DefaultRetryPolicy.MaxDelay, "Maximum time to wait between retries of a failed request to a log")
+	flag.DurationVar(&flags.retryNotify, "retry_notify_threshold", 0, "Notify when the wait before retrying a request to a log reaches this long (0 to disable)")
 	flag.StringVar(&flags.script, "script", "", "Program to execute when a matching certificate is discovered")
+	flag.IntVar(&flags.scriptConc, "script_concurrency", 4, "Maximum number of scripts to execute at once (0 for unlimited)")
+	flag.DurationVar(&flags.scriptTime, "script_timeout", 60*time.Second, "Kill scripts which run for longer than this (0 to disable)")
+	flag.StringVar(&flags.search, "search", "", "Search saved certificates for the given terms, print the matches, and exit")
+	flag.StringVar(&flags.slackChan, "slack_channel", "", "Post Slack notifications to this channel instead of the webhook's default")
+	flag.StringVar(&flags.slackUser, "slack_username", "", "Post Slack notifications as this username instead of the webhook's default")
+	flag.StringVar(&flags.slackHook, "slack_webhook_file", "", "File containing the Slack incoming webhook URL to which notifications are posted (default: $CERTSPOTTER_SLACK_WEBHOOK)")
+	flag.StringVar(&flags.smtpServer, "smtp_server", "", "Send email using this SMTP server (HOST or HOST:PORT) instead of sendmail")
+	flag.StringVar(&flags.smtpPwFile, "smtp_password_file", "", "File containing the password for -smtp_username (default: $CERTSPOTTER_SMTP_PASSWORD)")
+	flag.BoolVar(&flags.smtpTLS, "smtp_tls", false, "Connect to -smtp_server using TLS instead of STARTTLS")
+	flag.StringVar(&flags.smtpUser, "smtp_username", "", "Username for authenticating to -smtp_server")
+	flag.StringVar(&flags.snsEndpoint, "sns_endpoint", "", "URL of SNS endpoint for -sns_topic_arn, e.g. for LocalStack (default: the region's standard endpoint)")
+	flag.StringVar(&flags.snsRegion, "sns_region", "", "AWS region of -sns_topic_arn (default: the region in the ARN)")
+	flag.StringVar(&flags.snsTopic, "sns_topic_arn", "", "ARN of AWS SNS topic to which notifications are published, using AWS credentials found in the same way as the AWS CLI")
 	flag.BoolVar(&flags.startAtEnd, "start_at_end", false, "Start monitoring new logs from the end rather than the beginning (saves considerable bandwidth)")
+	flag.Func("start_at_time", "Start monitoring new logs from the first entry logged at or after this date (YYYY-MM-DD) or RFC 3339 time", startTimeFunc(&flags.startAtTime))
 	flag.StringVar(&flags.stateDir, "state_dir", defaultStateDir(), "Directory for storing log position and discovered certificates")
+	flag.BoolVar(&flags.status, "status", false, "Print each log's monitoring progress according to the state directory, and exit")
 	flag.BoolVar(&flags.jsonLog, "jsonLog", false, "Write matching certificates to stdout in JSON format")
+	flag.StringVar(&flags.syslog, "syslog", "", "Send notifications and log messages to syslog: local, HOST:PORT, udp://HOST:PORT, or tcp://HOST:PORT")
+	flag.StringVar(&flags.syslogFac, "syslog_facility", "daemon", "Syslog facility for -syslog (e.g. daemon, user, or local0 through local7)")
 	flag.BoolVar(&flags.stdout, "stdout", false, "Write matching certificates to stdout")
+	flag.IntVar(&flags.stdoutBuf, "stdout_buffer", 0, "Buffer up to this many records written to stdout by -stdout, -jsonLog, or -ndjson, and write them from a background goroutine (0 to write synchronously)")
+	flag.StringVar(&flags.teamsHook, "teams_webhook_file", "", "File containing the Microsoft Teams webhook URL to which notifications are posted (default: $CERTSPOTTER_TEAMS_WEBHOOK)")
+	flag.StringVar(&flags.telegramID, "telegram_chat_id", "", "Telegram chat to which notifications are sent by the bot whose token is in -telegram_token_file")
+	flag.StringVar(&flags.telegramTok, "telegram_token_file", "", "File containing the Telegram bot token for -telegram_chat_id (default: $CERTSPOTTER_TELEGRAM_BOT_TOKEN)")
+	flag.BoolVar(&flags.keyReuse, "track_key_reuse", false, "Notify when a matching certificate's key was previously seen with a different issuer")
+	flag.StringVar(&flags.userAgent, "user_agent", "", "User-Agent to send with requests to logs and the log list (default: none to logs, certspotter's version to the log list)")
 	flag.BoolVar(&flags.verbose, "verbose", false, "Be verbose")
+	flag.StringVar(&flags.verify, "verify", "full", "How to verify downloaded entries against signed tree heads (full, sth, or none)")
+	flag.BoolVar(&flags.verifyProof, "verify_proofs", false, "Fetch and verify an inclusion proof for each matching certificate")
 	flag.BoolVar(&flags.version, "version", false, "Print version and exit")
-	flag.StringVar(&flags.watchlist, "watchlist", defaultWatchListPathIfExists(), "File containing domain names to watch")
+	flag.Func("watchlist", "File or HTTP(S) URL containing domain names to watch (repeatable; default: "+defaultWatchListPath()+")", appendFunc(&flags.watchlist))
+	flag.StringVar(&flags.webhook, "webhook", "", "URL to which notifications are POSTed as JSON")
+	flag.StringVar(&flags.webhookCA, "webhook_ca_file", "", "File containing PEM-encoded CA certificates to trust, instead of the system roots, when verifying the -webhook server")
+	flag.StringVar(&flags.webhookCert, "webhook_client_cert", "", "File containing a PEM-encoded TLS client certificate to present to -webhook (requires -webhook_client_key)")
+	flag.StringVar(&flags.webhookKey, "webhook_client_key", "", "File containing the PEM-encoded private key for -webhook_client_cert")
+	flag.Func("webhook_pin_sha256", "Base64 or hex SHA-256 hash of the SubjectPublicKeyInfo which the -webhook server's certificate must have (repeatable)", pinFunc(&flags.webhookPins))
+	flag.StringVar(&flags.webhookType, "webhook_content_type", "application/json", "Content-Type header to send with -webhook requests")
+	flag.StringVar(&flags.webhookTok, "webhook_token_file", "", "File containing a bearer token to send with -webhook requests (default: $CERTSPOTTER_WEBHOOK_TOKEN)")
 	flag.Parse()
+	if flags.config != "" {
+		if err := applyConfigFile(flag.CommandLine, flags.config); err != nil {
+			logger.Sugar().Warnf("%s: -config: %s: %s", programName, flags.config, err)
+			os.Exit(exitUsage)
+		}
+	}
+	if flags.batchSize < 1 {
+		logger.Sugar().Warnf("%s: -batch_size: must be at least 1", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.maxRespSize < 0 {
+		logger.Sugar().Warnf("%s: -max_response_size: must not be negative", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.workers < 1 {
+		logger.Sugar().Warnf("%s: -download_workers: must be at least 1", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.stdoutBuf < 0 {
+		logger.Sugar().Warnf("%s: -stdout_buffer: must not be negative", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.rateLimit < 0 {
+		logger.Sugar().Warnf("%s: -rate_limit: must not be negative", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.retryBase <= 0 || flags.retryMax < flags.retryBase {
+		logger.Sugar().Warnf("%s: -retry_base_delay: must be positive and no greater than -retry_max_delay", programName)
+		os.Exit(exitUsage)
+	}
+	var logKey []byte
+	if flags.logKey != "" {
+		if flags.logURL == "" {
+			logger.Sugar().Warnf("%s: -log_key: can only be used with -log_url", programName)
+			os.Exit(exitUsage)
+		}
+		var err error
+		if logKey, err = base64.StdEncoding.DecodeString(flags.logKey); err != nil {
+			logger.Sugar().Warnf("%s: -log_key: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		if _, err := x509.ParsePKIXPublicKey(logKey); err != nil {
+			logger.Sugar().Warnf("%s: -log_key: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+	}
+	if flags.quiet && flags.verbose {
+		logger.Sugar().Warnf("%s: -quiet: cannot be used with -verbose", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.startAtEnd && !flags.startAtTime.IsZero() {
+		logger.Sugar().Warnf("%s: -start_at_time: cannot be used with -start_at_end", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.proxy != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(flags.proxy)
+		loglist.Transport = transport
+		monitor.NotificationProxy = flags.proxy
+	}
+	if flags.userAgent != "" {
+		loglist.UserAgent = flags.userAgent
+	}
+	loglist.Headers = flags.headers
+	if flags.ndjson {
+		if flags.stdout || flags.jsonLog {
+			logger.Sugar().Warnf("%s: -ndjson: cannot be used with -stdout or -jsonLog", programName)
+			os.Exit(exitUsage)
+		}
+		// Keep stdout free for the NDJSON records
+		logger = zap.New(zapcore.NewCore(
+			zapcore.NewJSONEncoder(encoderCfg),
+			zapcore.Lock(os.Stderr),
+			atom,
+		))
+		defer logger.Sync()
+	}
+	if flags.output != "" {
+		interval, err := monitor.ParseRotationInterval(flags.rotate)
+		if err != nil {
+			logger.Sugar().Warnf("%s: -output_rotate: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		location, err := time.LoadLocation(flags.rotateTZ)
+		if err != nil {
+			logger.Sugar().Warnf("%s: -output_rotate_tz: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		output := monitor.NewRotatingFile(flags.output, interval, location)
+		defer output.Close()
+		logger = zap.New(zapcore.NewCore(
+			zapcore.NewJSONEncoder(encoderCfg),
+			output,
+			atom,
+		))
+		defer logger.Sync()
+	}
+	var syslogger syslogWriter
+	if flags.syslog != "" {
+		var err error
+		if syslogger, err = openSyslog(flags.syslog, flags.syslogFac); err != nil {
+			logger.Sugar().Warnf("%s: -syslog: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		defer syslogger.Close()
+		logger = zap.New(zapcore.NewTee(logger.Core(), newSyslogCore(syslogger, encoderCfg, atom)))
+		log.SetOutput(io.MultiWriter(os.Stderr, syslogger))
+	}
 	if flags.version {
 		logger.Sugar().Infof("certspotter version %s", certspotterVersion())
-		os.Exit(0)
+		os.Exit(exitOK)
+	}
+	if flags.status {
+		if err := printStatus(context.Background(), flags.stateDir, flags.logs); err != nil {
+			logger.Sugar().Warnf("%s: error printing status: %s", programName, err)
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	}
+	if flags.compact {
+		if err := compactState(context.Background(), flags.stateDir, flags.logs); err != nil {
+			logger.Sugar().Warnf("%s: error compacting state directory: %s", programName, err)
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	}
+	if flags.search != "" {
+		if err := searchCerts(flags.stateDir, flags.search); err != nil {
+			logger.Sugar().Warnf("%s: error searching saved certificates: %s", programName, err)
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	}
+	if len(flags.watchlist) == 0 && defaultWatchListPathIfExists() != "" {
+		flags.watchlist = []string{defaultWatchListPath()}
 	}
-	if flags.watchlist == "" {
+	if len(flags.watchlist) == 0 {
 		logger.Sugar().Warnf("%s: watch list not found: please create %s or specify alternative path using -watchlist", programName, defaultWatchListPath())
-		os.Exit(2)
+		os.Exit(exitWatchList)
+	}
+
+	verification, err := monitor.ParseVerificationMode(flags.verify)
+	if err == nil {
+		err = checkVerificationMode(verification, flags.logProxies)
+	}
+	if err != nil {
+		logger.Sugar().Warnf("%s: -verify: %s", programName, err)
+		os.Exit(exitUsage)
+	}
+
+	certFields, err := monitor.ParseCertFields(flags.certFields)
+	if err != nil {
+		logger.Sugar().Warnf("%s: -cert_fields: %s", programName, err)
+		os.Exit(exitUsage)
+	}
+
+	if !monitor.IsEmailCharset(flags.emailCset) {
+		logger.Sugar().Warnf("%s: -email_charset: must be UTF-8 or US-ASCII", programName)
+		os.Exit(exitUsage)
 	}
 
 	fsstate := &monitor.FilesystemState{
@@ -210,61 +947,378 @@ func main() {
 		Email:     flags.email,
 		Stdout:    flags.stdout,
 		Json:      flags.jsonLog,
+		NDJSON:    flags.ndjson,
+
+		EmailCharset: flags.emailCset,
+		EmailHTML:    flags.emailHTML,
+
+		ScriptTimeout:     flags.scriptTime,
+		ScriptConcurrency: flags.scriptConc,
+
+		CertFields: certFields,
+	}
+	if flags.certName != "" {
+		tmpl, err := monitor.ParseCertFilenameTemplate(flags.certName)
+		if err != nil {
+			logger.Sugar().Warnf("%s: -cert_filename_template: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		fsstate.CertFilenameTemplate = tmpl
+	}
+	if flags.webhook != "" {
+		token, err := readSecret(flags.webhookTok, "CERTSPOTTER_WEBHOOK_TOKEN")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading webhook token: %s", programName, err)
+			os.Exit(exitError)
+		}
+		fsstate.Webhook = flags.webhook
+		fsstate.WebhookContentType = flags.webhookType
+		fsstate.WebhookToken = token
+	}
+	if flags.webhookCert != "" || flags.webhookKey != "" || flags.webhookCA != "" || len(flags.webhookPins) > 0 {
+		if (flags.webhookCert == "") != (flags.webhookKey == "") {
+			logger.Sugar().Warnf("%s: -webhook_client_cert and -webhook_client_key must be specified together", programName)
+			os.Exit(exitUsage)
+		}
+		if flags.webhook == "" {
+			logger.Sugar().Warnf("%s: -webhook_client_cert, -webhook_ca_file, and -webhook_pin_sha256 require -webhook", programName)
+			os.Exit(exitUsage)
+		}
+		creds, err := monitor.LoadTLSCredentials(flags.webhookCert, flags.webhookKey, flags.webhookCA, flags.webhookPins)
+		if err != nil {
+			logger.Sugar().Warnf("%s: error loading webhook TLS credentials: %s", programName, err)
+			os.Exit(exitError)
+		}
+		fsstate.WebhookTLS = creds
+	}
+	if flags.pingURL != "" && flags.pingURLFile != "" {
+		logger.Sugar().Warnf("%s: -healthcheck_ping_url and -healthcheck_ping_url_file cannot be specified together", programName)
+		os.Exit(exitUsage)
+	} else if flags.pingURL == "" {
+		pingURL, err := readSecret(flags.pingURLFile, "CERTSPOTTER_HEALTHCHECK_PING_URL")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading health check ping URL: %s", programName, err)
+			os.Exit(exitError)
+		}
+		flags.pingURL = pingURL
+	}
+	if slackHook, err := readSecret(flags.slackHook, "CERTSPOTTER_SLACK_WEBHOOK"); err != nil {
+		logger.Sugar().Warnf("%s: error reading Slack webhook URL: %s", programName, err)
+		os.Exit(exitError)
+	} else if slackHook != "" {
+		fsstate.SlackWebhookURL = slackHook
+		fsstate.SlackChannel = flags.slackChan
+		fsstate.SlackUsername = flags.slackUser
+	} else if flags.slackHook != "" {
+		logger.Sugar().Warnf("%s: -slack_webhook_file: %s is empty", programName, flags.slackHook)
+		os.Exit(exitUsage)
+	}
+	if discordHook, err := readSecret(flags.discordHook, "CERTSPOTTER_DISCORD_WEBHOOK"); err != nil {
+		logger.Sugar().Warnf("%s: error reading Discord webhook URL: %s", programName, err)
+		os.Exit(exitError)
+	} else if discordHook != "" {
+		fsstate.DiscordWebhookURL = discordHook
+	} else if flags.discordHook != "" {
+		logger.Sugar().Warnf("%s: -discord_webhook_file: %s is empty", programName, flags.discordHook)
+		os.Exit(exitUsage)
+	}
+	if teamsHook, err := readSecret(flags.teamsHook, "CERTSPOTTER_TEAMS_WEBHOOK"); err != nil {
+		logger.Sugar().Warnf("%s: error reading Microsoft Teams webhook URL: %s", programName, err)
+		os.Exit(exitError)
+	} else if teamsHook != "" {
+		fsstate.TeamsWebhookURL = teamsHook
+	} else if flags.teamsHook != "" {
+		logger.Sugar().Warnf("%s: -teams_webhook_file: %s is empty", programName, flags.teamsHook)
+		os.Exit(exitUsage)
+	}
+	if flags.ntfyURL != "" {
+		for name, priority := range map[string]string{"ntfy_priority": flags.ntfyPrio, "ntfy_warn_priority": flags.ntfyWarn} {
+			if !isNtfyPriority(priority) {
+				logger.Sugar().Warnf("%s: -%s: must be 1-5, min, low, default, high, max, or urgent", programName, name)
+				os.Exit(exitUsage)
+			}
+		}
+		token, err := readSecret(flags.ntfyTokFile, "CERTSPOTTER_NTFY_TOKEN")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading ntfy token: %s", programName, err)
+			os.Exit(exitError)
+		}
+		fsstate.NtfyURL = flags.ntfyURL
+		fsstate.NtfyTopic = flags.ntfyTopic
+		fsstate.NtfyToken = token
+		fsstate.NtfyPriority = flags.ntfyPrio
+		fsstate.NtfyWarnPriority = flags.ntfyWarn
+	}
+	if flags.gotifyURL != "" {
+		for name, priority := range map[string]int{"gotify_priority": flags.gotifyPrio, "gotify_warn_priority": flags.gotifyWarn} {
+			if priority < 0 || priority > 10 {
+				logger.Sugar().Warnf("%s: -%s: must be between 0 and 10", programName, name)
+				os.Exit(exitUsage)
+			}
+		}
+		token, err := readSecret(flags.gotifyTok, "CERTSPOTTER_GOTIFY_TOKEN")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading Gotify token: %s", programName, err)
+			os.Exit(exitError)
+		} else if token == "" {
+			logger.Sugar().Warnf("%s: -gotify_url: requires an application token from -gotify_token_file or $CERTSPOTTER_GOTIFY_TOKEN", programName)
+			os.Exit(exitUsage)
+		}
+		fsstate.GotifyURL = flags.gotifyURL
+		fsstate.GotifyToken = token
+		fsstate.GotifyPriority = flags.gotifyPrio
+		fsstate.GotifyWarnPriority = flags.gotifyWarn
+	}
+	if flags.snsTopic != "" {
+		if flags.snsRegion == "" && monitor.SNSRegionFromARN(flags.snsTopic) == "" {
+			logger.Sugar().Warnf("%s: -sns_topic_arn: must be of the form arn:aws:sns:REGION:ACCOUNT:TOPIC, or -sns_region must be specified", programName)
+			os.Exit(exitUsage)
+		}
+		fsstate.SNSTopicARN = flags.snsTopic
+		fsstate.SNSRegion = flags.snsRegion
+		fsstate.SNSEndpoint = flags.snsEndpoint
+	}
+	if syslogger != nil {
+		fsstate.Syslog = syslogger
+	}
+	if routingKey, err := readSecret(flags.pdKeyFile, "CERTSPOTTER_PAGERDUTY_ROUTING_KEY"); err != nil {
+		logger.Sugar().Warnf("%s: error reading PagerDuty routing key: %s", programName, err)
+		os.Exit(exitError)
+	} else if routingKey != "" {
+		fsstate.PagerDutyRoutingKey = routingKey
+		fsstate.PagerDutyURL = flags.pdURL
+	} else if flags.pdKeyFile != "" {
+		logger.Sugar().Warnf("%s: -pagerduty_routing_key_file: %s is empty", programName, flags.pdKeyFile)
+		os.Exit(exitUsage)
+	} else if flags.pdURL != "" {
+		logger.Sugar().Warnf("%s: -pagerduty_url: requires a routing key from -pagerduty_routing_key_file or $CERTSPOTTER_PAGERDUTY_ROUTING_KEY", programName)
+		os.Exit(exitUsage)
+	}
+	if flags.telegramID != "" {
+		token, err := readSecret(flags.telegramTok, "CERTSPOTTER_TELEGRAM_BOT_TOKEN")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading Telegram bot token: %s", programName, err)
+			os.Exit(exitError)
+		} else if token == "" {
+			logger.Sugar().Warnf("%s: -telegram_chat_id: requires a bot token from -telegram_token_file or $CERTSPOTTER_TELEGRAM_BOT_TOKEN", programName)
+			os.Exit(exitUsage)
+		}
+		fsstate.TelegramBotToken = token
+		fsstate.TelegramChatID = flags.telegramID
+	}
+	if flags.matrixHS != "" || flags.matrixRoom != "" {
+		if flags.matrixHS == "" || flags.matrixRoom == "" {
+			logger.Sugar().Warnf("%s: -matrix_homeserver and -matrix_room_id must be specified together", programName)
+			os.Exit(exitUsage)
+		}
+		token, err := readSecret(flags.matrixTok, "CERTSPOTTER_MATRIX_ACCESS_TOKEN")
+		if err != nil {
+			logger.Sugar().Warnf("%s: error reading Matrix access token: %s", programName, err)
+			os.Exit(exitError)
+		} else if token == "" {
+			logger.Sugar().Warnf("%s: -matrix_homeserver: requires an access token from -matrix_token_file or $CERTSPOTTER_MATRIX_ACCESS_TOKEN", programName)
+			os.Exit(exitUsage)
+		}
+		fsstate.MatrixHomeserver = flags.matrixHS
+		fsstate.MatrixAccessToken = token
+		fsstate.MatrixRoomID = flags.matrixRoom
+		fsstate.MatrixInsecureSkipVerify = flags.matrixNoTLS
+	}
+	if flags.smtpServer != "" {
+		smtpConfig, err := monitor.ParseSMTPServer(flags.smtpServer, flags.smtpTLS)
+		if err != nil {
+			logger.Sugar().Warnf("%s: -smtp_server: %s", programName, err)
+			os.Exit(exitUsage)
+		}
+		smtpConfig.Username = flags.smtpUser
+		if smtpConfig.Password, err = readSecret(flags.smtpPwFile, "CERTSPOTTER_SMTP_PASSWORD"); err != nil {
+			logger.Sugar().Warnf("%s: error reading SMTP password: %s", programName, err)
+			os.Exit(exitError)
+		}
+		fsstate.SMTP = smtpConfig
 	}
 	if flags.verbose {
 		atom.SetLevel(zap.DebugLevel)
 	}
+	// -jsonLog output is logged at info level, so it uses a logger which
+	// isn't affected by -quiet
+	fsstate.JsonLogger = logger
+	if flags.quiet {
+		logger = logger.WithOptions(zap.IncreaseLevel(zap.WarnLevel))
+	}
 	zap.ReplaceGlobals(logger)
 
+	retryPolicy := client.DefaultRetryPolicy
+	retryPolicy.BaseDelay = flags.retryBase
+	retryPolicy.MaxDelay = flags.retryMax
+
+	var state monitor.StateProvider = fsstate
+	if flags.dryRun {
+		state = monitor.NewDryRunState(fsstate)
+	}
+
 	config := &monitor.Config{
 		LogListSource:       flags.logs,
-		State:               fsstate,
+		LogURL:              flags.logURL,
+		LogKey:              logKey,
+		LogProxies:          flags.logProxies,
+		HTTPHeaders:         flags.headers,
+		UserAgent:           flags.userAgent,
+		ProxyURL:            flags.proxy,
+		State:               state,
 		StartAtEnd:          flags.startAtEnd,
+		StartAtTime:         flags.startAtTime,
+		OneShot:             flags.once,
+		MatchCommonName:     !flags.noMatchCN,
+		CheckAnomalies:      flags.anomalies,
+		CheckPrecerts:       flags.precerts,
+		CheckRoots:          flags.checkRoots,
+		DedupePrecerts:      flags.dedupe,
+		CoalesceWindow:      flags.coalesce,
+		DigestInterval:      flags.digest,
+		TrackKeyReuse:       flags.keyReuse,
+		ResolveDNS:          flags.resolve,
+		ResolveCNAME:        flags.resolveCN,
+		ResolveTimeout:      flags.resolveTime,
+		NotifyLogContact:    flags.notifyLogs,
+		NotifyLifecycle:     flags.notifyLife,
+		Version:             certspotterVersion(),
+		MinSCTs:             flags.minSCTs,
+		MaxValidityDays:     flags.maxValidity,
+		Verification:        verification,
+		VerifyInclusion:     flags.verifyProof,
 		Verbose:             flags.verbose,
 		HealthCheckInterval: flags.healthcheck,
+		MaxBacklog:          flags.maxBacklog,
+		BatchSize:           flags.batchSize,
+		MaxResponseSize:     flags.maxRespSize,
+		DownloadWorkers:     flags.workers,
+
+		RetryPolicy:          &retryPolicy,
+		RetryNotifyThreshold: flags.retryNotify,
+
+		LogListShrinkThreshold: flags.logsShrink,
+		KeepLogListOnShrink:    flags.logsKeep,
+		MaxLogListAge:          flags.logsMaxAge,
+		LogListReloadInterval:  flags.logsReload,
+		NotifyLogListChanges:   flags.notifyList,
+		RateLimit:              flags.rateLimit,
+		OperatorRateLimits:     flags.opLimits,
+		IncludeOperators:       flags.includeOps,
+		ExcludeOperators:       flags.excludeOps,
+		HealthCheckPingURL:     flags.pingURL,
+		HealthCheckPingFail:    flags.pingFail,
 	}
 
 	emailFileExists := false
-	if emailRecipients, err := readEmailFile(defaultEmailFile()); err == nil {
-		emailFileExists = true
-		fsstate.Email = append(fsstate.Email, emailRecipients...)
-	} else if !errors.Is(err, fs.ErrNotExist) {
-		logger.Sugar().Warnf("%s: error reading email recipients file %q: %s", programName, defaultEmailFile(), err)
-		os.Exit(1)
+	if !flags.noEmailFile {
+		if emailRecipients, err := readEmailFile(defaultEmailFile()); err == nil {
+			emailFileExists = true
+			fsstate.Email = append(fsstate.Email, emailRecipients...)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			logger.Sugar().Warnf("%s: error reading email recipients file %q: %s", programName, defaultEmailFile(), err)
+			os.Exit(exitError)
+		}
 	}
 
-	if len(fsstate.Email) == 0 && !emailFileExists && fsstate.Script == "" && !fileExists(fsstate.ScriptDir) && fsstate.Stdout == false {
+	if !flags.dryRun && len(fsstate.Email) == 0 && !emailFileExists && fsstate.Script == "" && fsstate.Webhook == "" && fsstate.SlackWebhookURL == "" && fsstate.DiscordWebhookURL == "" && fsstate.TeamsWebhookURL == "" && fsstate.TelegramBotToken == "" && fsstate.NtfyURL == "" && fsstate.GotifyURL == "" && fsstate.SNSTopicARN == "" && fsstate.MatrixHomeserver == "" && fsstate.Syslog == nil && !fileExists(fsstate.ScriptDir) && fsstate.Stdout == false && !fsstate.NDJSON {
 		logger.Sugar().Warnf("%s: no notification methods were specified", programName)
 		logger.Sugar().Warnf("Please specify at least one of the following notification methods:")
-		logger.Sugar().Warnf(" - Place one or more email addresses in %s (one address per line)", defaultEmailFile())
+		if !flags.noEmailFile {
+			logger.Sugar().Warnf(" - Place one or more email addresses in %s (one address per line)", defaultEmailFile())
+		}
 		logger.Sugar().Warnf(" - Place one or more executable scripts in the %s directory", fsstate.ScriptDir)
 		logger.Sugar().Warnf(" - Specify an email address using the -email flag")
 		logger.Sugar().Warnf(" - Specify the path to an executable script using the -script flag")
-		logger.Sugar().Warnf(" - Specify the -stdout flag")
-		os.Exit(2)
+		logger.Sugar().Warnf(" - Specify a URL using the -webhook flag")
+		logger.Sugar().Warnf(" - Specify a Slack incoming webhook URL using the -slack_webhook_file flag")
+		logger.Sugar().Warnf(" - Specify a Discord webhook URL using the -discord_webhook_file flag")
+		logger.Sugar().Warnf(" - Specify a Microsoft Teams webhook URL using the -teams_webhook_file flag")
+		logger.Sugar().Warnf(" - Specify a Telegram chat using the -telegram_chat_id flag")
+		logger.Sugar().Warnf(" - Specify an ntfy topic using the -ntfy_url flag")
+		logger.Sugar().Warnf(" - Specify a Gotify server using the -gotify_url flag")
+		logger.Sugar().Warnf(" - Specify an AWS SNS topic using the -sns_topic_arn flag")
+		logger.Sugar().Warnf(" - Specify a Matrix room using the -matrix_room_id flag")
+		logger.Sugar().Warnf(" - Specify a syslog server using the -syslog flag")
+		logger.Sugar().Warnf(" - Specify the -stdout or -ndjson flag")
+		os.Exit(exitUsage)
 	}
 
-	if flags.watchlist == "-" {
-		watchlist, err := monitor.ReadWatchList(os.Stdin)
+	var stdinList *monitor.WatchList
+	if slices.Contains(flags.watchlist, "-") {
+		stdinList, err = monitor.ReadWatchList(os.Stdin)
 		if err != nil {
 			logger.Sugar().Warnf("%s: error reading watchlist from standard in: %s", programName, err)
-			os.Exit(1)
+			os.Exit(exitWatchList)
 		}
-		config.WatchList = watchlist
-	} else {
-		watchlist, err := readWatchListFile(flags.watchlist)
-		if err != nil {
-			logger.Sugar().Warnf("%s: error reading watchlist from %q: %s", programName, flags.watchlist, err)
-			os.Exit(1)
-		}
-		config.WatchList = watchlist
+	}
+	config.WatchList, err = readWatchLists(context.Background(), flags.watchlist, flags.stateDir, stdinList, true)
+	if err != nil {
+		logger.Sugar().Warnf("%s: %s", programName, err)
+		os.Exit(exitWatchList)
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := monitor.Run(ctx, config); err != nil && !errors.Is(err, context.Canceled) {
+	var reloads []func(context.Context)
+	if slices.ContainsFunc(flags.watchlist, func(source string) bool { return source != "-" }) {
+		reloads = append(reloads, reloadWatchList(flags.watchlist, flags.stateDir, stdinList, config.WatchList, state))
+	}
+	if fsstate.WebhookTLS != nil {
+		reloads = append(reloads, reloadWebhookTLS(fsstate, state))
+	}
+	if len(reloads) > 0 {
+		reloadOnHangup(ctx, reloads...)
+	}
+
+	if flags.metrics != "" {
+		listener, err := net.Listen("tcp", flags.metrics)
+		if err != nil {
+			logger.Sugar().Warnf("%s: -metrics_addr: %s", programName, err)
+			os.Exit(exitError)
+		}
+		config.Metrics = monitor.NewMetrics()
+		go func() {
+			if err := config.Metrics.Serve(ctx, listener); err != nil {
+				logger.Sugar().Warnf("%s: error serving metrics: %s", programName, err)
+			}
+		}()
+	}
+
+	if flags.maxRuntime > 0 {
+		// Cancel rather than using a deadline so that certspotter shuts
+		// down exactly as it would upon receiving a signal.
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		timer := time.AfterFunc(flags.maxRuntime, func() { cancel(monitor.ErrMaxRuntime) })
+		defer timer.Stop()
+	}
+
+	var stdout *monitor.BufferedWriter
+	if flags.stdoutBuf > 0 {
+		// The buffer is flushed when ctx is canceled, and also below in
+		// case monitor.Run returns for another reason
+		stdout = monitor.NewBufferedWriter(ctx, os.Stdout, flags.stdoutBuf)
+		fsstate.StdoutWriter = stdout
+		if flags.jsonLog && flags.output == "" {
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg), stdout, atom)
+			if syslogger != nil {
+				core = zapcore.NewTee(core, newSyslogCore(syslogger, encoderCfg, atom))
+			}
+			fsstate.JsonLogger = zap.New(core)
+		}
+	}
+
+	err = monitor.Run(ctx, config)
+	if stdout != nil {
+		if err := stdout.Close(); err != nil {
+			logger.Sugar().Warnf("%s: error writing to stdout: %s", programName, err)
+		}
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
 		logger.Sugar().Warnf("%s: %s", programName, err)
-		os.Exit(1)
+		os.Exit(exitStatus(err))
 	}
 }
@@ -0,0 +1,183 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/monitor"
+)
+
+const testWatchList = "# watch list\n.example.com\nwww.example.net\n"
+
+// serveWatchList returns a server whose responses are produced by handler,
+// which is initially set to serve testWatchList.
+func serveWatchList(t *testing.T) (*httptest.Server, *http.HandlerFunc) {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, testWatchList) })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler(w, r) }))
+	t.Cleanup(server.Close)
+	return server, &handler
+}
+
+func TestFetchWatchList(t *testing.T) {
+	ctx := context.Background()
+	cacheFile := filepath.Join(t.TempDir(), "watchlist_cache")
+	server, handler := serveWatchList(t)
+
+	watchlist, err := fetchWatchList(ctx, server.URL, cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(watchlist.Items()) != 2 {
+		t.Fatalf("watchlist has %d items; want 2", len(watchlist.Items()))
+	}
+	if cached, err := os.ReadFile(cacheFile); err != nil {
+		t.Fatal(err)
+	} else if string(cached) != testWatchList {
+		t.Fatalf("cached watchlist is %q", cached)
+	}
+
+	defer func(timeout time.Duration) { watchListFetchTimeout = timeout }(watchListFetchTimeout)
+	watchListFetchTimeout = 200 * time.Millisecond
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"empty", func(w http.ResponseWriter, r *http.Request) {}},
+		{"only comments", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "# nothing to see here\n\n") }},
+		{"only exclusions", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "!www.example.com\n") }},
+		{"unparseable", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "<html>Sign in to continue</html>\n") }},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, testWatchList)
+		}},
+		{"too large", func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, io.LimitReader(repeatReader(".example.org\n"), maxWatchListSize+100))
+		}},
+		{"too slow", func(w http.ResponseWriter, r *http.Request) {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}},
+	}
+	for _, test := range tests {
+		*handler = test.handler
+		begin := time.Now()
+		if watchlist, err := fetchWatchList(ctx, server.URL, cacheFile); err == nil {
+			t.Errorf("%s: returned watchlist with %d items instead of an error", test.name, len(watchlist.Items()))
+		}
+		if elapsed := time.Since(begin); elapsed > 10*time.Second {
+			t.Errorf("%s: took %s", test.name, elapsed)
+		}
+		if cached, err := os.ReadFile(cacheFile); err != nil {
+			t.Fatal(err)
+		} else if string(cached) != testWatchList {
+			t.Errorf("%s: cached watchlist was replaced with %q", test.name, truncateForTest(cached))
+		}
+	}
+
+	// The cached copy is used at startup if the download fails
+	watchlist, err = readWatchLists(ctx, []string{server.URL}, filepath.Dir(cacheFile), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(watchlist.Items()) != 2 {
+		t.Errorf("watchlist read from cache has %d items; want 2", len(watchlist.Items()))
+	}
+}
+
+type repeatReader string
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r)
+	}
+	return n, nil
+}
+
+func truncateForTest(b []byte) string {
+	if len(b) > 100 {
+		return string(b[:100]) + "..."
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestCheckVerificationMode(t *testing.T) {
+	proxies := map[string]string{"https://log.example": "https://proxy.example/"}
+	tests := []struct {
+		mode       monitor.VerificationMode
+		logProxies map[string]string
+		ok         bool
+	}{
+		{monitor.VerifyFull, nil, true},
+		{monitor.VerifySTH, nil, true},
+		{monitor.VerifyNone, nil, true},
+		{monitor.VerifyFull, proxies, true},
+		{monitor.VerifySTH, proxies, false},
+		{monitor.VerifyNone, proxies, false},
+	}
+	for _, test := range tests {
+		if err := checkVerificationMode(test.mode, test.logProxies); (err == nil) != test.ok {
+			t.Errorf("checkVerificationMode(%d, %v) = %v; expected ok=%v", test.mode, test.logProxies, err, test.ok)
+		}
+	}
+}
+
+func TestHTTPHeaderFunc(t *testing.T) {
+	var headers map[string]http.Header
+	parse := httpHeaderFunc(&headers)
+	for _, value := range []string{"Mirror.Example=Authorization: Bearer secret", "mirror.example=x-extra:  a=b "} {
+		if err := parse(value); err != nil {
+			t.Errorf("httpHeaderFunc(%q) = %v", value, err)
+		}
+	}
+	for _, value := range []string{"Authorization: Bearer secret", "mirror.example:443=X-Extra: 1", "=X-Extra: 1", "mirror.example=X-Extra"} {
+		if err := parse(value); err == nil {
+			t.Errorf("httpHeaderFunc(%q) succeeded; expected an error", value)
+		}
+	}
+	expected := map[string]http.Header{"mirror.example": {"Authorization": {"Bearer secret"}, "X-Extra": {"a=b"}}}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("headers are %v; expected %v", headers, expected)
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	t.Setenv("CERTSPOTTER_TEST_SECRET", "from-environment")
+	filename := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(filename, []byte("from-file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if secret, err := readSecret(filename, "CERTSPOTTER_TEST_SECRET"); err != nil {
+		t.Fatal(err)
+	} else if secret != "from-file" {
+		t.Errorf("readSecret returned %q from the file; expected the file, without the trailing newline", secret)
+	}
+	if secret, err := readSecret("", "CERTSPOTTER_TEST_SECRET"); err != nil {
+		t.Fatal(err)
+	} else if secret != "from-environment" {
+		t.Errorf("readSecret returned %q without a file; expected the environment variable", secret)
+	}
+	if secret, err := readSecret("", "CERTSPOTTER_TEST_UNSET_SECRET"); err != nil || secret != "" {
+		t.Errorf("readSecret returned (%q, %v) for an unset environment variable; expected an empty secret", secret, err)
+	}
+	if _, err := readSecret(filepath.Join(t.TempDir(), "missing"), "CERTSPOTTER_TEST_SECRET"); err == nil {
+		t.Errorf("readSecret succeeded for a missing file instead of falling back to the environment")
+	}
+}
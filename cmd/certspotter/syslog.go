@@ -0,0 +1,102 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogWriter is implemented by *syslog.Writer, on platforms which have
+// log/syslog.
+type syslogWriter interface {
+	io.WriteCloser
+	Err(message string) error
+	Warning(message string) error
+	Notice(message string) error
+	Info(message string) error
+	Debug(message string) error
+}
+
+// parseSyslogAddress parses a -syslog value, which is "local" or
+// [udp://|tcp://]HOST:PORT, into the network and address arguments of
+// syslog.Dial.
+func parseSyslogAddress(value string) (network string, address string, err error) {
+	if value == "local" {
+		return "", "", nil
+	}
+	network, address, found := strings.Cut(value, "://")
+	if !found {
+		network, address = "udp", value
+	}
+	if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("must be local, HOST:PORT, udp://HOST:PORT, or tcp://HOST:PORT")
+	}
+	if !strings.Contains(address, ":") {
+		return "", "", fmt.Errorf("%q does not contain a port", address)
+	}
+	return network, address, nil
+}
+
+// syslogCore is a zapcore.Core which sends log entries to syslog, at the
+// priority corresponding to their level.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  syslogWriter
+}
+
+func newSyslogCore(writer syslogWriter, encoderCfg zapcore.EncoderConfig, level zapcore.LevelEnabler) zapcore.Core {
+	// syslog records the time itself
+	encoderCfg.TimeKey = zapcore.OmitKey
+	return &syslogCore{LevelEnabler: level, encoder: zapcore.NewJSONEncoder(encoderCfg), writer: writer}
+}
+
+func (core *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *core
+	clone.encoder = core.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+	return &clone
+}
+
+func (core *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+func (core *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buffer, err := core.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	message := strings.TrimSuffix(buffer.String(), "\n")
+	buffer.Free()
+	switch {
+	case entry.Level >= zapcore.ErrorLevel:
+		return core.writer.Err(message)
+	case entry.Level == zapcore.WarnLevel:
+		return core.writer.Warning(message)
+	case entry.Level == zapcore.InfoLevel:
+		return core.writer.Info(message)
+	default:
+		return core.writer.Debug(message)
+	}
+}
+
+func (core *syslogCore) Sync() error {
+	return nil
+}
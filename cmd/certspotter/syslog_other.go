@@ -0,0 +1,20 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+)
+
+func openSyslog(address string, facility string) (syslogWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
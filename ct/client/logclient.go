@@ -5,6 +5,7 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
@@ -19,14 +20,52 @@ import (
 	"strconv"
 	"time"
 
+	"golang.org/x/time/rate"
 	"software.sslmate.com/src/certspotter/ct"
 )
 
-const (
-	baseRetryDelay = 1 * time.Second
-	maxRetryDelay  = 120 * time.Second
-	maxRetries     = 10
-)
+// RetryPolicy controls how a LogClient retries failed requests.  The delay
+// before the nth retry is BaseDelay * Multiplier^n, capped at MaxDelay, plus
+// a random amount of up to Jitter times the delay.  If the log sends a
+// Retry-After header, it is used instead, also capped at MaxDelay.
+type RetryPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     float64
+	MaxRetries int
+
+	// If non-nil, OnRetry is called before sleeping for delay.  err
+	// describes the failure that is being retried.
+	OnRetry func(ctx context.Context, numRetries int, delay time.Duration, err error)
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:  1 * time.Second,
+	MaxDelay:   120 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+	MaxRetries: 10,
+}
+
+// Delay returns the delay before retry number numRetries (starting at 0),
+// excluding jitter.
+func (policy *RetryPolicy) Delay(numRetries int) time.Duration {
+	delay := float64(policy.BaseDelay)
+	for i := 0; i < numRetries && delay < float64(policy.MaxDelay); i++ {
+		delay *= policy.Multiplier
+	}
+	return min(time.Duration(delay), policy.MaxDelay)
+}
+
+// DefaultMaxResponseSize is the maximum size of a response body (after
+// decompression) unless overridden with SetMaxResponseSize.  It comfortably
+// fits a get-entries response containing 1000 entries.
+const DefaultMaxResponseSize = 64 << 20
+
+// ErrResponseTooLarge is returned (wrapped) when a response body exceeds the
+// maximum size.  Such requests are not retried.
+var ErrResponseTooLarge = errors.New("response body is too large")
 
 func isRetryableStatusCode(code int) bool {
 	return code/100 == 5 || code == http.StatusTooManyRequests
@@ -36,15 +75,20 @@ func randomDuration(min, max time.Duration) time.Duration {
 	return min + time.Duration(insecurerand.Int63n(int64(max)-int64(min)+1))
 }
 
-func getRetryAfter(resp *http.Response) (time.Duration, bool) {
+// getRetryAfter returns the delay requested by resp's Retry-After header,
+// capped at maxDelay so that a date far in the future can't stall the client.
+func getRetryAfter(resp *http.Response, maxDelay time.Duration) (time.Duration, bool) {
 	if resp == nil {
 		return 0, false
 	}
-	seconds, err := strconv.ParseUint(resp.Header.Get("Retry-After"), 10, 16)
-	if err != nil {
-		return 0, false
+	retryAfter := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseUint(retryAfter, 10, 16); err == nil {
+		return min(time.Duration(seconds)*time.Second, maxDelay), true
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		return min(max(time.Until(date), 0), maxDelay), true
 	}
-	return time.Duration(seconds) * time.Second, true
+	return 0, false
 }
 
 func sleep(ctx context.Context, duration time.Duration) {
@@ -62,6 +106,7 @@ const (
 	GetEntriesPath        = "/ct/v1/get-entries"
 	GetSTHConsistencyPath = "/ct/v1/get-sth-consistency"
 	GetProofByHashPath    = "/ct/v1/get-proof-by-hash"
+	GetRootsPath          = "/ct/v1/get-roots"
 	AddChainPath          = "/ct/v1/add-chain"
 )
 
@@ -70,6 +115,12 @@ type LogClient struct {
 	uri        string       // the base URI of the log. e.g. http://ct.googleapis/pilot
 	httpClient *http.Client // used to interact with the log via HTTP
 	verifier   *ct.SignatureVerifier // if non-nil, used to verify STH signatures
+	retry      RetryPolicy
+	limiter    *rate.Limiter // if non-nil, every request (including retries) waits for it
+	onGzip     func(ctx context.Context, uri string, compressedSize, uncompressedSize int)
+	userAgent  string      // empty to send no User-Agent
+	header     http.Header // if non-nil, added to every request to the log's host
+	maxSize    int64       // maximum size of a response body; zero means DefaultMaxResponseSize
 }
 
 //////////////////////////////////////////////////////////////////////////////////
@@ -107,6 +158,11 @@ type getAuditProofResponse struct {
 	AuditPath [][]byte `json:"audit_path"`
 }
 
+// getRootsResponse represents the JSON response to the CT get-roots method
+type getRootsResponse struct {
+	Certificates [][]byte `json:"certificates"`
+}
+
 type addChainRequest struct {
 	Chain [][]byte `json:"chain"`
 }
@@ -130,6 +186,7 @@ func NewWithVerifier(uri string, verifier *ct.SignatureVerifier) *LogClient {
 	var c LogClient
 	c.uri = uri
 	c.verifier = verifier
+	c.retry = DefaultRetryPolicy
 	transport := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
 		TLSHandshakeTimeout:   15 * time.Second,
@@ -139,6 +196,7 @@ func NewWithVerifier(uri string, verifier *ct.SignatureVerifier) *LogClient {
 		MaxIdleConns:          100,
 		IdleConnTimeout:       15 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		DisableCompression:    true, // we request and decompress gzip ourselves in do()
 		TLSClientConfig: &tls.Config{
 			// We have to disable TLS certificate validation because because several logs
 			// (WoSign, StartCom, GDCA) use certificates that are not widely trusted.
@@ -154,6 +212,74 @@ func NewWithVerifier(uri string, verifier *ct.SignatureVerifier) *LogClient {
 	return &c
 }
 
+// SetRetryPolicy changes how the client retries failed requests.  It must
+// not be called concurrently with requests.
+func (c *LogClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// SetRateLimiter makes the client wait for limiter before sending each
+// request, including retries.  It must not be called concurrently with
+// requests.
+func (c *LogClient) SetRateLimiter(limiter *rate.Limiter) {
+	c.limiter = limiter
+}
+
+// SetGzipObserver makes the client call observer after receiving each
+// gzip-compressed response, with the size of the response body before and
+// after decompression.  It must not be called concurrently with requests.
+func (c *LogClient) SetGzipObserver(observer func(ctx context.Context, uri string, compressedSize, uncompressedSize int)) {
+	c.onGzip = observer
+}
+
+// SetUserAgent makes the client send userAgent with every request, instead of
+// no User-Agent.  It must not be called concurrently with requests.
+func (c *LogClient) SetUserAgent(userAgent string) {
+	c.userAgent = userAgent
+}
+
+// SetHeader makes the client add header to every request (e.g. to
+// authenticate to a log mirror).  If the log redirects a request to a
+// different host, header is not sent to that host.  It must not be called
+// concurrently with requests.
+func (c *LogClient) SetHeader(header http.Header) {
+	c.header = header
+	c.httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for name := range header {
+				req.Header.Del(name)
+			}
+		}
+		return nil
+	}
+}
+
+// SetMaxResponseSize makes the client fail requests whose response body
+// (after decompression) is larger than maxSize bytes, instead of
+// DefaultMaxResponseSize, to protect against malicious or buggy logs which
+// send enormous responses.  It must not be called concurrently with requests.
+func (c *LogClient) SetMaxResponseSize(maxSize int64) {
+	c.maxSize = maxSize
+}
+
+func (c *LogClient) maxResponseSize() int64 {
+	if c.maxSize <= 0 {
+		return DefaultMaxResponseSize
+	}
+	return c.maxSize
+}
+
+// SetProxy makes the client send requests through the proxy at proxyURL
+// (an http, https, or socks5 URL), instead of the proxy specified by the
+// environment (e.g. $HTTPS_PROXY).  It must not be called concurrently with
+// requests.
+func (c *LogClient) SetProxy(proxyURL *url.URL) {
+	c.httpClient.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+}
+
 func (c *LogClient) fetchAndParse(ctx context.Context, uri string, respBody interface{}) error {
 	return c.doAndParse(ctx, "GET", uri, nil, respBody)
 }
@@ -180,48 +306,125 @@ func (c *LogClient) makeRequest(ctx context.Context, method string, uri string,
 }
 
 func (c *LogClient) doAndParse(ctx context.Context, method string, uri string, reqBody interface{}, respBody interface{}) error {
+	respBodyBytes, err := c.do(ctx, method, uri, reqBody)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBodyBytes, respBody); err != nil {
+		return fmt.Errorf("%s %s: error parsing response JSON: %w", method, uri, err)
+	}
+	return nil
+}
+
+// do sends the request, retrying if necessary, and returns the body of the
+// successful response.
+func (c *LogClient) do(ctx context.Context, method string, uri string, reqBody interface{}) ([]byte, error) {
 	numRetries := 0
 retry:
 	if ctx.Err() != nil {
-		return ctx.Err()
+		return nil, ctx.Err()
+	}
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 	}
 	req, err := c.makeRequest(ctx, method, uri, reqBody)
 	if err != nil {
-		return fmt.Errorf("%s %s: error creating request: %w", method, uri, err)
+		return nil, fmt.Errorf("%s %s: error creating request: %w", method, uri, err)
+	}
+	req.Header.Set("User-Agent", c.userAgent) // By default, don't send a User-Agent to make life harder for malicious logs
+	req.Header.Set("Accept-Encoding", "gzip")
+	for name, values := range c.header {
+		req.Header[name] = values
 	}
-	req.Header.Set("User-Agent", "") // Don't send a User-Agent to make life harder for malicious logs
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		if c.shouldRetry(ctx, numRetries, nil) {
+		if c.shouldRetry(ctx, numRetries, nil, err) {
 			numRetries++
 			goto retry
 		}
-		return err
+		return nil, err
 	}
-	respBodyBytes, err := io.ReadAll(resp.Body)
+	respBodyBytes, compressedSize, err := readResponseBody(resp, c.maxResponseSize())
 	resp.Body.Close()
-	if err != nil {
-		if c.shouldRetry(ctx, numRetries, nil) {
+	if err == nil && compressedSize >= 0 && c.onGzip != nil {
+		c.onGzip(ctx, uri, compressedSize, len(respBodyBytes))
+	}
+	if errors.Is(err, ErrResponseTooLarge) {
+		return nil, fmt.Errorf("%s %s: %w", method, uri, err)
+	} else if err != nil {
+		err = fmt.Errorf("%s %s: error reading response: %w", method, uri, err)
+		if c.shouldRetry(ctx, numRetries, nil, err) {
 			numRetries++
 			goto retry
 		}
-		return fmt.Errorf("%s %s: error reading response: %w", method, uri, err)
+		return nil, err
 	}
 	if resp.StatusCode/100 != 2 {
-		if c.shouldRetry(ctx, numRetries, resp) {
+		err := fmt.Errorf("%s %s: %s (%s)", method, uri, resp.Status, string(respBodyBytes))
+		if c.shouldRetry(ctx, numRetries, resp, err) {
 			numRetries++
 			goto retry
 		}
-		return fmt.Errorf("%s %s: %s (%s)", method, uri, resp.Status, string(respBodyBytes))
+		return nil, err
 	}
-	if err := json.Unmarshal(respBodyBytes, respBody); err != nil {
-		return fmt.Errorf("%s %s: error parsing response JSON: %w", method, uri, err)
+	return respBodyBytes, nil
+}
+
+// readResponseBody reads the body of resp, decompressing it if the server
+// used gzip.  compressedSize is the number of bytes read from the wire if the
+// body was compressed, or -1 if it was not.  If the (decompressed) body is
+// larger than maxSize bytes, an error wrapping ErrResponseTooLarge is
+// returned.
+func readResponseBody(resp *http.Response, maxSize int64) (body []byte, compressedSize int, err error) {
+	if resp.ContentLength > maxSize {
+		return nil, -1, fmt.Errorf("%w (Content-Length is %d bytes, but the limit is %d)", ErrResponseTooLarge, resp.ContentLength, maxSize)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		body, err = readAtMost(resp.Body, maxSize)
+		return body, -1, err
+	}
+	counter := &countingReader{r: resp.Body}
+	gzipReader, err := gzip.NewReader(counter)
+	if err != nil {
+		return nil, -1, fmt.Errorf("error decompressing gzip: %w", err)
 	}
-	return nil
+	body, err = readAtMost(gzipReader, maxSize)
+	if errors.Is(err, ErrResponseTooLarge) {
+		return nil, -1, err
+	} else if err != nil {
+		return nil, -1, fmt.Errorf("error decompressing gzip: %w", err)
+	}
+	return body, counter.n, nil
+}
+
+// readAtMost reads all of r, returning an error wrapping ErrResponseTooLarge
+// if it contains more than maxSize bytes.
+func readAtMost(r io.Reader, maxSize int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%w (the limit is %d bytes)", ErrResponseTooLarge, maxSize)
+	}
+	return body, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
 }
 
-func (c *LogClient) shouldRetry(ctx context.Context, numRetries int, resp *http.Response) bool {
-	if numRetries == maxRetries {
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *LogClient) shouldRetry(ctx context.Context, numRetries int, resp *http.Response, err error) bool {
+	if numRetries >= c.retry.MaxRetries {
 		return false
 	}
 
@@ -230,20 +433,20 @@ func (c *LogClient) shouldRetry(ctx context.Context, numRetries int, resp *http.
 	}
 
 	var delay time.Duration
-	if retryAfter, hasRetryAfter := getRetryAfter(resp); hasRetryAfter {
+	if retryAfter, hasRetryAfter := getRetryAfter(resp, c.retry.MaxDelay); hasRetryAfter {
 		delay = retryAfter
 	} else {
-		delay = baseRetryDelay * (1 << numRetries)
-		if delay > maxRetryDelay {
-			delay = maxRetryDelay
-		}
-		delay += randomDuration(0, delay/2)
+		delay = c.retry.Delay(numRetries)
+		delay += randomDuration(0, time.Duration(float64(delay)*c.retry.Jitter))
 	}
 
 	if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Now().Add(delay).After(deadline) {
 		return false
 	}
 
+	if c.retry.OnRetry != nil {
+		c.retry.OnRetry(ctx, numRetries, delay, err)
+	}
 	sleep(ctx, delay)
 	return true
 }
@@ -388,6 +591,19 @@ func (c *LogClient) GetAuditProof(ctx context.Context, hash ct.MerkleTreeNode, t
 	return path, resp.LeafIndex, nil
 }
 
+// GetRoots retrieves the root certificates which the log accepts.
+func (c *LogClient) GetRoots(ctx context.Context) ([]ct.ASN1Cert, error) {
+	var resp getRootsResponse
+	if err := c.fetchAndParse(ctx, c.uri+GetRootsPath, &resp); err != nil {
+		return nil, err
+	}
+	roots := make([]ct.ASN1Cert, len(resp.Certificates))
+	for index, certBytes := range resp.Certificates {
+		roots[index] = certBytes
+	}
+	return roots, nil
+}
+
 func (c *LogClient) AddChain(ctx context.Context, chain [][]byte) (*ct.SignedCertificateTimestamp, error) {
 	req := addChainRequest{Chain: chain}
 
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetRetryAfter(t *testing.T) {
+	maxDelay := 2 * time.Minute
+	tests := []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"soon", 0, false},
+		{"30", 30 * time.Second, true},
+		{"3600", maxDelay, true},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+		{time.Now().Add(24 * 365 * time.Hour).UTC().Format(http.TimeFormat), maxDelay, true},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {test.header}}}
+		delay, ok := getRetryAfter(resp, maxDelay)
+		if delay != test.expected || ok != test.ok {
+			t.Errorf("getRetryAfter(%q) = (%s, %v); expected (%s, %v)", test.header, delay, ok, test.expected, test.ok)
+		}
+	}
+	// A date in the near future yields the time remaining until it
+	date := time.Now().Add(time.Minute + time.Second).UTC().Format(http.TimeFormat)
+	if delay, ok := getRetryAfter(&http.Response{Header: http.Header{"Retry-After": {date}}}, maxDelay); !ok || delay <= 0 || delay > time.Minute+time.Second {
+		t.Errorf("getRetryAfter(%q) = (%s, %v); expected about a minute", date, delay, ok)
+	}
+}
+
+func TestRetryAfterDateCappedAtMaxDelay(t *testing.T) {
+	farFuture := time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", farFuture)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	client := New(server.URL)
+	client.SetRetryPolicy(RetryPolicy{
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+		Multiplier: 2,
+		MaxRetries: 2,
+		OnRetry: func(ctx context.Context, numRetries int, delay time.Duration, err error) {
+			delays = append(delays, delay)
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.GetSTH(ctx); err == nil {
+		t.Fatal("GetSTH succeeded even though the log returned 503")
+	}
+	if len(delays) != 2 {
+		t.Fatalf("retried %d times; expected 2", len(delays))
+	}
+	for _, delay := range delays {
+		if delay != 10*time.Millisecond {
+			t.Errorf("retried after %s; expected the Retry-After date to be capped at 10ms", delay)
+		}
+	}
+}
+
+func TestHeaderNotSentToOtherHosts(t *testing.T) {
+	received := make(map[string]string)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["other"] = r.Header.Get("X-Mirror-Token")
+		w.Write([]byte("{}"))
+	}))
+	defer other.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["mirror"] = r.Header.Get("X-Mirror-Token")
+		http.Redirect(w, r, other.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer mirror.Close()
+
+	client := New(mirror.URL)
+	client.SetHeader(http.Header{"X-Mirror-Token": {"secret"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.do(ctx, "GET", mirror.URL+GetSTHPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if received["mirror"] != "secret" {
+		t.Errorf("mirror received header %q; expected %q", received["mirror"], "secret")
+	}
+	if received["other"] != "" {
+		t.Errorf("header was sent to %s after a redirect", other.URL)
+	}
+}
@@ -0,0 +1,13 @@
+package client
+
+import (
+	"golang.org/x/time/rate"
+)
+
+// NewRateLimiter returns a limiter which allows r requests per second, with
+// bursts of up to one second's worth of requests.  Limiters are safe for
+// concurrent use, so one can be shared by the clients of every log on the
+// same host.
+func NewRateLimiter(r float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(r), max(1, int(r)))
+}
@@ -0,0 +1,554 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// Paths and parameters from the Static CT API (https://c2sp.org/static-ct-api)
+const (
+	CheckpointPath = "/checkpoint"
+	TilePath       = "/tile"
+	IssuerPath     = "/issuer"
+
+	tileHeight = 8
+	tileWidth  = 1 << tileHeight
+)
+
+// StaticLogClient is a client for a CT log which implements the tile-based
+// Static CT API, rather than the RFC 6962 JSON API.  It provides the same
+// methods as LogClient which are needed to monitor a log.
+type StaticLogClient struct {
+	client *LogClient // used for making requests and verifying signatures
+
+	mu       sync.Mutex
+	treeSize uint64              // size of the most recent checkpoint returned by GetSTH
+	issuers  map[[32]byte][]byte // cache of issuer certificates, by SHA-256 fingerprint
+}
+
+// NewStatic constructs a client for the static CT log whose monitoring
+// prefix is uri.
+func NewStatic(uri string, verifier *ct.SignatureVerifier) *StaticLogClient {
+	return &StaticLogClient{
+		client:  NewWithVerifier(strings.TrimRight(uri, "/"), verifier),
+		issuers: make(map[[32]byte][]byte),
+	}
+}
+
+// SetRetryPolicy changes how the client retries failed requests.  It must
+// not be called concurrently with requests.
+func (c *StaticLogClient) SetRetryPolicy(policy RetryPolicy) {
+	c.client.SetRetryPolicy(policy)
+}
+
+// SetRateLimiter makes the client wait for limiter before sending each
+// request.  It must not be called concurrently with requests.
+func (c *StaticLogClient) SetRateLimiter(limiter *rate.Limiter) {
+	c.client.SetRateLimiter(limiter)
+}
+
+// SetGzipObserver makes the client call observer after receiving each
+// gzip-compressed response.  See LogClient.SetGzipObserver.
+func (c *StaticLogClient) SetGzipObserver(observer func(ctx context.Context, uri string, compressedSize, uncompressedSize int)) {
+	c.client.SetGzipObserver(observer)
+}
+
+// SetUserAgent makes the client send userAgent with every request.
+func (c *StaticLogClient) SetUserAgent(userAgent string) {
+	c.client.SetUserAgent(userAgent)
+}
+
+// SetHeader makes the client add header to every request.  See
+// LogClient.SetHeader.
+func (c *StaticLogClient) SetHeader(header http.Header) {
+	c.client.SetHeader(header)
+}
+
+// SetMaxResponseSize limits the size of each response body (e.g. of a
+// tile).  See LogClient.SetMaxResponseSize.
+func (c *StaticLogClient) SetMaxResponseSize(maxSize int64) {
+	c.client.SetMaxResponseSize(maxSize)
+}
+
+// SetProxy makes the client send requests through the proxy at proxyURL.
+// It must not be called concurrently with requests.
+func (c *StaticLogClient) SetProxy(proxyURL *url.URL) {
+	c.client.SetProxy(proxyURL)
+}
+
+func (c *StaticLogClient) fetch(ctx context.Context, path string) ([]byte, error) {
+	return c.client.do(ctx, "GET", c.client.uri+path, nil)
+}
+
+// GetSTH retrieves the log's current checkpoint and returns it as a
+// SignedTreeHead, whose signature can be verified like an RFC 6962 STH.
+func (c *StaticLogClient) GetSTH(ctx context.Context) (*ct.SignedTreeHead, error) {
+	checkpoint, err := c.fetch(ctx, CheckpointPath)
+	if err != nil {
+		return nil, err
+	}
+	sth, err := c.parseCheckpoint(checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s%s: %w", c.client.uri, CheckpointPath, err)
+	}
+	c.mu.Lock()
+	c.treeSize = max(c.treeSize, sth.TreeSize)
+	c.mu.Unlock()
+	return sth, nil
+}
+
+// parseCheckpoint parses a checkpoint, which is a signed note whose text
+// contains the origin, tree size, and root hash, and returns the STH with a
+// valid signature.  Each note signature contains the STH timestamp followed
+// by the TLS-encoded RFC 6962 tree head signature.
+func (c *StaticLogClient) parseCheckpoint(checkpoint []byte) (*ct.SignedTreeHead, error) {
+	text, signatures, found := strings.Cut(string(checkpoint), "\n\n")
+	if !found {
+		return nil, errors.New("malformed checkpoint: no signatures")
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) < 3 {
+		return nil, errors.New("malformed checkpoint: too few lines")
+	}
+	origin := lines[0]
+	treeSize, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed checkpoint: invalid tree size: %w", err)
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(rootHash) != sha256.Size {
+		return nil, errors.New("malformed checkpoint: invalid root hash")
+	}
+
+	var lastErr error = errors.New("checkpoint is not signed by the log")
+	for _, line := range strings.Split(strings.TrimSuffix(signatures, "\n"), "\n") {
+		name, sig, found := strings.Cut(strings.TrimPrefix(line, "— "), " ")
+		if !found || name != origin {
+			continue
+		}
+		sigBytes, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil || len(sigBytes) < 4+8 {
+			continue
+		}
+		sigBytes = sigBytes[4:] // skip the key ID
+		sth := &ct.SignedTreeHead{
+			TreeSize:  treeSize,
+			Timestamp: binary.BigEndian.Uint64(sigBytes[:8]),
+		}
+		copy(sth.SHA256RootHash[:], rootHash)
+		ds, err := ct.UnmarshalDigitallySigned(bytes.NewReader(sigBytes[8:]))
+		if err != nil {
+			continue
+		}
+		sth.TreeHeadSignature = *ds
+		if c.client.verifier != nil {
+			if err := c.client.verifier.VerifySTHSignature(*sth); err != nil {
+				lastErr = fmt.Errorf("checkpoint has invalid signature: %w", err)
+				continue
+			}
+		}
+		return sth, nil
+	}
+	return nil, lastErr
+}
+
+// tilePath returns the path of the tile at the given level and index, which
+// is partial if width is less than tileWidth.  The index is encoded as
+// zero-padded 3-digit path elements, all but the last prefixed with "x".
+func tilePath(level string, index uint64, width uint64) string {
+	path := fmt.Sprintf("%03d", index%1000)
+	for index /= 1000; index > 0; index /= 1000 {
+		path = fmt.Sprintf("x%03d/%s", index%1000, path)
+	}
+	path = TilePath + "/" + level + "/" + path
+	if width < tileWidth {
+		path += ".p/" + strconv.FormatUint(width, 10)
+	}
+	return path
+}
+
+// tileWidthAt returns the width of the tile at the given index of a level
+// which contains levelSize nodes.
+func tileWidthAt(index uint64, levelSize uint64) uint64 {
+	return min(levelSize-index*tileWidth, tileWidth)
+}
+
+func (c *StaticLogClient) currentTreeSize() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.treeSize
+}
+
+// GetRawEntries retrieves entries from the log's data tiles, returning them
+// in the same form as the RFC 6962 get-entries endpoint.  Unlike get-entries,
+// every requested entry is returned, fetching as many tiles as necessary, so
+// a caller which shrinks its requests to the number of entries returned
+// doesn't end up requesting one tile at a time.  end must be less than the
+// size of the most recent checkpoint returned by GetSTH.
+func (c *StaticLogClient) GetRawEntries(ctx context.Context, start, end uint64) ([]GetEntriesItem, error) {
+	if end < start {
+		panic("StaticLogClient.GetRawEntries: end < start")
+	}
+	treeSize := c.currentTreeSize()
+	if end >= treeSize {
+		return nil, fmt.Errorf("entry %d is beyond the latest checkpoint (%d)", end, treeSize)
+	}
+	entries := make([]GetEntriesItem, 0, end-start+1)
+	for index := start; index <= end; {
+		tileIndex := index / tileWidth
+		leaves, err := c.getDataTile(ctx, tileIndex, treeSize)
+		if err != nil {
+			return nil, err
+		}
+		last := min(end, tileIndex*tileWidth+uint64(len(leaves))-1)
+		for _, leaf := range leaves[index%tileWidth : last%tileWidth+1] {
+			entry, err := c.makeEntry(ctx, leaf)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		index = last + 1
+	}
+	return entries, nil
+}
+
+// getDataTile fetches and parses the data tile at the given index of the
+// tree of size treeSize.
+func (c *StaticLogClient) getDataTile(ctx context.Context, tileIndex uint64, treeSize uint64) ([]*tileLeaf, error) {
+	width := tileWidthAt(tileIndex, treeSize)
+	path := tilePath("data", tileIndex, width)
+	tile, err := c.fetch(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := parseDataTile(tile)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s%s: %w", c.client.uri, path, err)
+	}
+	if uint64(len(leaves)) != width {
+		return nil, fmt.Errorf("GET %s%s: tile contains %d entries instead of %d", c.client.uri, path, len(leaves), width)
+	}
+	return leaves, nil
+}
+
+type tileLeaf struct {
+	timestampedEntry []byte
+	isPrecert        bool
+	precert          []byte // the precertificate, for precert entries
+	chain            [][32]byte
+}
+
+// parseDataTile parses the concatenated TileLeaf structures in a data tile.
+func parseDataTile(tile []byte) ([]*tileLeaf, error) {
+	var leaves []*tileLeaf
+	for len(tile) > 0 {
+		leaf, rest, err := parseTileLeaf(tile)
+		if err != nil {
+			return nil, fmt.Errorf("malformed entry %d in data tile: %w", len(leaves), err)
+		}
+		leaves = append(leaves, leaf)
+		tile = rest
+	}
+	return leaves, nil
+}
+
+func parseTileLeaf(data []byte) (*tileLeaf, []byte, error) {
+	r := &tlsReader{data: data}
+	leaf := new(tileLeaf)
+	r.skip(8) // timestamp
+	switch entryType := r.uint(2); ct.LogEntryType(entryType) {
+	case ct.X509LogEntryType:
+		r.varBytes(3) // certificate
+	case ct.PrecertLogEntryType:
+		leaf.isPrecert = true
+		r.skip(32)    // issuer key hash
+		r.varBytes(3) // TBSCertificate
+	default:
+		return nil, nil, fmt.Errorf("unknown entry type %d", entryType)
+	}
+	r.varBytes(2) // extensions
+	leaf.timestampedEntry = data[:r.offset]
+	if leaf.isPrecert {
+		leaf.precert = r.varBytes(3)
+	}
+	fingerprints := r.varBytes(2)
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if len(fingerprints)%32 != 0 {
+		return nil, nil, errors.New("chain fingerprints have invalid length")
+	}
+	for ; len(fingerprints) > 0; fingerprints = fingerprints[32:] {
+		leaf.chain = append(leaf.chain, [32]byte(fingerprints[:32]))
+	}
+	return leaf, data[r.offset:], nil
+}
+
+// makeEntry converts a TileLeaf into the leaf_input (a MerkleTreeLeaf) and
+// extra_data which the RFC 6962 get-entries endpoint would return.
+func (c *StaticLogClient) makeEntry(ctx context.Context, leaf *tileLeaf) (GetEntriesItem, error) {
+	var chain bytes.Buffer
+	for _, fingerprint := range leaf.chain {
+		issuer, err := c.getIssuer(ctx, fingerprint)
+		if err != nil {
+			return GetEntriesItem{}, err
+		}
+		appendVarBytes(&chain, issuer, 3)
+	}
+
+	var entry GetEntriesItem
+	entry.LeafInput = append([]byte{byte(ct.V1), byte(ct.TimestampedEntryLeafType)}, leaf.timestampedEntry...)
+	var extraData bytes.Buffer
+	if leaf.isPrecert {
+		appendVarBytes(&extraData, leaf.precert, 3)
+	}
+	appendVarBytes(&extraData, chain.Bytes(), 3)
+	entry.ExtraData = extraData.Bytes()
+	return entry, nil
+}
+
+func (c *StaticLogClient) getIssuer(ctx context.Context, fingerprint [32]byte) ([]byte, error) {
+	c.mu.Lock()
+	issuer, ok := c.issuers[fingerprint]
+	c.mu.Unlock()
+	if ok {
+		return issuer, nil
+	}
+	path := IssuerPath + "/" + hex.EncodeToString(fingerprint[:])
+	issuer, err := c.fetch(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(issuer) != fingerprint {
+		return nil, fmt.Errorf("GET %s%s: issuer does not match fingerprint", c.client.uri, path)
+	}
+	c.mu.Lock()
+	c.issuers[fingerprint] = issuer
+	c.mu.Unlock()
+	return issuer, nil
+}
+
+// treeReader computes hashes in the tree of a given size using the log's
+// hash tiles, caching the tiles it fetches.
+type treeReader struct {
+	ctx      context.Context
+	client   *StaticLogClient
+	treeSize uint64
+	tiles    map[[2]uint64][]merkletree.Hash
+}
+
+func (c *StaticLogClient) newTreeReader(ctx context.Context, treeSize uint64) *treeReader {
+	return &treeReader{
+		ctx:      ctx,
+		client:   c,
+		treeSize: treeSize,
+		tiles:    make(map[[2]uint64][]merkletree.Hash),
+	}
+}
+
+func (r *treeReader) tile(level uint64, index uint64) ([]merkletree.Hash, error) {
+	if tile, ok := r.tiles[[2]uint64{level, index}]; ok {
+		return tile, nil
+	}
+	width := tileWidthAt(index, r.treeSize>>(level*tileHeight))
+	path := tilePath(strconv.FormatUint(level, 10), index, width)
+	tileBytes, err := r.client.fetch(r.ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(tileBytes)) != width*merkletree.HashLen {
+		return nil, fmt.Errorf("GET %s%s: tile has length %d instead of %d", r.client.client.uri, path, len(tileBytes), width*merkletree.HashLen)
+	}
+	tile := make([]merkletree.Hash, width)
+	for i := range tile {
+		copy(tile[i][:], tileBytes[i*merkletree.HashLen:])
+	}
+	r.tiles[[2]uint64{level, index}] = tile
+	return tile, nil
+}
+
+// nodeHash returns the root hash of the perfect subtree of height level
+// containing the leaves [index<<level, (index+1)<<level).
+func (r *treeReader) nodeHash(level uint64, index uint64) (merkletree.Hash, error) {
+	// The hash is computed from 2^(level%tileHeight) consecutive hashes
+	// in a single tile at the nearest tile level below
+	tileLevel, height := level/tileHeight, level%tileHeight
+	first := index << height
+	tile, err := r.tile(tileLevel, first/tileWidth)
+	if err != nil {
+		return merkletree.Hash{}, err
+	}
+	offset := first % tileWidth
+	if offset+(1<<height) > uint64(len(tile)) {
+		return merkletree.Hash{}, fmt.Errorf("node %d at level %d is not in the tree of size %d", index, level, r.treeSize)
+	}
+	hashes := append([]merkletree.Hash(nil), tile[offset:offset+(1<<height)]...)
+	for len(hashes) > 1 {
+		for i := range hashes[:len(hashes)/2] {
+			hashes[i] = merkletree.HashChildren(hashes[2*i], hashes[2*i+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+	return hashes[0], nil
+}
+
+// rangeHash returns the Merkle Tree Hash of the leaves [begin, end), where
+// begin is a multiple of the largest power of 2 less than end-begin.
+func (r *treeReader) rangeHash(begin, end uint64) (merkletree.Hash, error) {
+	size := end - begin
+	if size&(size-1) == 0 {
+		level := uint64(bits.TrailingZeros64(size))
+		return r.nodeHash(level, begin>>level)
+	}
+	split := uint64(1) << (63 - bits.LeadingZeros64(size-1))
+	left, err := r.rangeHash(begin, begin+split)
+	if err != nil {
+		return merkletree.Hash{}, err
+	}
+	right, err := r.rangeHash(begin+split, end)
+	if err != nil {
+		return merkletree.Hash{}, err
+	}
+	return merkletree.HashChildren(left, right), nil
+}
+
+// GetCollapsedTree computes the collapsed Merkle tree of the given size
+// (which must not exceed the size of the most recent checkpoint returned by
+// GetSTH) from the log's hash tiles.  The caller should verify that its root
+// hash matches a checkpoint.
+func (c *StaticLogClient) GetCollapsedTree(ctx context.Context, treeSize uint64) (*merkletree.CollapsedTree, error) {
+	r := c.newTreeReader(ctx, c.currentTreeSize())
+	if treeSize > r.treeSize {
+		return nil, fmt.Errorf("tree size %d is beyond the latest checkpoint (%d)", treeSize, r.treeSize)
+	}
+	var nodes []merkletree.Hash
+	var offset uint64
+	for level := uint64(63); level < 64; level-- {
+		if treeSize&(1<<level) == 0 {
+			continue
+		}
+		hash, err := r.nodeHash(level, offset>>level)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, hash)
+		offset += 1 << level
+	}
+	return merkletree.NewCollapsedTree(nodes, treeSize)
+}
+
+// GetConsistencyProof computes a Merkle Consistency Proof between the trees
+// of size first and second (which must not exceed the size of the most
+// recent checkpoint returned by GetSTH) from the log's hash tiles, as
+// specified by section 2.1.2 of RFC 6962.
+func (c *StaticLogClient) GetConsistencyProof(ctx context.Context, first, second int64) (ct.ConsistencyProof, error) {
+	if first < 0 || second < first {
+		return nil, errors.New("GetConsistencyProof: must have 0 <= first <= second")
+	}
+	r := c.newTreeReader(ctx, c.currentTreeSize())
+	if uint64(second) > r.treeSize {
+		return nil, fmt.Errorf("tree size %d is beyond the latest checkpoint (%d)", second, r.treeSize)
+	}
+	if first == 0 || first == second {
+		return ct.ConsistencyProof{}, nil
+	}
+	var proof ct.ConsistencyProof
+	if err := r.subproof(uint64(first), 0, uint64(second), true, &proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// subproof appends SUBPROOF(m, D[begin:end], b) to proof.
+func (r *treeReader) subproof(m, begin, end uint64, b bool, proof *ct.ConsistencyProof) error {
+	size := end - begin
+	if m == size {
+		if !b {
+			hash, err := r.rangeHash(begin, end)
+			if err != nil {
+				return err
+			}
+			*proof = append(*proof, hash[:])
+		}
+		return nil
+	}
+	k := uint64(1) << (63 - bits.LeadingZeros64(size-1))
+	var hash merkletree.Hash
+	var err error
+	if m <= k {
+		if err := r.subproof(m, begin, begin+k, b, proof); err != nil {
+			return err
+		}
+		hash, err = r.rangeHash(begin+k, end)
+	} else {
+		if err := r.subproof(m-k, begin+k, end, false, proof); err != nil {
+			return err
+		}
+		hash, err = r.rangeHash(begin, begin+k)
+	}
+	if err != nil {
+		return err
+	}
+	*proof = append(*proof, hash[:])
+	return nil
+}
+
+// tlsReader reads TLS-encoded values from data, recording the first error.
+type tlsReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data)-r.offset {
+		r.err = errors.New("truncated")
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *tlsReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *tlsReader) uint(n int) uint64 {
+	var value uint64
+	for _, b := range r.bytes(n) {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func (r *tlsReader) varBytes(lenBytes int) []byte {
+	return r.bytes(int(r.uint(lenBytes)))
+}
+
+func appendVarBytes(buf *bytes.Buffer, value []byte, lenBytes int) {
+	for i := lenBytes - 1; i >= 0; i-- {
+		buf.WriteByte(byte(len(value) >> (8 * i)))
+	}
+	buf.Write(value)
+}
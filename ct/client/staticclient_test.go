@@ -0,0 +1,349 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+const testOrigin = "ct.example.com/2026"
+
+// testStaticLog is an in-memory static CT API log.  Every entry's chain
+// consists of issuer.
+type testStaticLog struct {
+	key    *ecdsa.PrivateKey
+	issuer []byte
+	leaves [][]byte          // TileLeaf structures
+	inputs [][]byte          // MerkleTreeLeaf structures (i.e. leaf_input)
+	hashes []merkletree.Hash // leaf hashes
+}
+
+func newTestStaticLog(t *testing.T, treeSize int) *testStaticLog {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &testStaticLog{key: key, issuer: []byte("issuer certificate")}
+	issuerFingerprint := sha256.Sum256(log.issuer)
+	for i := 0; i < treeSize; i++ {
+		var timestampedEntry bytes.Buffer
+		timestampedEntry.Write(binary.BigEndian.AppendUint64(nil, uint64(1000+i)))
+		var leaf []byte
+		if i%3 == 0 {
+			timestampedEntry.Write([]byte{0, byte(ct.PrecertLogEntryType)})
+			timestampedEntry.Write(make([]byte, 32)) // issuer key hash
+			appendVarBytes(&timestampedEntry, []byte(fmt.Sprintf("tbs %d", i)), 3)
+			appendVarBytes(&timestampedEntry, nil, 2)
+			var rest bytes.Buffer
+			appendVarBytes(&rest, []byte(fmt.Sprintf("precert %d", i)), 3)
+			appendVarBytes(&rest, issuerFingerprint[:], 2)
+			leaf = append(bytes.Clone(timestampedEntry.Bytes()), rest.Bytes()...)
+		} else {
+			timestampedEntry.Write([]byte{0, byte(ct.X509LogEntryType)})
+			appendVarBytes(&timestampedEntry, []byte(fmt.Sprintf("cert %d", i)), 3)
+			appendVarBytes(&timestampedEntry, nil, 2)
+			var rest bytes.Buffer
+			appendVarBytes(&rest, issuerFingerprint[:], 2)
+			leaf = append(bytes.Clone(timestampedEntry.Bytes()), rest.Bytes()...)
+		}
+		input := append([]byte{byte(ct.V1), byte(ct.TimestampedEntryLeafType)}, timestampedEntry.Bytes()...)
+		log.leaves = append(log.leaves, leaf)
+		log.inputs = append(log.inputs, input)
+		log.hashes = append(log.hashes, merkletree.HashLeaf(input))
+	}
+	return log
+}
+
+// merkleTreeHash computes the Merkle Tree Hash of the leaf hashes as
+// specified by section 2.1 of RFC 6962.
+func merkleTreeHash(hashes []merkletree.Hash) merkletree.Hash {
+	switch len(hashes) {
+	case 0:
+		return merkletree.Hash(sha256.Sum256(nil))
+	case 1:
+		return hashes[0]
+	}
+	k := 1
+	for k*2 < len(hashes) {
+		k *= 2
+	}
+	return merkletree.HashChildren(merkleTreeHash(hashes[:k]), merkleTreeHash(hashes[k:]))
+}
+
+// checkpoint returns a checkpoint for the tree of the given size, signed by
+// the log's key under the given name.
+func (log *testStaticLog) checkpoint(t *testing.T, treeSize uint64, name string) string {
+	t.Helper()
+	root := merkleTreeHash(log.hashes[:treeSize])
+	sth := ct.SignedTreeHead{TreeSize: treeSize, Timestamp: 1234, SHA256RootHash: ct.SHA256Hash(root)}
+	input, err := ct.SerializeSTHSignatureInput(sth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(input)
+	signature, err := ecdsa.SignASN1(rand.Reader, log.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := ct.MarshalDigitallySigned(ct.DigitallySigned{HashAlgorithm: ct.SHA256, SignatureAlgorithm: ct.ECDSA, Signature: signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noteSignature := append(binary.BigEndian.AppendUint64(make([]byte, 4), sth.Timestamp), ds...)
+	return fmt.Sprintf("%s\n%d\n%s\n\n— %s %s\n", testOrigin, treeSize, base64.StdEncoding.EncodeToString(root[:]), name, base64.StdEncoding.EncodeToString(noteSignature))
+}
+
+// files returns the contents of the log's checkpoint, issuer, and tiles
+// at the given tree size, keyed by path.
+func (log *testStaticLog) files(t *testing.T, treeSize uint64) map[string][]byte {
+	files := map[string][]byte{
+		CheckpointPath: []byte(log.checkpoint(t, treeSize, testOrigin)),
+		IssuerPath + "/" + hex.EncodeToString(func() []byte { h := sha256.Sum256(log.issuer); return h[:] }()): log.issuer,
+	}
+	for index := uint64(0); index*tileWidth < treeSize; index++ {
+		width := tileWidthAt(index, treeSize)
+		files[tilePath("data", index, width)] = bytes.Join(log.leaves[index*tileWidth:index*tileWidth+width], nil)
+	}
+	for level := uint64(0); treeSize>>(level*tileHeight) > 0; level++ {
+		levelSize := treeSize >> (level * tileHeight)
+		for index := uint64(0); index*tileWidth < levelSize; index++ {
+			width := tileWidthAt(index, levelSize)
+			var tile []byte
+			for i := index * tileWidth; i < index*tileWidth+width; i++ {
+				leavesPerNode := uint64(1) << (level * tileHeight)
+				hash := merkleTreeHash(log.hashes[i*leavesPerNode : (i+1)*leavesPerNode])
+				tile = append(tile, hash[:]...)
+			}
+			files[tilePath(strconv.FormatUint(level, 10), index, width)] = tile
+		}
+	}
+	return files
+}
+
+// serve serves the log at the given tree size, and returns a client for it
+// on which GetSTH has been called.
+func (log *testStaticLog) serve(t *testing.T, treeSize uint64) *StaticLogClient {
+	t.Helper()
+	files := log.files(t, treeSize)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if file, ok := files[req.URL.Path]; ok {
+			w.Write(file)
+		} else {
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+	verifier, err := ct.NewSignatureVerifier(log.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewStatic(server.URL, verifier)
+	client.SetRetryPolicy(RetryPolicy{MaxRetries: 0})
+	if sth, err := client.GetSTH(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if sth.TreeSize != treeSize {
+		t.Fatalf("GetSTH returned tree size %d, want %d", sth.TreeSize, treeSize)
+	}
+	return client
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	log := newTestStaticLog(t, 10)
+	verifier, err := ct.NewSignatureVerifier(log.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewStatic("https://ct.example.com", verifier)
+	valid := log.checkpoint(t, 10, testOrigin)
+	text, signature, _ := strings.Cut(valid, "\n\n")
+	tests := []struct {
+		name       string
+		checkpoint string
+		wantErr    string
+	}{
+		{"valid", valid, ""},
+		{"extra signature", text + "\n\n— other.example AAAA\n" + signature, ""},
+		{"no signatures", text + "\n", "no signatures"},
+		{"too few lines", testOrigin + "\n10\n\n" + signature, "too few lines"},
+		{"invalid tree size", strings.Replace(valid, "\n10\n", "\nten\n", 1), "invalid tree size"},
+		{"invalid root hash", strings.Replace(valid, "\n10\n", "\n10\nAAAA\n", 1), "invalid root hash"},
+		{"wrong signer", log.checkpoint(t, 10, "other.example"), "not signed by the log"},
+		{"wrong tree size", strings.Replace(valid, "\n10\n", "\n9\n", 1), "invalid signature"},
+		{"malformed signature", text + "\n\n— " + testOrigin + " !!!\n", "not signed by the log"},
+		{"truncated signature", text + "\n\n— " + testOrigin + " AAAA\n", "not signed by the log"},
+	}
+	for _, test := range tests {
+		sth, err := client.parseCheckpoint([]byte(test.checkpoint))
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			} else if sth.TreeSize != 10 || merkletree.Hash(sth.SHA256RootHash) != merkleTreeHash(log.hashes) || sth.Timestamp != 1234 {
+				t.Errorf("%s: parsed incorrect STH %+v", test.name, sth)
+			}
+		} else if err == nil {
+			t.Errorf("%s: parsed invalid checkpoint", test.name)
+		} else if !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestParseTileLeaf(t *testing.T) {
+	log := newTestStaticLog(t, 2)
+	precert, cert := log.leaves[0], log.leaves[1]
+	tests := []struct {
+		name      string
+		data      []byte
+		isPrecert bool
+		wantErr   string
+	}{
+		{"precert", precert, true, ""},
+		{"cert", cert, false, ""},
+		{"unknown entry type", append(append(bytes.Clone(cert[:8]), 0, 7), cert[10:]...), false, "unknown entry type"},
+		{"truncated", cert[:len(cert)-1], false, "truncated"},
+		{"empty", nil, false, "truncated"},
+		{"bad fingerprints", append(bytes.Clone(cert[:len(cert)-34]), 0, 31, 0), false, "invalid length"},
+	}
+	for _, test := range tests {
+		// Each leaf is followed by another, which must be returned as the rest
+		leaf, rest, err := parseTileLeaf(append(bytes.Clone(test.data), cert...))
+		if test.name == "truncated" || test.name == "empty" {
+			leaf, rest, err = parseTileLeaf(test.data)
+		}
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(rest, cert) {
+			t.Errorf("%s: rest is %x, want the following leaf", test.name, rest)
+		}
+		if leaf.isPrecert != test.isPrecert || len(leaf.chain) != 1 || leaf.chain[0] != sha256.Sum256(log.issuer) {
+			t.Errorf("%s: parsed incorrect leaf %+v", test.name, leaf)
+		}
+		if index := map[bool]int{true: 0, false: 1}[test.isPrecert]; !bytes.Equal(leaf.timestampedEntry, log.inputs[index][2:]) {
+			t.Errorf("%s: timestamped entry is %x, want %x", test.name, leaf.timestampedEntry, log.inputs[index][2:])
+		}
+		if test.isPrecert && string(leaf.precert) != "precert 0" {
+			t.Errorf("%s: precertificate is %q", test.name, leaf.precert)
+		}
+	}
+}
+
+func TestStaticLogClientGetRawEntries(t *testing.T) {
+	const treeSize = 600
+	log := newTestStaticLog(t, treeSize)
+	client := log.serve(t, treeSize)
+	ctx := context.Background()
+	for _, test := range [][2]uint64{{0, 0}, {0, 599}, {250, 260}, {255, 256}, {256, 511}, {590, 599}} {
+		entries, err := client.GetRawEntries(ctx, test[0], test[1])
+		if err != nil {
+			t.Errorf("GetRawEntries(%d, %d): %s", test[0], test[1], err)
+			continue
+		}
+		if uint64(len(entries)) != test[1]-test[0]+1 {
+			t.Errorf("GetRawEntries(%d, %d) returned %d entries", test[0], test[1], len(entries))
+			continue
+		}
+		for i, entry := range entries {
+			index := test[0] + uint64(i)
+			if !bytes.Equal(entry.LeafInput, log.inputs[index]) {
+				t.Errorf("GetRawEntries(%d, %d): entry %d has leaf input %x, want %x", test[0], test[1], index, entry.LeafInput, log.inputs[index])
+			}
+			var chain []ct.ASN1Cert
+			if index%3 == 0 {
+				chain, err = ct.UnmarshalPrecertChainArray(entry.ExtraData)
+				chain = chain[1:]
+			} else {
+				chain, err = ct.UnmarshalX509ChainArray(entry.ExtraData)
+			}
+			if err != nil || len(chain) != 1 || !bytes.Equal(chain[0], log.issuer) {
+				t.Errorf("GetRawEntries(%d, %d): entry %d has incorrect extra data (%v)", test[0], test[1], index, err)
+			}
+		}
+	}
+	if _, err := client.GetRawEntries(ctx, 599, 600); err == nil {
+		t.Errorf("GetRawEntries succeeded beyond the checkpoint")
+	}
+}
+
+func TestStaticLogClientGetCollapsedTree(t *testing.T) {
+	const treeSize = 70000 // three levels of tiles
+	log := newTestStaticLog(t, treeSize)
+	client := log.serve(t, treeSize)
+	for _, size := range []uint64{1, 2, 255, 256, 257, 511, 512, 600, 65535, 65536, 65537, treeSize} {
+		tree, err := client.GetCollapsedTree(context.Background(), size)
+		if err != nil {
+			t.Errorf("GetCollapsedTree(%d): %s", size, err)
+		} else if tree.Size() != size || tree.CalculateRoot() != merkleTreeHash(log.hashes[:size]) {
+			t.Errorf("GetCollapsedTree(%d) returned incorrect tree of size %d", size, tree.Size())
+		}
+	}
+	if _, err := client.GetCollapsedTree(context.Background(), treeSize+1); err == nil {
+		t.Errorf("GetCollapsedTree succeeded beyond the checkpoint")
+	}
+}
+
+func TestStaticLogClientGetConsistencyProof(t *testing.T) {
+	const treeSize = 1000
+	log := newTestStaticLog(t, treeSize)
+	client := log.serve(t, treeSize)
+	ctx := context.Background()
+	sizes := []uint64{1, 2, 3, 7, 8, 255, 256, 257, 300, 512, 513, 999, treeSize}
+	for _, first := range sizes {
+		for _, second := range sizes {
+			if second < first {
+				continue
+			}
+			rawProof, err := client.GetConsistencyProof(ctx, int64(first), int64(second))
+			if err != nil {
+				t.Errorf("GetConsistencyProof(%d, %d): %s", first, second, err)
+				continue
+			}
+			proof := make([]merkletree.Hash, len(rawProof))
+			for i := range proof {
+				if err := proof[i].UnmarshalBinary(rawProof[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := merkletree.VerifyConsistencyProof(first, second, merkleTreeHash(log.hashes[:first]), merkleTreeHash(log.hashes[:second]), proof); err != nil {
+				t.Errorf("GetConsistencyProof(%d, %d) returned invalid proof: %s", first, second, err)
+			}
+		}
+	}
+	for _, test := range [][2]int64{{-1, 5}, {5, 4}, {5, treeSize + 1}} {
+		if _, err := client.GetConsistencyProof(ctx, test[0], test[1]); err == nil {
+			t.Errorf("GetConsistencyProof(%d, %d) succeeded", test[0], test[1])
+		}
+	}
+}
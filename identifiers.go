@@ -129,11 +129,15 @@ func sanitizeDNSName(value string) string {
 }
 
 // Like sanitizeDNSName, but labels that are Unicode are converted to Punycode.
+// Labels are mapped as by a web browser (e.g. normalized to NFC) if they are
+// valid IDNs, so that they match the equivalent A-labels.
 func sanitizeUnicodeDNSName(value string) string {
 	value = strings.ToLower(trimTrailingDots(strings.TrimSpace(value)))
 	labels := strings.Split(value, ".")
 	for i, label := range labels {
-		if asciiLabel, err := idna.ToASCII(label); err == nil && isSaneDNSLabel(asciiLabel) {
+		if asciiLabel, err := idna.Lookup.ToASCII(label); err == nil && !strings.Contains(asciiLabel, ".") && isSaneDNSLabel(asciiLabel) {
+			labels[i] = asciiLabel
+		} else if asciiLabel, err := idna.ToASCII(label); err == nil && isSaneDNSLabel(asciiLabel) {
 			labels[i] = asciiLabel
 		} else {
 			labels[i] = UnparsableDNSLabelPlaceholder
@@ -291,8 +295,26 @@ func (cert *CertInfo) ParseIdentifiers() (*Identifiers, error) {
 		ids.AddCN(cn)
 	}
 
+	if err := cert.addSANIdentifiers(ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ParseSANIdentifiers is like ParseIdentifiers, but ignores the subject's
+// common names, returning only the identifiers in the subject alternative
+// names.
+func (cert *CertInfo) ParseSANIdentifiers() (*Identifiers, error) {
+	ids := NewIdentifiers()
+	if err := cert.addSANIdentifiers(ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (cert *CertInfo) addSANIdentifiers(ids *Identifiers) error {
 	if cert.SANsParseError != nil {
-		return nil, cert.SANsParseError
+		return cert.SANsParseError
 	}
 	for _, san := range cert.SANs {
 		switch san.Type {
@@ -312,5 +334,5 @@ func (cert *CertInfo) ParseIdentifiers() (*Identifiers, error) {
 		}
 	}
 
-	return ids, nil
+	return nil
 }
@@ -27,6 +27,13 @@ func (log *Log) LogIDString() string {
 	return log.LogID.Base64String()
 }
 
+// IsStaticCTAPI reports whether the log implements the static CT API
+// (https://c2sp.org/static-ct-api), and should be monitored by fetching
+// tiles from MonitoringURL instead of using the RFC 6962 API at URL.
+func (log *Log) IsStaticCTAPI() bool {
+	return log.MonitoringURL != ""
+}
+
 func (log *Log) AcceptsExpiration(expiration time.Time) bool {
 	return log.TemporalInterval == nil || withinInterval(expiration, log.TemporalInterval.StartInclusive, log.TemporalInterval.EndExclusive)
 }
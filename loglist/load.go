@@ -23,6 +23,16 @@ import (
 
 var UserAgent = "certspotter"
 
+// Headers contains extra headers to send when fetching log lists, keyed by
+// the lower-case host name (without port) to which they are sent.  Headers
+// are only sent to a log list URL whose host is in Headers, and not when the
+// request is redirected to a different host.
+var Headers map[string]http.Header
+
+// Transport is used to fetch log lists over HTTPS.  Replace it to use a
+// different proxy than the one specified by the environment.
+var Transport http.RoundTripper = http.DefaultTransport
+
 type ModificationToken struct {
 	etag     string
 	modified time.Time
@@ -73,10 +83,28 @@ func FetchIfModified(ctx context.Context, url string, token *ModificationToken)
 		return nil, nil, err
 	}
 	request.Header.Set("User-Agent", UserAgent)
+	header := Headers[strings.ToLower(request.URL.Hostname())]
+	for name, values := range header {
+		request.Header[name] = values
+	}
 	if token != nil {
 		token.setRequestHeaders(request)
 	}
-	response, err := http.DefaultClient.Do(request)
+	client := &http.Client{
+		Transport: Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Host != via[0].URL.Host {
+				for name := range header {
+					req.Header.Del(name)
+				}
+			}
+			return nil
+		},
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -22,9 +22,10 @@ type List struct {
 }
 
 type Operator struct {
-	Name  string   `json:"name"`
-	Email []string `json:"email"`
-	Logs  []Log    `json:"logs"`
+	Name      string   `json:"name"`
+	Email     []string `json:"email"`
+	Logs      []Log    `json:"logs"`
+	TiledLogs []Log    `json:"tiled_logs"` // Logs implementing the static CT API
 }
 
 type Log struct {
@@ -32,6 +33,8 @@ type Log struct {
 	LogID            ct.SHA256Hash `json:"log_id"`
 	MMD              int           `json:"mmd"`
 	URL              string        `json:"url"`
+	SubmissionURL    string        `json:"submission_url"` // Only for static-ct-api logs
+	MonitoringURL    string        `json:"monitoring_url"` // Only for static-ct-api logs
 	Description      string        `json:"description"`
 	State            State         `json:"state"`
 	DNS              string        `json:"dns"`
@@ -41,6 +44,10 @@ type Log struct {
 		EndExclusive   time.Time `json:"end_exclusive"`
 	} `json:"temporal_interval"`
 
+	// Name of the log's operator.  Not part of the log list JSON; set by
+	// certspotter when it loads the log list.
+	OperatorName string `json:"-"`
+
 	// TODO: add previous_operators
 }
 
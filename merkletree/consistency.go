@@ -0,0 +1,78 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// VerifyConsistencyProof verifies that proof proves that the tree of size
+// oldSize with root hash oldRoot is a prefix of the tree of size newSize with
+// root hash newRoot, using the algorithm in section 2.1.4.2 of RFC 9162.
+func VerifyConsistencyProof(oldSize, newSize uint64, oldRoot, newRoot Hash, proof []Hash) error {
+	switch {
+	case oldSize > newSize:
+		return fmt.Errorf("old tree size %d is larger than new tree size %d", oldSize, newSize)
+	case oldSize == newSize:
+		if len(proof) != 0 {
+			return errors.New("proof between trees of the same size is not empty")
+		}
+		if oldRoot != newRoot {
+			return errors.New("trees of the same size have different root hashes")
+		}
+		return nil
+	case oldSize == 0:
+		if len(proof) != 0 {
+			return errors.New("proof from the empty tree is not empty")
+		}
+		return nil
+	case len(proof) == 0:
+		return errors.New("proof is empty")
+	}
+
+	if bits.OnesCount64(oldSize) == 1 {
+		proof = append([]Hash{oldRoot}, proof...)
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = HashChildren(c, fr)
+			sr = HashChildren(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = HashChildren(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("proof is too short")
+	}
+	if fr != oldRoot {
+		return fmt.Errorf("calculated old root hash (%x) does not match expected (%x)", fr, oldRoot)
+	}
+	if sr != newRoot {
+		return fmt.Errorf("calculated new root hash (%x) does not match expected (%x)", sr, newRoot)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package merkletree
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The leaves, roots, and proofs of the reference tree used by the RFC 6962
+// test vectors in the Certificate Transparency implementations
+var testLeaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var testRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var testConsistencyProofs = []struct {
+	oldSize, newSize uint64
+	proof            []string
+}{
+	{1, 1, nil},
+	{1, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{6, 8, []string{
+		"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 5, []string{
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+func mustDecodeHash(t *testing.T, s string) Hash {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h Hash
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func testLeafHashes(t *testing.T, n int) []Hash {
+	t.Helper()
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		var leaf []byte
+		if i < len(testLeaves) {
+			var err error
+			if leaf, err = hex.DecodeString(testLeaves[i]); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			leaf = []byte{byte(i), byte(i >> 8)}
+		}
+		hashes[i] = HashLeaf(leaf)
+	}
+	return hashes
+}
+
+// treeHash computes MTH from RFC 6962 section 2.1
+func treeHash(leaves []Hash) Hash {
+	switch len(leaves) {
+	case 0:
+		return HashNothing()
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return HashChildren(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// subproof computes SUBPROOF from RFC 6962 section 2.1.2
+func subproof(m uint64, leaves []Hash, complete bool) []Hash {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []Hash{treeHash(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), treeHash(leaves[k:]))
+	}
+	return append(subproof(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
+
+func TestTreeHashVectors(t *testing.T) {
+	leaves := testLeafHashes(t, len(testRoots))
+	for i, root := range testRoots {
+		if got := treeHash(leaves[:i+1]); got != mustDecodeHash(t, root) {
+			t.Errorf("tree of size %d: root is %x; want %s", i+1, got, root)
+		}
+	}
+}
+
+func TestVerifyConsistencyProofVectors(t *testing.T) {
+	for _, test := range testConsistencyProofs {
+		oldRoot := mustDecodeHash(t, testRoots[test.oldSize-1])
+		newRoot := mustDecodeHash(t, testRoots[test.newSize-1])
+		proof := make([]Hash, len(test.proof))
+		for i := range test.proof {
+			proof[i] = mustDecodeHash(t, test.proof[i])
+		}
+		if err := VerifyConsistencyProof(test.oldSize, test.newSize, oldRoot, newRoot, proof); err != nil {
+			t.Errorf("%d -> %d: valid proof rejected: %s", test.oldSize, test.newSize, err)
+		}
+		testInvalidConsistencyProofs(t, test.oldSize, test.newSize, oldRoot, newRoot, proof)
+	}
+}
+
+func TestVerifyConsistencyProof(t *testing.T) {
+	const maxSize = 40
+	leaves := testLeafHashes(t, maxSize)
+	for newSize := uint64(1); newSize <= maxSize; newSize++ {
+		newRoot := treeHash(leaves[:newSize])
+		for oldSize := uint64(1); oldSize <= newSize; oldSize++ {
+			oldRoot := treeHash(leaves[:oldSize])
+			proof := subproof(oldSize, leaves[:newSize], true)
+			if err := VerifyConsistencyProof(oldSize, newSize, oldRoot, newRoot, proof); err != nil {
+				t.Errorf("%d -> %d: valid proof rejected: %s", oldSize, newSize, err)
+			}
+			testInvalidConsistencyProofs(t, oldSize, newSize, oldRoot, newRoot, proof)
+		}
+	}
+
+	if err := VerifyConsistencyProof(0, 5, HashNothing(), treeHash(leaves[:5]), nil); err != nil {
+		t.Errorf("0 -> 5: empty proof rejected: %s", err)
+	}
+	if err := VerifyConsistencyProof(0, 5, HashNothing(), treeHash(leaves[:5]), []Hash{HashNothing()}); err == nil {
+		t.Errorf("0 -> 5: non-empty proof accepted")
+	}
+	if err := VerifyConsistencyProof(5, 3, treeHash(leaves[:5]), treeHash(leaves[:3]), subproof(3, leaves[:5], true)); err == nil {
+		t.Errorf("5 -> 3: proof accepted")
+	}
+}
+
+func testInvalidConsistencyProofs(t *testing.T, oldSize, newSize uint64, oldRoot, newRoot Hash, proof []Hash) {
+	t.Helper()
+	reject := func(what string, oldRoot, newRoot Hash, proof []Hash) {
+		t.Helper()
+		if err := VerifyConsistencyProof(oldSize, newSize, oldRoot, newRoot, proof); err == nil {
+			t.Errorf("%d -> %d: %s accepted", oldSize, newSize, what)
+		}
+	}
+
+	wrongRoot := HashLeaf([]byte("wrong"))
+	if oldSize != newSize {
+		reject("wrong old root", wrongRoot, newRoot, proof)
+	}
+	reject("wrong new root", oldRoot, wrongRoot, proof)
+	reject("extended proof", oldRoot, newRoot, append(append([]Hash(nil), proof...), wrongRoot))
+	if len(proof) > 0 {
+		reject("truncated proof", oldRoot, newRoot, proof[:len(proof)-1])
+		reject("empty proof", oldRoot, newRoot, nil)
+	}
+	for i := range proof {
+		modified := append([]Hash(nil), proof...)
+		modified[i][0] ^= 1
+		reject("modified proof", oldRoot, newRoot, modified)
+	}
+}
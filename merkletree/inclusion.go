@@ -0,0 +1,49 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// VerifyInclusionProof verifies that proof proves that the leaf with hash
+// leafHash is at position index in the tree of size treeSize with root hash
+// root, using the algorithm in section 2.1.3.2 of RFC 9162.
+func VerifyInclusionProof(index, treeSize uint64, leafHash, root Hash, proof []Hash) error {
+	if index >= treeSize {
+		return fmt.Errorf("leaf index %d is not less than tree size %d", index, treeSize)
+	}
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New("proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = HashChildren(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = HashChildren(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("proof is too short")
+	}
+	if r != root {
+		return fmt.Errorf("calculated root hash (%x) does not match expected (%x)", r, root)
+	}
+	return nil
+}
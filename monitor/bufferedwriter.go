@@ -0,0 +1,106 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// BufferedWriter writes to an underlying writer (such as stdout) from a
+// background goroutine, so that callers of Write aren't blocked on system
+// calls when many records are written at once.  Each call to Write is one
+// record, which is written to the underlying writer with a single call to
+// its Write method, so records are never interleaved.  Up to a fixed number
+// of records are buffered; once the buffer is full, Write blocks until there
+// is room.
+//
+// The buffer is flushed and the background goroutine stops when Close is
+// called or the context passed to NewBufferedWriter is done.  After that,
+// Write writes to the underlying writer directly, so no records are dropped
+// (e.g. notifications sent while shutting down).
+type BufferedWriter struct {
+	w       io.Writer
+	records chan []byte
+	stopped chan struct{} // closed once the background goroutine has written every record
+
+	mu     sync.RWMutex // held for reading while sending to records, and for writing to close records or write directly
+	closed bool
+	err    error // the first error returned by w, if any; only accessed by run until stopped is closed
+}
+
+// NewBufferedWriter returns a BufferedWriter which buffers up to size
+// records before writing them to w.
+func NewBufferedWriter(ctx context.Context, w io.Writer, size int) *BufferedWriter {
+	b := &BufferedWriter{
+		w:       w,
+		records: make(chan []byte, size),
+		stopped: make(chan struct{}),
+	}
+	go b.run()
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.Close()
+		case <-b.stopped:
+		}
+	}()
+	return b
+}
+
+func (b *BufferedWriter) run() {
+	defer close(b.stopped)
+	for record := range b.records {
+		if _, err := b.w.Write(record); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+}
+
+// Write buffers a copy of p to be written to the underlying writer.  It
+// never returns an error unless the BufferedWriter has been closed, in
+// which case it returns the error from the underlying writer.
+func (b *BufferedWriter) Write(p []byte) (int, error) {
+	b.mu.RLock()
+	if !b.closed {
+		b.records <- bytes.Clone(p)
+		b.mu.RUnlock()
+		return len(p), nil
+	}
+	b.mu.RUnlock()
+
+	// Don't write ahead of records which are still being flushed
+	<-b.stopped
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Write(p)
+}
+
+// Sync does nothing; it exists so a BufferedWriter can be used as a
+// zapcore.WriteSyncer.  Use Close to flush the buffer.
+func (b *BufferedWriter) Sync() error {
+	return nil
+}
+
+// Close writes every buffered record to the underlying writer and stops the
+// background goroutine.  It returns the first error returned by the
+// underlying writer.  Close may be called more than once.
+func (b *BufferedWriter) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.records)
+	}
+	b.mu.Unlock()
+	<-b.stopped
+	return b.err
+}
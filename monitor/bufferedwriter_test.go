@@ -0,0 +1,53 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBufferedWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := new(bytes.Buffer)
+	b := NewBufferedWriter(ctx, out, 2)
+
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("record %d\n", i)
+		want.WriteString(line)
+		if _, err := b.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Canceling the context must flush every buffered record
+	cancel()
+	<-b.stopped
+	if out.String() != want.String() {
+		t.Fatalf("wrote %q; want %q", out.String(), want.String())
+	}
+
+	// Records written after shutdown must be written directly
+	if _, err := b.Write([]byte("after shutdown\n")); err != nil {
+		t.Fatal(err)
+	}
+	want.WriteString("after shutdown\n")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want.String() {
+		t.Errorf("wrote %q; want %q", out.String(), want.String())
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"software.sslmate.com/src/certspotter"
+)
+
+// DefaultCertFields are the fields included in the JSON file of a discovered
+// certificate when no fields are configured.
+var DefaultCertFields = []string{"tbs_sha256", "pubkey_sha256", "dns_names", "ip_addresses", "not_before", "not_after"}
+
+type certField func(*DiscoveredCert) any
+
+var certFields = map[string]certField{
+	"tbs_sha256":    func(cert *DiscoveredCert) any { return hex.EncodeToString(cert.TBSSHA256[:]) },
+	"cert_sha256":   func(cert *DiscoveredCert) any { return hex.EncodeToString(cert.SHA256[:]) },
+	"pubkey_sha256": func(cert *DiscoveredCert) any { return hex.EncodeToString(cert.PubkeySHA256[:]) },
+	"dns_names":     func(cert *DiscoveredCert) any { return cert.Identifiers.DNSNames },
+	"ip_addresses":  func(cert *DiscoveredCert) any { return cert.Identifiers.IPAddrs },
+	"not_before": func(cert *DiscoveredCert) any {
+		if cert.Info.ValidityParseError != nil {
+			return nil
+		}
+		return cert.Info.Validity.NotBefore
+	},
+	"not_after": func(cert *DiscoveredCert) any {
+		if cert.Info.ValidityParseError != nil {
+			return nil
+		}
+		return cert.Info.Validity.NotAfter
+	},
+	"serial": func(cert *DiscoveredCert) any {
+		if cert.Info.SerialNumberParseError != nil {
+			return nil
+		}
+		return fmt.Sprintf("%x", cert.Info.SerialNumber)
+	},
+	"subject.dn": func(cert *DiscoveredCert) any {
+		if cert.Info.SubjectParseError != nil {
+			return nil
+		}
+		return cert.Info.Subject.String()
+	},
+	"issuer.dn": func(cert *DiscoveredCert) any {
+		if cert.Info.IssuerParseError != nil {
+			return nil
+		}
+		return cert.Info.Issuer.String()
+	},
+	"san.dns": func(cert *DiscoveredCert) any { return sansOfType(cert.Info, "DNS:") },
+	"san.ip":  func(cert *DiscoveredCert) any { return sansOfType(cert.Info, "IP:") },
+	"registrable_domains": func(cert *DiscoveredCert) any {
+		return registrableDomains(cert.Identifiers.DNSNames)
+	},
+	"sct_list": func(cert *DiscoveredCert) any {
+		if cert.EmbeddedSCTsParseError != nil {
+			return nil
+		}
+		list := make([]map[string]any, len(cert.EmbeddedSCTs))
+		for i, sct := range cert.EmbeddedSCTs {
+			list[i] = map[string]any{
+				"log_id":    sct.LogID.Base64String(),
+				"timestamp": time.UnixMilli(int64(sct.Timestamp)).UTC(),
+			}
+		}
+		return list
+	},
+	"raw_der": func(cert *DiscoveredCert) any { return base64.StdEncoding.EncodeToString(cert.Chain[0]) },
+	"raw_der_chain": func(cert *DiscoveredCert) any {
+		chain := make([]string, len(cert.Chain))
+		for i, certBytes := range cert.Chain {
+			chain[i] = base64.StdEncoding.EncodeToString(certBytes)
+		}
+		return chain
+	},
+	"crl_dps": func(cert *DiscoveredCert) any {
+		uris, err := cert.Info.TBS.ParseCRLDistributionPoints()
+		if err != nil {
+			return nil
+		}
+		return uris
+	},
+}
+
+var rdnFieldLabels = []string{"cn", "o", "ou", "c", "l", "st"}
+
+func init() {
+	for _, label := range rdnFieldLabels {
+		certFields["subject."+label] = rdnField(label, func(info *certspotter.CertInfo) (certspotter.RDNSequence, error) {
+			return info.Subject, info.SubjectParseError
+		})
+		certFields["issuer."+label] = rdnField(label, func(info *certspotter.CertInfo) (certspotter.RDNSequence, error) {
+			return info.Issuer, info.IssuerParseError
+		})
+	}
+}
+
+func rdnField(label string, getRDNs func(*certspotter.CertInfo) (certspotter.RDNSequence, error)) certField {
+	return func(cert *DiscoveredCert) any {
+		rdns, err := getRDNs(cert.Info)
+		if err != nil {
+			return nil
+		}
+		values, err := rdns.ParseAttributes(label)
+		if err != nil {
+			return nil
+		}
+		return values
+	}
+}
+
+func sansOfType(info *certspotter.CertInfo, prefix string) any {
+	if info.SANsParseError != nil {
+		return nil
+	}
+	values := []string{}
+	for _, san := range info.SANs {
+		if str := san.String(); strings.HasPrefix(str, prefix) {
+			values = append(values, strings.TrimPrefix(str, prefix))
+		}
+	}
+	return values
+}
+
+// CertFieldNames returns the sorted names of all fields which can be
+// extracted from a discovered certificate.
+func CertFieldNames() []string {
+	names := make([]string, 0, len(certFields))
+	for name := range certFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseCertFields parses a comma-separated list of certificate field names.
+func ParseCertFields(str string) ([]string, error) {
+	var fields []string
+	for _, name := range strings.Split(str, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := certFields[name]; !ok {
+			return nil, fmt.Errorf("unknown certificate field %q (valid fields are: %s)", name, strings.Join(CertFieldNames(), ", "))
+		}
+		if !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+func (cert *DiscoveredCert) extractFields(fields []string) map[string]any {
+	if len(fields) == 0 {
+		fields = DefaultCertFields
+	}
+	object := make(map[string]any, len(fields))
+	for _, name := range fields {
+		object[name] = certFields[name](cert)
+	}
+	object["matched_watch_item"] = cert.WatchItem.String()
+	if cert.MatchedIdentifier != "" {
+		object["matched_identifier"] = cert.MatchedIdentifier
+		object["match_source"] = cert.MatchSource
+	}
+	if cert.Sightings != nil {
+		sightings := make([]map[string]any, len(cert.Sightings))
+		for i, entry := range cert.Sightings {
+			sightings[i] = map[string]any{"log_uri": entry.Log.URL, "entry_index": entry.Index}
+		}
+		object["sightings"] = sightings
+	}
+	if cert.DNSResolutions != nil {
+		object["dns_resolutions"] = cert.DNSResolutions
+	}
+	if cert.Anomalies != nil {
+		object["anomalies"] = cert.Anomalies
+	}
+	if cert.InsufficientSCTs {
+		object["insufficient_scts"] = true
+	}
+	if cert.OverlongValidity {
+		object["overlong_validity"] = true
+	}
+	if cert.InclusionTreeSize != 0 {
+		object["inclusion_verified"] = cert.InclusionError == nil
+		object["inclusion_tree_size"] = cert.InclusionTreeSize
+	}
+	return object
+}
+
+// certFieldsJson returns the given fields as zap fields, so that the fields
+// configured with -cert_fields are included in the JSON output and webhook
+// payload along with the cert's Json().  If fields is empty, nothing is
+// returned, since Json() already contains the default fields.
+func certFieldsJson(cert *DiscoveredCert, fields []string) []zap.Field {
+	jsonFields := make([]zap.Field, 0, len(fields))
+	for _, name := range fields {
+		jsonFields = append(jsonFields, zap.Any(name, certFields[name](cert)))
+	}
+	return jsonFields
+}
+
+// certFieldsEnviron returns an environment variable for each of the given
+// fields, named CERT_FIELD_ followed by the upper-cased field name with dots
+// replaced by underscores.
+func certFieldsEnviron(cert *DiscoveredCert, fields []string) []string {
+	var env []string
+	for _, name := range fields {
+		value := certFields[name](cert)
+		if value == nil {
+			continue
+		}
+		varName := "CERT_FIELD_" + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+		env = append(env, varName+"="+formatEnvValue(value))
+	}
+	return env
+}
+
+func formatEnvValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []string:
+		return strings.Join(value, ",")
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(jsonBytes)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+func TestParseCertFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"dns_names", []string{"dns_names"}, true},
+		{" DNS_Names , serial,,subject.cn ", []string{"dns_names", "serial", "subject.cn"}, true},
+		{"serial,serial", []string{"serial"}, true},
+		{"sct_list,crl_dps", []string{"sct_list", "crl_dps"}, true},
+		{"dns_names,bogus", nil, false},
+		{"subject.xx", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseCertFields(test.in)
+		if test.ok && err != nil {
+			t.Errorf("%q: unexpected error: %s", test.in, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%q: got %v, want error", test.in, got)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+// TestCertFieldSCTList checks that sct_list contains the SCTs embedded in a
+// certificate, even though they are removed from the TBSCertificate which
+// is notified about.
+func TestCertFieldSCTList(t *testing.T) {
+	sct, err := ct.SerializeSCT(ct.SignedCertificateTimestamp{
+		SCTVersion: ct.V1,
+		LogID:      ct.SHA256Hash{7},
+		Timestamp:  1700000000000,
+		Signature:  ct.DigitallySigned{HashAlgorithm: ct.SHA256, SignatureAlgorithm: ct.ECDSA, Signature: []byte{1, 2, 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := binary.BigEndian.AppendUint16(nil, uint16(2+len(sct)))
+	list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+	list = append(list, sct...)
+	extValue, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		DNSNames:        []string{"www.example.com"},
+		NotBefore:       time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC),
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}, Value: extValue}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notified *DiscoveredCert
+	config := &Config{
+		WatchList: mustReadWatchList(t, ".example.com"),
+		State:     &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = cert }},
+	}
+	entry := &LogEntry{Log: &loglist.Log{URL: "https://log.example/"}, ExtraData: []byte{0, 0, 0}}
+	if err := processX509LogEntry(context.Background(), config, entry, time.Now(), der); err != nil {
+		t.Fatal(err)
+	} else if notified == nil {
+		t.Fatal("certificate wasn't notified about")
+	}
+	scts, ok := notified.extractFields([]string{"sct_list"})["sct_list"].([]map[string]any)
+	if !ok || len(scts) != 1 {
+		t.Fatalf("sct_list is %#v; want one SCT", scts)
+	}
+	if scts[0]["log_id"] != (ct.SHA256Hash{7}).Base64String() || scts[0]["timestamp"] != time.UnixMilli(1700000000000).UTC() {
+		t.Errorf("sct_list contains %v", scts[0])
+	}
+}
+
+func TestCertFieldsInWebhookPayload(t *testing.T) {
+	server, requests := newRecordingServer(t, http.StatusOK)
+	state := &FilesystemState{StateDir: t.TempDir(), Webhook: server.URL, CertFields: []string{"serial", "san.dns"}}
+	ctx := context.Background()
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.NotifyCert(ctx, makeHeldTestCert(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	var payload webhookPayload
+	decodeJSONBody(t, <-requests, &payload)
+	if serial, _ := payload.Fields["serial"].(string); serial == "" {
+		t.Errorf("serial field is %v", payload.Fields["serial"])
+	}
+	if sans, _ := payload.Fields["san.dns"].([]any); len(sans) != 1 || sans[0] != "www.example.com" {
+		t.Errorf("san.dns field is %v", payload.Fields["san.dns"])
+	}
+	if _, ok := payload.Fields["tbs_sha256"]; ok {
+		t.Errorf("payload contains tbs_sha256, which wasn't configured")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CertFilenameData contains the fields which are available to a certificate
+// filename template.
+type CertFilenameData struct {
+	SHA256    string    // hex-encoded SHA-256 fingerprint of the certificate
+	TBSSHA256 string    // hex-encoded SHA-256 hash of the TBSCertificate
+	Domain    string    // first DNS name, or else first IP address, or else SHA256
+	Serial    string    // hex-encoded serial number; empty if unparsable
+	NotBefore time.Time // zero if unparsable
+	Type      string    // "cert" or "precert"
+}
+
+func makeCertFilenameData(cert *DiscoveredCert) *CertFilenameData {
+	data := &CertFilenameData{
+		SHA256:    hex.EncodeToString(cert.SHA256[:]),
+		TBSSHA256: hex.EncodeToString(cert.TBSSHA256[:]),
+		Domain:    primaryName(cert),
+		Type:      "cert",
+	}
+	if cert.Info.SerialNumberParseError == nil {
+		data.Serial = fmt.Sprintf("%x", cert.Info.SerialNumber)
+	}
+	if cert.Info.ValidityParseError == nil {
+		data.NotBefore = cert.Info.Validity.NotBefore
+	}
+	if cert.IsPrecert {
+		data.Type = "precert"
+	}
+	return data
+}
+
+// ParseCertFilenameTemplate parses a template for the paths, relative to the
+// certs directory and without an extension, at which discovered certificates
+// are saved.  The template is executed with a *CertFilenameData.
+func ParseCertFilenameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("cert_filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeCertFilenameTemplate(tmpl, &CertFilenameData{SHA256: strings.Repeat("00", 32)}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// executeCertFilenameTemplate returns the sanitized output of tmpl, which
+// is a slash-separated relative path that does not contain any empty, ".",
+// or ".." components.  Characters other than letters, digits, '-', '_', and
+// '.' are replaced with '_', as is a leading '.' in a component.
+func executeCertFilenameTemplate(tmpl *template.Template, data *CertFilenameData) (string, error) {
+	output := new(strings.Builder)
+	if err := tmpl.Execute(output, data); err != nil {
+		return "", err
+	}
+	var components []string
+	for _, component := range strings.Split(output.String(), "/") {
+		if component == "" {
+			continue
+		}
+		components = append(components, sanitizeFilename(component))
+	}
+	if len(components) == 0 {
+		return "", errors.New("template produced an empty filename")
+	}
+	return path.Join(components...), nil
+}
+
+func sanitizeFilename(component string) string {
+	sanitized := []byte(component)
+	for i, c := range sanitized {
+		isAllowed := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || (c == '.' && i > 0)
+		if !isAllowed {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func TestCertFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		domain   string
+		want     string
+	}{
+		{"{{.Domain}}/{{.SHA256}}", "www.example.com", "www.example.com/abcd"},
+		{"{{.Domain}}/{{.SHA256}}", "*.example.com", "_.example.com/abcd"},
+		{"{{.Domain}}/{{.SHA256}}", "..", "_./abcd"},
+		{"{{.Domain}}/{{.SHA256}}", "../../etc", "_./_./etc/abcd"},
+		{"/{{.Domain}}//{{.SHA256}}", ".hidden", "_hidden/abcd"},
+		{"{{.Domain}}\\{{.SHA256}}", "example.com", "example.com_abcd"},
+		{"{{.Type}}-{{.SHA256}}", "example.com", "cert-abcd"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseCertFilenameTemplate(test.template)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.template, err)
+		}
+		got, err := executeCertFilenameTemplate(tmpl, &CertFilenameData{SHA256: "abcd", Domain: test.domain, Type: "cert"})
+		if err != nil {
+			t.Errorf("%q with domain %q: unexpected error: %s", test.template, test.domain, err)
+		} else if got != test.want {
+			t.Errorf("%q with domain %q: got %q, want %q", test.template, test.domain, got, test.want)
+		}
+	}
+
+	for _, template := range []string{"{{.Nonexistent}}", "{{", "/"} {
+		if _, err := ParseCertFilenameTemplate(template); err == nil {
+			t.Errorf("%q: expected error", template)
+		}
+	}
+}
+
+func TestFilesystemStateCertFilenameTemplateNotified(t *testing.T) {
+	ctx := context.Background()
+	out := new(bytes.Buffer)
+	state := &FilesystemState{StateDir: t.TempDir(), SaveCerts: true, Stdout: true, StdoutWriter: out}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The template doesn't include .SHA256, so both certificates are saved
+	// at the same path
+	tmpl, err := ParseCertFilenameTemplate("certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.CertFilenameTemplate = tmpl
+
+	cert1, cert2 := makeTestCert(t, "", "www.example.com"), makeTestCert(t, "", "www.example.com")
+	for _, cert := range []*DiscoveredCert{cert1, cert2} {
+		cert.SHA256 = sha256.Sum256(cert.Chain[0])
+		cert.WatchItem = mustReadWatchList(t, ".example.com").Items()[0]
+		cert.Identifiers = &certspotter.Identifiers{DNSNames: []string{"www.example.com"}}
+	}
+	notified := func() int {
+		return strings.Count(out.String(), fmt.Sprintf("%x:\n", cert1.SHA256)) + strings.Count(out.String(), fmt.Sprintf("%x:\n", cert2.SHA256))
+	}
+
+	for _, cert := range []*DiscoveredCert{cert1, cert2, cert1} {
+		if err := state.NotifyCert(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := notified(); n != 2 {
+		t.Fatalf("notified %d times, want once for each certificate", n)
+	}
+
+	// Changing the template must not cause certificates to be notified again
+	if state.CertFilenameTemplate, err = ParseCertFilenameTemplate("certificates/{{.SHA256}}"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cert := range []*DiscoveredCert{cert1, cert2} {
+		if err := state.NotifyCert(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := notified(); n != 2 {
+		t.Errorf("notified %d times after changing the template, want 2", n)
+	}
+}
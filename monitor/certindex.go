@@ -0,0 +1,261 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The cert index is a file containing one JSON object (a CertIndexRecord) per
+// line for every certificate saved in the state directory.  It is appended to
+// each time a certificate is saved, and lets the saved certificates be
+// searched without reading every file under the certs directory.
+//
+// To search, the file is read into a CertIndex, which maps each DNS name and
+// fingerprint to the records containing it.  A query term which is exactly a
+// DNS name or fingerprint is looked up in these maps, and only the remaining
+// terms are matched as substrings against the candidate records.
+//
+// This is deliberately not a full-text index such as Bleve or SQLite FTS:
+// those would be certspotter's largest dependencies (and SQLite needs cgo),
+// while substring queries can't be answered by a token-based full-text
+// index anyway.
+
+var certIndexMu sync.Mutex
+
+type CertIndexRecord struct {
+	SHA256      string     `json:"sha256"`
+	DNSNames    []string   `json:"dns_names"`
+	IPAddresses []string   `json:"ip_addresses"`
+	Issuer      string     `json:"issuer,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	LogURI      string     `json:"log_uri"`
+	EntryIndex  uint64     `json:"entry_index"`
+	Filename    string     `json:"filename"`
+}
+
+func certIndexPath(stateDir string) string {
+	return filepath.Join(stateDir, "certs", "index.jsonl")
+}
+
+func makeCertIndexRecord(cert *DiscoveredCert, paths *certPaths) *CertIndexRecord {
+	record := &CertIndexRecord{
+		SHA256:      hex.EncodeToString(cert.SHA256[:]),
+		DNSNames:    cert.Identifiers.DNSNames,
+		IPAddresses: ips(cert.Identifiers.IPAddrs),
+		LogURI:      cert.LogEntry.Log.URL,
+		EntryIndex:  cert.LogEntry.Index,
+		Filename:    paths.certPath,
+	}
+	if cert.Info.IssuerParseError == nil {
+		record.Issuer = cert.Info.Issuer.String()
+	}
+	if cert.Info.SubjectParseError == nil {
+		record.Subject = cert.Info.Subject.String()
+	}
+	if cert.Info.ValidityParseError == nil {
+		record.NotBefore = &cert.Info.Validity.NotBefore
+		record.NotAfter = &cert.Info.Validity.NotAfter
+	}
+	return record
+}
+
+func appendToCertIndex(stateDir string, record *CertIndexRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	recordBytes = append(recordBytes, '\n')
+
+	certIndexMu.Lock()
+	defer certIndexMu.Unlock()
+
+	file, err := os.OpenFile(certIndexPath(stateDir), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("error opening cert index: %w", err)
+	}
+	if _, err := file.Write(recordBytes); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing to cert index: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing to cert index: %w", err)
+	}
+	return nil
+}
+
+func (record *CertIndexRecord) searchText() string {
+	fields := []string{record.SHA256, record.Issuer, record.Subject, record.LogURI}
+	fields = append(fields, record.DNSNames...)
+	fields = append(fields, record.IPAddresses...)
+	return strings.ToLower(strings.Join(fields, "\n"))
+}
+
+// Matches reports whether every whitespace-separated term in query is a
+// case-insensitive substring of the record's DNS names, IP addresses,
+// issuer, subject, log URI, or fingerprint.
+func (record *CertIndexRecord) Matches(query string) bool {
+	return record.matchesTerms(strings.Fields(strings.ToLower(query)))
+}
+
+func (record *CertIndexRecord) matchesTerms(terms []string) bool {
+	text := record.searchText()
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// CertIndex is the cert index of a state directory, loaded into memory.
+type CertIndex struct {
+	records   []*CertIndexRecord
+	bySHA256  map[string][]int // fingerprint => indexes into records
+	byDNSName map[string][]int // lower-cased DNS name => indexes into records
+}
+
+// LoadCertIndex reads the cert index of stateDir into memory.  A
+// non-existent index is treated as empty.
+func LoadCertIndex(stateDir string) (*CertIndex, error) {
+	index := &CertIndex{
+		bySHA256:  make(map[string][]int),
+		byDNSName: make(map[string][]int),
+	}
+	file, err := os.Open(certIndexPath(stateDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		record := new(CertIndexRecord)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("error parsing line %d of %s: %w", lineNo, certIndexPath(stateDir), err)
+		}
+		index.add(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (index *CertIndex) add(record *CertIndexRecord) {
+	i := len(index.records)
+	index.records = append(index.records, record)
+	addToIndexMap(index.bySHA256, strings.ToLower(record.SHA256), i)
+	for _, dnsName := range record.DNSNames {
+		addToIndexMap(index.byDNSName, strings.ToLower(dnsName), i)
+	}
+}
+
+func addToIndexMap(m map[string][]int, key string, i int) {
+	if list := m[key]; len(list) == 0 || list[len(list)-1] != i {
+		m[key] = append(list, i)
+	}
+}
+
+// lookup returns the indexes of the records which have term (which must be
+// lower-case) as their fingerprint or one of their DNS names, in ascending
+// order, or false if no record does.
+func (index *CertIndex) lookup(term string) ([]int, bool) {
+	if list, ok := index.bySHA256[term]; ok {
+		return list, true
+	}
+	list, ok := index.byDNSName[term]
+	return list, ok
+}
+
+// Search calls fn for every record which matches query, in the order the
+// certificates were saved.  A term in query which is exactly the
+// fingerprint or one of the DNS names of some certificate matches only
+// those certificates; any other term matches as described by Matches.
+func (index *CertIndex) Search(query string, fn func(*CertIndexRecord) error) error {
+	var (
+		candidates []int
+		narrowed   bool
+		terms      []string
+	)
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if list, ok := index.lookup(term); !ok {
+			terms = append(terms, term)
+		} else if !narrowed {
+			candidates, narrowed = list, true
+		} else {
+			candidates = intersectSorted(candidates, list)
+		}
+	}
+
+	check := func(record *CertIndexRecord) error {
+		if !record.matchesTerms(terms) {
+			return nil
+		}
+		return fn(record)
+	}
+	if narrowed {
+		for _, i := range candidates {
+			if err := check(index.records[i]); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, record := range index.records {
+			if err := check(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func intersectSorted(a, b []int) []int {
+	var result []int
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			result = append(result, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return result
+}
+
+// SearchCertIndex loads the cert index of stateDir and calls fn for every
+// record which matches query, as described by CertIndex.Search.
+func SearchCertIndex(stateDir string, query string, fn func(*CertIndexRecord) error) error {
+	index, err := LoadCertIndex(stateDir)
+	if err != nil {
+		return err
+	}
+	return index.Search(query, fn)
+}
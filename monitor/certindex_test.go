@@ -0,0 +1,150 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCertIndexRecordMatches(t *testing.T) {
+	record := &CertIndexRecord{
+		SHA256:      "5f4a6e9b",
+		DNSNames:    []string{"www.example.com", "api.example.net"},
+		IPAddresses: []string{"192.0.2.1"},
+		Issuer:      "CN=Example CA, O=Example",
+		Subject:     "CN=www.example.com",
+		LogURI:      "https://log.example/2026/",
+	}
+	for _, test := range []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"example.com", true},
+		{"API.EXAMPLE", true},
+		{"192.0.2", true},
+		{"example ca", true},
+		{"5F4A", true},
+		{"log.example/2026", true},
+		{"www.example.com 192.0.2.1", true},
+		{"www.example.com example.org", false},
+		{"198.51.100.1", false},
+	} {
+		if got := record.Matches(test.query); got != test.want {
+			t.Errorf("Matches(%q) = %t, want %t", test.query, got, test.want)
+		}
+	}
+}
+
+func TestCertIndexSearchLookup(t *testing.T) {
+	index := &CertIndex{bySHA256: make(map[string][]int), byDNSName: make(map[string][]int)}
+	for _, record := range []*CertIndexRecord{
+		{SHA256: "aa01", DNSNames: []string{"www.example.com", "WWW.example.com"}, Issuer: "CN=CA 1"},
+		{SHA256: "aa02", DNSNames: []string{"www.example.com.au"}, Issuer: "CN=CA 1"},
+		{SHA256: "aa03", DNSNames: []string{"www.example.com", "mail.example.com"}, Issuer: "CN=CA 2"},
+		{SHA256: "aa04", DNSNames: []string{"mail.example.com"}, Issuer: "CN=CA 1"},
+	} {
+		index.add(record)
+	}
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"", "aa01 aa02 aa03 aa04"},
+		{"www.example.com", "aa01 aa03"},
+		{"WWW.EXAMPLE", "aa01 aa02 aa03"},
+		{"AA02", "aa02"},
+		{"aa0", "aa01 aa02 aa03 aa04"},
+		{"www.example.com mail.example.com", "aa03"},
+		{"mail.example.com ca 1", "aa04"},
+		{"www.example.com aa04", ""},
+	} {
+		var got []string
+		if err := index.Search(test.query, func(record *CertIndexRecord) error {
+			got = append(got, record.SHA256)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("Search(%q) returned %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSearchCertIndex(t *testing.T) {
+	ctx := context.Background()
+	stateDir := filepath.Join(t.TempDir(), "state")
+	state := &FilesystemState{StateDir: stateDir, SaveCerts: true}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string) []*CertIndexRecord {
+		t.Helper()
+		var records []*CertIndexRecord
+		if err := SearchCertIndex(stateDir, query, func(record *CertIndexRecord) error {
+			records = append(records, record)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	if records := search("example"); len(records) != 0 {
+		t.Errorf("found %d records before any certificates were saved", len(records))
+	}
+
+	var certs []*DiscoveredCert
+	for _, dnsName := range []string{"www.example.com", "mail.example.org", "www.example.net"} {
+		cert := makeHeldTestCert(t, dnsName)
+		if err := state.NotifyCert(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	records := search("WWW.EXAMPLE")
+	if len(records) != 2 || records[0].DNSNames[0] != "www.example.com" || records[1].DNSNames[0] != "www.example.net" {
+		t.Fatalf("search returned %v; want the www certificates in the order they were saved", records)
+	}
+	if records[0].LogURI != "https://log.example/" || records[0].EntryIndex != 42 || records[0].NotAfter == nil {
+		t.Errorf("record is missing fields: %+v", records[0])
+	}
+	if !fileExists(records[0].Filename) {
+		t.Errorf("record refers to %s, which doesn't exist", records[0].Filename)
+	}
+	if records := search("example.org mail"); len(records) != 1 {
+		t.Errorf("search returned %d records; want 1", len(records))
+	}
+
+	// Notifying about a certificate again must not index it twice
+	if err := state.NotifyCert(ctx, certs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if records := search("www.example.com"); len(records) != 1 {
+		t.Errorf("search returned %d records after notifying again; want 1", len(records))
+	}
+
+	file, err := os.OpenFile(certIndexPath(stateDir), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{truncated\n")
+	file.Close()
+	err = SearchCertIndex(stateDir, "", func(*CertIndexRecord) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("searching a corrupt index returned %v; want an error about line 4", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const coalesceNotifyTimeout = 1 * time.Minute
+
+// coalescingState is a StateProvider which delays the notification about a
+// discovered certificate until window has elapsed since the certificate was
+// first seen, and then sends a single notification listing every log entry
+// in which the certificate was seen during the window.  The pending
+// certificates are stored using StoreHeldCert (if the StateProvider
+// implements heldCertStore), so they are notified about after certspotter
+// restarts if it exits before the window elapses.
+type coalescingState struct {
+	StateProvider
+	window time.Duration
+	config *Config // used to record errors which occur after NotifyCert returns
+
+	mu      sync.Mutex
+	pending map[[32]byte]*pendingCert
+	wg      sync.WaitGroup
+}
+
+func (s *coalescingState) unwrapState() StateProvider { return s.StateProvider }
+
+type pendingCert struct {
+	cert  *DiscoveredCert
+	timer *time.Timer
+}
+
+// newCoalescingState returns a coalescingState which starts out with the
+// certificates that were pending when certspotter last exited.
+func newCoalescingState(ctx context.Context, config *Config, window time.Duration) (*coalescingState, error) {
+	s := &coalescingState{
+		StateProvider: config.State,
+		window:        window,
+		config:        config,
+		pending:       make(map[[32]byte]*pendingCert),
+	}
+	certs, err := loadHeldCerts(ctx, config.State, heldCertsCoalesce)
+	if err != nil {
+		return nil, classifyError(fmt.Errorf("error loading certificates held for coalescing: %w", err), ErrState)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cert := range certs {
+		s.hold(cert)
+	}
+	return s, nil
+}
+
+func (s *coalescingState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pending, ok := s.pending[cert.SHA256]; ok {
+		sightings := append(slices.Clip(pending.cert.Sightings), cert.LogEntry)
+		updated := *pending.cert
+		updated.Sightings = sightings
+		if err := storeHeldCert(ctx, s.StateProvider, heldCertsCoalesce, &updated); err != nil {
+			return fmt.Errorf("error storing certificate held for coalescing: %w", err)
+		}
+		pending.cert.Sightings = sightings
+		return nil
+	}
+
+	cert.Sightings = []*LogEntry{cert.LogEntry}
+	if err := storeHeldCert(ctx, s.StateProvider, heldCertsCoalesce, cert); err != nil {
+		return fmt.Errorf("error storing certificate held for coalescing: %w", err)
+	}
+	s.hold(cert)
+	return nil
+}
+
+// hold starts the timer for cert, which fires once window has elapsed
+// since cert was observed.  s.mu must be held.
+func (s *coalescingState) hold(cert *DiscoveredCert) {
+	pending := &pendingCert{cert: cert}
+	s.wg.Add(1)
+	pending.timer = time.AfterFunc(max(s.window-time.Since(cert.ObservedAt), 0), func() {
+		defer s.wg.Done()
+		s.send(cert.SHA256)
+	})
+	s.pending[cert.SHA256] = pending
+}
+
+func (s *coalescingState) send(fingerprint [32]byte) {
+	s.mu.Lock()
+	pending, ok := s.pending[fingerprint]
+	delete(s.pending, fingerprint)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// Nothing cancels a timer-driven notification, so bound it to keep a
+	// hung notification method from holding up flush forever
+	ctx, cancel := context.WithTimeout(context.Background(), coalesceNotifyTimeout)
+	defer cancel()
+	if err := s.StateProvider.NotifyCert(ctx, pending.cert); err != nil {
+		// The certificate remains stored, so it is notified about
+		// when certspotter restarts
+		recordError(ctx, s.config, nil, fmt.Errorf("error notifying about certificate %x: %w", fingerprint, err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[fingerprint]; ok {
+		// Seen again since the notification was sent, and stored for the next one
+		return
+	}
+	if err := removeHeldCert(ctx, s.StateProvider, heldCertsCoalesce, fingerprint); err != nil {
+		recordError(ctx, s.config, nil, fmt.Errorf("error removing certificate %x held for coalescing: %w", fingerprint, err))
+	}
+}
+
+// flush immediately sends the notifications for all pending certificates,
+// and waits for any in-progress notifications to finish.
+func (s *coalescingState) flush() {
+	s.mu.Lock()
+	var fingerprints [][32]byte
+	for fingerprint, pending := range s.pending {
+		if pending.timer.Stop() {
+			s.wg.Done()
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, fingerprint := range fingerprints {
+		s.send(fingerprint)
+	}
+	s.wg.Wait()
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+// CompactResult describes the STHs removed from a log's state by
+// CompactState.
+type CompactResult struct {
+	Log     *loglist.Log
+	STHs    int // number of STHs before compaction
+	Removed int
+}
+
+// CompactState removes STHs which are no longer needed from the state of
+// every log in the log list at logListSource, and returns the results sorted
+// by URL.  An STH is no longer needed if its tree size is smaller than the
+// log's verified position, or if it has the same tree size and root hash as
+// the verified STH or another STH.  Conflicting STHs (with the same tree size
+// but different root hashes) are kept, so that they are still notified about.
+//
+// Since the monitor would discard the same STHs without using them, this is
+// safe to run while certspotter is monitoring the logs.
+func CompactState(ctx context.Context, state StateProvider, logListSource string) ([]*CompactResult, error) {
+	logs, _, _, err := getLogList(ctx, logListSource, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error loading log list: %w", err)
+	}
+	results := make([]*CompactResult, 0, len(logs))
+	for _, ctlog := range logs {
+		result, err := compactLogState(ctx, state, ctlog)
+		if err != nil {
+			return nil, fmt.Errorf("error compacting state of %s: %w", ctlog.URL, err)
+		} else if result != nil {
+			results = append(results, result)
+		}
+	}
+	slices.SortFunc(results, func(a, b *CompactResult) int { return strings.Compare(a.Log.URL, b.Log.URL) })
+	return results, nil
+}
+
+// compactLogState removes the log's unneeded STHs, as described by
+// CompactState.  It returns nil if the log has never been monitored.
+func compactLogState(ctx context.Context, state StateProvider, ctlog *loglist.Log) (*CompactResult, error) {
+	logState, err := state.LoadLogState(ctx, ctlog.LogID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading log state: %w", err)
+	} else if logState == nil {
+		return nil, nil
+	}
+	sths, err := state.LoadSTHs(ctx, ctlog.LogID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading STHs: %w", err)
+	}
+	result := &CompactResult{Log: ctlog, STHs: len(sths)}
+	var kept []*ct.SignedTreeHead // STHs with the current tree size which are being kept
+	if logState.VerifiedSTH != nil {
+		kept = append(kept, logState.VerifiedSTH)
+	}
+	for _, sth := range sths {
+		if sth.TreeSize >= logState.VerifiedPosition.Size() {
+			if len(kept) > 0 && kept[0].TreeSize != sth.TreeSize {
+				kept = kept[:0]
+			}
+			if !slices.ContainsFunc(kept, func(other *ct.SignedTreeHead) bool { return other.SHA256RootHash == sth.SHA256RootHash }) {
+				kept = append(kept, sth)
+				continue
+			}
+		}
+		if err := state.RemoveSTH(ctx, ctlog.LogID, sth); err != nil {
+			return nil, fmt.Errorf("error removing STH: %w", err)
+		}
+		result.Removed++
+	}
+	return result, nil
+}
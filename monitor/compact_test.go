@@ -0,0 +1,55 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+func TestCompactLogState(t *testing.T) {
+	ctx := context.Background()
+	state := new(MemoryState)
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+
+	position := merkletree.EmptyCollapsedTree()
+	position.Add(merkletree.Hash{1})
+	position.Add(merkletree.Hash{2})
+	verifiedSTH := &ct.SignedTreeHead{TreeSize: 2, Timestamp: 20, SHA256RootHash: ct.SHA256Hash{2}}
+	state.StoreLogState(ctx, ctlog.LogID, &LogState{DownloadPosition: position, VerifiedPosition: position, VerifiedSTH: verifiedSTH})
+
+	sths := []*ct.SignedTreeHead{
+		{TreeSize: 1, Timestamp: 10, SHA256RootHash: ct.SHA256Hash{1}}, // older than the verified position
+		{TreeSize: 2, Timestamp: 21, SHA256RootHash: ct.SHA256Hash{2}}, // duplicates the verified STH
+		{TreeSize: 2, Timestamp: 22, SHA256RootHash: ct.SHA256Hash{9}}, // conflicts with the verified STH
+		{TreeSize: 3, Timestamp: 30, SHA256RootHash: ct.SHA256Hash{3}},
+		{TreeSize: 3, Timestamp: 31, SHA256RootHash: ct.SHA256Hash{3}}, // duplicates the previous STH
+		{TreeSize: 4, Timestamp: 40, SHA256RootHash: ct.SHA256Hash{4}},
+	}
+	for _, sth := range sths {
+		state.StoreSTH(ctx, ctlog.LogID, sth)
+	}
+
+	result, err := compactLogState(ctx, state, ctlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.STHs != 6 || result.Removed != 3 {
+		t.Errorf("compactLogState removed %d of %d STHs, want 3 of 6", result.Removed, result.STHs)
+	}
+	remaining, _ := state.LoadSTHs(ctx, ctlog.LogID)
+	if len(remaining) != 3 || remaining[0].Timestamp != 22 || remaining[1].Timestamp != 30 || remaining[2].Timestamp != 40 {
+		t.Errorf("remaining STHs are %v, want the STHs with timestamps 22, 30, and 40", remaining)
+	}
+}
@@ -10,15 +10,164 @@
 package monitor
 
 import (
+	"net/http"
+	"net/url"
 	"time"
+
+	"software.sslmate.com/src/certspotter/ct/client"
 )
 
 type Config struct {
 	LogListSource       string
+	LogProxies          map[string]string // maps log URL (without trailing slash) to the URL of a caching proxy
+	ProxyURL            *url.URL          // if non-nil, send requests to logs through this HTTP or SOCKS5 proxy instead of the environment's
 	State               StateProvider
 	StartAtEnd          bool
-	WatchList           WatchList
+	StartAtTime         time.Time // if non-zero, start new logs at the first entry logged at or after this time
+	OneShot             bool      // download each log up to its latest STH once, then return from Run
+	WatchList           *WatchList
+	MatchCommonName     bool // match the DNS names and IP addresses in the subject CN as well as the SANs
+	CheckAnomalies      bool
+	TrackKeyReuse       bool
+	CheckPrecerts       bool
+	DedupePrecerts      bool          // notify about only one of each precertificate/certificate pair
+	CoalesceWindow      time.Duration // if non-zero, send one notification per cert listing every log it was seen in during this window
+	DigestInterval      time.Duration // if non-zero, send one notification listing every cert discovered during each interval
+	ResolveDNS          bool          // look up the DNS names of matching certificates
+	ResolveCNAME        bool          // also look up CNAMEs, if ResolveDNS is set
+	ResolveTimeout      time.Duration // maximum time to spend resolving a certificate's DNS names
+	NotifyLogContact    bool
+	NotifyLifecycle     bool   // send certspotter_started/stopped events to notification channels
+	Version             string // included in lifecycle events
+	MinSCTs             int    // flag certificates with fewer embedded SCTs; 0 disables
+	MaxValidityDays     int    // flag certificates valid for longer than this many days; 0 disables
+	Verification        VerificationMode
+	VerifyInclusion     bool // fetch and verify an inclusion proof for each matching certificate
+	CheckRoots          bool // notify when the roots accepted by a log (per get-roots) change
 	Verbose             bool
 	JsonLog             bool
 	HealthCheckInterval time.Duration
+	MaxBacklog          uint64   // don't notify about a backlog unless it exceeds this many entries
+	BatchSize           int      // max entries to request per get-entries call; 0 means 1000
+	MaxResponseSize     int64    // max size of a response from a log, in bytes; 0 means big enough for BatchSize entries
+	Metrics             *Metrics // if non-nil, updated as logs are monitored
+	DownloadWorkers     int      // concurrent get-entries requests per log; 0 or 1 downloads serially
+
+	// If non-nil, called with each certificate which matches the watch
+	// list, after name matching but before notifyCert.  Certificates for
+	// which it returns false are ignored as if they didn't match the watch
+	// list: they aren't notified about, aren't checked for key reuse or
+	// precert anomalies, and don't count towards DedupePrecerts.
+	//
+	// The filter receives a partially populated DiscoveredCert, rather than
+	// the log entry and parsed certificate, so that it can use the match
+	// results.  When it runs, LogEntry, SCTTimestamp, Info, Chain, IsPrecert,
+	// PrecertError, EmbeddedSCTs, ObservedAt, WatchItem, MatchedIdentifier,
+	// MatchSource, Identifiers, the SHA-256 hashes, InsufficientSCTs,
+	// OverlongValidity, and Anomalies are set.  DNSResolutions,
+	// InclusionTreeSize, InclusionError, and Sightings are not set yet,
+	// since they're filled in afterwards (some of them require network
+	// requests).
+	//
+	// Since the filter runs before certspotter checks whether the
+	// certificate was already notified about (and before DedupePrecerts),
+	// it may be called more than once for the same certificate.  It's
+	// called concurrently for different logs.
+	MatchFilter func(*DiscoveredCert) bool
+
+	// Extra headers (e.g. Authorization) sent with requests to logs, keyed
+	// by the lower-case host name (without port) to which they are sent.
+	// For a log with a proxy in LogProxies, the proxy's host is used, not
+	// the log's.  Headers are not sent to other hosts, including when a
+	// request is redirected.
+	HTTPHeaders map[string]http.Header
+
+	// User-Agent sent with every request to a log or its proxy.  Empty
+	// means no User-Agent is sent.
+	UserAgent string
+
+	// How to retry failed requests to logs.  Nil means
+	// client.DefaultRetryPolicy.
+	RetryPolicy *client.RetryPolicy
+
+	// If a log request fails repeatedly, such that the delay before
+	// retrying reaches this duration, notify about it (at most once per
+	// HealthCheckInterval per log).  Zero disables the notification.
+	RetryNotifyThreshold time.Duration
+
+	// How often to reload the log list.  Zero means a random interval
+	// between 30 and 90 minutes.
+	LogListReloadInterval time.Duration
+
+	// If true, notify when a reloaded log list adds or removes logs.
+	NotifyLogListChanges bool
+
+	// Maximum number of requests per second to send to each log host.
+	// Logs on the same host share the limit.  Zero means unlimited.
+	RateLimit float64
+
+	// URL to ping (with a POST request) after each health check which finds
+	// no problems, for use with a dead man's switch service such as
+	// healthchecks.io.  Empty disables pinging.
+	HealthCheckPingURL string
+
+	// If true, ping HealthCheckPingURL with "/fail" appended after each
+	// health check which finds a problem.
+	HealthCheckPingFail bool
+
+	// Overrides RateLimit for the logs of particular operators, by
+	// operator name (as it appears in the log list).
+	OperatorRateLimits map[string]float64
+
+	// If non-empty, monitor only the logs of these operators (by name, as
+	// it appears in the log list, ignoring case).
+	IncludeOperators []string
+
+	// Don't monitor the logs of these operators.
+	ExcludeOperators []string
+
+	// If non-empty, monitor only the log with this URL, for debugging.  If
+	// LogKey is set, the log list isn't used at all; the log is assumed to
+	// implement RFC 6962 and to have this DER-encoded public key.
+	// Otherwise, the log is looked up in the log list by URL.
+	LogURL string
+	LogKey []byte
+
+	// Refuse to use a log list whose timestamp is older than this.
+	// Zero disables the check.
+	MaxLogListAge time.Duration
+
+	// If the log list shrinks by more than this fraction (between 0 and 1),
+	// notify about it.  Zero disables the check.
+	LogListShrinkThreshold float64
+
+	// If true, keep using the previous log list when it shrinks by more
+	// than LogListShrinkThreshold.
+	KeepLogListOnShrink bool
+}
+
+func (config *Config) batchSize() uint64 {
+	if config.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return uint64(config.BatchSize)
+}
+
+// maxEntrySize is the size of a get-entries response per entry that
+// maxResponseSize allows for by default.  It's much larger than almost every
+// real entry.
+const maxEntrySize = 64 << 10
+
+func (config *Config) maxResponseSize() int64 {
+	if config.MaxResponseSize > 0 {
+		return config.MaxResponseSize
+	}
+	return max(client.DefaultMaxResponseSize, int64(config.batchSize())*maxEntrySize)
+}
+
+func (config *Config) retryPolicy() client.RetryPolicy {
+	if config.RetryPolicy == nil {
+		return client.DefaultRetryPolicy
+	}
+	return *config.RetryPolicy
 }
@@ -14,6 +14,9 @@ import (
 	"errors"
 	"fmt"
 	insecurerand "math/rand"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -30,7 +33,10 @@ func randomDuration(min, max time.Duration) time.Duration {
 	return min + time.Duration(insecurerand.Int63n(int64(max-min+1)))
 }
 
-func reloadLogListInterval() time.Duration {
+func reloadLogListInterval(config *Config) time.Duration {
+	if config.LogListReloadInterval > 0 {
+		return config.LogListReloadInterval
+	}
 	return randomDuration(reloadLogListIntervalMin, reloadLogListIntervalMax)
 }
 
@@ -47,9 +53,16 @@ type daemon struct {
 	logListToken   *loglist.ModificationToken
 	logListError   string
 	logListErrorAt time.Time
+	logListShrank  int                          // size of the most recent shrunken log list that was notified about
+	logListStale   time.Time                    // timestamp of the most recent stale log list that was notified about
+	unhealthy      map[LogID]HealthCheckFailure // failure found by the most recent health check of each unhealthy log
+
+	incompleteMu sync.Mutex
+	incomplete   []error // errors wrapping ErrIncomplete returned by tasks in one-shot mode
 }
 
 func (daemon *daemon) healthCheck(ctx context.Context) error {
+	var failures []HealthCheckFailure
 	if time.Since(daemon.logsLoadedAt) >= daemon.config.HealthCheckInterval {
 		info := &StaleLogListInfo{
 			Source:        daemon.config.LogListSource,
@@ -60,11 +73,27 @@ func (daemon *daemon) healthCheck(ctx context.Context) error {
 		if err := daemon.config.State.NotifyHealthCheckFailure(ctx, nil, info); err != nil {
 			return fmt.Errorf("error notifying about stale log list: %w", err)
 		}
+		failures = append(failures, info)
 	}
 
-	for _, task := range daemon.tasks {
-		if err := healthCheckLog(ctx, daemon.config, task.log); err != nil {
+	for logID, task := range daemon.tasks {
+		failure, err := healthCheckLog(ctx, daemon.config, task.log, daemon.unhealthy[logID])
+		if err != nil {
 			return fmt.Errorf("error checking health of log %q: %w", task.log.URL, err)
+		} else if failure != nil {
+			failures = append(failures, failure)
+			daemon.unhealthy[logID] = failure
+		} else {
+			delete(daemon.unhealthy, logID)
+		}
+	}
+
+	if err := storeHealthCheckResult(ctx, daemon.config.State, failures); err != nil {
+		return fmt.Errorf("error storing health check result: %w", err)
+	}
+	if daemon.config.HealthCheckPingURL != "" {
+		if err := pingHealthCheck(ctx, daemon.config, failures); err != nil {
+			recordError(ctx, daemon.config, nil, err)
 		}
 	}
 	return nil
@@ -78,7 +107,16 @@ func (daemon *daemon) startTask(ctx context.Context, ctlog *loglist.Log) task {
 		if daemon.config.Verbose {
 			zap.S().Errorf("task for log %s stopped with error %s", ctlog.URL, err)
 		}
-		if ctx.Err() == context.Canceled && errors.Is(err, context.Canceled) {
+		if err == nil {
+			return nil
+		} else if ctx.Err() == context.Canceled && errors.Is(err, context.Canceled) {
+			return nil
+		} else if errors.Is(err, ErrIncomplete) {
+			// Returning the error would stop the other tasks, so Run
+			// returns it after they finish
+			daemon.incompleteMu.Lock()
+			defer daemon.incompleteMu.Unlock()
+			daemon.incomplete = append(daemon.incomplete, fmt.Errorf("error while monitoring %s: %w", ctlog.URL, err))
 			return nil
 		} else {
 			return fmt.Errorf("error while monitoring %s: %w", ctlog.URL, err)
@@ -88,17 +126,51 @@ func (daemon *daemon) startTask(ctx context.Context, ctlog *loglist.Log) task {
 }
 
 func (daemon *daemon) loadLogList(ctx context.Context) error {
-	newLogList, newToken, err := getLogList(ctx, daemon.config.LogListSource, daemon.logListToken)
+	if daemon.config.LogURL != "" && daemon.config.LogKey != nil {
+		// The log doesn't come from the log list, so it never changes
+		if daemon.logsLoadedAt.IsZero() {
+			for logID, ctlog := range makeSingleLogList(daemon.config.LogURL, daemon.config.LogKey) {
+				daemon.tasks[logID] = daemon.startTask(ctx, ctlog)
+			}
+		}
+		daemon.logsLoadedAt = time.Now()
+		return nil
+	}
+
+	newLogList, newTimestamp, newToken, err := getLogList(ctx, daemon.config.LogListSource, daemon.logListToken)
 	if errors.Is(err, loglist.ErrNotModified) {
 		return nil
 	} else if err != nil {
 		return err
 	}
 
+	if err := daemon.checkLogListAge(ctx, newTimestamp); err != nil {
+		return err
+	}
+
 	if daemon.config.Verbose {
 		zap.S().Debugf("fetched %d logs from %q", len(newLogList), daemon.config.LogListSource)
 	}
 
+	if daemon.config.LogURL != "" {
+		if err := selectLog(newLogList, daemon.config.LogURL); err != nil {
+			return err
+		}
+	} else {
+		filterLogList(daemon.config, newLogList)
+	}
+
+	if shrank, err := daemon.checkLogListShrinkage(ctx, newLogList); err != nil {
+		return err
+	} else if shrank && daemon.config.KeepLogListOnShrink {
+		// The log list was retrieved successfully, and keeping the
+		// previous one is what the user asked for, so this is not an
+		// error, and the log list must not be reported as stale
+		daemon.logsLoadedAt = time.Now()
+		return nil
+	}
+
+	var added, removed []*loglist.Log
 	for logID, task := range daemon.tasks {
 		if _, exists := newLogList[logID]; exists {
 			continue
@@ -108,6 +180,8 @@ func (daemon *daemon) loadLogList(ctx context.Context) error {
 		}
 		task.stop()
 		delete(daemon.tasks, logID)
+		delete(daemon.unhealthy, logID)
+		removed = append(removed, task.log)
 	}
 	for logID, ctlog := range newLogList {
 		if _, isRunning := daemon.tasks[logID]; isRunning {
@@ -117,37 +191,127 @@ func (daemon *daemon) loadLogList(ctx context.Context) error {
 			zap.S().Debugf("starting task for log %s (%s)", logID.Base64String(), ctlog.URL)
 		}
 		daemon.tasks[logID] = daemon.startTask(ctx, ctlog)
+		added = append(added, ctlog)
 	}
+	initialLoad := daemon.logsLoadedAt.IsZero()
 	daemon.logsLoadedAt = time.Now()
 	daemon.logListToken = newToken
+
+	if daemon.config.NotifyLogListChanges && !initialLoad && (len(added) > 0 || len(removed) > 0) {
+		sortLogsByURL(added)
+		sortLogsByURL(removed)
+		if err := notifyLogListChanged(ctx, daemon.config.State, added, removed); err != nil {
+			return fmt.Errorf("error notifying about log list changes: %w", err)
+		}
+	}
 	return nil
 }
 
+func sortLogsByURL(logs []*loglist.Log) {
+	slices.SortFunc(logs, func(a, b *loglist.Log) int { return strings.Compare(a.URL, b.URL) })
+}
+
+// checkLogListAge returns an error if the log list timestamp is older than
+// config.MaxLogListAge.  Unless this is the initial load of the log list
+// (in which case the error prevents certspotter from starting), it also
+// notifies about the stale log list the first time it is observed.
+func (daemon *daemon) checkLogListAge(ctx context.Context, timestamp time.Time) error {
+	if daemon.config.MaxLogListAge <= 0 || timestamp.IsZero() || time.Since(timestamp) <= daemon.config.MaxLogListAge {
+		return nil
+	}
+	err := fmt.Errorf("log list from %q is stale: its timestamp (%s) is more than %s old", daemon.config.LogListSource, timestamp, daemon.config.MaxLogListAge)
+	if !daemon.logsLoadedAt.IsZero() && !daemon.logListStale.Equal(timestamp) {
+		info := &StaleLogListInfo{
+			Source:        daemon.config.LogListSource,
+			LastSuccess:   daemon.logsLoadedAt,
+			LastError:     err.Error(),
+			LastErrorTime: time.Now(),
+		}
+		if err := daemon.config.State.NotifyHealthCheckFailure(ctx, nil, info); err != nil {
+			return fmt.Errorf("error notifying about stale log list: %w", err)
+		}
+		daemon.logListStale = timestamp
+	}
+	return err
+}
+
+// checkLogListShrinkage returns true if newLogList contains fewer logs than
+// the current log list by more than config.LogListShrinkThreshold, notifying
+// about the shrinkage (and, if config.KeepLogListOnShrink is set, logging a
+// warning) the first time it is observed.
+func (daemon *daemon) checkLogListShrinkage(ctx context.Context, newLogList map[LogID]*loglist.Log) (bool, error) {
+	oldSize, newSize := len(daemon.tasks), len(newLogList)
+	if daemon.config.LogListShrinkThreshold <= 0 || oldSize == 0 || newSize >= oldSize {
+		daemon.logListShrank = 0
+		return false, nil
+	}
+	if float64(oldSize-newSize)/float64(oldSize) <= daemon.config.LogListShrinkThreshold {
+		return false, nil
+	}
+	if daemon.logListShrank != newSize {
+		info := &LogListShrankInfo{
+			Source:       daemon.config.LogListSource,
+			PreviousSize: oldSize,
+			NewSize:      newSize,
+			KeptPrevious: daemon.config.KeepLogListOnShrink,
+		}
+		if err := daemon.config.State.NotifyHealthCheckFailure(ctx, nil, info); err != nil {
+			return false, fmt.Errorf("error notifying about shrunken log list: %w", err)
+		}
+		if daemon.config.KeepLogListOnShrink {
+			zap.S().Warnf("log list from %q shrank from %d to %d logs; continuing to use the previous log list", daemon.config.LogListSource, oldSize, newSize)
+		}
+		daemon.logListShrank = newSize
+	}
+	return true, nil
+}
+
 func (daemon *daemon) run(ctx context.Context) error {
 	if err := daemon.config.State.Prepare(ctx); err != nil {
-		return fmt.Errorf("error preparing state: %w", err)
+		return classifyError(fmt.Errorf("error preparing state: %w", err), ErrState)
 	}
 
 	if err := daemon.loadLogList(ctx); err != nil {
-		return fmt.Errorf("error loading log list: %w", err)
+		return classifyError(fmt.Errorf("error loading log list: %w", err), ErrLogList)
+	}
+
+	if err := recordLifecycleEvent(ctx, daemon.config, newLifecycleEvent(daemon.config, EventStarted, len(daemon.tasks))); err != nil {
+		return err
+	}
+
+	// Notifications which failed before certspotter last stopped
+	if err := retryPendingNotifications(ctx, daemon.config); err != nil {
+		recordError(ctx, daemon.config, nil, fmt.Errorf("%w (will try again later)", err))
+	}
+
+	if daemon.config.OneShot {
+		// Run returns once every task has caught up and returned
+		return nil
 	}
 
-	reloadLogListTicker := time.NewTicker(reloadLogListInterval())
+	reloadLogListTicker := time.NewTicker(reloadLogListInterval(daemon.config))
 	defer reloadLogListTicker.Stop()
 
 	healthCheckTicker := time.NewTicker(daemon.config.HealthCheckInterval)
 	defer healthCheckTicker.Stop()
 
+	retryNotificationsTicker := time.NewTicker(retryNotificationsInterval)
+	defer retryNotificationsTicker.Stop()
+
 	for ctx.Err() == nil {
 		select {
 		case <-ctx.Done():
+		case <-retryNotificationsTicker.C:
+			if err := retryPendingNotifications(ctx, daemon.config); err != nil {
+				recordError(ctx, daemon.config, nil, fmt.Errorf("%w (will try again later)", err))
+			}
 		case <-reloadLogListTicker.C:
 			if err := daemon.loadLogList(ctx); err != nil {
 				daemon.logListError = err.Error()
 				daemon.logListErrorAt = time.Now()
 				recordError(ctx, daemon.config, nil, fmt.Errorf("error reloading log list (will try again later): %w", err))
 			}
-			reloadLogListTicker.Reset(reloadLogListInterval())
+			reloadLogListTicker.Reset(reloadLogListInterval(daemon.config))
 		case <-healthCheckTicker.C:
 			if err := daemon.healthCheck(ctx); err != nil {
 				return err
@@ -158,12 +322,67 @@ func (daemon *daemon) run(ctx context.Context) error {
 }
 
 func Run(ctx context.Context, config *Config) error {
-	group, ctx := errgroup.WithContext(ctx)
+	if config.Metrics != nil {
+		metricsConfig := *config
+		metricsConfig.State = &metricsState{StateProvider: config.State, metrics: config.Metrics}
+		config = &metricsConfig
+	}
+
+	var digester *digestState
+	if config.DigestInterval > 0 {
+		var err error
+		digester, err = newDigestState(ctx, config, config.DigestInterval)
+		if err != nil {
+			return err
+		}
+		digestConfig := *config
+		digestConfig.State = digester
+		config = &digestConfig
+	}
+
+	var coalescer *coalescingState
+	if config.CoalesceWindow > 0 {
+		var err error
+		coalescer, err = newCoalescingState(ctx, config, config.CoalesceWindow)
+		if err != nil {
+			return err
+		}
+		coalescingConfig := *config
+		coalescingConfig.State = coalescer
+		config = &coalescingConfig
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
 	daemon := &daemon{
 		config:    config,
 		taskgroup: group,
 		tasks:     make(map[LogID]task),
+		unhealthy: make(map[LogID]HealthCheckFailure),
+	}
+	group.Go(func() error { return daemon.run(groupCtx) })
+	err := group.Wait()
+	if err == nil {
+		err = errors.Join(daemon.incomplete...)
+	}
+
+	if coalescer != nil {
+		coalescer.flush()
+	}
+	if digester != nil {
+		digester.flush()
+	}
+
+	if daemon.logsLoadedAt.IsZero() {
+		// never started, so don't record a stop
+		return err
+	}
+	event := newLifecycleEvent(config, EventStopped, len(daemon.tasks))
+	event.Reason = stoppedReason(ctx, config, err)
+	if event.Reason == "error" {
+		event.Error = err
+	}
+	if notifyErr := recordLifecycleEvent(ctx, config, event); notifyErr != nil && err == nil {
+		err = notifyErr
 	}
-	group.Go(func() error { return daemon.run(ctx) })
-	return group.Wait()
+	return err
 }
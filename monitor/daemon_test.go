@@ -0,0 +1,99 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+// writeTestLogList writes a log list containing numLogs logs to filename,
+// and returns the logs.
+func writeTestLogList(t *testing.T, filename string, numLogs int) []*loglist.Log {
+	t.Helper()
+	list := &loglist.List{Operators: []loglist.Operator{{Name: "Example"}}}
+	for i := 0; i < numLogs; i++ {
+		key := []byte(fmt.Sprintf("key %d", i))
+		list.Operators[0].Logs = append(list.Operators[0].Logs, loglist.Log{
+			Key:   key,
+			LogID: sha256.Sum256(key),
+			URL:   fmt.Sprintf("https://log%d.example/", i),
+		})
+	}
+	listBytes, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, listBytes, 0666); err != nil {
+		t.Fatal(err)
+	}
+	logs := make([]*loglist.Log, numLogs)
+	for i := range logs {
+		logs[i] = &list.Operators[0].Logs[i]
+	}
+	return logs
+}
+
+func TestLoadLogListKeepOnShrink(t *testing.T) {
+	ctx := context.Background()
+	var failures []HealthCheckFailure
+	var errs []error
+	state := &MemoryState{
+		OnHealthCheckFailure: func(_ *loglist.Log, failure HealthCheckFailure) { failures = append(failures, failure) },
+		OnError:              func(_ *loglist.Log, err error) { errs = append(errs, err) },
+	}
+	logListPath := filepath.Join(t.TempDir(), "loglist.json")
+	daemon := &daemon{
+		config: &Config{
+			State:                  state,
+			LogListSource:          logListPath,
+			LogListShrinkThreshold: 0.25,
+			KeepLogListOnShrink:    true,
+		},
+		tasks:        make(map[LogID]task),
+		unhealthy:    make(map[LogID]HealthCheckFailure),
+		logsLoadedAt: time.Now().Add(-time.Hour),
+	}
+	// Pretend the logs are already being monitored, so no tasks are started
+	for _, ctlog := range writeTestLogList(t, logListPath, 4) {
+		daemon.tasks[ctlog.LogID] = task{log: ctlog, stop: func() { t.Errorf("task for %s was stopped", ctlog.URL) }}
+	}
+
+	writeTestLogList(t, logListPath, 1)
+	for i := 0; i < 2; i++ {
+		loadedAt := time.Now()
+		if err := daemon.loadLogList(ctx); err != nil {
+			t.Fatalf("loadLogList returned error for a shrunken log list which was kept: %s", err)
+		}
+		if len(daemon.tasks) != 4 {
+			t.Errorf("%d logs are being monitored; expected the previous 4", len(daemon.tasks))
+		}
+		if daemon.logsLoadedAt.Before(loadedAt) {
+			t.Errorf("the log list wasn't recorded as loaded, so it would be reported as stale")
+		}
+	}
+	if len(failures) != 1 {
+		t.Fatalf("%d health check failures were notified; expected 1", len(failures))
+	}
+	if info, ok := failures[0].(*LogListShrankInfo); !ok || info.PreviousSize != 4 || info.NewSize != 1 || !info.KeptPrevious {
+		t.Errorf("wrong health check failure %#v", failures[0])
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors were recorded: %v", errs)
+	}
+}
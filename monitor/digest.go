@@ -0,0 +1,139 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// digestState is a StateProvider which accumulates discovered certificates
+// instead of notifying about them individually, and passes them to
+// NotifyCertDigest once interval has elapsed since the first of them was
+// discovered.  The pending certificates are stored using StoreHeldCert (if
+// the StateProvider implements heldCertStore), so they are included in a
+// digest sent after certspotter restarts if it exits before the interval
+// elapses.
+type digestState struct {
+	StateProvider
+	interval time.Duration
+	config   *Config // used to record errors which occur after NotifyCert returns
+
+	mu      sync.Mutex
+	pending []*DiscoveredCert
+	seen    map[[32]byte]bool // fingerprints of the pending certificates
+	timer   *time.Timer       // non-nil if pending is non-empty
+	wg      sync.WaitGroup
+}
+
+func (s *digestState) unwrapState() StateProvider { return s.StateProvider }
+
+// newDigestState returns a digestState which starts out with the
+// certificates that were held for a digest when certspotter last exited.
+func newDigestState(ctx context.Context, config *Config, interval time.Duration) (*digestState, error) {
+	s := &digestState{
+		StateProvider: config.State,
+		interval:      interval,
+		config:        config,
+		seen:          make(map[[32]byte]bool),
+	}
+	certs, err := loadHeldCerts(ctx, config.State, heldCertsDigest)
+	if err != nil {
+		return nil, classifyError(fmt.Errorf("error loading certificates held for digest: %w", err), ErrState)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cert := range certs {
+		s.hold(cert)
+	}
+	return s, nil
+}
+
+func (s *digestState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen[cert.SHA256] {
+		return nil
+	}
+	if err := storeHeldCert(ctx, s.StateProvider, heldCertsDigest, cert); err != nil {
+		return fmt.Errorf("error storing certificate held for digest: %w", err)
+	}
+	s.hold(cert)
+	return nil
+}
+
+// hold adds cert to the digest, starting the timer if cert is the first
+// pending certificate.  s.mu must be held.
+func (s *digestState) hold(cert *DiscoveredCert) {
+	s.seen[cert.SHA256] = true
+	s.pending = append(s.pending, cert)
+	if s.timer == nil {
+		s.wg.Add(1)
+		s.timer = time.AfterFunc(max(s.interval-time.Since(cert.ObservedAt), 0), func() {
+			defer s.wg.Done()
+			s.send()
+		})
+	}
+}
+
+func (s *digestState) send() {
+	s.mu.Lock()
+	certs := s.pending
+	s.pending = nil
+	s.seen = make(map[[32]byte]bool)
+	s.timer = nil
+	s.mu.Unlock()
+	if len(certs) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	var err error
+	if len(certs) == 1 {
+		err = s.StateProvider.NotifyCert(ctx, certs[0])
+	} else {
+		err = notifyCertDigest(ctx, s.StateProvider, certs)
+	}
+	if err != nil {
+		// The certificates remain stored, so the digest is sent again
+		// when certspotter restarts
+		recordError(ctx, s.config, nil, fmt.Errorf("error notifying about %d certificates: %w", len(certs), err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cert := range certs {
+		if s.seen[cert.SHA256] {
+			// Discovered again since the digest was sent, and stored for the next digest
+			continue
+		}
+		if err := removeHeldCert(ctx, s.StateProvider, heldCertsDigest, cert.SHA256); err != nil {
+			recordError(ctx, s.config, nil, fmt.Errorf("error removing certificate %x held for digest: %w", cert.SHA256, err))
+		}
+	}
+}
+
+// flush immediately sends the digest of the pending certificates, and waits
+// for any in-progress digest to finish.
+func (s *digestState) flush() {
+	s.mu.Lock()
+	stopped := s.timer != nil && s.timer.Stop()
+	s.mu.Unlock()
+
+	if stopped {
+		s.wg.Done()
+		s.send()
+	}
+	s.wg.Wait()
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	discordMaxContentLength     = 2000
+	discordMaxTitleLength       = 256
+	discordMaxDescriptionLength = 4096
+	discordMaxFieldLength       = 1024
+)
+
+type discordMessage struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordEscape escapes the characters which have special meaning in
+// Discord's markdown.
+func discordEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`).Replace(text)
+}
+
+func makeDiscordMessage(notif *notification) *discordMessage {
+	message := &discordMessage{Content: truncate(notif.summary, discordMaxContentLength)}
+	if notif.cert != nil {
+		message.Embeds = []discordEmbed{discordCertEmbed(notif.cert)}
+	} else {
+		message.Embeds = []discordEmbed{{
+			Title:       truncate(notif.summary, discordMaxTitleLength),
+			Description: "```\n" + truncate(strings.ReplaceAll(notif.text, "```", "'''"), discordMaxDescriptionLength-8) + "\n```",
+		}}
+	}
+	return message
+}
+
+// primaryName returns the name which best identifies the certificate: its
+// first DNS name, or else its first IP address, or else its fingerprint.
+func primaryName(cert *DiscoveredCert) string {
+	if len(cert.Identifiers.DNSNames) > 0 {
+		return cert.Identifiers.DNSNames[0]
+	} else if len(cert.Identifiers.IPAddrs) > 0 {
+		return cert.Identifiers.IPAddrs[0].String()
+	} else {
+		return hex.EncodeToString(cert.SHA256[:])
+	}
+}
+
+func discordCertEmbed(cert *DiscoveredCert) discordEmbed {
+	field := func(name string, value string) discordField {
+		return discordField{Name: name, Value: truncate(discordEscape(value), discordMaxFieldLength), Inline: true}
+	}
+
+	var issuer, serial, notBefore, notAfter string
+	if cert.Info.IssuerParseError == nil {
+		issuer = cert.Info.Issuer.String()
+	} else {
+		issuer = fmt.Sprintf("[unable to parse: %s]", cert.Info.IssuerParseError)
+	}
+	if cert.Info.SerialNumberParseError == nil {
+		serial = fmt.Sprintf("%x", cert.Info.SerialNumber)
+	} else {
+		serial = fmt.Sprintf("[unable to parse: %s]", cert.Info.SerialNumberParseError)
+	}
+	if cert.Info.ValidityParseError == nil {
+		notBefore = cert.Info.Validity.NotBefore.UTC().Format(time.RFC3339)
+		notAfter = cert.Info.Validity.NotAfter.UTC().Format(time.RFC3339)
+	} else {
+		notBefore = fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError)
+		notAfter = notBefore
+	}
+
+	var names []string
+	names = append(names, cert.Identifiers.DNSNames...)
+	for _, ipaddr := range cert.Identifiers.IPAddrs {
+		names = append(names, ipaddr.String())
+	}
+
+	return discordEmbed{
+		Title:       truncate(primaryName(cert), discordMaxTitleLength),
+		URL:         "https://crt.sh/?sha256=" + hex.EncodeToString(cert.SHA256[:]),
+		Description: truncate(discordEscape(strings.Join(names, "\n")), discordMaxDescriptionLength),
+		Fields: []discordField{
+			field("Issuer", issuer),
+			field("Serial", serial),
+			field("Not Before", notBefore),
+			field("Not After", notAfter),
+		},
+	}
+}
+
+// sendDiscord posts the notification to the Discord webhook at
+// s.DiscordWebhookURL.
+func sendDiscord(ctx context.Context, s *FilesystemState, notif *notification) error {
+	body, err := json.Marshal(makeDiscordMessage(notif))
+	if err != nil {
+		return fmt.Errorf("error encoding Discord message: %w", err)
+	}
+	if err := postWithRetries(ctx, s.DiscordWebhookURL, body, nil); err != nil {
+		return fmt.Errorf("error posting to Discord: %w", err)
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,6 +25,12 @@ import (
 	"software.sslmate.com/src/certspotter/ct"
 )
 
+// Values of DiscoveredCert.MatchSource
+const (
+	MatchSourceSAN = "san" // a subject alternative name
+	MatchSourceCN  = "cn"  // a common name in the subject
+)
+
 type DiscoveredCert struct {
 	WatchItem    WatchItem
 	LogEntry     *LogEntry
@@ -33,6 +40,42 @@ type DiscoveredCert struct {
 	SHA256       [32]byte      // computed over Chain[0]
 	PubkeySHA256 [32]byte      // computed over Info.TBS.PublicKey.FullBytes
 	Identifiers  *certspotter.Identifiers
+	Anomalies    []string // nil unless Config.CheckAnomalies is set
+
+	// The DNS name or IP address which matched WatchItem; empty if
+	// WatchItem matched by issuer, public key, or serial number.
+	MatchedIdentifier string
+
+	// Where MatchedIdentifier was found (MatchSourceSAN or MatchSourceCN);
+	// empty if MatchedIdentifier is empty.
+	MatchSource string
+
+	// The timestamp of the log entry (i.e. of its SCT), and when certspotter
+	// processed the entry; the difference is the detection latency.
+	SCTTimestamp time.Time
+	ObservedAt   time.Time
+
+	IsPrecert    bool
+	PrecertError error // precertificate failed validation; only set if Config.CheckPrecerts
+
+	EmbeddedSCTs           []*ct.SignedCertificateTimestamp // nil for precertificates
+	EmbeddedSCTsParseError error
+	InsufficientSCTs       bool // true if fewer than Config.MinSCTs SCTs are embedded
+
+	OverlongValidity bool // true if valid for longer than Config.MaxValidityDays
+
+	DNSResolutions []*DNSResolution // nil unless Config.ResolveDNS is set
+
+	// The size of the tree in which the cert's inclusion proof was checked,
+	// and the error if the proof couldn't be fetched or is invalid; zero
+	// unless Config.VerifyInclusion is set and the log supports inclusion
+	// proofs.
+	InclusionTreeSize uint64
+	InclusionError    error
+
+	// Every log entry in which the certificate was seen; nil unless
+	// Config.CoalesceWindow is set.  Sightings[0] is LogEntry.
+	Sightings []*LogEntry
 }
 
 type certPaths struct {
@@ -41,43 +84,49 @@ type certPaths struct {
 	textPath string
 }
 
+// maxChainEnvironSize is the maximum number of bytes of PEM to put in the
+// CERT_CHAIN_* environment variables, which keeps the environment well under
+// the limits imposed by operating systems.
+const maxChainEnvironSize = 64 * 1024
+
 func (cert *DiscoveredCert) pemChain() []byte {
 	var buffer bytes.Buffer
 	for _, certBytes := range cert.Chain {
-		if err := pem.Encode(&buffer, &pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: certBytes,
-		}); err != nil {
-			panic(fmt.Errorf("encoding certificate as PEM failed unexpectedly: %w", err))
-		}
+		buffer.Write(pemCert(certBytes))
 	}
 	return buffer.Bytes()
 }
 
-func (cert *DiscoveredCert) json() any {
-	object := map[string]any{
-		"tbs_sha256":    hex.EncodeToString(cert.TBSSHA256[:]),
-		"pubkey_sha256": hex.EncodeToString(cert.PubkeySHA256[:]),
-		"dns_names":     cert.Identifiers.DNSNames,
-		"ip_addresses":  cert.Identifiers.IPAddrs,
-	}
+func pemCert(certBytes []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certBytes,
+	})
+}
 
-	if cert.Info.ValidityParseError == nil {
-		object["not_before"] = cert.Info.Validity.NotBefore
-		object["not_after"] = cert.Info.Validity.NotAfter
-	} else {
-		object["not_before"] = nil
-		object["not_after"] = nil
+// chainEnviron returns a CERT_CHAIN_N variable containing the PEM of each
+// certificate in the chain, starting with the certificate itself (N=0).  If
+// the chain is too large, it is truncated and CERT_CHAIN_TRUNCATED is set.
+func chainEnviron(cert *DiscoveredCert) []string {
+	var env []string
+	size := 0
+	for i, certBytes := range cert.Chain {
+		pemBytes := pemCert(certBytes)
+		if size+len(pemBytes) > maxChainEnvironSize {
+			env = append(env, "CERT_CHAIN_TRUNCATED=yes")
+			break
+		}
+		size += len(pemBytes)
+		env = append(env, fmt.Sprintf("CERT_CHAIN_%d=%s", i, pemBytes))
 	}
-
-	return object
+	return env
 }
 
-func writeCertFiles(cert *DiscoveredCert, paths *certPaths) error {
+func writeCertFiles(cert *DiscoveredCert, paths *certPaths, fields []string) error {
 	if err := writeFile(paths.certPath, cert.pemChain(), 0666); err != nil {
 		return err
 	}
-	if err := writeJSONFile(paths.jsonPath, cert.json(), 0666); err != nil {
+	if err := writeJSONFile(paths.jsonPath, cert.extractFields(fields), 0666); err != nil {
 		return err
 	}
 	if err := writeTextFile(paths.textPath, certNotificationText(cert, paths), 0666); err != nil {
@@ -94,6 +143,7 @@ func certNotificationEnviron(cert *DiscoveredCert, paths *certPaths) []string {
 		"LOG_URI=" + cert.LogEntry.Log.URL,
 		"ENTRY_INDEX=" + fmt.Sprint(cert.LogEntry.Index),
 		"WATCH_ITEM=" + cert.WatchItem.String(),
+		"WATCH_ITEM_TYPE=" + cert.WatchItem.Type(),
 		"TBS_SHA256=" + hex.EncodeToString(cert.TBSSHA256[:]),
 		"CERT_SHA256=" + hex.EncodeToString(cert.SHA256[:]),
 		"FINGERPRINT=" + hex.EncodeToString(cert.SHA256[:]), // backwards compat with pre-0.15.0; not documented
@@ -136,6 +186,66 @@ func certNotificationEnviron(cert *DiscoveredCert, paths *certPaths) []string {
 		env = append(env, "SERIAL_PARSE_ERROR="+cert.Info.SerialNumberParseError.Error())
 	}
 
+	if constraints := cert.WatchItem.Constraints(); len(constraints) > 0 {
+		env = append(env, "WATCH_CONSTRAINTS="+strings.Join(constraints, " "))
+	}
+
+	if cert.MatchedIdentifier != "" {
+		env = append(env, "MATCHED_IDENTIFIER="+cert.MatchedIdentifier)
+		env = append(env, "MATCH_SOURCE="+cert.MatchSource)
+	}
+
+	env = append(env, "SCT_TIMESTAMP="+cert.SCTTimestamp.Format(time.RFC3339))
+	env = append(env, "OBSERVED_AT="+cert.ObservedAt.Format(time.RFC3339))
+
+	if domains := registrableDomains(cert.Identifiers.DNSNames); len(domains) > 0 {
+		var pairs []string
+		for domain, count := range domains {
+			pairs = append(pairs, fmt.Sprintf("%s=%d", domain, count))
+		}
+		sort.Strings(pairs)
+		env = append(env, "REGISTRABLE_DOMAINS="+strings.Join(pairs, " "))
+	}
+
+	if cert.Sightings != nil {
+		env = append(env, "LOG_ENTRIES="+strings.Join(cert.sightingStrings(), " "))
+	}
+
+	if cert.DNSResolutions != nil {
+		resolutions := make([]string, len(cert.DNSResolutions))
+		for i, resolution := range cert.DNSResolutions {
+			resolutions[i] = resolution.Name + "=" + strings.Join(resolution.Addrs, ",")
+		}
+		env = append(env, "DNS_RESOLUTIONS="+strings.Join(resolutions, " "))
+	}
+
+	if cert.Anomalies != nil {
+		env = append(env, "ANOMALIES="+strings.Join(cert.Anomalies, "; "))
+	}
+
+	if cert.EmbeddedSCTsParseError == nil && cert.EmbeddedSCTs != nil {
+		env = append(env, "EMBEDDED_SCTS="+fmt.Sprint(len(cert.EmbeddedSCTs)))
+		env = append(env, "EMBEDDED_SCT_LIST="+strings.Join(cert.embeddedSCTStrings(), " "))
+	}
+	if cert.InsufficientSCTs {
+		env = append(env, "INSUFFICIENT_SCTS=yes")
+	}
+	if cert.OverlongValidity {
+		env = append(env, "CERT_OVERLONG=yes")
+		env = append(env, "VALIDITY_DAYS="+fmt.Sprint(validityDays(cert.Info.Validity)))
+	}
+	if cert.InclusionTreeSize != 0 {
+		env = append(env, "INCLUSION_TREE_SIZE="+fmt.Sprint(cert.InclusionTreeSize))
+		if cert.InclusionError == nil {
+			env = append(env, "INCLUSION_VERIFIED=yes")
+		} else {
+			env = append(env, "INCLUSION_VERIFIED=no")
+			env = append(env, "INCLUSION_ERROR="+cert.InclusionError.Error())
+		}
+	}
+
+	env = append(env, chainEnviron(cert)...)
+
 	return env
 }
 
@@ -167,7 +277,8 @@ func (cert *DiscoveredCert) Json() []zap.Field {
 		log.NotBefore = fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError)
 		log.NotAfter = fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError)
 	}
-	return []zapcore.Field{
+	fields := []zapcore.Field{
+		zap.String("type", "certificate"),
 		zap.String("notBefore", log.NotBefore),
 		zap.String("notAfter", log.NotAfter),
 		zap.String("sha256", log.Sha256),
@@ -175,6 +286,73 @@ func (cert *DiscoveredCert) Json() []zap.Field {
 		zap.Strings("ips", ips(log.IPs)),
 		zap.String("issuer", log.Issuer),
 		zap.String("pubkey", log.Pubkey)}
+	fields = append(fields, zap.String("watchItem", cert.WatchItem.String()), zap.String("watchItemType", cert.WatchItem.Type()))
+	if cert.MatchedIdentifier != "" {
+		fields = append(fields, zap.String("matchedIdentifier", cert.MatchedIdentifier), zap.String("matchSource", cert.MatchSource))
+	}
+	fields = append(fields, zap.Time("sctTimestamp", cert.SCTTimestamp), zap.Time("observedAt", cert.ObservedAt))
+	if constraints := cert.WatchItem.Constraints(); len(constraints) > 0 {
+		fields = append(fields, zap.Strings("watchConstraints", constraints))
+	}
+	if cert.Sightings != nil {
+		fields = append(fields, zap.Strings("logEntries", cert.sightingStrings()))
+	}
+	if cert.DNSResolutions != nil {
+		fields = append(fields, zap.Any("dnsResolutions", cert.DNSResolutions))
+	}
+	if cert.Anomalies != nil {
+		fields = append(fields, zap.Strings("anomalies", cert.Anomalies))
+	}
+	if cert.EmbeddedSCTsParseError == nil && cert.EmbeddedSCTs != nil {
+		fields = append(fields, zap.Any("embeddedSCTList", cert.embeddedSCTsJson()))
+	}
+	if cert.InsufficientSCTs {
+		fields = append(fields, zap.Bool("insufficientSCTs", true), zap.Int("embeddedSCTs", len(cert.EmbeddedSCTs)))
+	}
+	if cert.OverlongValidity {
+		fields = append(fields, zap.Bool("overlongValidity", true), zap.Int("validityDays", validityDays(cert.Info.Validity)))
+	}
+	if cert.InclusionTreeSize != 0 {
+		fields = append(fields, zap.Bool("inclusionVerified", cert.InclusionError == nil), zap.Uint64("inclusionTreeSize", cert.InclusionTreeSize))
+		if cert.InclusionError != nil {
+			fields = append(fields, zap.String("inclusionError", cert.InclusionError.Error()))
+		}
+	}
+	return fields
+}
+
+type embeddedSCTJson struct {
+	LogID     string    `json:"logId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (cert *DiscoveredCert) embeddedSCTsJson() []embeddedSCTJson {
+	scts := make([]embeddedSCTJson, len(cert.EmbeddedSCTs))
+	for i, sct := range cert.EmbeddedSCTs {
+		scts[i] = embeddedSCTJson{
+			LogID:     sct.LogID.Base64String(),
+			Timestamp: time.UnixMilli(int64(sct.Timestamp)).UTC(),
+		}
+	}
+	return scts
+}
+
+// embeddedSCTStrings returns LOG_ID@TIMESTAMP for each embedded SCT, where
+// LOG_ID is base64 and TIMESTAMP is RFC 3339 with milliseconds.
+func (cert *DiscoveredCert) embeddedSCTStrings() []string {
+	strs := make([]string, len(cert.EmbeddedSCTs))
+	for i, sct := range cert.embeddedSCTsJson() {
+		strs[i] = sct.LogID + "@" + sct.Timestamp.Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	return strs
+}
+
+func (cert *DiscoveredCert) sightingStrings() []string {
+	strs := make([]string, len(cert.Sightings))
+	for i, entry := range cert.Sightings {
+		strs[i] = fmt.Sprintf("%d@%s", entry.Index, entry.Log.URL)
+	}
+	return strs
 }
 
 func ips(data []net.IP) []string {
@@ -195,9 +373,15 @@ func certNotificationText(cert *DiscoveredCert, paths *certPaths) string {
 	for _, dnsName := range cert.Identifiers.DNSNames {
 		writeField("DNS Name", dnsName)
 	}
+	if len(cert.Identifiers.DNSNames) > 1 {
+		writeField("Domains", strings.Join(registrableDomainStrings(cert.Identifiers.DNSNames), ", "))
+	}
 	for _, ipaddr := range cert.Identifiers.IPAddrs {
 		writeField("IP Address", ipaddr)
 	}
+	for _, resolution := range cert.DNSResolutions {
+		writeField("Resolves", resolution.Name+" -> "+resolution.String())
+	}
 	writeField("Pubkey", hex.EncodeToString(cert.PubkeySHA256[:]))
 	if cert.Info.IssuerParseError == nil {
 		writeField("Issuer", cert.Info.Issuer)
@@ -211,7 +395,39 @@ func certNotificationText(cert *DiscoveredCert, paths *certPaths) string {
 		writeField("Not Before", fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError))
 		writeField("Not After", fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError))
 	}
-	writeField("Log Entry", fmt.Sprintf("%d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL))
+	for _, constraint := range cert.WatchItem.Constraints() {
+		writeField("Constraint", constraint)
+	}
+	if cert.MatchSource == MatchSourceCN {
+		writeField("Matched", cert.MatchedIdentifier+" (in the subject CN, not a SAN)")
+	}
+	for _, anomaly := range cert.Anomalies {
+		writeField("Anomaly", anomaly)
+	}
+	if cert.InsufficientSCTs {
+		if cert.EmbeddedSCTsParseError != nil {
+			writeField("Warning", fmt.Sprintf("InsufficientSCTs: unable to parse embedded SCTs: %s", cert.EmbeddedSCTsParseError))
+		} else {
+			writeField("Warning", fmt.Sprintf("InsufficientSCTs: only %d embedded SCTs", len(cert.EmbeddedSCTs)))
+		}
+	}
+	if cert.OverlongValidity {
+		writeField("Warning", fmt.Sprintf("OverlongValidity: valid for %d days", validityDays(cert.Info.Validity)))
+	}
+	if cert.InclusionTreeSize != 0 {
+		if cert.InclusionError == nil {
+			writeField("Inclusion", fmt.Sprintf("verified in tree of size %d", cert.InclusionTreeSize))
+		} else {
+			writeField("Warning", fmt.Sprintf("InclusionProof: %s", cert.InclusionError))
+		}
+	}
+	if cert.Sightings != nil {
+		for _, sighting := range cert.sightingStrings() {
+			writeField("Log Entry", sighting)
+		}
+	} else {
+		writeField("Log Entry", fmt.Sprintf("%d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL))
+	}
 	writeField("crt.sh", "https://crt.sh/?sha256="+hex.EncodeToString(cert.SHA256[:]))
 	if paths != nil {
 		writeField("Filename", paths.certPath)
@@ -223,3 +439,39 @@ func certNotificationText(cert *DiscoveredCert, paths *certPaths) string {
 func certNotificationSummary(cert *DiscoveredCert) string {
 	return fmt.Sprintf("Certificate Discovered for %s", cert.WatchItem)
 }
+
+func certDigestSummary(count int, groups []*registrableDomainGroup) string {
+	if len(groups) == 1 && groups[0].domain != "" {
+		return fmt.Sprintf("%d Certificates Discovered for %s", count, groups[0].domain)
+	}
+	return fmt.Sprintf("%d Certificates Discovered for %d Domains", count, len(groups))
+}
+
+// certDigestText returns the text of a digest, in which the text of each
+// certificate appears under the registrable domain of the certificate,
+// preceded by the number of certificates for each domain.
+func certDigestText(groups []*registrableDomainGroup, certTexts []string) string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "certspotter discovered %d certificates matching your watch list:\n\n", len(certTexts))
+	for _, group := range groups {
+		fmt.Fprintf(text, "\t%s\n", registrableDomainGroupHeading(group))
+	}
+	for _, group := range groups {
+		fmt.Fprintf(text, "\n== %s ==\n", registrableDomainGroupHeading(group))
+		for _, i := range group.indexes {
+			fmt.Fprintf(text, "\n%s", certTexts[i])
+		}
+	}
+	return text.String()
+}
+
+func registrableDomainGroupHeading(group *registrableDomainGroup) string {
+	domain := group.domain
+	if domain == "" {
+		domain = "[no DNS names]"
+	}
+	if len(group.indexes) == 1 {
+		return domain + ": 1 certificate"
+	}
+	return fmt.Sprintf("%s: %d certificates", domain, len(group.indexes))
+}
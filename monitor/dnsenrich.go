@@ -0,0 +1,100 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const maxConcurrentDNSLookups = 8
+
+// dnsLookupSemaphore limits the number of DNS lookups in progress across all
+// certificates, since certificates from different logs are processed
+// concurrently.
+var dnsLookupSemaphore = make(chan struct{}, maxConcurrentDNSLookups)
+
+// DNSResolution is the best-effort result of resolving one of the DNS names
+// of a discovered certificate.
+type DNSResolution struct {
+	Name  string   `json:"name"`
+	Addrs []string `json:"addrs"`
+	CNAME string   `json:"cname,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func (r *DNSResolution) String() string {
+	var str string
+	if r.CNAME != "" {
+		str = "CNAME " + r.CNAME + " "
+	}
+	if r.Error != "" {
+		return str + "[" + r.Error + "]"
+	}
+	return str + strings.Join(r.Addrs, ", ")
+}
+
+// resolveDNSNames looks up the A and AAAA records (and, if lookupCNAME is
+// true, the CNAME) of each DNS name, other than wildcard and redacted names.
+// At most maxConcurrentDNSLookups names are resolved at once by the whole
+// process, and all lookups (including ones still waiting for their turn) are
+// abandoned after timeout.  Failures are recorded in the result rather than
+// returned.
+func resolveDNSNames(ctx context.Context, dnsNames []string, timeout time.Duration, lookupCNAME bool) []*DNSResolution {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var resolutions []*DNSResolution
+	for _, dnsName := range dnsNames {
+		if strings.ContainsAny(dnsName, "*?") || strings.Contains(dnsName, "<") {
+			continue
+		}
+		resolutions = append(resolutions, &DNSResolution{Name: dnsName})
+	}
+
+	group := new(errgroup.Group)
+	for _, resolution := range resolutions {
+		resolution := resolution
+		group.Go(func() error {
+			select {
+			case dnsLookupSemaphore <- struct{}{}:
+				defer func() { <-dnsLookupSemaphore }()
+			case <-ctx.Done():
+				resolution.Error = ctx.Err().Error()
+				return nil
+			}
+			resolveDNSName(ctx, resolution, lookupCNAME)
+			return nil
+		})
+	}
+	group.Wait()
+	return resolutions
+}
+
+func resolveDNSName(ctx context.Context, resolution *DNSResolution, lookupCNAME bool) {
+	if lookupCNAME {
+		if cname, err := net.DefaultResolver.LookupCNAME(ctx, resolution.Name); err == nil && strings.TrimSuffix(cname, ".") != resolution.Name {
+			resolution.CNAME = strings.TrimSuffix(cname, ".")
+		}
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, resolution.Name)
+	if err != nil {
+		resolution.Error = err.Error()
+		return
+	}
+	resolution.Addrs = make([]string, len(addrs))
+	for i, addr := range addrs {
+		resolution.Addrs[i] = addr.IP.String()
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// DryRunState wraps a StateProvider, reading state from it but never writing
+// to it or sending notifications.  Instead, state is written to memory (so
+// that monitoring makes progress as usual) and notifications are logged.
+type DryRunState struct {
+	StateProvider
+
+	mu          sync.Mutex
+	logStates   map[LogID]*LogState
+	sths        map[LogID][]*ct.SignedTreeHead
+	keyIssuers  map[[32]byte][]string
+	precertTBS  map[[32]byte]bool
+	notifiedTBS map[[32]byte]bool
+	notified    map[notifiedLeaf]bool
+	roots       map[LogID][][32]byte
+}
+
+func NewDryRunState(state StateProvider) *DryRunState {
+	return &DryRunState{
+		StateProvider: state,
+		logStates:     make(map[LogID]*LogState),
+		sths:          make(map[LogID][]*ct.SignedTreeHead),
+		keyIssuers:    make(map[[32]byte][]string),
+		precertTBS:    make(map[[32]byte]bool),
+		notifiedTBS:   make(map[[32]byte]bool),
+		notified:      make(map[notifiedLeaf]bool),
+		roots:         make(map[LogID][][32]byte),
+	}
+}
+
+func (s *DryRunState) Prepare(ctx context.Context) error {
+	return nil
+}
+
+func (s *DryRunState) PrepareLog(ctx context.Context, logID LogID) error {
+	return nil
+}
+
+func (s *DryRunState) StoreLogState(ctx context.Context, logID LogID, state *LogState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logStates[logID] = state
+	return nil
+}
+
+func (s *DryRunState) LoadLogState(ctx context.Context, logID LogID) (*LogState, error) {
+	s.mu.Lock()
+	state, ok := s.logStates[logID]
+	s.mu.Unlock()
+	if ok {
+		return state, nil
+	}
+	return s.StateProvider.LoadLogState(ctx, logID)
+}
+
+// loadSTHs returns the in-memory STHs for the log, copying them from the
+// underlying StateProvider the first time.  s.mu must be held.
+func (s *DryRunState) loadSTHs(ctx context.Context, logID LogID) ([]*ct.SignedTreeHead, error) {
+	if sths, ok := s.sths[logID]; ok {
+		return sths, nil
+	}
+	sths, err := s.StateProvider.LoadSTHs(ctx, logID)
+	if err != nil {
+		return nil, err
+	}
+	s.sths[logID] = sths
+	return sths, nil
+}
+
+func (s *DryRunState) StoreSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sths, err := s.loadSTHs(ctx, logID)
+	if err != nil {
+		return err
+	}
+	for _, existing := range sths {
+		if existing.TreeSize == sth.TreeSize && existing.Timestamp == sth.Timestamp && existing.SHA256RootHash == sth.SHA256RootHash {
+			return nil
+		}
+	}
+	sths = append(slices.Clone(sths), sth)
+	slices.SortStableFunc(sths, func(a, b *ct.SignedTreeHead) int {
+		switch {
+		case a.TreeSize < b.TreeSize:
+			return -1
+		case a.TreeSize > b.TreeSize:
+			return 1
+		default:
+			return 0
+		}
+	})
+	s.sths[logID] = sths
+	return nil
+}
+
+func (s *DryRunState) LoadSTHs(ctx context.Context, logID LogID) ([]*ct.SignedTreeHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sths, err := s.loadSTHs(ctx, logID)
+	return slices.Clone(sths), err
+}
+
+func (s *DryRunState) RemoveSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sths, err := s.loadSTHs(ctx, logID)
+	if err != nil {
+		return err
+	}
+	s.sths[logID] = slices.DeleteFunc(slices.Clone(sths), func(existing *ct.SignedTreeHead) bool {
+		return existing.TreeSize == sth.TreeSize && existing.Timestamp == sth.Timestamp && existing.SHA256RootHash == sth.SHA256RootHash
+	})
+	return nil
+}
+
+func (s *DryRunState) StoreConflictingSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	logDryRunNotification("conflicting STH retained as evidence", zap.String("logID", logID.Base64String()), zap.Uint64("treeSize", sth.TreeSize), zap.String("rootHash", sth.SHA256RootHash.Base64String()))
+	return nil
+}
+
+func (s *DryRunState) LoadKeyIssuers(ctx context.Context, pubkeySHA256 [32]byte) ([]string, error) {
+	var issuers []string
+	if store, ok := optionalState[keyIssuerStore](s.StateProvider); ok {
+		stored, err := store.LoadKeyIssuers(ctx, pubkeySHA256)
+		if err != nil {
+			return nil, err
+		}
+		issuers = stored
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, issuer := range s.keyIssuers[pubkeySHA256] {
+		if !slices.Contains(issuers, issuer) {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers, nil
+}
+
+func (s *DryRunState) StoreKeyIssuer(ctx context.Context, pubkeySHA256 [32]byte, issuer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyIssuers[pubkeySHA256] = append(s.keyIssuers[pubkeySHA256], issuer)
+	return nil
+}
+
+func (s *DryRunState) StorePrecertTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.precertTBS[tbsSHA256] = true
+	return nil
+}
+
+func (s *DryRunState) HasPrecertTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	s.mu.Lock()
+	stored := s.precertTBS[tbsSHA256]
+	s.mu.Unlock()
+	if stored {
+		return true, nil
+	}
+	if store, ok := optionalState[precertTBSStore](s.StateProvider); ok {
+		return store.HasPrecertTBS(ctx, tbsSHA256)
+	}
+	return false, nil
+}
+
+func (s *DryRunState) StoreNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifiedTBS[tbsSHA256] = true
+	return nil
+}
+
+func (s *DryRunState) HasNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	s.mu.Lock()
+	stored := s.notifiedTBS[tbsSHA256]
+	s.mu.Unlock()
+	if stored {
+		return true, nil
+	}
+	if store, ok := optionalState[notifiedTBSStore](s.StateProvider); ok {
+		return store.HasNotifiedTBS(ctx, tbsSHA256)
+	}
+	return false, nil
+}
+
+func (s *DryRunState) MarkNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notified[notifiedLeaf{logID, leafHash}] = true
+	return nil
+}
+
+func (s *DryRunState) WasNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) (bool, error) {
+	s.mu.Lock()
+	stored := s.notified[notifiedLeaf{logID, leafHash}]
+	s.mu.Unlock()
+	if stored {
+		return true, nil
+	}
+	if store, ok := optionalState[notifiedLeafStore](s.StateProvider); ok {
+		return store.WasNotified(ctx, logID, leafHash)
+	}
+	return false, nil
+}
+
+func (s *DryRunState) LoadRoots(ctx context.Context, logID LogID) ([][32]byte, error) {
+	s.mu.Lock()
+	roots, stored := s.roots[logID]
+	s.mu.Unlock()
+	if stored {
+		return slices.Clone(roots), nil
+	}
+	if store, ok := optionalState[rootsStore](s.StateProvider); ok {
+		return store.LoadRoots(ctx, logID)
+	}
+	return nil, nil
+}
+
+func (s *DryRunState) StoreRoots(ctx context.Context, logID LogID, fingerprints [][32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roots[logID] = slices.Clone(fingerprints)
+	return nil
+}
+
+func logDryRunNotification(summary string, fields ...zap.Field) {
+	zap.L().Info("dry run: would notify: "+summary, fields...)
+}
+
+func (s *DryRunState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	logDryRunNotification(certNotificationSummary(cert), cert.Json()...)
+	return nil
+}
+
+func (s *DryRunState) NotifyCertDigest(ctx context.Context, certs []*DiscoveredCert) error {
+	for _, cert := range certs {
+		logDryRunNotification(certNotificationSummary(cert), cert.Json()...)
+	}
+	return nil
+}
+
+func (s *DryRunState) LoadPendingNotifications(ctx context.Context) ([]*PendingNotification, error) {
+	// Notifications aren't sent during a dry run, so don't retry
+	// notifications which failed before it
+	return nil, nil
+}
+
+func (s *DryRunState) SendPendingNotification(ctx context.Context, pending *PendingNotification) error {
+	logDryRunNotification("retry: "+pending.Summary, zap.Strings("methods", pending.Methods))
+	return nil
+}
+
+func (s *DryRunState) StoreHeldCert(ctx context.Context, queue string, cert *DiscoveredCert) error {
+	return nil
+}
+
+func (s *DryRunState) LoadHeldCerts(ctx context.Context, queue string) ([]*DiscoveredCert, error) {
+	// Certificates held before the dry run are sent when certspotter
+	// next runs normally, so don't send them now
+	return nil, nil
+}
+
+func (s *DryRunState) RemoveHeldCert(ctx context.Context, queue string, fingerprint [32]byte) error {
+	return nil
+}
+
+func (s *DryRunState) NotifyKeyReusedAcrossIssuers(ctx context.Context, cert *DiscoveredCert, otherIssuers []string) error {
+	logDryRunNotification(keyReuseSummary(cert), keyReuseJson(cert, otherIssuers)...)
+	return nil
+}
+
+func (s *DryRunState) NotifyPrecertAnomaly(ctx context.Context, cert *DiscoveredCert, anomaly string) error {
+	logDryRunNotification(precertAnomalySummary(cert), precertAnomalyJson(cert, anomaly)...)
+	return nil
+}
+
+func (s *DryRunState) NotifyMalformedEntry(ctx context.Context, entry *LogEntry, parseError error) error {
+	logDryRunNotification("malformed log entry", append(entry.Json(), zap.Error(parseError))...)
+	return nil
+}
+
+func (s *DryRunState) NotifyLogContacted(ctx context.Context, ctlog *loglist.Log, sth *ct.SignedTreeHead) error {
+	logDryRunNotification("log contacted", zap.String("log", ctlog.URL), zap.Uint64("treeSize", sth.TreeSize))
+	return nil
+}
+
+func (s *DryRunState) NotifyLogListChanged(ctx context.Context, added []*loglist.Log, removed []*loglist.Log) error {
+	logDryRunNotification("log list changed", zap.Int("added", len(added)), zap.Int("removed", len(removed)))
+	return nil
+}
+
+func (s *DryRunState) NotifyLifecycleEvent(ctx context.Context, event *LifecycleEvent) error {
+	logDryRunNotification(event.Summary(), event.Json()...)
+	return nil
+}
+
+func (s *DryRunState) NotifyHealthCheckFailure(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	logDryRunNotification(info.Summary(), info.Json()...)
+	return nil
+}
+
+func (s *DryRunState) NotifyHealthCheckRecovered(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	logDryRunNotification("recovered: "+info.Summary(), info.Json()...)
+	return nil
+}
+
+func (s *DryRunState) StoreHealthCheckResult(ctx context.Context, failures []HealthCheckFailure) error {
+	return nil
+}
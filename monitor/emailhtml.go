@@ -0,0 +1,106 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+type emailHTMLLink struct {
+	Text string
+	URL  string // empty if there is nothing to link to
+}
+
+type emailHTMLData struct {
+	Summary    string
+	Text       string // preformatted text, used if the notification is not about a certificate
+	Names      []string
+	Issuer     string
+	NotBefore  string
+	NotAfter   string
+	LogEntries []emailHTMLLink
+	CrtSh      emailHTMLLink
+}
+
+var emailHTMLTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Summary}}</title></head>
+<body style="font-family: sans-serif">
+<h2>{{.Summary}}</h2>
+{{- if .Names}}
+<table cellpadding="4" style="border-collapse: collapse">
+<tr><th align="left" valign="top">Identifiers</th><td>{{range $i, $name := .Names}}{{if $i}}<br>{{end}}<code>{{$name}}</code>{{end}}</td></tr>
+<tr><th align="left" valign="top">Issuer</th><td>{{.Issuer}}</td></tr>
+<tr><th align="left" valign="top">Not Before</th><td>{{.NotBefore}}</td></tr>
+<tr><th align="left" valign="top">Not After</th><td>{{.NotAfter}}</td></tr>
+<tr><th align="left" valign="top">Log Entry</th><td>{{range $i, $entry := .LogEntries}}{{if $i}}<br>{{end}}{{if $entry.URL}}<a href="{{$entry.URL}}">{{$entry.Text}}</a>{{else}}{{$entry.Text}}{{end}}{{end}}</td></tr>
+<tr><th align="left" valign="top">crt.sh</th><td><a href="{{.CrtSh.URL}}">{{.CrtSh.Text}}</a></td></tr>
+</table>
+{{- else}}
+<pre>{{.Text}}</pre>
+{{- end}}
+</body>
+</html>
+`))
+
+// logEntryLink returns a link to the get-entries URL for the log entry, or
+// just its description if the log doesn't support get-entries.
+func logEntryLink(entry *LogEntry) emailHTMLLink {
+	link := emailHTMLLink{Text: fmt.Sprintf("%d @ %s", entry.Index, entry.Log.URL)}
+	if !entry.Log.IsStaticCTAPI() {
+		link.URL = fmt.Sprintf("%s/ct/v1/get-entries?start=%d&end=%d", strings.TrimRight(entry.Log.URL, "/"), entry.Index, entry.Index)
+	}
+	return link
+}
+
+// makeEmailHTML returns an HTML version of the notification.  Notifications
+// about a certificate are formatted as a table; others contain the text.
+func makeEmailHTML(notif *notification) string {
+	data := emailHTMLData{
+		Summary: notif.summary,
+		Text:    strings.TrimRight(notif.text, "\n"),
+	}
+	if cert := notif.cert; cert != nil {
+		data.Names = append(data.Names, cert.Identifiers.DNSNames...)
+		for _, ipaddr := range cert.Identifiers.IPAddrs {
+			data.Names = append(data.Names, ipaddr.String())
+		}
+		if cert.Info.IssuerParseError == nil {
+			data.Issuer = cert.Info.Issuer.String()
+		} else {
+			data.Issuer = fmt.Sprintf("[unable to parse: %s]", cert.Info.IssuerParseError)
+		}
+		if cert.Info.ValidityParseError == nil {
+			data.NotBefore = cert.Info.Validity.NotBefore.UTC().Format(time.RFC3339)
+			data.NotAfter = cert.Info.Validity.NotAfter.UTC().Format(time.RFC3339)
+		} else {
+			data.NotBefore = fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError)
+			data.NotAfter = data.NotBefore
+		}
+		if cert.Sightings != nil {
+			for _, entry := range cert.Sightings {
+				data.LogEntries = append(data.LogEntries, logEntryLink(entry))
+			}
+		} else {
+			data.LogEntries = []emailHTMLLink{logEntryLink(cert.LogEntry)}
+		}
+		sha256 := hex.EncodeToString(cert.SHA256[:])
+		data.CrtSh = emailHTMLLink{Text: sha256, URL: "https://crt.sh/?sha256=" + sha256}
+	}
+	var html strings.Builder
+	if err := emailHTMLTemplate.Execute(&html, &data); err != nil {
+		panic(fmt.Errorf("error executing email HTML template: %w", err))
+	}
+	return html.String()
+}
@@ -11,6 +11,7 @@ package monitor
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	"go.uber.org/zap"
@@ -27,3 +28,34 @@ func recordError(ctx context.Context, config *Config, ctlog *loglist.Log, errToR
 		}
 	}
 }
+
+// Errors returned by Run may wrap one of the following errors, which indicate
+// the category of the failure.
+var (
+	ErrState        = errors.New("state error")
+	ErrLogList      = errors.New("log list error")
+	ErrNotification = errors.New("notification error")
+
+	// ErrIncomplete is returned in one-shot mode (see Config.OneShot)
+	// if at least one log couldn't be monitored up to its latest STH.
+	// Unlike other errors, it doesn't stop the other logs from being
+	// monitored.
+	ErrIncomplete = errors.New("incomplete")
+)
+
+// classifiedError wraps err so that it also matches class with errors.Is,
+// without changing its message.
+type classifiedError struct {
+	err   error
+	class error
+}
+
+func (e *classifiedError) Error() string   { return e.err.Error() }
+func (e *classifiedError) Unwrap() []error { return []error{e.err, e.class} }
+
+func classifyError(err error, class error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, class: class}
+}
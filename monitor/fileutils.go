@@ -50,6 +50,19 @@ func writeJSONFile(filename string, data any, perm os.FileMode) error {
 	return writeFile(filename, fileBytes, perm)
 }
 
+// replaceSymlink atomically creates or replaces a symlink at linkname pointing to target
+func replaceSymlink(target string, linkname string) error {
+	tempname := linkname + ".tmp." + randomFileSuffix()
+	if err := os.Symlink(target, tempname); err != nil {
+		return fmt.Errorf("error creating symlink %s: %w", linkname, err)
+	}
+	if err := os.Rename(tempname, linkname); err != nil {
+		os.Remove(tempname)
+		return fmt.Errorf("error creating symlink %s: %w", linkname, err)
+	}
+	return nil
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Lstat(filename)
 	return err == nil
@@ -0,0 +1,45 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linkname := filepath.Join(dir, "latest.txt")
+	for _, target := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, target), []byte(target), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := replaceSymlink(target, linkname); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.Readlink(linkname); err != nil {
+			t.Fatal(err)
+		} else if got != target {
+			t.Errorf("symlink points to %q; expected %q", got, target)
+		}
+		if contents, err := os.ReadFile(linkname); err != nil {
+			t.Fatal(err)
+		} else if string(contents) != target {
+			t.Errorf("reading through the symlink returned %q; expected %q", contents, target)
+		}
+	}
+	// No temporary files are left behind
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 3 {
+		t.Errorf("directory contains %d files; expected 3", len(entries))
+	}
+}
@@ -15,12 +15,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"go.uber.org/zap"
 	"software.sslmate.com/src/certspotter/ct"
 	"software.sslmate.com/src/certspotter/loglist"
 )
@@ -31,8 +39,95 @@ type FilesystemState struct {
 	Script    string
 	ScriptDir string
 	Email     []string
+	SMTP      *SMTPConfig // if non-nil, send email using SMTP instead of sendmail
+	Webhook   string      // URL to which notifications are POSTed as JSON
 	Stdout    bool
 	Json      bool
+
+	JsonLogger *zap.Logger // logger for Json output; if nil, zap.L() is used
+	NDJSON     bool        // write a JSON record for each discovered certificate to stdout
+
+	// Writer for Stdout and NDJSON output (e.g. a BufferedWriter); if nil,
+	// os.Stdout is used.  Json output is written by JsonLogger instead.
+	StdoutWriter io.Writer
+
+	// Character set of email, either UTF-8 or US-ASCII (in which case
+	// non-ASCII characters are replaced with "?").  Empty means UTF-8.
+	EmailCharset string
+
+	// If true, email contains an HTML version of the notification in
+	// addition to the text.
+	EmailHTML bool
+
+	WebhookContentType string // defaults to application/json
+	WebhookToken       string // if non-empty, sent as a bearer token
+
+	// If non-nil, the client certificate presented to the Webhook server
+	// when it requests one, and the CAs trusted to verify its certificate.
+	// Use ReloadWebhookTLS to reload them.
+	WebhookTLS *TLSCredentials
+
+	SlackWebhookURL string // Slack incoming webhook to which notifications are posted
+	SlackChannel    string // if non-empty, overrides the webhook's default channel
+	SlackUsername   string // if non-empty, overrides the webhook's default username
+
+	DiscordWebhookURL string // Discord webhook to which notifications are posted
+
+	TeamsWebhookURL string // Microsoft Teams webhook to which notifications are posted
+
+	// If non-nil, discovered certificates are saved under the certs
+	// directory at the path produced by this template (see
+	// ParseCertFilenameTemplate) instead of at certs/XX/SHA256.
+	CertFilenameTemplate *template.Template
+
+	TelegramBotToken string // if non-empty, notifications are sent to TelegramChatID using this bot
+	TelegramChatID   string
+
+	NtfyURL          string // ntfy server (e.g. https://ntfy.sh), or topic URL if NtfyTopic is empty
+	NtfyTopic        string
+	NtfyToken        string // if non-empty, sent as a bearer token
+	NtfyPriority     string // priority of informational notifications; empty means the server's default
+	NtfyWarnPriority string // priority of warnings, such as health check failures
+
+	GotifyURL          string // if non-empty, notifications are posted to this Gotify server
+	GotifyToken        string // application token
+	GotifyPriority     int    // priority of informational notifications
+	GotifyWarnPriority int    // priority of warnings, such as health check failures
+
+	SNSTopicARN string // if non-empty, notifications are published to this AWS SNS topic
+	SNSRegion   string // if empty, the region in SNSTopicARN is used
+	SNSEndpoint string // if empty, the standard endpoint for the region is used
+
+	PagerDutyRoutingKey string // if non-empty, health check failures trigger PagerDuty alerts, which are resolved on recovery
+	PagerDutyURL        string // if empty, the standard Events API v2 endpoint is used
+
+	MatrixHomeserver         string // if non-empty, notifications are sent to MatrixRoomID via this homeserver
+	MatrixAccessToken        string
+	MatrixRoomID             string
+	MatrixInsecureSkipVerify bool // don't verify the homeserver's TLS certificate
+
+	Syslog SyslogWriter // if non-nil, notification summaries are sent to syslog
+
+	ScriptTimeout     time.Duration // if non-zero, scripts which run for longer are killed
+	ScriptConcurrency int           // maximum number of scripts to run at once; 0 means unlimited
+
+	webhookClientOnce sync.Once
+	webhookClient     *http.Client
+
+	snsClientMu sync.Mutex
+	snsClient   *sns.Client
+
+	scriptSemOnce sync.Once
+	scriptSem     chan struct{}
+
+	notifiedLeavesMu sync.Mutex
+	notifiedLeaves   map[LogID]*notifiedLeafSet // loaded lazily; see MarkNotified
+
+	// Fields to extract from discovered certificates into the JSON file,
+	// JSON output, and script environment.  If empty, DefaultCertFields is
+	// used for the JSON file and nothing extra is added to the JSON output
+	// or environment.
+	CertFields []string
 }
 
 func (s *FilesystemState) logStateDir(logID LogID) string {
@@ -93,59 +188,185 @@ func (s *FilesystemState) RemoveSTH(ctx context.Context, logID LogID, sth *ct.Si
 	return removeSTHFromDir(sthsDirPath, sth)
 }
 
+func (s *FilesystemState) StoreConflictingSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	sthsDirPath := filepath.Join(s.logStateDir(logID), "conflicting_sths")
+	if err := os.Mkdir(sthsDirPath, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return storeSTHInDir(sthsDirPath, sth)
+}
+
 func (s *FilesystemState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
-	var notifiedPath string
-	var paths *certPaths
-	if s.SaveCerts {
-		hexFingerprint := hex.EncodeToString(cert.SHA256[:])
-		prefixPath := filepath.Join(s.StateDir, "certs", hexFingerprint[0:2])
-		var (
-			notifiedFilename      = "." + hexFingerprint + ".notified"
-			certFilename          = hexFingerprint + ".pem"
-			jsonFilename          = hexFingerprint + ".v1.json"
-			textFilename          = hexFingerprint + ".txt"
-			legacyCertFilename    = hexFingerprint + ".cert.pem"
-			legacyPrecertFilename = hexFingerprint + ".precert.pem"
-		)
-
-		for _, filename := range []string{notifiedFilename, legacyCertFilename, legacyPrecertFilename} {
-			if fileExists(filepath.Join(prefixPath, filename)) {
-				return nil
-			}
+	notifiedPath, paths, alreadyNotified, err := s.saveCert(cert)
+	if err != nil {
+		return err
+	} else if alreadyNotified {
+		return nil
+	}
+
+	if err := s.notify(ctx, &notification{
+		summary: certNotificationSummary(cert),
+		environ: append(certNotificationEnviron(cert, paths), certFieldsEnviron(cert, s.CertFields)...),
+		text:    certNotificationText(cert, paths),
+		json:    append(cert.Json(), certFieldsJson(cert, s.CertFields)...),
+		cert:    cert,
+	}); err != nil {
+		return fmt.Errorf("error notifying about discovered certificate for %s (%x): %w", cert.WatchItem, cert.SHA256, err)
+	}
+
+	return s.markCertNotified(cert, notifiedPath, paths)
+}
+
+// saveCert writes the certificate's files to the state directory, if
+// s.SaveCerts is set, and returns the path of the file which marks it as
+// notified along with the paths of the files.  alreadyNotified is true if the
+// certificate was previously notified about, in which case no files are
+// written.
+func (s *FilesystemState) saveCert(cert *DiscoveredCert) (notifiedPath string, paths *certPaths, alreadyNotified bool, err error) {
+	if !s.SaveCerts {
+		// TODO-4: save cert to temporary files, and defer their unlinking
+		return "", nil, false, nil
+	}
+
+	// Whether the certificate was notified about is always recorded under
+	// certs/XX, keyed by fingerprint, so that it's independent of
+	// s.CertFilenameTemplate, which might not produce a unique path for
+	// each certificate, and which might change.
+	hexFingerprint := hex.EncodeToString(cert.SHA256[:])
+	fingerprintPath := filepath.Join(s.StateDir, "certs", hexFingerprint[0:2])
+	notifiedPath = filepath.Join(fingerprintPath, "."+hexFingerprint+".notified")
+	previouslyNotifiedPaths := []string{
+		notifiedPath,
+		filepath.Join(fingerprintPath, hexFingerprint+".cert.pem"),    // legacy
+		filepath.Join(fingerprintPath, hexFingerprint+".precert.pem"), // legacy
+	}
+
+	prefixPath := fingerprintPath
+	basename := hexFingerprint
+	if s.CertFilenameTemplate != nil {
+		name, err := executeCertFilenameTemplate(s.CertFilenameTemplate, makeCertFilenameData(cert))
+		if err != nil {
+			return "", nil, false, fmt.Errorf("error executing filename template for certificate %x: %w", cert.SHA256, err)
+		}
+		prefixPath = filepath.Join(s.StateDir, "certs", filepath.Dir(filepath.FromSlash(name)))
+		basename = path.Base(name)
+		if strings.Contains(name, hexFingerprint) {
+			// Older versions saved the marker next to the certificate's
+			// files, which is only unique to this certificate if the
+			// path contains its fingerprint
+			previouslyNotifiedPaths = append(previouslyNotifiedPaths, filepath.Join(prefixPath, "."+basename+".notified"))
 		}
+	}
 
-		if err := os.Mkdir(prefixPath, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
-			return fmt.Errorf("error creating directory in which to save certificate %x: %w", cert.SHA256, err)
+	for _, previouslyNotifiedPath := range previouslyNotifiedPaths {
+		if fileExists(previouslyNotifiedPath) {
+			return "", nil, true, nil
 		}
+	}
 
-		notifiedPath = filepath.Join(prefixPath, notifiedFilename)
-		paths = &certPaths{
-			certPath: filepath.Join(prefixPath, certFilename),
-			jsonPath: filepath.Join(prefixPath, jsonFilename),
-			textPath: filepath.Join(prefixPath, textFilename),
+	for _, dir := range []string{fingerprintPath, prefixPath} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return "", nil, false, fmt.Errorf("error creating directory in which to save certificate %x: %w", cert.SHA256, err)
 		}
-		if err := writeCertFiles(cert, paths); err != nil {
-			return fmt.Errorf("error saving certificate %x: %w", cert.SHA256, err)
+	}
+
+	paths = &certPaths{
+		certPath: filepath.Join(prefixPath, basename+".pem"),
+		jsonPath: filepath.Join(prefixPath, basename+".v1.json"),
+		textPath: filepath.Join(prefixPath, basename+".txt"),
+	}
+	if err := writeCertFiles(cert, paths, s.CertFields); err != nil {
+		return "", nil, false, fmt.Errorf("error saving certificate %x: %w", cert.SHA256, err)
+	}
+	return notifiedPath, paths, false, nil
+}
+
+// markCertNotified records that the certificate saved by saveCert has been
+// notified about.
+func (s *FilesystemState) markCertNotified(cert *DiscoveredCert, notifiedPath string, paths *certPaths) error {
+	if notifiedPath == "" {
+		return nil
+	}
+	if err := os.WriteFile(notifiedPath, nil, 0666); err != nil {
+		return fmt.Errorf("error saving certificate %x: %w", cert.SHA256, err)
+	}
+	if err := appendToCertIndex(s.StateDir, makeCertIndexRecord(cert, paths)); err != nil {
+		return fmt.Errorf("error indexing certificate %x: %w", cert.SHA256, err)
+	}
+	return nil
+}
+
+func (s *FilesystemState) NotifyCertDigest(ctx context.Context, certs []*DiscoveredCert) error {
+	type savedCert struct {
+		cert         *DiscoveredCert
+		notifiedPath string
+		paths        *certPaths
+	}
+	var saved []savedCert
+	for _, cert := range certs {
+		notifiedPath, paths, alreadyNotified, err := s.saveCert(cert)
+		if err != nil {
+			return err
+		} else if !alreadyNotified {
+			saved = append(saved, savedCert{cert: cert, notifiedPath: notifiedPath, paths: paths})
 		}
-	} else {
-		// TODO-4: save cert to temporary files, and defer their unlinking
+	}
+	if len(saved) == 0 {
+		return nil
 	}
 
+	var (
+		fingerprints  = make([]string, len(saved))
+		texts         = make([]string, len(saved))
+		digest        = make([]*DiscoveredCert, len(saved))
+		jsonFilenames []string
+	)
+	for i, c := range saved {
+		digest[i] = c.cert
+		fingerprints[i] = hex.EncodeToString(c.cert.SHA256[:])
+		texts[i] = certNotificationText(c.cert, c.paths)
+		if c.paths != nil {
+			jsonFilenames = append(jsonFilenames, c.paths.jsonPath)
+		}
+	}
+	groups := groupByRegistrableDomain(digest)
+	domainCounts := make(map[string]int, len(groups))
+	domainPairs := make([]string, len(groups))
+	for i, group := range groups {
+		domainCounts[group.domain] = len(group.indexes)
+		domainPairs[i] = fmt.Sprintf("%s=%d", group.domain, len(group.indexes))
+	}
+	summary := certDigestSummary(len(saved), groups)
+	environ := []string{
+		"EVENT=discovered_cert_digest",
+		"SUMMARY=" + summary,
+		"CERT_COUNT=" + fmt.Sprint(len(saved)),
+		"CERT_SHA256S=" + strings.Join(fingerprints, " "),
+		"REGISTRABLE_DOMAINS=" + strings.Join(domainPairs, " "),
+	}
+	if jsonFilenames != nil {
+		environ = append(environ, "JSON_FILENAMES="+strings.Join(jsonFilenames, " "))
+	}
 	if err := s.notify(ctx, &notification{
-		summary: certNotificationSummary(cert),
-		environ: certNotificationEnviron(cert, paths),
-		text:    certNotificationText(cert, paths),
-		json:    cert.Json(),
+		summary: summary,
+		environ: environ,
+		text:    certDigestText(groups, texts),
+		json: []zap.Field{
+			zap.String("type", "certificate_digest"),
+			zap.Int("certCount", len(saved)),
+			zap.Strings("certSHA256s", fingerprints),
+			zap.Any("registrableDomains", domainCounts),
+		},
+		digest: digest,
 	}); err != nil {
-		return fmt.Errorf("error notifying about discovered certificate for %s (%x): %w", cert.WatchItem, cert.SHA256, err)
+		return fmt.Errorf("error notifying about digest of %d discovered certificates: %w", len(saved), err)
 	}
 
-	if notifiedPath != "" {
-		if err := os.WriteFile(notifiedPath, nil, 0666); err != nil {
-			return fmt.Errorf("error saving certificate %x: %w", cert.SHA256, err)
+	for _, c := range saved {
+		if err := s.markCertNotified(c.cert, c.notifiedPath, c.paths); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
@@ -196,13 +417,189 @@ func (s *FilesystemState) NotifyMalformedEntry(ctx context.Context, entry *LogEn
 		environ: environ,
 		summary: summary,
 		text:    text.String(),
-		json:    entry.Json(),
+		json:    append(entry.Json(), zap.String("parseError", parseError.Error())),
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s *FilesystemState) LoadKeyIssuers(ctx context.Context, pubkeySHA256 [32]byte) ([]string, error) {
+	return readKeyIssuers(keyIssuersPath(s.StateDir, pubkeySHA256))
+}
+
+func (s *FilesystemState) StoreKeyIssuer(ctx context.Context, pubkeySHA256 [32]byte, issuer string) error {
+	return appendKeyIssuer(keyIssuersPath(s.StateDir, pubkeySHA256), issuer)
+}
+
+func (s *FilesystemState) NotifyKeyReusedAcrossIssuers(ctx context.Context, cert *DiscoveredCert, otherIssuers []string) error {
+	environ := []string{
+		"EVENT=key_reused_across_issuers",
+		"SUMMARY=" + keyReuseSummary(cert),
+		"LOG_URI=" + cert.LogEntry.Log.URL,
+		"ENTRY_INDEX=" + fmt.Sprint(cert.LogEntry.Index),
+		"WATCH_ITEM=" + cert.WatchItem.String(),
+		"CERT_SHA256=" + hex.EncodeToString(cert.SHA256[:]),
+		"PUBKEY_SHA256=" + hex.EncodeToString(cert.PubkeySHA256[:]),
+		"ISSUER_DN=" + cert.Info.Issuer.String(),
+		"PRIOR_ISSUERS=" + strings.Join(otherIssuers, "; "),
+	}
+
+	return s.notify(ctx, &notification{
+		environ: environ,
+		summary: keyReuseSummary(cert),
+		text:    keyReuseText(cert, otherIssuers),
+		json:    keyReuseJson(cert, otherIssuers),
+	})
+}
+
+func (s *FilesystemState) StorePrecertTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	path := precertsPath(s.StateDir, hex.EncodeToString(tbsSHA256[:]))
+	if err := os.Mkdir(filepath.Dir(path), 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return os.WriteFile(path, nil, 0666)
+}
+
+func (s *FilesystemState) HasPrecertTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	return fileExists(precertsPath(s.StateDir, hex.EncodeToString(tbsSHA256[:]))), nil
+}
+
+func (s *FilesystemState) StoreNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	path := notifiedTBSPath(s.StateDir, hex.EncodeToString(tbsSHA256[:]))
+	if err := os.Mkdir(filepath.Dir(path), 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return os.WriteFile(path, nil, 0666)
+}
+
+func (s *FilesystemState) HasNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	return fileExists(notifiedTBSPath(s.StateDir, hex.EncodeToString(tbsSHA256[:]))), nil
+}
+
+func (s *FilesystemState) NotifyPrecertAnomaly(ctx context.Context, cert *DiscoveredCert, anomaly string) error {
+	hexFingerprint := hex.EncodeToString(cert.SHA256[:])
+	notifiedPath := filepath.Join(s.StateDir, "precerts", hexFingerprint[0:2], "."+hexFingerprint+".notified")
+	if fileExists(notifiedPath) {
+		return nil
+	}
+
+	environ := []string{
+		"EVENT=precert_anomaly",
+		"SUMMARY=" + precertAnomalySummary(cert),
+		"LOG_URI=" + cert.LogEntry.Log.URL,
+		"ENTRY_INDEX=" + fmt.Sprint(cert.LogEntry.Index),
+		"WATCH_ITEM=" + cert.WatchItem.String(),
+		"TBS_SHA256=" + hex.EncodeToString(cert.TBSSHA256[:]),
+		"CERT_SHA256=" + hex.EncodeToString(cert.SHA256[:]),
+		"ANOMALY=" + anomaly,
+	}
+	if err := s.notify(ctx, &notification{
+		environ: environ,
+		summary: precertAnomalySummary(cert),
+		text:    precertAnomalyText(cert, anomaly),
+		json:    precertAnomalyJson(cert, anomaly),
+	}); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(filepath.Dir(notifiedPath), 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return os.WriteFile(notifiedPath, nil, 0666)
+}
+
+func (s *FilesystemState) NotifyLogContacted(ctx context.Context, ctlog *loglist.Log, sth *ct.SignedTreeHead) error {
+	summary := fmt.Sprintf("Successfully Contacted %s", ctlog.URL)
+
+	text := new(strings.Builder)
+	writeField := func(name string, value any) { fmt.Fprintf(text, "\t%13s = %s\n", name, value) }
+	fmt.Fprintf(text, "certspotter has successfully retrieved a signed tree head from %s for the first time since starting.\n", ctlog.URL)
+	writeField("Tree Size", fmt.Sprint(sth.TreeSize))
+	writeField("Timestamp", sth.TimestampTime())
+
+	environ := []string{
+		"EVENT=log_contacted",
+		"SUMMARY=" + summary,
+		"LOG_URI=" + ctlog.URL,
+		"TREE_SIZE=" + fmt.Sprint(sth.TreeSize),
+		"STH_TIMESTAMP=" + sth.TimestampTime().Format(time.RFC3339),
+	}
+
+	return s.notify(ctx, &notification{
+		environ: environ,
+		summary: summary,
+		text:    text.String(),
+		json: []zap.Field{
+			zap.String("log", ctlog.URL),
+			zap.Uint64("treeSize", sth.TreeSize),
+			zap.Time("sthTimestamp", sth.TimestampTime()),
+		},
+	})
+}
+
+func (s *FilesystemState) NotifyLogListChanged(ctx context.Context, added []*loglist.Log, removed []*loglist.Log) error {
+	summary := fmt.Sprintf("Log List Changed: %d Added, %d Removed", len(added), len(removed))
+
+	urls := func(logs []*loglist.Log) []string {
+		urls := make([]string, len(logs))
+		for i, ctlog := range logs {
+			urls[i] = ctlog.URL
+		}
+		return urls
+	}
+
+	text := new(strings.Builder)
+	writeField := func(name string, value any) { fmt.Fprintf(text, "\t%13s = %s\n", name, value) }
+	fmt.Fprintf(text, "The log list has changed, and certspotter has started monitoring the added logs and stopped monitoring the removed logs.\n")
+	for _, url := range urls(added) {
+		writeField("Added", url)
+	}
+	for _, url := range urls(removed) {
+		writeField("Removed", url)
+	}
+
+	environ := []string{
+		"EVENT=log_list_changed",
+		"SUMMARY=" + summary,
+		"ADDED_LOGS=" + strings.Join(urls(added), " "),
+		"REMOVED_LOGS=" + strings.Join(urls(removed), " "),
+	}
+
+	return s.notify(ctx, &notification{
+		environ: environ,
+		summary: summary,
+		text:    text.String(),
+		json: []zap.Field{
+			zap.Strings("addedLogs", urls(added)),
+			zap.Strings("removedLogs", urls(removed)),
+		},
+	})
+}
+
+func (s *FilesystemState) NotifyLifecycleEvent(ctx context.Context, event *LifecycleEvent) error {
+	environ := []string{
+		"EVENT=" + event.Event,
+		"SUMMARY=" + event.Summary(),
+		"VERSION=" + event.Version,
+		"LOG_COUNT=" + fmt.Sprint(event.LogCount),
+		"WATCHLIST_SIZE=" + fmt.Sprint(event.WatchListSize),
+	}
+	if event.Reason != "" {
+		environ = append(environ, "REASON="+event.Reason)
+	}
+	if event.Error != nil {
+		environ = append(environ, "ERROR="+event.Error.Error())
+	}
+
+	return s.notify(ctx, &notification{
+		environ: environ,
+		summary: event.Summary(),
+		text:    event.Text(),
+		json:    event.Json(),
+	})
+}
+
 func (s *FilesystemState) healthCheckDir(ctlog *loglist.Log) string {
 	if ctlog == nil {
 		return filepath.Join(s.StateDir, "healthchecks")
@@ -212,7 +609,10 @@ func (s *FilesystemState) healthCheckDir(ctlog *loglist.Log) string {
 }
 
 func (s *FilesystemState) NotifyHealthCheckFailure(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
-	textPath := filepath.Join(s.healthCheckDir(ctlog), healthCheckFilename())
+	var (
+		dirPath  = s.healthCheckDir(ctlog)
+		textPath = filepath.Join(dirPath, healthCheckFilename())
+	)
 	environ := []string{
 		"EVENT=error",
 		"SUMMARY=" + info.Summary(),
@@ -223,16 +623,55 @@ func (s *FilesystemState) NotifyHealthCheckFailure(ctx context.Context, ctlog *l
 		return fmt.Errorf("error saving text file: %w", err)
 	}
 	if err := s.notify(ctx, &notification{
-		environ: environ,
-		summary: info.Summary(),
-		text:    text,
-		json:    info.Json(),
+		environ:   environ,
+		summary:   info.Summary(),
+		text:      text,
+		json:      info.Json(),
+		pagerDuty: makePagerDutyTrigger(ctlog, info),
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// NotifyHealthCheckRecovered resolves the PagerDuty alert about the failure,
+// if s.PagerDutyRoutingKey is set.  Recoveries are not sent using the other
+// notification methods.
+func (s *FilesystemState) NotifyHealthCheckRecovered(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	if s.PagerDutyRoutingKey != "" {
+		if err := resolvePagerDuty(ctx, s, ctlog, info); err != nil {
+			s.NotifyError(ctx, ctlog, err)
+		}
+	}
+	return nil
+}
+
+// StoreHealthCheckResult writes the result of the health check to a
+// timestamped file under healthchecks/status and points the
+// healthchecks/latest.txt symlink to it, removing the previous result.
+func (s *FilesystemState) StoreHealthCheckResult(ctx context.Context, failures []HealthCheckFailure) error {
+	var (
+		dirPath    = s.healthCheckDir(nil)
+		target     = filepath.Join("status", healthCheckFilename())
+		latestPath = filepath.Join(dirPath, "latest.txt")
+	)
+	if err := os.MkdirAll(filepath.Join(dirPath, "status"), 0777); err != nil {
+		return err
+	}
+	text := "Health check at " + time.Now().UTC().Format(time.RFC3339) + "\n\n" + healthCheckSummary(failures)
+	if err := writeTextFile(filepath.Join(dirPath, target), text, 0666); err != nil {
+		return fmt.Errorf("error saving health check result: %w", err)
+	}
+	previous, _ := os.Readlink(latestPath)
+	if err := replaceSymlink(target, latestPath); err != nil {
+		return err
+	}
+	if previous != "" && previous != target && filepath.Dir(previous) == "status" {
+		os.Remove(filepath.Join(dirPath, previous))
+	}
+	return nil
+}
+
 func (s *FilesystemState) NotifyError(ctx context.Context, ctlog *loglist.Log, err error) error {
 	if ctlog == nil {
 		log.Print(err)
@@ -0,0 +1,58 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func gotifyEndpoint(s *FilesystemState) string {
+	return strings.TrimRight(s.GotifyURL, "/") + "/message?token=" + url.QueryEscape(s.GotifyToken)
+}
+
+// sendGotify posts the notification to the Gotify server at s.GotifyURL,
+// using the summary as the title and the text as the message.
+func sendGotify(ctx context.Context, s *FilesystemState, notif *notification) error {
+	priority := s.GotifyPriority
+	if notif.isWarning() {
+		priority = s.GotifyWarnPriority
+	}
+	body, err := json.Marshal(&gotifyMessage{
+		Title:    notif.summary,
+		Message:  notif.text,
+		Priority: priority,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding Gotify message: %w", err)
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if err := postWithRetries(ctx, gotifyEndpoint(s), body, header); err != nil {
+		// Don't include the URL, which contains the application token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error sending Gotify message to %s: %w", redactURL(s.GotifyURL), err)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ package monitor
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -24,21 +25,54 @@ func healthCheckFilename() string {
 	return time.Now().UTC().Format(time.RFC3339) + ".txt"
 }
 
-func healthCheckLog(ctx context.Context, config *Config, ctlog *loglist.Log) error {
+// healthCheckSummary returns a line for each failure found by a health
+// check, or a line saying that all logs are healthy if there were none.
+func healthCheckSummary(failures []HealthCheckFailure) string {
+	if len(failures) == 0 {
+		return "All logs are healthy\n"
+	}
+	var summary strings.Builder
+	for _, failure := range failures {
+		summary.WriteString(failure.Summary())
+		summary.WriteString("\n")
+	}
+	return summary.String()
+}
+
+// healthCheckLog notifies about the log if it hasn't been successfully
+// contacted within the health check interval, and returns the failure that
+// was notified about, or nil if the log is healthy.  previous is the failure
+// returned by the last health check of the log, if any; if the log is now
+// healthy, or is failing in a different way, its recovery from previous is
+// notified about.
+func healthCheckLog(ctx context.Context, config *Config, ctlog *loglist.Log, previous HealthCheckFailure) (HealthCheckFailure, error) {
+	failure, err := checkLogHealth(ctx, config, ctlog)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil && (failure == nil || failure.Type() != previous.Type()) {
+		if err := notifyHealthCheckRecovered(ctx, config.State, ctlog, previous); err != nil {
+			return nil, fmt.Errorf("error notifying about recovery: %w", err)
+		}
+	}
+	return failure, nil
+}
+
+func checkLogHealth(ctx context.Context, config *Config, ctlog *loglist.Log) (HealthCheckFailure, error) {
 	state, err := config.State.LoadLogState(ctx, ctlog.LogID)
 	if err != nil {
-		return fmt.Errorf("error loading log state: %w", err)
+		return nil, fmt.Errorf("error loading log state: %w", err)
 	} else if state == nil {
-		return nil
+		return nil, nil
 	}
 
 	if time.Since(state.LastSuccess) < config.HealthCheckInterval {
-		return nil
+		return nil, nil
 	}
 
 	sths, err := config.State.LoadSTHs(ctx, ctlog.LogID)
 	if err != nil {
-		return fmt.Errorf("error loading STHs: %w", err)
+		return nil, fmt.Errorf("error loading STHs: %w", err)
 	}
 
 	if len(sths) == 0 {
@@ -48,26 +82,30 @@ func healthCheckLog(ctx context.Context, config *Config, ctlog *loglist.Log) err
 			LatestSTH:   state.VerifiedSTH,
 		}
 		if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
-			return fmt.Errorf("error notifying about stale STH: %w", err)
+			return nil, fmt.Errorf("error notifying about stale STH: %w", err)
 		}
+		return info, nil
 	} else {
 		info := &BacklogInfo{
 			Log:       ctlog,
 			LatestSTH: sths[len(sths)-1],
 			Position:  state.DownloadPosition.Size(),
 		}
+		if info.Backlog() <= config.MaxBacklog {
+			return nil, nil
+		}
 		if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
-			return fmt.Errorf("error notifying about backlog: %w", err)
+			return nil, fmt.Errorf("error notifying about backlog: %w", err)
 		}
+		return info, nil
 	}
-
-	return nil
 }
 
 type HealthCheckFailure interface {
 	Summary() string
 	Text() string
 	Json() []zap.Field
+	Type() string // category of the failure (e.g. "stale_sth"), as found in the "type" field of its JSON
 }
 
 type StaleSTHInfo struct {
@@ -89,6 +127,44 @@ type StaleLogListInfo struct {
 	LastErrorTime time.Time
 }
 
+// LogListShrankInfo describes a log list which contains significantly fewer
+// logs than the previously loaded log list.
+type LogListShrankInfo struct {
+	Source       string
+	PreviousSize int
+	NewSize      int
+	KeptPrevious bool
+}
+
+// ConflictingSTHInfo describes two STHs from the same log which have the same
+// tree size but different root hashes.  This indicates that the log is
+// presenting a split view.
+type ConflictingSTHInfo struct {
+	Log  *loglist.Log
+	STH1 *ct.SignedTreeHead
+	STH2 *ct.SignedTreeHead
+}
+
+// ConsistencyFailureInfo describes two STHs from the same log for which the
+// log could not provide a valid consistency proof.  This indicates that the
+// log has rewritten its history or is presenting a split view.
+type ConsistencyFailureInfo struct {
+	Log    *loglist.Log
+	OldSTH *ct.SignedTreeHead
+	NewSTH *ct.SignedTreeHead
+	Error  string // why the consistency proof is invalid
+}
+
+// RetryBackoffInfo describes a request to a log which has failed so many
+// times that certspotter is waiting at least Config.RetryNotifyThreshold
+// before retrying it.
+type RetryBackoffInfo struct {
+	Log     *loglist.Log
+	Retries int           // number of the upcoming retry, starting at 1
+	Delay   time.Duration // how long until the retry
+	Error   string        // the most recent failure
+}
+
 func (e *BacklogInfo) Backlog() uint64 {
 	return e.LatestSTH.TreeSize - e.Position
 }
@@ -103,17 +179,108 @@ func (e *StaleLogListInfo) Summary() string {
 	return fmt.Sprintf("Unable to retrieve log list since %s", e.LastSuccess)
 }
 
-func (cert *StaleLogListInfo) Json() []zap.Field {
-	return []zap.Field{}
+func (e *LogListShrankInfo) Summary() string {
+	return fmt.Sprintf("Log list shrank from %d to %d logs", e.PreviousSize, e.NewSize)
+}
+func (e *ConflictingSTHInfo) Summary() string {
+	return fmt.Sprintf("Conflicting STHs of size %d from %s", e.STH1.TreeSize, e.Log.URL)
+}
+func (e *ConsistencyFailureInfo) Summary() string {
+	return fmt.Sprintf("Inconsistent STHs of sizes %d and %d from %s", e.OldSTH.TreeSize, e.NewSTH.TreeSize, e.Log.URL)
+}
+
+func (e *RetryBackoffInfo) Summary() string {
+	return fmt.Sprintf("Repeated failures contacting %s", e.Log.URL)
+}
+
+func (e *StaleSTHInfo) Type() string           { return "stale_sth" }
+func (e *BacklogInfo) Type() string            { return "backlog" }
+func (e *StaleLogListInfo) Type() string       { return "stale_log_list" }
+func (e *LogListShrankInfo) Type() string      { return "log_list_shrank" }
+func (e *ConflictingSTHInfo) Type() string     { return "conflicting_sth" }
+func (e *ConsistencyFailureInfo) Type() string { return "consistency_failure" }
+func (e *RetryBackoffInfo) Type() string       { return "retry_backoff" }
+
+func (e *StaleLogListInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("source", e.Source),
+		zap.Time("lastSuccess", e.LastSuccess),
+		zap.String("lastError", e.LastError),
+		zap.Time("lastErrorTime", e.LastErrorTime),
+	}
+}
+func (e *BacklogInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("log", e.Log.URL),
+		zap.Uint64("treeSize", e.LatestSTH.TreeSize),
+		zap.Time("sthTimestamp", e.LatestSTH.TimestampTime()),
+		zap.Uint64("position", e.Position),
+		zap.Uint64("backlog", e.Backlog()),
+	}
+}
+func (e *StaleSTHInfo) Json() []zap.Field {
+	fields := []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("log", e.Log.URL),
+		zap.Time("lastSuccess", e.LastSuccess),
+	}
+	if e.LatestSTH != nil {
+		fields = append(fields,
+			zap.Uint64("treeSize", e.LatestSTH.TreeSize),
+			zap.Time("sthTimestamp", e.LatestSTH.TimestampTime()),
+		)
+	}
+	return fields
 }
-func (cert *BacklogInfo) Json() []zap.Field {
-	return []zap.Field{}
+func (e *LogListShrankInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("source", e.Source),
+		zap.Int("previousSize", e.PreviousSize),
+		zap.Int("newSize", e.NewSize),
+		zap.Bool("keptPrevious", e.KeptPrevious),
+	}
 }
-func (cert *StaleSTHInfo) Json() []zap.Field {
-	return []zap.Field{}
+func (e *ConflictingSTHInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("severity", "critical"),
+		zap.String("log", e.Log.URL),
+		zap.Uint64("treeSize", e.STH1.TreeSize),
+		zap.String("rootHash1", e.STH1.SHA256RootHash.Base64String()),
+		zap.String("rootHash2", e.STH2.SHA256RootHash.Base64String()),
+	}
+}
+func (e *ConsistencyFailureInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("severity", "critical"),
+		zap.String("log", e.Log.URL),
+		zap.Uint64("oldTreeSize", e.OldSTH.TreeSize),
+		zap.String("oldRootHash", e.OldSTH.SHA256RootHash.Base64String()),
+		zap.Uint64("newTreeSize", e.NewSTH.TreeSize),
+		zap.String("newRootHash", e.NewSTH.SHA256RootHash.Base64String()),
+		zap.String("error", e.Error),
+	}
+}
+func (e *RetryBackoffInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("log", e.Log.URL),
+		zap.Int("retries", e.Retries),
+		zap.Duration("delay", e.Delay),
+		zap.String("error", e.Error),
+	}
 }
 func (entry *LogEntry) Json() []zap.Field {
-	return []zap.Field{}
+	return []zap.Field{
+		zap.String("type", "malformed_entry"),
+		zap.String("log", entry.Log.URL),
+		zap.Uint64("entryIndex", entry.Index),
+		zap.String("leafHash", entry.LeafHash.Base64String()),
+	}
 }
 func (e *StaleSTHInfo) Text() string {
 	text := new(strings.Builder)
@@ -148,5 +315,58 @@ func (e *StaleLogListInfo) Text() string {
 	fmt.Fprintf(text, "Consequentially, certspotter may not be monitoring all logs, and might fail to detect certificates.\n")
 	return text.String()
 }
+func (e *LogListShrankInfo) Text() string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "The log list retrieved from %s contains %d logs, down from %d logs in the previous log list.\n", e.Source, e.NewSize, e.PreviousSize)
+	fmt.Fprintf(text, "\n")
+	if e.KeptPrevious {
+		fmt.Fprintf(text, "certspotter is continuing to monitor the logs in the previous log list until the log list recovers.\n")
+	} else {
+		fmt.Fprintf(text, "certspotter has stopped monitoring the removed logs. If the log list is incorrect, certspotter might fail to detect certificates.\n")
+	}
+	return text.String()
+}
+func (e *ConflictingSTHInfo) Text() string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "%s has returned two signed tree heads with the same size but different root hashes. This means the log is presenting a split view, and certificates in one view might not be visible in the other.\n", e.Log.URL)
+	fmt.Fprintf(text, "\n")
+	fmt.Fprintf(text, "This is a serious log misbehavior. Please retain this information and report it to the log operator and to the CT community.\n")
+	fmt.Fprintf(text, "\n")
+	writeSTH(text, "First STH", e.STH1)
+	writeSTH(text, "Second STH", e.STH2)
+	return text.String()
+}
+func (e *ConsistencyFailureInfo) Text() string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "%s has returned two signed tree heads which it could not prove are consistent with each other. This means the log may have rewritten its history or be presenting a split view, and certificates in one tree might not be visible in the other.\n", e.Log.URL)
+	fmt.Fprintf(text, "\n")
+	fmt.Fprintf(text, "This is a serious log misbehavior. Please retain this information and report it to the log operator and to the CT community.\n")
+	fmt.Fprintf(text, "\n")
+	fmt.Fprintf(text, "Consistency proof error: %s\n", e.Error)
+	fmt.Fprintf(text, "\n")
+	writeSTH(text, "Older STH", e.OldSTH)
+	writeSTH(text, "Newer STH", e.NewSTH)
+	return text.String()
+}
+
+// writeSTH writes the STH, including its signature, to text, so that it can be
+// retained as evidence of log misbehavior.
+func writeSTH(text io.Writer, name string, sth *ct.SignedTreeHead) {
+	fmt.Fprintf(text, "%s:\n", name)
+	fmt.Fprintf(text, "\t Tree size = %d\n", sth.TreeSize)
+	fmt.Fprintf(text, "\t Timestamp = %d (%s)\n", sth.Timestamp, sth.TimestampTime())
+	fmt.Fprintf(text, "\t Root hash = %s\n", sth.SHA256RootHash.Base64String())
+	if signature, err := sth.TreeHeadSignature.Base64String(); err == nil {
+		fmt.Fprintf(text, "\t Signature = %s\n", signature)
+	}
+}
+
+func (e *RetryBackoffInfo) Text() string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "A request to %s has failed repeatedly, and certspotter is backing off for %s before retry number %d. Consequentially, certspotter may be slow to notify you about certificates in this log.\n", e.Log.URL, e.Delay.Round(time.Second), e.Retries)
+	fmt.Fprintf(text, "\n")
+	fmt.Fprintf(text, "Most recent error: %s\n", e.Error)
+	return text.String()
+}
 
 // TODO-3: make the errors more actionable
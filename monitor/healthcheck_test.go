@@ -0,0 +1,175 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+func TestHealthCheckLogRecovery(t *testing.T) {
+	ctx := context.Background()
+	var recovered []HealthCheckFailure
+	state := &MemoryState{OnHealthCheckRecovered: func(ctlog *loglist.Log, info HealthCheckFailure) { recovered = append(recovered, info) }}
+	config := &Config{State: state, HealthCheckInterval: time.Hour}
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+
+	state.StoreLogState(ctx, ctlog.LogID, &LogState{LastSuccess: time.Now().Add(-2 * time.Hour)})
+	failure, err := healthCheckLog(ctx, config, ctlog, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if failure.Type() != "stale_sth" {
+		t.Fatalf("healthCheckLog returned %v, want stale STH failure", failure)
+	}
+
+	state.StoreLogState(ctx, ctlog.LogID, &LogState{LastSuccess: time.Now()})
+	if healthy, err := healthCheckLog(ctx, config, ctlog, failure); err != nil {
+		t.Fatal(err)
+	} else if healthy != nil {
+		t.Fatalf("healthCheckLog returned %v, want nil", healthy)
+	}
+	if len(recovered) != 1 || recovered[0] != failure {
+		t.Errorf("notified about %d recoveries, want 1", len(recovered))
+	}
+
+	if _, err := healthCheckLog(ctx, config, ctlog, nil); err != nil {
+		t.Fatal(err)
+	} else if len(recovered) != 1 {
+		t.Errorf("notified about recovery of a log which wasn't unhealthy")
+	}
+}
+
+func TestHealthCheckLogMaxBacklog(t *testing.T) {
+	ctx := context.Background()
+	state := new(MemoryState)
+	config := &Config{State: state, HealthCheckInterval: time.Hour, MaxBacklog: 100}
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+
+	position := merkletree.EmptyCollapsedTree()
+	position.Add(merkletree.Hash{1})
+	state.StoreLogState(ctx, ctlog.LogID, &LogState{DownloadPosition: position, LastSuccess: time.Now().Add(-2 * time.Hour)})
+
+	state.StoreSTH(ctx, ctlog.LogID, &ct.SignedTreeHead{TreeSize: 101, Timestamp: 1})
+	if failure, err := healthCheckLog(ctx, config, ctlog, nil); err != nil {
+		t.Fatal(err)
+	} else if failure != nil {
+		t.Errorf("healthCheckLog returned %v for a backlog of 100, want nil", failure)
+	}
+
+	state.StoreSTH(ctx, ctlog.LogID, &ct.SignedTreeHead{TreeSize: 102, Timestamp: 2})
+	if failure, err := healthCheckLog(ctx, config, ctlog, nil); err != nil {
+		t.Fatal(err)
+	} else if backlog, ok := failure.(*BacklogInfo); !ok || backlog.Backlog() != 101 {
+		t.Errorf("healthCheckLog returned %v for a backlog of 101, want BacklogInfo", failure)
+	}
+}
+
+func TestSplitViewFailuresAreCritical(t *testing.T) {
+	ctlog := &loglist.Log{URL: "https://log.example/"}
+	sth := &ct.SignedTreeHead{TreeSize: 100}
+	for _, failure := range []HealthCheckFailure{
+		&ConflictingSTHInfo{Log: ctlog, STH1: sth, STH2: sth},
+		&ConsistencyFailureInfo{Log: ctlog, OldSTH: sth, NewSTH: sth},
+	} {
+		fields := make(map[string]string)
+		for _, field := range failure.Json() {
+			fields[field.Key] = field.String
+		}
+		if fields["type"] != failure.Type() {
+			t.Errorf("%s failure has JSON type %q", failure.Type(), fields["type"])
+		}
+		if fields["severity"] != "critical" {
+			t.Errorf("%s failure has severity %q; expected critical", failure.Type(), fields["severity"])
+		}
+	}
+}
+
+func TestFilesystemStateHealthCheckResult(t *testing.T) {
+	ctx := context.Background()
+	stateDir := t.TempDir()
+	state := &FilesystemState{StateDir: stateDir}
+	latestPath := filepath.Join(stateDir, "healthchecks", "latest.txt")
+	statusDir := filepath.Join(stateDir, "healthchecks", "status")
+
+	failures := []HealthCheckFailure{&BacklogInfo{Log: &loglist.Log{URL: "https://log.example/"}, LatestSTH: &ct.SignedTreeHead{TreeSize: 100}, Position: 40}}
+	if err := state.StoreHealthCheckResult(ctx, failures); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := os.ReadFile(latestPath); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasSuffix(string(contents), "\n\nBacklog of size 60 from https://log.example/\n") {
+		t.Errorf("latest.txt contains %q after a failed health check", contents)
+	}
+
+	// Pretend the previous result was written by an earlier health check
+	if err := os.Rename(filepath.Join(stateDir, "healthchecks", mustReadlink(t, latestPath)), filepath.Join(statusDir, "earlier.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceSymlink(filepath.Join("status", "earlier.txt"), latestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// latest.txt must reflect a passing health check too
+	if err := state.StoreHealthCheckResult(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := os.ReadFile(latestPath); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasSuffix(string(contents), "\n\nAll logs are healthy\n") {
+		t.Errorf("latest.txt contains %q after a passing health check", contents)
+	}
+	if entries, err := os.ReadDir(statusDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 || filepath.Join("status", entries[0].Name()) != mustReadlink(t, latestPath) {
+		t.Errorf("the previous result was not removed from %s", statusDir)
+	}
+}
+
+func mustReadlink(t *testing.T, name string) string {
+	t.Helper()
+	target, err := os.Readlink(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func TestDaemonHealthCheckStoresResult(t *testing.T) {
+	ctx := context.Background()
+	var results [][]HealthCheckFailure
+	state := &MemoryState{OnHealthCheckResult: func(failures []HealthCheckFailure) { results = append(results, failures) }}
+	daemon := &daemon{
+		config:       &Config{State: state, HealthCheckInterval: time.Hour},
+		tasks:        make(map[LogID]task),
+		unhealthy:    make(map[LogID]HealthCheckFailure),
+		logsLoadedAt: time.Now(),
+	}
+	if err := daemon.healthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	daemon.logsLoadedAt = time.Now().Add(-2 * time.Hour)
+	if err := daemon.healthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || len(results[0]) != 0 || len(results[1]) != 1 {
+		t.Fatalf("health check results were %v; expected a passing result followed by one failure", results)
+	}
+	if _, ok := results[1][0].(*StaleLogListInfo); !ok {
+		t.Errorf("health check failure is %#v; expected a stale log list", results[1][0])
+	}
+}
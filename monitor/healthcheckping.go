@@ -0,0 +1,39 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pingHealthCheck reports the outcome of a health check to
+// config.HealthCheckPingURL, so that a dead man's switch service can alert
+// if certspotter stops running or stops being healthy.  If there were
+// failures, the "/fail" variant of the URL is pinged if
+// config.HealthCheckPingFail is set, and otherwise nothing is pinged.  The
+// body of the request lists the failures.
+func pingHealthCheck(ctx context.Context, config *Config, failures []HealthCheckFailure) error {
+	pingURL := config.HealthCheckPingURL
+	if len(failures) > 0 {
+		if !config.HealthCheckPingFail {
+			return nil
+		}
+		pingURL = strings.TrimRight(pingURL, "/") + "/fail"
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	if err := postWithRetries(ctx, pingURL, []byte(healthCheckSummary(failures)), header); err != nil {
+		return fmt.Errorf("error pinging health check URL %s: %w", redactURL(pingURL), err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+func TestPingHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newRecordingServer(t, http.StatusOK)
+	failures := []HealthCheckFailure{
+		&BacklogInfo{Log: &loglist.Log{URL: "https://log.example/"}, LatestSTH: &ct.SignedTreeHead{TreeSize: 100}, Position: 40},
+		&StaleLogListInfo{LastSuccess: time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name         string
+		pingFail     bool
+		failures     []HealthCheckFailure
+		expectedPath string // empty if nothing should be pinged
+		expectedBody string
+	}{
+		{"healthy", false, nil, "/ping/", "All logs are healthy\n"},
+		{"healthy with -healthcheck_ping_fail", true, nil, "/ping/", "All logs are healthy\n"},
+		{"unhealthy", false, failures, "", ""},
+		{"unhealthy with -healthcheck_ping_fail", true, failures, "/ping/fail", "Backlog of size 60 from https://log.example/\nUnable to retrieve log list since 2024-02-01 12:00:00 +0000 UTC\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{HealthCheckPingURL: server.URL + "/ping/", HealthCheckPingFail: test.pingFail}
+			if err := pingHealthCheck(ctx, config, test.failures); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case request := <-requests:
+				if test.expectedPath == "" {
+					t.Fatalf("pinged %s; expected no ping", request.url.Path)
+				}
+				if request.method != http.MethodPost || request.url.Path != test.expectedPath {
+					t.Errorf("sent %s %s; expected POST %s", request.method, request.url.Path, test.expectedPath)
+				}
+				if contentType := request.header.Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+					t.Errorf("sent Content-Type %q", contentType)
+				}
+				if string(request.body) != test.expectedBody {
+					t.Errorf("sent body %q; expected %q", request.body, test.expectedBody)
+				}
+			default:
+				if test.expectedPath != "" {
+					t.Errorf("did not ping %s", test.expectedPath)
+				}
+			}
+		})
+	}
+}
+
+func TestPingHealthCheckError(t *testing.T) {
+	server, _ := newRecordingServer(t, http.StatusNotFound)
+	config := &Config{HealthCheckPingURL: strings.Replace(server.URL, "http://", "http://user:secret@", 1) + "/ping"}
+	err := pingHealthCheck(context.Background(), config, nil)
+	if err == nil {
+		t.Fatal("pingHealthCheck succeeded even though the server returned 404")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q contains the password from the URL", err)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// Names of the queues passed to StoreHeldCert
+const (
+	heldCertsDigest   = "digest"
+	heldCertsCoalesce = "coalesce"
+)
+
+type heldLogEntry struct {
+	Log       *loglist.Log    `json:"log"`
+	Operator  string          `json:"operator,omitempty"`
+	Index     uint64          `json:"index"`
+	LeafInput []byte          `json:"leaf_input,omitempty"`
+	ExtraData []byte          `json:"extra_data,omitempty"`
+	LeafHash  merkletree.Hash `json:"leaf_hash"`
+}
+
+func makeHeldLogEntry(entry *LogEntry, withLeaf bool) heldLogEntry {
+	held := heldLogEntry{
+		Log:      entry.Log,
+		Operator: entry.Log.OperatorName,
+		Index:    entry.Index,
+		LeafHash: entry.LeafHash,
+	}
+	if withLeaf {
+		held.LeafInput = entry.LeafInput
+		held.ExtraData = entry.ExtraData
+	}
+	return held
+}
+
+func (held heldLogEntry) logEntry() *LogEntry {
+	if held.Log == nil {
+		held.Log = new(loglist.Log)
+	}
+	held.Log.OperatorName = held.Operator
+	return &LogEntry{
+		Log:       held.Log,
+		Index:     held.Index,
+		LeafInput: held.LeafInput,
+		ExtraData: held.ExtraData,
+		LeafHash:  held.LeafHash,
+	}
+}
+
+// heldCert is the form in which a DiscoveredCert is stored by
+// FilesystemState.StoreHeldCert.  The certificate is parsed again from its
+// log entry when it is loaded, so only the fields which processCertificate
+// computes from the configuration or the network are stored.
+type heldCert struct {
+	LogEntry          heldLogEntry     `json:"log_entry"`
+	WatchItem         string           `json:"watch_item"`
+	MatchedIdentifier string           `json:"matched_identifier,omitempty"`
+	MatchSource       string           `json:"match_source,omitempty"`
+	ObservedAt        time.Time        `json:"observed_at"`
+	DNSNames          []string         `json:"dns_names"`
+	IPAddrs           []net.IP         `json:"ip_addrs"`
+	Anomalies         []string         `json:"anomalies"`
+	PrecertError      string           `json:"precert_error,omitempty"`
+	InsufficientSCTs  bool             `json:"insufficient_scts,omitempty"`
+	OverlongValidity  bool             `json:"overlong_validity,omitempty"`
+	DNSResolutions    []*DNSResolution `json:"dns_resolutions"`
+	InclusionTreeSize uint64           `json:"inclusion_tree_size,omitempty"`
+	InclusionError    string           `json:"inclusion_error,omitempty"`
+	Sightings         []heldLogEntry   `json:"sightings"`
+}
+
+func makeHeldCert(cert *DiscoveredCert) *heldCert {
+	held := &heldCert{
+		LogEntry:          makeHeldLogEntry(cert.LogEntry, true),
+		WatchItem:         cert.WatchItem.String(),
+		MatchedIdentifier: cert.MatchedIdentifier,
+		MatchSource:       cert.MatchSource,
+		ObservedAt:        cert.ObservedAt,
+		Anomalies:         cert.Anomalies,
+		InsufficientSCTs:  cert.InsufficientSCTs,
+		OverlongValidity:  cert.OverlongValidity,
+		DNSResolutions:    cert.DNSResolutions,
+		InclusionTreeSize: cert.InclusionTreeSize,
+	}
+	if cert.Identifiers != nil {
+		held.DNSNames = cert.Identifiers.DNSNames
+		held.IPAddrs = cert.Identifiers.IPAddrs
+	}
+	if cert.PrecertError != nil {
+		held.PrecertError = cert.PrecertError.Error()
+	}
+	if cert.InclusionError != nil {
+		held.InclusionError = cert.InclusionError.Error()
+	}
+	if cert.Sightings != nil {
+		held.Sightings = make([]heldLogEntry, len(cert.Sightings))
+		for i, entry := range cert.Sightings {
+			held.Sightings[i] = makeHeldLogEntry(entry, false)
+		}
+	}
+	return held
+}
+
+// discoveredCert parses the certificate in the held log entry the same way
+// processLogEntry does, and returns it with the stored fields filled in.
+func (held *heldCert) discoveredCert() (*DiscoveredCert, error) {
+	entry := held.LogEntry.logEntry()
+	leaf, err := ct.ReadMerkleTreeLeaf(bytes.NewReader(entry.LeafInput))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Merkle Tree Leaf: %w", err)
+	}
+	cert := &DiscoveredCert{
+		LogEntry:     entry,
+		SCTTimestamp: time.UnixMilli(int64(leaf.TimestampedEntry.Timestamp)).UTC(),
+	}
+	switch leaf.TimestampedEntry.EntryType {
+	case ct.X509LogEntryType:
+		x509Entry := leaf.TimestampedEntry.X509Entry
+		if cert.Info, err = certspotter.MakeCertInfoFromRawCert(x509Entry); err != nil {
+			return nil, fmt.Errorf("error parsing X.509 certificate: %w", err)
+		}
+		chain, err := ct.UnmarshalX509ChainArray(entry.ExtraData)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing extra_data for X.509 entry: %w", err)
+		}
+		cert.Chain = append([]ct.ASN1Cert{x509Entry}, chain...)
+		cert.EmbeddedSCTs, cert.EmbeddedSCTsParseError = cert.Info.TBS.ParseSCTList()
+		if cert.Info.TBS, err = certspotter.ReconstructPrecertTBS(cert.Info.TBS); err != nil {
+			return nil, fmt.Errorf("error reconstructing precertificate TBSCertificate: %w", err)
+		}
+	case ct.PrecertLogEntryType:
+		if cert.Info, err = certspotter.MakeCertInfoFromRawTBS(leaf.TimestampedEntry.PrecertEntry.TBSCertificate); err != nil {
+			return nil, fmt.Errorf("error parsing precert TBSCertificate: %w", err)
+		}
+		if cert.Chain, err = ct.UnmarshalPrecertChainArray(entry.ExtraData); err != nil {
+			return nil, fmt.Errorf("error parsing extra_data for precert entry: %w", err)
+		}
+		cert.IsPrecert = true
+	default:
+		return nil, fmt.Errorf("unknown log entry type %d", leaf.TimestampedEntry.EntryType)
+	}
+
+	if held.WatchItem != "" {
+		if cert.WatchItem, err = ParseWatchItem(held.WatchItem); err != nil {
+			return nil, fmt.Errorf("error parsing watch item: %w", err)
+		}
+	}
+	cert.MatchedIdentifier = held.MatchedIdentifier
+	cert.MatchSource = held.MatchSource
+	cert.ObservedAt = held.ObservedAt
+	cert.TBSSHA256 = sha256.Sum256(cert.Info.TBS.Raw)
+	cert.SHA256 = sha256.Sum256(cert.Chain[0])
+	cert.PubkeySHA256 = sha256.Sum256(cert.Info.TBS.PublicKey.FullBytes)
+	cert.Identifiers = &certspotter.Identifiers{DNSNames: held.DNSNames, IPAddrs: held.IPAddrs}
+	cert.Anomalies = held.Anomalies
+	if held.PrecertError != "" {
+		cert.PrecertError = errors.New(held.PrecertError)
+	}
+	cert.InsufficientSCTs = held.InsufficientSCTs
+	cert.OverlongValidity = held.OverlongValidity
+	cert.DNSResolutions = held.DNSResolutions
+	cert.InclusionTreeSize = held.InclusionTreeSize
+	if held.InclusionError != "" {
+		cert.InclusionError = errors.New(held.InclusionError)
+	}
+	if held.Sightings != nil {
+		cert.Sightings = make([]*LogEntry, len(held.Sightings))
+		for i, sighting := range held.Sightings {
+			cert.Sightings[i] = sighting.logEntry()
+		}
+		if len(cert.Sightings) > 0 {
+			cert.Sightings[0] = entry
+		}
+	}
+	return cert, nil
+}
+
+func (s *FilesystemState) heldCertsDir(queue string) string {
+	return filepath.Join(s.StateDir, "held_certs", queue)
+}
+
+func (s *FilesystemState) StoreHeldCert(ctx context.Context, queue string, cert *DiscoveredCert) error {
+	dirPath := s.heldCertsDir(queue)
+	if err := os.MkdirAll(dirPath, 0777); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dirPath, hex.EncodeToString(cert.SHA256[:])+".json"), makeHeldCert(cert), 0666)
+}
+
+func (s *FilesystemState) LoadHeldCerts(ctx context.Context, queue string) ([]*DiscoveredCert, error) {
+	dirPath := s.heldCertsDir(queue)
+	entries, err := os.ReadDir(dirPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var certs []*DiscoveredCert
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		filePath := filepath.Join(dirPath, entry.Name())
+		fileBytes, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		held := new(heldCert)
+		if err := json.Unmarshal(fileBytes, held); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", filePath, err)
+		}
+		cert, err := held.discoveredCert()
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate from %s: %w", filePath, err)
+		}
+		certs = append(certs, cert)
+	}
+	slices.SortStableFunc(certs, func(a, b *DiscoveredCert) int { return a.ObservedAt.Compare(b.ObservedAt) })
+	return certs, nil
+}
+
+func (s *FilesystemState) RemoveHeldCert(ctx context.Context, queue string, fingerprint [32]byte) error {
+	filePath := filepath.Join(s.heldCertsDir(queue), hex.EncodeToString(fingerprint[:])+".json")
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// makeHeldTestCert returns a certificate discovered in an X.509 log entry,
+// with the fields set by processCertificate filled in.
+func makeHeldTestCert(t *testing.T, dnsName string) *DiscoveredCert {
+	t.Helper()
+	cert := makeTestCert(t, dnsName, dnsName)
+	der := cert.Chain[0]
+
+	timestamp := time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC)
+	leafInput := []byte{0, 0} // version v1, leaf type timestamped_entry
+	leafInput = binary.BigEndian.AppendUint64(leafInput, uint64(timestamp.UnixMilli()))
+	leafInput = append(leafInput, 0, 0) // x509_entry
+	leafInput = append(leafInput, byte(len(der)>>16), byte(len(der)>>8), byte(len(der)))
+	leafInput = append(leafInput, der...)
+	leafInput = append(leafInput, 0, 0) // no extensions
+
+	cert.LogEntry = &LogEntry{
+		Log:       &loglist.Log{URL: "https://log.example/", OperatorName: "Example"},
+		Index:     42,
+		LeafInput: leafInput,
+		ExtraData: []byte{0, 0, 0}, // empty chain
+		LeafHash:  merkletree.HashLeaf(leafInput),
+	}
+	cert.SCTTimestamp = timestamp
+	cert.ObservedAt = timestamp.Add(time.Minute)
+	cert.WatchItem = mustParseWatchItem(t, "."+dnsName)
+	cert.MatchedIdentifier = dnsName
+	cert.MatchSource = MatchSourceSAN
+	cert.TBSSHA256 = sha256.Sum256(cert.Info.TBS.Raw)
+	cert.SHA256 = sha256.Sum256(der)
+	cert.PubkeySHA256 = sha256.Sum256(cert.Info.TBS.PublicKey.FullBytes)
+	identifiers, err := cert.Info.ParseIdentifiers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Identifiers = identifiers
+	cert.EmbeddedSCTs, cert.EmbeddedSCTsParseError = cert.Info.TBS.ParseSCTList()
+	return cert
+}
+
+func mustParseWatchItem(t *testing.T, str string) WatchItem {
+	t.Helper()
+	item, err := ParseWatchItem(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestFilesystemStateHeldCerts(t *testing.T) {
+	ctx := context.Background()
+	stateDir := filepath.Join(t.TempDir(), "state")
+	state := &FilesystemState{StateDir: stateDir}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := makeHeldTestCert(t, "www.example.com")
+	cert.Anomalies = []string{}
+	cert.InclusionTreeSize = 100
+	cert.InclusionError = errors.New("invalid inclusion proof")
+	cert.DNSResolutions = []*DNSResolution{{Name: "www.example.com", Addrs: []string{"192.0.2.1"}}}
+	other := &LogEntry{Log: &loglist.Log{URL: "https://other.example/"}, Index: 7}
+	cert.Sightings = []*LogEntry{cert.LogEntry, other}
+	if err := state.StoreHeldCert(ctx, heldCertsCoalesce, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new FilesystemState must load the certificate from disk
+	certs, err := (&FilesystemState{StateDir: stateDir}).LoadHeldCerts(ctx, heldCertsCoalesce)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 1 {
+		t.Fatalf("loaded %d certificates; expected 1", len(certs))
+	}
+	loaded := certs[0]
+	if loaded.SHA256 != cert.SHA256 || loaded.TBSSHA256 != cert.TBSSHA256 || loaded.PubkeySHA256 != cert.PubkeySHA256 {
+		t.Errorf("loaded certificate has different fingerprints")
+	}
+	if loaded.LogEntry.Log.URL != "https://log.example/" || loaded.LogEntry.Log.OperatorName != "Example" || loaded.LogEntry.Index != 42 || loaded.LogEntry.LeafHash != cert.LogEntry.LeafHash {
+		t.Errorf("loaded certificate has wrong log entry %+v", loaded.LogEntry)
+	}
+	if loaded.WatchItem.String() != ".www.example.com" || loaded.MatchedIdentifier != "www.example.com" || loaded.MatchSource != MatchSourceSAN {
+		t.Errorf("loaded certificate matched %q (%q from %q); expected .www.example.com", loaded.WatchItem, loaded.MatchedIdentifier, loaded.MatchSource)
+	}
+	if !loaded.SCTTimestamp.Equal(cert.SCTTimestamp) || !loaded.ObservedAt.Equal(cert.ObservedAt) {
+		t.Errorf("loaded certificate has timestamps %s and %s", loaded.SCTTimestamp, loaded.ObservedAt)
+	}
+	if len(loaded.Identifiers.DNSNames) != 1 || loaded.Identifiers.DNSNames[0] != "www.example.com" {
+		t.Errorf("loaded certificate has DNS names %v", loaded.Identifiers.DNSNames)
+	}
+	if loaded.IsPrecert || loaded.EmbeddedSCTsParseError != nil || loaded.Anomalies == nil {
+		t.Errorf("loaded certificate is wrong: %+v", loaded)
+	}
+	if loaded.InclusionTreeSize != 100 || loaded.InclusionError == nil || loaded.InclusionError.Error() != "invalid inclusion proof" {
+		t.Errorf("loaded certificate has inclusion result %d %v", loaded.InclusionTreeSize, loaded.InclusionError)
+	}
+	if len(loaded.DNSResolutions) != 1 || loaded.DNSResolutions[0].Addrs[0] != "192.0.2.1" {
+		t.Errorf("loaded certificate has DNS resolutions %v", loaded.DNSResolutions)
+	}
+	if len(loaded.Sightings) != 2 || loaded.Sightings[0] != loaded.LogEntry || loaded.Sightings[1].Log.URL != "https://other.example/" || loaded.Sightings[1].Index != 7 {
+		t.Errorf("loaded certificate has sightings %v", loaded.sightingStrings())
+	}
+
+	if certs, err := state.LoadHeldCerts(ctx, heldCertsDigest); err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 0 {
+		t.Errorf("loaded %d certificates from a different queue", len(certs))
+	}
+	if err := state.RemoveHeldCert(ctx, heldCertsCoalesce, cert.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	if certs, err := state.LoadHeldCerts(ctx, heldCertsCoalesce); err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 0 {
+		t.Errorf("loaded %d certificates after removing them", len(certs))
+	}
+}
+
+func TestDigestStateResumesHeldCerts(t *testing.T) {
+	ctx := context.Background()
+	var digests [][]*DiscoveredCert
+	state := &MemoryState{OnCertDigest: func(certs []*DiscoveredCert) { digests = append(digests, certs) }}
+	config := &Config{State: state}
+
+	digester, err := newDigestState(ctx, config, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dnsName := range []string{"a.example.com", "b.example.com"} {
+		if err := digester.NotifyCert(ctx, makeHeldTestCert(t, dnsName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Simulate certspotter exiting without sending the digest
+	digester.timer.Stop()
+
+	digester, err = newDigestState(ctx, config, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digester.flush()
+	if len(digests) != 1 || len(digests[0]) != 2 {
+		t.Fatalf("sent %d digests; expected one digest of the 2 held certificates", len(digests))
+	}
+	if certs, err := state.LoadHeldCerts(ctx, heldCertsDigest); err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 0 {
+		t.Errorf("%d certificates are still held after the digest was sent", len(certs))
+	}
+}
+
+func TestCoalescingStateResumesHeldCerts(t *testing.T) {
+	ctx := context.Background()
+	var notified []*DiscoveredCert
+	state := &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = append(notified, cert) }}
+	config := &Config{State: state}
+
+	coalescer, err := newCoalescingState(ctx, config, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := makeHeldTestCert(t, "www.example.com")
+	sighting := *cert
+	sighting.LogEntry = &LogEntry{Log: &loglist.Log{URL: "https://other.example/"}, Index: 7}
+	for _, cert := range []*DiscoveredCert{cert, &sighting} {
+		if err := coalescer.NotifyCert(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Simulate certspotter exiting before the window elapses
+	coalescer.pending[cert.SHA256].timer.Stop()
+
+	coalescer, err = newCoalescingState(ctx, config, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coalescer.flush()
+	if len(notified) != 1 {
+		t.Fatalf("sent %d notifications; expected 1", len(notified))
+	}
+	if sightings := notified[0].sightingStrings(); len(sightings) != 2 {
+		t.Errorf("notification lists sightings %v; expected 2", sightings)
+	}
+	if certs, err := state.LoadHeldCerts(ctx, heldCertsCoalesce); err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 0 {
+		t.Errorf("%d certificates are still held after they were notified about", len(certs))
+	}
+}
+
+// deadlineState records the deadline of the context passed to NotifyCert.
+type deadlineState struct {
+	*MemoryState
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (s *deadlineState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	s.deadline, s.hasDeadline = ctx.Deadline()
+	return s.MemoryState.NotifyCert(ctx, cert)
+}
+
+func TestCoalescingStateNotifyTimeout(t *testing.T) {
+	ctx := context.Background()
+	state := &deadlineState{MemoryState: new(MemoryState)}
+	coalescer, err := newCoalescingState(ctx, &Config{State: state}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := coalescer.NotifyCert(ctx, makeHeldTestCert(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	coalescer.flush()
+	if !state.hasDeadline {
+		t.Fatal("coalesced notification was sent without a deadline")
+	}
+	if state.deadline.After(time.Now().Add(coalesceNotifyTimeout)) {
+		t.Errorf("coalesced notification was sent with a deadline of %s; expected at most %s from now", state.deadline, coalesceNotifyTimeout)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// auditProofClient is implemented by log clients which support
+// get-proof-by-hash.  Static CT API logs don't, so their entries aren't
+// checked (they are still verified against the STH like any other entry).
+type auditProofClient interface {
+	GetAuditProof(ctx context.Context, hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error)
+}
+
+var errInclusionUnsupported = errors.New("log does not support inclusion proofs")
+
+// verifyInclusion fetches an inclusion proof for entry's leaf hash from the
+// log and verifies it against entry.sth, which is the STH that the log is
+// being downloaded toward.  The verified STH can't be used because it
+// doesn't yet contain the entry.
+//
+// A leaf hash might appear more than once in a log, so the proof is verified
+// at whichever index the log returns, which needn't be entry.Index.
+func verifyInclusion(ctx context.Context, entry *LogEntry) error {
+	client, ok := entry.client.(auditProofClient)
+	if !ok {
+		return errInclusionUnsupported
+	}
+	path, index, err := client.GetAuditProof(ctx, entry.LeafHash[:], entry.sth.TreeSize)
+	if err != nil {
+		return fmt.Errorf("error fetching inclusion proof: %w", err)
+	}
+	proof := make([]merkletree.Hash, len(path))
+	for i, node := range path {
+		if len(node) != merkletree.HashLen {
+			return fmt.Errorf("inclusion proof contains node of wrong length (%d)", len(node))
+		}
+		proof[i] = merkletree.Hash(node)
+	}
+	if err := merkletree.VerifyInclusionProof(index, entry.sth.TreeSize, entry.LeafHash, merkletree.Hash(entry.sth.SHA256RootHash), proof); err != nil {
+		return fmt.Errorf("invalid inclusion proof at tree size %d: %w", entry.sth.TreeSize, err)
+	}
+	return nil
+}
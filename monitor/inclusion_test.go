@@ -0,0 +1,100 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// treeHash computes the Merkle Tree Hash of leaves as in section 2.1.1 of RFC 9162
+func treeHash(leaves []merkletree.Hash) merkletree.Hash {
+	if len(leaves) == 0 {
+		return merkletree.HashNothing()
+	} else if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return merkletree.HashChildren(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+// inclusionPath computes the inclusion proof for leaves[m] as in section 2.1.3.1 of RFC 9162
+func inclusionPath(m uint64, leaves []merkletree.Hash) []merkletree.Hash {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(uint64(len(leaves)))
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), treeHash(leaves[k:]))
+	}
+	return append(inclusionPath(m-k, leaves[k:]), treeHash(leaves[:k]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+type fakeAuditProofClient struct {
+	fakeLogClient
+	leaves  []merkletree.Hash
+	corrupt bool
+}
+
+func (c *fakeAuditProofClient) GetAuditProof(ctx context.Context, hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	for i, leaf := range c.leaves[:treeSize] {
+		if merkletree.Hash(hash) != leaf {
+			continue
+		}
+		var path ct.AuditPath
+		for _, node := range inclusionPath(uint64(i), c.leaves[:treeSize]) {
+			node := node
+			if c.corrupt {
+				node[0] ^= 1
+			}
+			path = append(path, node[:])
+		}
+		return path, uint64(i), nil
+	}
+	return nil, 0, fmt.Errorf("hash not found")
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	leaves := make([]merkletree.Hash, 23)
+	for i := range leaves {
+		leaves[i] = merkletree.HashLeaf([]byte{byte(i)})
+	}
+	for treeSize := uint64(1); treeSize <= uint64(len(leaves)); treeSize++ {
+		sth := &ct.SignedTreeHead{TreeSize: treeSize, SHA256RootHash: ct.SHA256Hash(treeHash(leaves[:treeSize]))}
+		for index := uint64(0); index < treeSize; index++ {
+			entry := &LogEntry{Index: index, LeafHash: leaves[index], sth: sth}
+
+			entry.client = &fakeAuditProofClient{leaves: leaves}
+			if err := verifyInclusion(context.Background(), entry); err != nil {
+				t.Errorf("tree size %d, index %d: %s", treeSize, index, err)
+			}
+
+			entry.client = &fakeAuditProofClient{leaves: leaves, corrupt: true}
+			if err := verifyInclusion(context.Background(), entry); err == nil && treeSize > 1 {
+				t.Errorf("tree size %d, index %d: corrupt proof verified", treeSize, index)
+			}
+		}
+	}
+
+	entry := &LogEntry{LeafHash: leaves[0], client: &fakeLogClient{}, sth: &ct.SignedTreeHead{TreeSize: 1}}
+	if err := verifyInclusion(context.Background(), entry); err != errInclusionUnsupported {
+		t.Errorf("verifyInclusion with a client that doesn't support inclusion proofs returned %v", err)
+	}
+}
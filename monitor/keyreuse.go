@@ -0,0 +1,139 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// keyReuseMu serializes checkKeyReuse, since logs are processed
+// concurrently, and two certificates with the same key and a new issuer
+// must not both see the key's previous issuers and notify.
+var keyReuseMu sync.Mutex
+
+// checkKeyReuse records the issuer of cert under its public key, and notifies
+// if the key was previously seen in a certificate from a different issuer.
+func checkKeyReuse(ctx context.Context, config *Config, cert *DiscoveredCert) error {
+	if cert.Info.IssuerParseError != nil {
+		return nil
+	}
+	store, ok := optionalState[keyIssuerStore](config.State)
+	if !ok {
+		return nil
+	}
+	issuer := cert.Info.Issuer.String()
+
+	keyReuseMu.Lock()
+	defer keyReuseMu.Unlock()
+
+	issuers, err := store.LoadKeyIssuers(ctx, cert.PubkeySHA256)
+	if err != nil {
+		return fmt.Errorf("error loading issuers of key %x: %w", cert.PubkeySHA256, err)
+	}
+	if slices.Contains(issuers, issuer) {
+		return nil
+	}
+	if len(issuers) > 0 {
+		if err := notifyKeyReusedAcrossIssuers(ctx, config.State, cert, issuers); err != nil {
+			return fmt.Errorf("error notifying about reuse of key %x: %w", cert.PubkeySHA256, err)
+		}
+	}
+	if err := store.StoreKeyIssuer(ctx, cert.PubkeySHA256, issuer); err != nil {
+		return fmt.Errorf("error storing issuer of key %x: %w", cert.PubkeySHA256, err)
+	}
+	return nil
+}
+
+func keyIssuersPath(stateDir string, pubkeySHA256 [32]byte) string {
+	hexKey := hex.EncodeToString(pubkeySHA256[:])
+	return filepath.Join(stateDir, "keys", hexKey[0:2], hexKey+".issuers")
+}
+
+// The issuers of a key are stored one per line.  Lines are only ever
+// appended, so concurrent writers may produce duplicates, which are ignored
+// when reading.
+
+func readKeyIssuers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var issuers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" && !slices.Contains(issuers, line) {
+			issuers = append(issuers, line)
+		}
+	}
+	return issuers, scanner.Err()
+}
+
+func appendKeyIssuer(path string, issuer string) error {
+	if err := os.Mkdir(filepath.Dir(path), 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(strings.ReplaceAll(issuer, "\n", " ") + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func keyReuseSummary(cert *DiscoveredCert) string {
+	return fmt.Sprintf("Key Reused Across Issuers for %s", cert.WatchItem)
+}
+
+func keyReuseText(cert *DiscoveredCert, otherIssuers []string) string {
+	text := new(strings.Builder)
+	writeField := func(name string, value any) { fmt.Fprintf(text, "\t%13s = %s\n", name, value) }
+
+	fmt.Fprintf(text, "A certificate for %s uses a public key which was previously seen in a certificate from a different issuer.  ", cert.WatchItem)
+	fmt.Fprintf(text, "This can indicate a migration to a new CA, or unauthorized issuance.\n")
+	fmt.Fprintf(text, "\n")
+	writeField("Pubkey", hex.EncodeToString(cert.PubkeySHA256[:]))
+	writeField("Issuer", cert.Info.Issuer)
+	for _, issuer := range otherIssuers {
+		writeField("Prior Issuer", issuer)
+	}
+	writeField("Certificate", hex.EncodeToString(cert.SHA256[:]))
+	writeField("Log Entry", fmt.Sprintf("%d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL))
+	return text.String()
+}
+
+func keyReuseJson(cert *DiscoveredCert, otherIssuers []string) []zap.Field {
+	return []zap.Field{
+		zap.String("event", "key_reused_across_issuers"),
+		zap.String("pubkey", hex.EncodeToString(cert.PubkeySHA256[:])),
+		zap.String("issuer", cert.Info.Issuer.String()),
+		zap.Strings("priorIssuers", otherIssuers),
+		zap.String("sha256", hex.EncodeToString(cert.SHA256[:])),
+		zap.Strings("dnsNames", cert.Identifiers.DNSNames),
+	}
+}
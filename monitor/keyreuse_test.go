@@ -0,0 +1,53 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckKeyReuseConcurrently(t *testing.T) {
+	ctx := context.Background()
+	var notifications atomic.Int32
+	state := &MemoryState{OnKeyReused: func(*DiscoveredCert, []string) {
+		notifications.Add(1)
+		time.Sleep(10 * time.Millisecond) // like a slow notification method
+	}}
+	config := &Config{State: state}
+	pubkey := [32]byte{1, 2, 3}
+
+	previous := makeTestCert(t, "Old CA")
+	previous.PubkeySHA256 = pubkey
+	if err := checkKeyReuse(ctx, config, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key is seen with a new issuer in several logs at once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		cert := makeTestCert(t, "New CA")
+		cert.PubkeySHA256 = pubkey
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := checkKeyReuse(ctx, config, cert); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := notifications.Load(); n != 1 {
+		t.Errorf("sent %d notifications about the reused key; expected 1", n)
+	}
+}
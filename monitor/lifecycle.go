@@ -0,0 +1,143 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	EventStarted = "certspotter_started"
+	EventStopped = "certspotter_stopped"
+
+	lifecycleNotifyTimeout = 1 * time.Minute
+)
+
+// ErrMaxRuntime is the cause with which the context passed to Run should be
+// canceled when certspotter stops because it reached its maximum runtime.
+var ErrMaxRuntime = errors.New("maximum runtime reached")
+
+// LifecycleEvent describes certspotter starting or stopping.
+type LifecycleEvent struct {
+	Event         string // EventStarted or EventStopped
+	Time          time.Time
+	Version       string
+	LogListSource string
+	StartAtEnd    bool
+	WatchListSize int
+	LogCount      int
+	Reason        string // for EventStopped: "signal", "max_runtime", "completed", or "error"
+	Error         error  // for EventStopped with Reason "error"
+}
+
+func (e *LifecycleEvent) Summary() string {
+	switch e.Event {
+	case EventStarted:
+		return fmt.Sprintf("certspotter %s started monitoring %d logs", e.Version, e.LogCount)
+	case EventStopped:
+		return fmt.Sprintf("certspotter %s stopped (%s)", e.Version, e.Reason)
+	default:
+		return e.Event
+	}
+}
+
+func (e *LifecycleEvent) Text() string {
+	text := new(strings.Builder)
+	writeField := func(name string, value any) { fmt.Fprintf(text, "\t%13s = %s\n", name, value) }
+	fmt.Fprintf(text, "%s.\n", e.Summary())
+	fmt.Fprintf(text, "\n")
+	writeField("Time", e.Time)
+	writeField("Version", e.Version)
+	writeField("Log List", e.LogListSource)
+	writeField("Log Count", fmt.Sprint(e.LogCount))
+	writeField("Watch List", fmt.Sprintf("%d items", e.WatchListSize))
+	writeField("Start at End", fmt.Sprint(e.StartAtEnd))
+	if e.Reason != "" {
+		writeField("Reason", e.Reason)
+	}
+	if e.Error != nil {
+		writeField("Error", e.Error)
+	}
+	return text.String()
+}
+
+func (e *LifecycleEvent) Json() []zap.Field {
+	fields := []zap.Field{
+		zap.String("event", e.Event),
+		zap.Time("time", e.Time),
+		zap.String("version", e.Version),
+		zap.String("logListSource", e.LogListSource),
+		zap.Bool("startAtEnd", e.StartAtEnd),
+		zap.Int("watchListSize", e.WatchListSize),
+		zap.Int("logCount", e.LogCount),
+	}
+	if e.Reason != "" {
+		fields = append(fields, zap.String("reason", e.Reason))
+	}
+	if e.Error != nil {
+		fields = append(fields, zap.Error(e.Error))
+	}
+	return fields
+}
+
+func newLifecycleEvent(config *Config, event string, logCount int) *LifecycleEvent {
+	watchListSize := 0
+	if config.WatchList != nil {
+		watchListSize = len(config.WatchList.Items())
+	}
+	return &LifecycleEvent{
+		Event:         event,
+		Time:          time.Now(),
+		Version:       config.Version,
+		LogListSource: config.LogListSource,
+		StartAtEnd:    config.StartAtEnd,
+		WatchListSize: watchListSize,
+		LogCount:      logCount,
+	}
+}
+
+// stoppedReason classifies the error returned by the daemon as a "signal"
+// (i.e. the context was canceled), "max_runtime" (i.e. the context was
+// canceled with ErrMaxRuntime), "completed" (i.e. a one-shot run caught up
+// on every log), or an "error" (including a one-shot run which didn't catch
+// up on every log).
+func stoppedReason(ctx context.Context, config *Config, err error) string {
+	if err == nil && config.OneShot && ctx.Err() == nil {
+		return "completed"
+	} else if err == nil || errors.Is(err, context.Canceled) {
+		if errors.Is(context.Cause(ctx), ErrMaxRuntime) {
+			return "max_runtime"
+		}
+		return "signal"
+	}
+	return "error"
+}
+
+// recordLifecycleEvent writes the event to the operational log and, if
+// config.NotifyLifecycle is set, sends it through the notification channels.
+// ctx may be canceled (e.g. when stopping); the notification is sent anyway.
+func recordLifecycleEvent(ctx context.Context, config *Config, event *LifecycleEvent) error {
+	zap.L().Info(event.Event, event.Json()...)
+	if !config.NotifyLifecycle {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lifecycleNotifyTimeout)
+	defer cancel()
+	if err := notifyLifecycleEvent(ctx, config.State, event); err != nil {
+		return fmt.Errorf("error notifying about %s event: %w", event.Event, err)
+	}
+	return nil
+}
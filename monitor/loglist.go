@@ -11,28 +11,103 @@ package monitor
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
 	"software.sslmate.com/src/certspotter/ct"
 	"software.sslmate.com/src/certspotter/loglist"
 )
 
 type LogID = ct.SHA256Hash
 
-func getLogList(ctx context.Context, source string, token *loglist.ModificationToken) (map[LogID]*loglist.Log, *loglist.ModificationToken, error) {
+// getLogList returns the logs in the log list, indexed by log ID, along with
+// the log list's timestamp (which is zero for v2 log lists).
+func getLogList(ctx context.Context, source string, token *loglist.ModificationToken) (map[LogID]*loglist.Log, time.Time, *loglist.ModificationToken, error) {
 	list, newToken, err := loglist.LoadIfModified(ctx, source, token)
 	if err != nil {
-		return nil, nil, err
+		return nil, time.Time{}, nil, err
 	}
 
 	logs := make(map[LogID]*loglist.Log)
+	add := func(log *loglist.Log, operator *loglist.Operator) error {
+		if _, exists := logs[log.LogID]; exists {
+			return fmt.Errorf("log list contains more than one entry with ID %s", log.LogID.Base64String())
+		}
+		if log.URL == "" {
+			// The URL identifies the log in notifications and -log_proxy
+			log.URL = log.MonitoringURL
+		}
+		log.OperatorName = operator.Name
+		logs[log.LogID] = log
+		return nil
+	}
 	for operatorIndex := range list.Operators {
-		for logIndex := range list.Operators[operatorIndex].Logs {
-			log := &list.Operators[operatorIndex].Logs[logIndex]
-			if _, exists := logs[log.LogID]; exists {
-				return nil, nil, fmt.Errorf("log list contains more than one entry with ID %s", log.LogID.Base64String())
+		operator := &list.Operators[operatorIndex]
+		for logIndex := range operator.Logs {
+			if err := add(&operator.Logs[logIndex], operator); err != nil {
+				return nil, time.Time{}, nil, err
+			}
+		}
+		for logIndex := range operator.TiledLogs {
+			if err := add(&operator.TiledLogs[logIndex], operator); err != nil {
+				return nil, time.Time{}, nil, err
 			}
-			logs[log.LogID] = log
 		}
 	}
-	return logs, newToken, nil
+	return logs, list.LogListTimestamp, newToken, nil
+}
+
+// makeSingleLogList returns a log list containing only the RFC 6962 log at
+// logURL with the given DER-encoded public key.
+func makeSingleLogList(logURL string, key []byte) map[LogID]*loglist.Log {
+	ctlog := &loglist.Log{
+		Key:   key,
+		LogID: sha256.Sum256(key),
+		URL:   logURL,
+	}
+	return map[LogID]*loglist.Log{ctlog.LogID: ctlog}
+}
+
+// selectLog removes every log except the one whose URL (or monitoring URL,
+// for static CT API logs) is logURL, ignoring trailing slashes.  It returns
+// an error if there is no such log.
+func selectLog(logs map[LogID]*loglist.Log, logURL string) error {
+	logURL = strings.TrimRight(logURL, "/")
+	found := false
+	for logID, ctlog := range logs {
+		if strings.TrimRight(ctlog.URL, "/") == logURL || (ctlog.MonitoringURL != "" && strings.TrimRight(ctlog.MonitoringURL, "/") == logURL) {
+			found = true
+		} else {
+			delete(logs, logID)
+		}
+	}
+	if !found {
+		return fmt.Errorf("log list does not contain a log with URL %s (specify the log's key to monitor it anyway)", logURL)
+	}
+	return nil
+}
+
+// monitorsOperator reports whether config.IncludeOperators and
+// config.ExcludeOperators permit monitoring the logs of the named operator.
+func (config *Config) monitorsOperator(name string) bool {
+	matches := func(operator string) bool { return strings.EqualFold(operator, name) }
+	if len(config.IncludeOperators) > 0 && !slices.ContainsFunc(config.IncludeOperators, matches) {
+		return false
+	}
+	return !slices.ContainsFunc(config.ExcludeOperators, matches)
+}
+
+// filterLogList removes the logs whose operators are not monitored according
+// to config, logging each one that is removed.
+func filterLogList(config *Config, logs map[LogID]*loglist.Log) {
+	for logID, ctlog := range logs {
+		if !config.monitorsOperator(ctlog.OperatorName) {
+			zap.L().Info("not monitoring log because of its operator", zap.String("log", ctlog.URL), zap.String("operator", ctlog.OperatorName))
+			delete(logs, logID)
+		}
+	}
 }
@@ -0,0 +1,45 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"testing"
+
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+func TestSelectLog(t *testing.T) {
+	makeLogs := func() map[LogID]*loglist.Log {
+		return map[LogID]*loglist.Log{
+			{1}: {LogID: LogID{1}, URL: "https://ct.example.com/2024/"},
+			{2}: {LogID: LogID{2}, URL: "https://ct.example.com/2025/"},
+			{3}: {LogID: LogID{3}, URL: "https://static.example.com/", MonitoringURL: "https://mon.example.com/"},
+		}
+	}
+	for _, test := range []struct {
+		logURL string
+		want   LogID
+	}{
+		{"https://ct.example.com/2024/", LogID{1}},
+		{"https://ct.example.com/2025", LogID{2}},
+		{"https://static.example.com", LogID{3}},
+		{"https://mon.example.com/", LogID{3}},
+	} {
+		logs := makeLogs()
+		if err := selectLog(logs, test.logURL); err != nil {
+			t.Errorf("selectLog(%q) returned error: %s", test.logURL, err)
+		} else if len(logs) != 1 || logs[test.want] == nil {
+			t.Errorf("selectLog(%q) left %d logs, want only %x", test.logURL, len(logs), test.want)
+		}
+	}
+	if err := selectLog(makeLogs(), "https://ct.example.com/"); err == nil {
+		t.Error("selectLog succeeded for a URL which isn't in the log list")
+	}
+}
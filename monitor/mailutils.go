@@ -10,9 +10,14 @@
 package monitor
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"mime"
+	"mime/quotedprintable"
 	"os"
+	"strings"
+	"unicode/utf8"
 )
 
 const mailDateFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
@@ -25,6 +30,14 @@ func generateMessageID() string {
 	return hex.EncodeToString(randomBytes[:]) + "@selfhosted.certspotter.org"
 }
 
+func generateMIMEBoundary() string {
+	var randomBytes [16]byte
+	if _, err := rand.Read(randomBytes[:]); err != nil {
+		panic(err)
+	}
+	return "certspotter-" + hex.EncodeToString(randomBytes[:])
+}
+
 func sendmailPath() string {
 	if envVar := os.Getenv("SENDMAIL_PATH"); envVar != "" {
 		return envVar
@@ -32,3 +45,60 @@ func sendmailPath() string {
 		return "/usr/sbin/sendmail"
 	}
 }
+
+// IsEmailCharset reports whether charset is supported by
+// FilesystemState.EmailCharset.
+func IsEmailCharset(charset string) bool {
+	return charset == "" || strings.EqualFold(charset, "UTF-8") || strings.EqualFold(charset, "US-ASCII")
+}
+
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// toASCII replaces every non-ASCII character in str with "?".
+func toASCII(str string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= utf8.RuneSelf {
+			return '?'
+		}
+		return r
+	}, str)
+}
+
+// encodeEmailHeader returns value, encoded per RFC 2047 if it contains
+// non-ASCII characters and charset is UTF-8.
+func encodeEmailHeader(charset string, value string) string {
+	if strings.EqualFold(charset, "US-ASCII") {
+		return toASCII(value)
+	}
+	return mime.QEncoding.Encode("UTF-8", value)
+}
+
+// encodeEmailBody returns the Content-Type and Content-Transfer-Encoding
+// headers for text of the given media type (e.g. text/plain) in the given
+// charset, and text encoded accordingly.  If charset is US-ASCII, non-ASCII
+// characters are replaced.  Plain text containing only ASCII characters is
+// sent as is; other text (including HTML, whose lines may be too long) is
+// sent as quoted-printable.  Lines are terminated by LF.
+func encodeEmailBody(charset string, mediaType string, text string) (contentType string, transferEncoding string, body string) {
+	if strings.EqualFold(charset, "US-ASCII") {
+		charset, text = "US-ASCII", toASCII(text)
+	} else {
+		charset = "UTF-8"
+	}
+	contentType = mediaType + "; charset=" + charset
+	if mediaType == "text/plain" && isASCII(text) {
+		return contentType, "7bit", text
+	}
+	encoded := new(bytes.Buffer)
+	writer := quotedprintable.NewWriter(encoded)
+	writer.Write([]byte(text))
+	writer.Close()
+	return contentType, "quoted-printable", strings.ReplaceAll(encoded.String(), "\r\n", "\n")
+}
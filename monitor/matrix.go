@@ -0,0 +1,79 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// insecureWebhookClient is used for Matrix homeservers when
+// FilesystemState.MatrixInsecureSkipVerify is set.
+var insecureWebhookClient = &http.Client{
+	Timeout:   webhookTimeout,
+	Transport: newNotificationTransport(&tls.Config{InsecureSkipVerify: true}),
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+func makeMatrixMessage(notif *notification) *matrixMessage {
+	return &matrixMessage{
+		MsgType:       "m.text",
+		Body:          notif.summary + "\n\n" + notif.text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: "<strong>" + html.EscapeString(notif.summary) + "</strong><br><pre>" + html.EscapeString(strings.TrimRight(notif.text, "\n")) + "</pre>",
+	}
+}
+
+// newMatrixTxnID returns a transaction ID which is unique to this message,
+// so that the homeserver ignores retries of a request which succeeded.
+func newMatrixTxnID() string {
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		panic(fmt.Errorf("error generating random transaction ID: %w", err))
+	}
+	return "certspotter-" + hex.EncodeToString(random[:])
+}
+
+func matrixEndpoint(s *FilesystemState, txnID string) string {
+	return strings.TrimRight(s.MatrixHomeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(s.MatrixRoomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+}
+
+// sendMatrix sends the notification to s.MatrixRoomID as an m.room.message
+// event, using the homeserver at s.MatrixHomeserver.
+func sendMatrix(ctx context.Context, s *FilesystemState, notif *notification) error {
+	body, err := json.Marshal(makeMatrixMessage(notif))
+	if err != nil {
+		return fmt.Errorf("error encoding Matrix message: %w", err)
+	}
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+s.MatrixAccessToken)
+	client := webhookClient
+	if s.MatrixInsecureSkipVerify {
+		client = insecureWebhookClient
+	}
+	if err := sendWithRetries(ctx, client, http.MethodPut, matrixEndpoint(s, newMatrixTxnID()), body, header); err != nil {
+		return fmt.Errorf("error sending Matrix message to %s via %s: %w", s.MatrixRoomID, redactURL(s.MatrixHomeserver), err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// MemoryState is a StateProvider which keeps all state in memory and passes
+// notifications to callbacks, for using this package without a state
+// directory.  State is lost when the program exits, so after a restart every
+// log is monitored from the beginning again (or from the end, if
+// Config.StartAtEnd is set), and certificates may be notified about again.
+//
+// Callbacks which are nil are not called.  If OnCertDigest is nil, OnCert is
+// called for each certificate in a digest instead.  The zero value is ready
+// to use, and a MemoryState must not be copied after first use.
+type MemoryState struct {
+	OnCert                 func(*DiscoveredCert)
+	OnCertDigest           func([]*DiscoveredCert)
+	OnKeyReused            func(cert *DiscoveredCert, otherIssuers []string)
+	OnPrecertAnomaly       func(cert *DiscoveredCert, anomaly string)
+	OnMalformedEntry       func(*LogEntry, error)
+	OnLogContacted         func(*loglist.Log, *ct.SignedTreeHead)
+	OnLogListChanged       func(added []*loglist.Log, removed []*loglist.Log)
+	OnLifecycleEvent       func(*LifecycleEvent)
+	OnHealthCheckFailure   func(*loglist.Log, HealthCheckFailure) // the log is nil if the failure is not associated with a log
+	OnHealthCheckRecovered func(*loglist.Log, HealthCheckFailure) // called with the failure the log recovered from
+	OnHealthCheckResult    func([]HealthCheckFailure)             // called after each health check with its failures
+	OnError                func(*loglist.Log, error)              // the log is nil if the error is not associated with a log
+
+	mu            sync.Mutex
+	logStates     map[LogID]*LogState
+	sths          map[LogID][]*ct.SignedTreeHead
+	keyIssuers    map[[32]byte][]string
+	precertTBS    map[[32]byte]bool
+	notifiedTBS   map[[32]byte]bool
+	notifiedCerts map[[32]byte]bool
+	notifiedLeaf  map[notifiedLeaf]bool
+	roots         map[LogID][][32]byte
+	heldCerts     map[string]map[[32]byte]*DiscoveredCert
+}
+
+// init allocates the maps, if necessary.  s.mu must be held.
+func (s *MemoryState) init() {
+	if s.logStates == nil {
+		s.logStates = make(map[LogID]*LogState)
+		s.sths = make(map[LogID][]*ct.SignedTreeHead)
+		s.keyIssuers = make(map[[32]byte][]string)
+		s.precertTBS = make(map[[32]byte]bool)
+		s.notifiedTBS = make(map[[32]byte]bool)
+		s.notifiedCerts = make(map[[32]byte]bool)
+		s.notifiedLeaf = make(map[notifiedLeaf]bool)
+		s.roots = make(map[LogID][][32]byte)
+		s.heldCerts = make(map[string]map[[32]byte]*DiscoveredCert)
+	}
+}
+
+func (s *MemoryState) Prepare(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return nil
+}
+
+func (s *MemoryState) PrepareLog(ctx context.Context, logID LogID) error {
+	return nil
+}
+
+func (s *MemoryState) StoreLogState(ctx context.Context, logID LogID, state *LogState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.logStates[logID] = state
+	return nil
+}
+
+func (s *MemoryState) LoadLogState(ctx context.Context, logID LogID) (*LogState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logStates[logID], nil
+}
+
+func (s *MemoryState) StoreSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	for _, existing := range s.sths[logID] {
+		if existing.Timestamp == sth.Timestamp && existing.SHA256RootHash == sth.SHA256RootHash {
+			return nil
+		}
+	}
+	sths := append(slices.Clone(s.sths[logID]), sth)
+	slices.SortStableFunc(sths, func(a, b *ct.SignedTreeHead) int {
+		switch {
+		case a.TreeSize < b.TreeSize:
+			return -1
+		case a.TreeSize > b.TreeSize:
+			return 1
+		default:
+			return 0
+		}
+	})
+	s.sths[logID] = sths
+	return nil
+}
+
+func (s *MemoryState) LoadSTHs(ctx context.Context, logID LogID) ([]*ct.SignedTreeHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sths[logID]), nil
+}
+
+func (s *MemoryState) RemoveSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.sths[logID] = slices.DeleteFunc(slices.Clone(s.sths[logID]), func(existing *ct.SignedTreeHead) bool {
+		return existing.Timestamp == sth.Timestamp && existing.SHA256RootHash == sth.SHA256RootHash
+	})
+	return nil
+}
+
+func (s *MemoryState) LoadKeyIssuers(ctx context.Context, pubkeySHA256 [32]byte) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keyIssuers[pubkeySHA256]), nil
+}
+
+func (s *MemoryState) StoreKeyIssuer(ctx context.Context, pubkeySHA256 [32]byte, issuer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if !slices.Contains(s.keyIssuers[pubkeySHA256], issuer) {
+		s.keyIssuers[pubkeySHA256] = append(s.keyIssuers[pubkeySHA256], issuer)
+	}
+	return nil
+}
+
+func (s *MemoryState) StorePrecertTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.precertTBS[tbsSHA256] = true
+	return nil
+}
+
+func (s *MemoryState) HasPrecertTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.precertTBS[tbsSHA256], nil
+}
+
+func (s *MemoryState) StoreNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.notifiedTBS[tbsSHA256] = true
+	return nil
+}
+
+func (s *MemoryState) HasNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notifiedTBS[tbsSHA256], nil
+}
+
+func (s *MemoryState) MarkNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.notifiedLeaf[notifiedLeaf{logID, leafHash}] = true
+	return nil
+}
+
+func (s *MemoryState) WasNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notifiedLeaf[notifiedLeaf{logID, leafHash}], nil
+}
+
+// markCertNotified records that cert has been notified about, and returns
+// false if it already was.
+func (s *MemoryState) markCertNotified(cert *DiscoveredCert) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.notifiedCerts[cert.SHA256] {
+		return false
+	}
+	s.notifiedCerts[cert.SHA256] = true
+	return true
+}
+
+func (s *MemoryState) LoadRoots(ctx context.Context, logID LogID) ([][32]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.roots[logID]), nil
+}
+
+func (s *MemoryState) StoreRoots(ctx context.Context, logID LogID, fingerprints [][32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.roots[logID] = slices.Clone(fingerprints)
+	return nil
+}
+
+func (s *MemoryState) LoadPendingNotifications(ctx context.Context) ([]*PendingNotification, error) {
+	// The callbacks can't fail, so there's never anything to retry
+	return nil, nil
+}
+
+func (s *MemoryState) SendPendingNotification(ctx context.Context, pending *PendingNotification) error {
+	return nil
+}
+
+func (s *MemoryState) StoreHeldCert(ctx context.Context, queue string, cert *DiscoveredCert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.heldCerts[queue] == nil {
+		s.heldCerts[queue] = make(map[[32]byte]*DiscoveredCert)
+	}
+	s.heldCerts[queue][cert.SHA256] = cert
+	return nil
+}
+
+func (s *MemoryState) LoadHeldCerts(ctx context.Context, queue string) ([]*DiscoveredCert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	var certs []*DiscoveredCert
+	for _, cert := range s.heldCerts[queue] {
+		certs = append(certs, cert)
+	}
+	slices.SortStableFunc(certs, func(a, b *DiscoveredCert) int { return a.ObservedAt.Compare(b.ObservedAt) })
+	return certs, nil
+}
+
+func (s *MemoryState) RemoveHeldCert(ctx context.Context, queue string, fingerprint [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	delete(s.heldCerts[queue], fingerprint)
+	return nil
+}
+
+func (s *MemoryState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	if s.markCertNotified(cert) && s.OnCert != nil {
+		s.OnCert(cert)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyCertDigest(ctx context.Context, certs []*DiscoveredCert) error {
+	var unnotified []*DiscoveredCert
+	for _, cert := range certs {
+		if s.markCertNotified(cert) {
+			unnotified = append(unnotified, cert)
+		}
+	}
+	if len(unnotified) == 0 {
+		return nil
+	}
+	if s.OnCertDigest != nil {
+		s.OnCertDigest(unnotified)
+	} else if s.OnCert != nil {
+		for _, cert := range unnotified {
+			s.OnCert(cert)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyKeyReusedAcrossIssuers(ctx context.Context, cert *DiscoveredCert, otherIssuers []string) error {
+	if s.OnKeyReused != nil {
+		s.OnKeyReused(cert, otherIssuers)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyPrecertAnomaly(ctx context.Context, cert *DiscoveredCert, anomaly string) error {
+	if s.OnPrecertAnomaly != nil {
+		s.OnPrecertAnomaly(cert, anomaly)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyMalformedEntry(ctx context.Context, entry *LogEntry, parseError error) error {
+	if s.OnMalformedEntry != nil {
+		s.OnMalformedEntry(entry, parseError)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyLogContacted(ctx context.Context, ctlog *loglist.Log, sth *ct.SignedTreeHead) error {
+	if s.OnLogContacted != nil {
+		s.OnLogContacted(ctlog, sth)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyLogListChanged(ctx context.Context, added []*loglist.Log, removed []*loglist.Log) error {
+	if s.OnLogListChanged != nil {
+		s.OnLogListChanged(added, removed)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyLifecycleEvent(ctx context.Context, event *LifecycleEvent) error {
+	if s.OnLifecycleEvent != nil {
+		s.OnLifecycleEvent(event)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyHealthCheckFailure(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	if s.OnHealthCheckFailure != nil {
+		s.OnHealthCheckFailure(ctlog, info)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyHealthCheckRecovered(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	if s.OnHealthCheckRecovered != nil {
+		s.OnHealthCheckRecovered(ctlog, info)
+	}
+	return nil
+}
+
+func (s *MemoryState) StoreHealthCheckResult(ctx context.Context, failures []HealthCheckFailure) error {
+	if s.OnHealthCheckResult != nil {
+		s.OnHealthCheckResult(failures)
+	}
+	return nil
+}
+
+func (s *MemoryState) NotifyError(ctx context.Context, ctlog *loglist.Log, err error) error {
+	if s.OnError != nil {
+		s.OnError(ctlog, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func TestMemoryState(t *testing.T) {
+	ctx := context.Background()
+	var notified []*DiscoveredCert
+	state := &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = append(notified, cert) }}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var logID LogID
+	for _, sth := range []*ct.SignedTreeHead{{TreeSize: 20, Timestamp: 2}, {TreeSize: 10, Timestamp: 1}, {TreeSize: 20, Timestamp: 2}} {
+		if err := state.StoreSTH(ctx, logID, sth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sths, _ := state.LoadSTHs(ctx, logID); len(sths) != 2 || sths[0].TreeSize != 10 || sths[1].TreeSize != 20 {
+		t.Errorf("LoadSTHs returned %v, want tree sizes 10 and 20", sths)
+	}
+	if err := state.RemoveSTH(ctx, logID, &ct.SignedTreeHead{TreeSize: 10, Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if sths, _ := state.LoadSTHs(ctx, logID); len(sths) != 1 || sths[0].TreeSize != 20 {
+		t.Errorf("LoadSTHs after RemoveSTH returned %v, want tree size 20", sths)
+	}
+
+	cert1, cert2 := &DiscoveredCert{SHA256: [32]byte{1}}, &DiscoveredCert{SHA256: [32]byte{2}}
+	state.NotifyCert(ctx, cert1)
+	state.NotifyCertDigest(ctx, []*DiscoveredCert{cert1, cert2})
+	state.NotifyCert(ctx, cert2)
+	if len(notified) != 2 || notified[0] != cert1 || notified[1] != cert2 {
+		t.Errorf("notified about %d certificates, want each certificate once", len(notified))
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+// Metrics collects statistics about the monitor, which can be served in the
+// Prometheus text exposition format.  A nil *Metrics collects nothing.
+type Metrics struct {
+	mu                   sync.Mutex
+	logs                 map[LogID]*logMetrics
+	notificationFailures uint64
+}
+
+type logMetrics struct {
+	url              string
+	position         uint64
+	treeSize         uint64 // size of the latest STH
+	lastSuccess      int64  // Unix time; zero if never successful
+	entriesProcessed uint64
+	parseErrors      uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{logs: make(map[LogID]*logMetrics)}
+}
+
+func (m *Metrics) log(logID LogID) *logMetrics {
+	if lm, ok := m.logs[logID]; ok {
+		return lm
+	}
+	lm := new(logMetrics)
+	m.logs[logID] = lm
+	return lm
+}
+
+func (m *Metrics) update(logID LogID, f func(*logMetrics)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(m.log(logID))
+}
+
+func (m *Metrics) registerLog(ctlog *loglist.Log) {
+	m.update(ctlog.LogID, func(lm *logMetrics) { lm.url = ctlog.URL })
+}
+
+func (m *Metrics) entryProcessed(entry *LogEntry) {
+	m.update(entry.Log.LogID, func(lm *logMetrics) { lm.entriesProcessed++ })
+}
+
+func (m *Metrics) notificationFailed() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notificationFailures++
+}
+
+// Serve serves the metrics over HTTP on listener until ctx is done.
+func (m *Metrics) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: m}
+	stop := context.AfterFunc(ctx, func() { server.Close() })
+	defer stop()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+func (m *Metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logs := make([]*logMetrics, 0, len(m.logs))
+	for _, lm := range m.logs {
+		if lm.url != "" {
+			logs = append(logs, lm)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].url < logs[j].url })
+
+	writeLogMetric := func(name, metricType, help string, value func(*logMetrics) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		for _, lm := range logs {
+			fmt.Fprintf(w, "%s{log=\"%s\"} %d\n", name, escapeLabelValue(lm.url), value(lm))
+		}
+	}
+	writeLogMetric("certspotter_log_download_position", "gauge", "Number of entries downloaded from the log.", func(lm *logMetrics) uint64 { return lm.position })
+	writeLogMetric("certspotter_log_tree_size", "gauge", "Tree size of the log's latest STH.", func(lm *logMetrics) uint64 { return lm.treeSize })
+	writeLogMetric("certspotter_log_backlog", "gauge", "Number of entries in the log's latest STH which have not been downloaded.", func(lm *logMetrics) uint64 {
+		if lm.treeSize < lm.position {
+			return 0
+		}
+		return lm.treeSize - lm.position
+	})
+	writeLogMetric("certspotter_log_last_success_timestamp_seconds", "gauge", "Unix time at which the log was last successfully monitored.", func(lm *logMetrics) uint64 { return uint64(lm.lastSuccess) })
+	writeLogMetric("certspotter_log_entries_processed_total", "counter", "Number of log entries processed.", func(lm *logMetrics) uint64 { return lm.entriesProcessed })
+	writeLogMetric("certspotter_log_parse_errors_total", "counter", "Number of log entries which could not be parsed.", func(lm *logMetrics) uint64 { return lm.parseErrors })
+
+	fmt.Fprintf(w, "# HELP certspotter_notification_failures_total Number of notifications which could not be sent.\n")
+	fmt.Fprintf(w, "# TYPE certspotter_notification_failures_total counter\n")
+	fmt.Fprintf(w, "certspotter_notification_failures_total %d\n", m.notificationFailures)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// metricsState is a StateProvider which updates metrics as the monitor
+// stores log state and sends notifications.
+type metricsState struct {
+	StateProvider
+	metrics *Metrics
+}
+
+func (s *metricsState) unwrapState() StateProvider { return s.StateProvider }
+
+func (s *metricsState) countFailure(err error) error {
+	if err != nil {
+		s.metrics.notificationFailed()
+	}
+	return err
+}
+
+func (s *metricsState) StoreLogState(ctx context.Context, logID LogID, state *LogState) error {
+	s.metrics.update(logID, func(lm *logMetrics) {
+		lm.position = state.DownloadPosition.Size()
+		if !state.LastSuccess.IsZero() {
+			lm.lastSuccess = state.LastSuccess.Unix()
+		}
+	})
+	return s.StateProvider.StoreLogState(ctx, logID, state)
+}
+
+func (s *metricsState) StoreSTH(ctx context.Context, logID LogID, sth *ct.SignedTreeHead) error {
+	s.metrics.update(logID, func(lm *logMetrics) { lm.treeSize = max(lm.treeSize, sth.TreeSize) })
+	return s.StateProvider.StoreSTH(ctx, logID, sth)
+}
+
+func (s *metricsState) NotifyCert(ctx context.Context, cert *DiscoveredCert) error {
+	return s.countFailure(s.StateProvider.NotifyCert(ctx, cert))
+}
+
+func (s *metricsState) NotifyCertDigest(ctx context.Context, certs []*DiscoveredCert) error {
+	return s.countFailure(notifyCertDigest(ctx, s.StateProvider, certs))
+}
+
+func (s *metricsState) LoadPendingNotifications(ctx context.Context) ([]*PendingNotification, error) {
+	return loadPendingNotifications(ctx, s.StateProvider)
+}
+
+func (s *metricsState) SendPendingNotification(ctx context.Context, pending *PendingNotification) error {
+	return s.countFailure(sendPendingNotification(ctx, s.StateProvider, pending))
+}
+
+func (s *metricsState) NotifyKeyReusedAcrossIssuers(ctx context.Context, cert *DiscoveredCert, otherIssuers []string) error {
+	return s.countFailure(notifyKeyReusedAcrossIssuers(ctx, s.StateProvider, cert, otherIssuers))
+}
+
+func (s *metricsState) NotifyPrecertAnomaly(ctx context.Context, cert *DiscoveredCert, anomaly string) error {
+	return s.countFailure(notifyPrecertAnomaly(ctx, s.StateProvider, cert, anomaly))
+}
+
+func (s *metricsState) NotifyMalformedEntry(ctx context.Context, entry *LogEntry, parseError error) error {
+	s.metrics.update(entry.Log.LogID, func(lm *logMetrics) { lm.parseErrors++ })
+	return s.countFailure(s.StateProvider.NotifyMalformedEntry(ctx, entry, parseError))
+}
+
+func (s *metricsState) NotifyLogContacted(ctx context.Context, ctlog *loglist.Log, sth *ct.SignedTreeHead) error {
+	return s.countFailure(notifyLogContacted(ctx, s.StateProvider, ctlog, sth))
+}
+
+func (s *metricsState) NotifyLogListChanged(ctx context.Context, added []*loglist.Log, removed []*loglist.Log) error {
+	return s.countFailure(notifyLogListChanged(ctx, s.StateProvider, added, removed))
+}
+
+func (s *metricsState) NotifyLifecycleEvent(ctx context.Context, event *LifecycleEvent) error {
+	return s.countFailure(notifyLifecycleEvent(ctx, s.StateProvider, event))
+}
+
+func (s *metricsState) NotifyHealthCheckFailure(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	if backlog, ok := info.(*BacklogInfo); ok && ctlog != nil {
+		s.metrics.update(ctlog.LogID, func(lm *logMetrics) {
+			lm.treeSize = max(lm.treeSize, backlog.LatestSTH.TreeSize)
+			lm.position = backlog.Position
+		})
+	}
+	return s.countFailure(s.StateProvider.NotifyHealthCheckFailure(ctx, ctlog, info))
+}
+
+func (s *metricsState) NotifyHealthCheckRecovered(ctx context.Context, ctlog *loglist.Log, info HealthCheckFailure) error {
+	return s.countFailure(notifyHealthCheckRecovered(ctx, s.StateProvider, ctlog, info))
+}
+
+func (s *metricsState) NotifyError(ctx context.Context, ctlog *loglist.Log, err error) error {
+	return s.countFailure(s.StateProvider.NotifyError(ctx, ctlog, err))
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+const expectedMetrics = `# HELP certspotter_log_download_position Number of entries downloaded from the log.
+# TYPE certspotter_log_download_position gauge
+certspotter_log_download_position{log="https://a.example/"} 5
+certspotter_log_download_position{log="https://b.example/\"quoted\"\\\n"} 30
+# HELP certspotter_log_tree_size Tree size of the log's latest STH.
+# TYPE certspotter_log_tree_size gauge
+certspotter_log_tree_size{log="https://a.example/"} 20
+certspotter_log_tree_size{log="https://b.example/\"quoted\"\\\n"} 25
+# HELP certspotter_log_backlog Number of entries in the log's latest STH which have not been downloaded.
+# TYPE certspotter_log_backlog gauge
+certspotter_log_backlog{log="https://a.example/"} 15
+certspotter_log_backlog{log="https://b.example/\"quoted\"\\\n"} 0
+# HELP certspotter_log_last_success_timestamp_seconds Unix time at which the log was last successfully monitored.
+# TYPE certspotter_log_last_success_timestamp_seconds gauge
+certspotter_log_last_success_timestamp_seconds{log="https://a.example/"} 1706788800
+certspotter_log_last_success_timestamp_seconds{log="https://b.example/\"quoted\"\\\n"} 0
+# HELP certspotter_log_entries_processed_total Number of log entries processed.
+# TYPE certspotter_log_entries_processed_total counter
+certspotter_log_entries_processed_total{log="https://a.example/"} 2
+certspotter_log_entries_processed_total{log="https://b.example/\"quoted\"\\\n"} 0
+# HELP certspotter_log_parse_errors_total Number of log entries which could not be parsed.
+# TYPE certspotter_log_parse_errors_total counter
+certspotter_log_parse_errors_total{log="https://a.example/"} 1
+certspotter_log_parse_errors_total{log="https://b.example/\"quoted\"\\\n"} 0
+# HELP certspotter_notification_failures_total Number of notifications which could not be sent.
+# TYPE certspotter_notification_failures_total counter
+certspotter_notification_failures_total 1
+`
+
+func TestMetricsWriteTo(t *testing.T) {
+	ctx := context.Background()
+	metrics := NewMetrics()
+	state := &metricsState{StateProvider: new(MemoryState), metrics: metrics}
+
+	logA := &loglist.Log{LogID: LogID{1}, URL: "https://a.example/"}
+	logB := &loglist.Log{LogID: LogID{2}, URL: "https://b.example/\"quoted\"\\\n"}
+	// Registered after logA so that the output must be sorted by URL
+	metrics.registerLog(logB)
+	metrics.registerLog(logA)
+	// Logs without a URL are omitted
+	metrics.update(LogID{3}, func(lm *logMetrics) { lm.entriesProcessed++ })
+
+	position := merkletree.EmptyCollapsedTree()
+	for i := 0; i < 5; i++ {
+		if err := position.Add(merkletree.Hash{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastSuccess := time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC)
+	if err := state.StoreLogState(ctx, logA.LogID, &LogState{DownloadPosition: position, LastSuccess: lastSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	for _, treeSize := range []uint64{20, 10} {
+		if err := state.StoreSTH(ctx, logA.LogID, &ct.SignedTreeHead{TreeSize: treeSize}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metrics.entryProcessed(&LogEntry{Log: logA})
+	metrics.entryProcessed(&LogEntry{Log: logA})
+	if err := state.NotifyMalformedEntry(ctx, &LogEntry{Log: logA}, errors.New("malformed")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A download position past the tree size doesn't produce a negative backlog
+	if err := state.NotifyHealthCheckFailure(ctx, logB, &BacklogInfo{Log: logB, LatestSTH: &ct.SignedTreeHead{TreeSize: 25}, Position: 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.countFailure(errors.New("notification failed")); err == nil {
+		t.Fatal("countFailure did not return the error")
+	}
+	if err := state.countFailure(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var output strings.Builder
+	metrics.writeTo(&output)
+	if got := output.String(); got != expectedMetrics {
+		t.Errorf("wrong metrics output:\n%s\nexpected:\n%s", got, expectedMetrics)
+	}
+}
@@ -14,10 +14,14 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 	"software.sslmate.com/src/certspotter/ct"
 	"software.sslmate.com/src/certspotter/ct/client"
 	"software.sslmate.com/src/certspotter/loglist"
@@ -25,7 +29,7 @@ import (
 )
 
 const (
-	maxGetEntriesSize  = 1000
+	defaultBatchSize   = 1000
 	monitorLogInterval = 5 * time.Minute
 )
 
@@ -33,7 +37,26 @@ func isFatalLogError(err error) bool {
 	return errors.Is(err, context.Canceled)
 }
 
-func newLogClient(ctlog *loglist.Log) (*client.LogClient, error) {
+// logClient is implemented by client.LogClient, for RFC 6962 logs, and by
+// client.StaticLogClient, for logs implementing the static CT API.
+type logClient interface {
+	GetSTH(context.Context) (*ct.SignedTreeHead, error)
+	GetRawEntries(ctx context.Context, start, end uint64) ([]client.GetEntriesItem, error)
+	GetConsistencyProof(ctx context.Context, first, second int64) (ct.ConsistencyProof, error)
+	SetRetryPolicy(client.RetryPolicy)
+	SetProxy(*url.URL)
+	SetUserAgent(string)
+	SetHeader(http.Header)
+	SetMaxResponseSize(int64)
+	SetRateLimiter(*rate.Limiter)
+	SetGzipObserver(func(ctx context.Context, uri string, compressedSize, uncompressedSize int))
+}
+
+// newLogClient returns a client for the log, or for its proxy in
+// config.LogProxies.  Either way, STH signatures are verified using the log's
+// key, and downloaded entries are verified against the STHs, so a proxy does
+// not need to be trusted.
+func newLogClient(config *Config, ctlog *loglist.Log) (logClient, error) {
 	logKey, err := x509.ParsePKIXPublicKey(ctlog.Key)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing log key: %w", err)
@@ -42,11 +65,114 @@ func newLogClient(ctlog *loglist.Log) (*client.LogClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error with log key: %w", err)
 	}
-	return client.NewWithVerifier(strings.TrimRight(ctlog.URL, "/"), verifier), nil
+	baseURL := ctlog.URL
+	if ctlog.IsStaticCTAPI() {
+		baseURL = ctlog.MonitoringURL
+	}
+	logURL := strings.TrimRight(baseURL, "/")
+	if proxyURL, ok := config.LogProxies[logURL]; ok {
+		if config.Verbose {
+			zap.S().Debugf("using proxy %s for %s", redactURL(proxyURL), baseURL)
+		}
+		logURL = strings.TrimRight(proxyURL, "/")
+	}
+	var logClient logClient
+	if ctlog.IsStaticCTAPI() {
+		logClient = client.NewStatic(logURL, verifier)
+	} else {
+		logClient = client.NewWithVerifier(logURL, verifier)
+	}
+	policy := config.retryPolicy()
+	if config.RetryNotifyThreshold > 0 {
+		policy.OnRetry = newRetryNotifier(config, ctlog)
+	}
+	logClient.SetRetryPolicy(policy)
+	if config.ProxyURL != nil {
+		logClient.SetProxy(config.ProxyURL)
+	}
+	logClient.SetMaxResponseSize(config.maxResponseSize())
+	if config.UserAgent != "" {
+		logClient.SetUserAgent(config.UserAgent)
+	}
+	if header := config.HTTPHeaders[urlHostname(logURL)]; header != nil {
+		logClient.SetHeader(header)
+	}
+	if limiter := getRateLimiter(config, ctlog, logURL); limiter != nil {
+		logClient.SetRateLimiter(limiter)
+	}
+	if config.Verbose {
+		logClient.SetGzipObserver(logGzipSavings)
+	}
+	return logClient, nil
+}
+
+// newRetryNotifier returns a client.RetryPolicy.OnRetry function which sends a
+// RetryBackoffInfo health check failure when the delay before retrying a
+// request to the log reaches config.RetryNotifyThreshold.  To avoid flooding
+// you with notifications about a struggling log, it notifies at most once per
+// config.HealthCheckInterval.
+func newRetryNotifier(config *Config, ctlog *loglist.Log) func(context.Context, int, time.Duration, error) {
+	var (
+		mu           sync.Mutex
+		lastNotified time.Time
+	)
+	return func(ctx context.Context, numRetries int, delay time.Duration, err error) {
+		if delay < config.RetryNotifyThreshold {
+			return
+		}
+		mu.Lock()
+		if time.Since(lastNotified) < config.HealthCheckInterval {
+			mu.Unlock()
+			return
+		}
+		lastNotified = time.Now()
+		mu.Unlock()
+
+		info := &RetryBackoffInfo{
+			Log:     ctlog,
+			Retries: numRetries + 1,
+			Delay:   delay,
+			Error:   err.Error(),
+		}
+		if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
+			recordError(ctx, config, ctlog, fmt.Errorf("error notifying about retry backoff: %w", err))
+		}
+	}
+}
+
+// logGzipSavings logs how many bytes were saved by a log sending a response
+// gzip-compressed.
+func logGzipSavings(ctx context.Context, uri string, compressedSize, uncompressedSize int) {
+	zap.L().Debug("received gzip-compressed response",
+		zap.String("uri", uri),
+		zap.Int("compressedBytes", compressedSize),
+		zap.Int("uncompressedBytes", uncompressedSize),
+		zap.Int("bytesSaved", uncompressedSize-compressedSize),
+	)
+}
+
+// redactURL replaces any password in rawURL with "xxxxx", so that credentials
+// for a proxy are never logged.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "[unparsable URL]"
+	}
+	return parsed.Redacted()
+}
+
+// urlHostname returns the lower-case host name (without port) of rawURL, or
+// the empty string if it can't be parsed.
+func urlHostname(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
 }
 
 func monitorLogContinously(ctx context.Context, config *Config, ctlog *loglist.Log) error {
-	logClient, err := newLogClient(ctlog)
+	logClient, err := newLogClient(config, ctlog)
 	if err != nil {
 		return err
 	}
@@ -54,10 +180,27 @@ func monitorLogContinously(ctx context.Context, config *Config, ctlog *loglist.L
 	ticker := time.NewTicker(monitorLogInterval)
 	defer ticker.Stop()
 
+	contacted := false
+	inconsistent := make(map[sthPair]bool)
+	var rootsCheckedAt time.Time
 	for ctx.Err() == nil {
-		if err := monitorLog(ctx, config, ctlog, logClient); err != nil {
+		caughtUp, err := monitorLog(ctx, config, ctlog, logClient, &contacted, inconsistent)
+		if err != nil {
 			return err
 		}
+		// Checked after monitorLog, which prepares the log's state
+		if config.CheckRoots && time.Since(rootsCheckedAt) >= config.HealthCheckInterval {
+			if err := checkRoots(ctx, config, ctlog, logClient); err != nil {
+				return err
+			}
+			rootsCheckedAt = time.Now()
+		}
+		if config.OneShot {
+			if !caughtUp {
+				return fmt.Errorf("%w: the log was not monitored up to its latest STH because of an error (which was reported separately)", ErrIncomplete)
+			}
+			return nil
+		}
 		select {
 		case <-ctx.Done():
 		case <-ticker.C:
@@ -66,71 +209,87 @@ func monitorLogContinously(ctx context.Context, config *Config, ctlog *loglist.L
 	return ctx.Err()
 }
 
-func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClient *client.LogClient) (returnedErr error) {
+// monitorLog downloads and verifies new entries from the log.  contacted
+// indicates whether an STH has been successfully retrieved from the log
+// since startup, and is set to true once this happens.  inconsistent contains
+// the pairs of STHs which have been notified about as inconsistent (see
+// verifySTHConsistency).  caughtUp is false if an error which was recorded
+// with recordError (e.g. a failure to download entries) prevented the log
+// from being monitored up to its latest STH.
+func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClient logClient, contacted *bool, inconsistent map[sthPair]bool) (caughtUp bool, returnedErr error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	if err := config.State.PrepareLog(ctx, ctlog.LogID); err != nil {
-		return fmt.Errorf("error preparing state: %w", err)
+		return false, fmt.Errorf("error preparing state: %w", err)
 	}
+	config.Metrics.registerLog(ctlog)
 
 	startTime := time.Now()
 	latestSTH, err := logClient.GetSTH(ctx)
 	if isFatalLogError(err) {
-		return err
+		return false, err
 	} else if err != nil {
 		recordError(ctx, config, ctlog, fmt.Errorf("error fetching latest STH: %w", err))
-		return nil
+		return false, nil
 	}
 	latestSTH.LogID = ctlog.LogID
-	if err := config.State.StoreSTH(ctx, ctlog.LogID, latestSTH); err != nil {
-		return fmt.Errorf("error storing latest STH: %w", err)
+	if !*contacted {
+		*contacted = true
+		if config.NotifyLogContact {
+			if err := notifyLogContacted(ctx, config.State, ctlog, latestSTH); err != nil {
+				return false, fmt.Errorf("error notifying about log contact: %w", err)
+			}
+		}
 	}
 
 	state, err := config.State.LoadLogState(ctx, ctlog.LogID)
 	if err != nil {
-		return fmt.Errorf("error loading log state: %w", err)
+		return false, fmt.Errorf("error loading log state: %w", err)
+	}
+	if state != nil && state.VerifiedSTH != nil && config.Verification.verifies(true) {
+		consistent, err := verifySTHConsistency(ctx, config, ctlog, logClient, state.VerifiedSTH, latestSTH, inconsistent)
+		if err != nil {
+			return false, err
+		} else if !consistent {
+			return false, nil
+		}
+	}
+
+	if err := config.State.StoreSTH(ctx, ctlog.LogID, latestSTH); err != nil {
+		return false, fmt.Errorf("error storing latest STH: %w", err)
 	}
 	if state == nil {
-		if config.StartAtEnd {
-			tree, err := reconstructTree(ctx, logClient, latestSTH)
-			if isFatalLogError(err) {
-				return err
-			} else if err != nil {
-				recordError(ctx, config, ctlog, fmt.Errorf("error reconstructing tree of size %d: %w", latestSTH.TreeSize, err))
-				return nil
-			}
-			state = &LogState{
-				DownloadPosition: tree,
-				VerifiedPosition: tree,
-				VerifiedSTH:      latestSTH,
-				LastSuccess:      startTime.UTC(),
-			}
-		} else {
-			state = &LogState{
-				DownloadPosition: merkletree.EmptyCollapsedTree(),
-				VerifiedPosition: merkletree.EmptyCollapsedTree(),
-				VerifiedSTH:      nil,
-				LastSuccess:      startTime.UTC(),
-			}
+		state, err = initialLogState(ctx, config, ctlog, logClient, latestSTH)
+		if isFatalLogError(err) {
+			return false, err
+		} else if err != nil {
+			recordError(ctx, config, ctlog, err)
+			return false, nil
 		}
+		state.LastSuccess = startTime.UTC()
 		if config.Verbose {
 			zap.S().Debugf("brand new log %s (starting from %d)", ctlog.URL, state.DownloadPosition.Size())
 		}
 		if err := config.State.StoreLogState(ctx, ctlog.LogID, state); err != nil {
-			return fmt.Errorf("error storing log state: %w", err)
+			return false, fmt.Errorf("error storing log state: %w", err)
 		}
 	}
 
 	sths, err := config.State.LoadSTHs(ctx, ctlog.LogID)
 	if err != nil {
-		return fmt.Errorf("error loading STHs: %w", err)
+		return false, fmt.Errorf("error loading STHs: %w", err)
+	}
+
+	sths, err = removeConflictingSTHs(ctx, config, ctlog, state.VerifiedSTH, sths)
+	if err != nil {
+		return false, err
 	}
 
 	for len(sths) > 0 && sths[0].TreeSize <= state.DownloadPosition.Size() {
 		// TODO-4: audit sths[0] against state.VerifiedSTH
 		if err := config.State.RemoveSTH(ctx, ctlog.LogID, sths[0]); err != nil {
-			return fmt.Errorf("error removing STH: %w", err)
+			return false, fmt.Errorf("error removing STH: %w", err)
 		}
 		sths = sths[1:]
 	}
@@ -146,13 +305,14 @@ func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClie
 
 	if len(sths) == 0 {
 		state.LastSuccess = startTime.UTC()
-		return nil
+		return true, nil
 	}
 
 	var (
 		downloadBegin = state.DownloadPosition.Size()
 		downloadEnd   = sths[len(sths)-1].TreeSize
-		entries       = make(chan client.GetEntriesItem, maxGetEntriesSize)
+		batchSize     = config.batchSize()
+		entries       = make(chan client.GetEntriesItem, batchSize)
 		downloadErr   error
 	)
 	if config.Verbose {
@@ -160,7 +320,11 @@ func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClie
 	}
 	go func() {
 		defer close(entries)
-		downloadErr = downloadEntries(ctx, logClient, entries, downloadBegin, downloadEnd)
+		if config.DownloadWorkers > 1 {
+			downloadErr = downloadEntriesConcurrently(ctx, logClient, entries, downloadBegin, downloadEnd, batchSize, config.DownloadWorkers)
+		} else {
+			downloadErr = downloadEntries(ctx, logClient, entries, downloadBegin, downloadEnd, batchSize)
+		}
 	}()
 	for rawEntry := range entries {
 		entry := &LogEntry{
@@ -170,30 +334,36 @@ func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClie
 			ExtraData: rawEntry.ExtraData,
 			LeafHash:  merkletree.HashLeaf(rawEntry.LeafInput),
 		}
+		if config.VerifyInclusion {
+			entry.client = logClient
+			entry.sth = sths[len(sths)-1]
+		}
 		if err := processLogEntry(ctx, config, entry); err != nil {
-			return fmt.Errorf("error processing entry %d: %w", entry.Index, err)
+			return false, fmt.Errorf("error processing entry %d: %w", entry.Index, err)
 		}
+		config.Metrics.entryProcessed(entry)
 
 		state.DownloadPosition.Add(entry.LeafHash)
-		rootHash := state.DownloadPosition.CalculateRoot()
 		shouldSaveState := state.DownloadPosition.Size()%10000 == 0
 
 		for len(sths) > 0 && state.DownloadPosition.Size() == sths[0].TreeSize {
-			if merkletree.Hash(sths[0].SHA256RootHash) != rootHash {
-				recordError(ctx, config, ctlog, fmt.Errorf("error verifying at tree size %d: the STH root hash (%x) does not match the entries returned by the log (%x)", sths[0].TreeSize, sths[0].SHA256RootHash, rootHash))
-
-				state.DownloadPosition = state.VerifiedPosition
-				if err := config.State.StoreLogState(ctx, ctlog.LogID, state); err != nil {
-					return fmt.Errorf("error storing log state: %w", err)
+			if config.Verification.verifies(len(sths) == 1) {
+				if rootHash := state.DownloadPosition.CalculateRoot(); merkletree.Hash(sths[0].SHA256RootHash) != rootHash {
+					recordError(ctx, config, ctlog, fmt.Errorf("error verifying at tree size %d: the STH root hash (%x) does not match the entries returned by the log (%x)", sths[0].TreeSize, sths[0].SHA256RootHash, rootHash))
+
+					state.DownloadPosition = state.VerifiedPosition
+					if err := config.State.StoreLogState(ctx, ctlog.LogID, state); err != nil {
+						return false, fmt.Errorf("error storing log state: %w", err)
+					}
+					return false, nil
 				}
-				return nil
 			}
 
 			state.VerifiedPosition = state.DownloadPosition
 			state.VerifiedSTH = sths[0]
 			shouldSaveState = true
 			if err := config.State.RemoveSTH(ctx, ctlog.LogID, sths[0]); err != nil {
-				return fmt.Errorf("error removing verified STH: %w", err)
+				return false, fmt.Errorf("error removing verified STH: %w", err)
 			}
 
 			sths = sths[1:]
@@ -201,16 +371,16 @@ func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClie
 
 		if shouldSaveState {
 			if err := config.State.StoreLogState(ctx, ctlog.LogID, state); err != nil {
-				return fmt.Errorf("error storing state file: %w", err)
+				return false, fmt.Errorf("error storing state file: %w", err)
 			}
 		}
 	}
 
 	if isFatalLogError(downloadErr) {
-		return downloadErr
+		return false, downloadErr
 	} else if downloadErr != nil {
 		recordError(ctx, config, ctlog, fmt.Errorf("error downloading entries: %w", downloadErr))
-		return nil
+		return false, nil
 	}
 
 	if config.Verbose {
@@ -218,19 +388,222 @@ func monitorLog(ctx context.Context, config *Config, ctlog *loglist.Log, logClie
 	}
 
 	state.LastSuccess = startTime.UTC()
-	return nil
+	return true, nil
+}
+
+// initialLogState returns the state for a log which hasn't been monitored
+// before, starting at the beginning of the log, the end of the log (if
+// config.StartAtEnd is set), or the first entry at or after
+// config.StartAtTime.  If the log can't be searched by time, it starts at
+// the end of the log.
+func initialLogState(ctx context.Context, config *Config, ctlog *loglist.Log, logClient logClient, latestSTH *ct.SignedTreeHead) (*LogState, error) {
+	startAtEnd := config.StartAtEnd
+	if !startAtEnd && !config.StartAtTime.IsZero() {
+		var searchErr *errSearchDidNotConverge
+		position, err := findEntryAtTime(ctx, logClient, latestSTH.TreeSize, config.StartAtTime)
+		if errors.As(err, &searchErr) {
+			recordError(ctx, config, ctlog, fmt.Errorf("error finding first entry at or after %s (starting at end of log instead): %w", config.StartAtTime, err))
+			startAtEnd = true
+		} else if err != nil {
+			return nil, fmt.Errorf("error finding first entry at or after %s: %w", config.StartAtTime, err)
+		} else if position < latestSTH.TreeSize {
+			tree, err := collapsedTreeAt(ctx, logClient, position)
+			if err != nil {
+				return nil, fmt.Errorf("error reconstructing tree of size %d: %w", position, err)
+			}
+			// The tree comes from unverified audit proof or tile data, so
+			// prove that it's a prefix of latestSTH before trusting it.
+			// There is no VerifiedSTH until the download position reaches
+			// the size of an STH.
+			if err := verifyCollapsedTree(ctx, logClient, tree, latestSTH); err != nil {
+				return nil, fmt.Errorf("error verifying reconstructed tree of size %d against STH of size %d: %w", position, latestSTH.TreeSize, err)
+			}
+			return &LogState{
+				DownloadPosition: tree,
+				VerifiedPosition: tree,
+			}, nil
+		} else {
+			startAtEnd = true
+		}
+	}
+	if startAtEnd {
+		tree, err := reconstructTree(ctx, logClient, latestSTH)
+		if err != nil {
+			return nil, fmt.Errorf("error reconstructing tree of size %d: %w", latestSTH.TreeSize, err)
+		}
+		return &LogState{
+			DownloadPosition: tree,
+			VerifiedPosition: tree,
+			VerifiedSTH:      latestSTH,
+		}, nil
+	}
+	return &LogState{
+		DownloadPosition: merkletree.EmptyCollapsedTree(),
+		VerifiedPosition: merkletree.EmptyCollapsedTree(),
+	}, nil
+}
+
+// verifyCollapsedTree fetches a consistency proof from the log and verifies
+// that tree is a prefix of the tree of sth.
+func verifyCollapsedTree(ctx context.Context, logClient logClient, tree *merkletree.CollapsedTree, sth *ct.SignedTreeHead) error {
+	if tree.Size() == 0 {
+		return nil
+	}
+	rawProof, err := logClient.GetConsistencyProof(ctx, int64(tree.Size()), int64(sth.TreeSize))
+	if err != nil {
+		return fmt.Errorf("error fetching consistency proof: %w", err)
+	}
+	proof := make([]merkletree.Hash, len(rawProof))
+	for i := range proof {
+		if err := proof[i].UnmarshalBinary(rawProof[i]); err != nil {
+			return fmt.Errorf("log returned malformed consistency proof: %w", err)
+		}
+	}
+	return merkletree.VerifyConsistencyProof(tree.Size(), sth.TreeSize, tree.CalculateRoot(), merkletree.Hash(sth.SHA256RootHash), proof)
+}
+
+// sthPair identifies two STHs of different sizes from the same log.
+type sthPair struct {
+	oldSize, newSize uint64
+	oldRoot, newRoot ct.SHA256Hash
+}
+
+// verifySTHConsistency fetches a consistency proof between verifiedSTH and sth
+// from the log, and notifies about it if the proof shows that the log has
+// rewritten the tree of verifiedSTH.  It returns false (and a nil error) if
+// the STHs are inconsistent, in which case sth should not be used.  Each pair
+// of inconsistent STHs is notified about only once, and is recorded in
+// inconsistent.
+//
+// If the proof can't be fetched, the error is recorded and true is returned,
+// so that a log whose proofs are unavailable doesn't stall.  This is safe
+// because entries are downloaded starting from the tree of verifiedSTH, and
+// are verified against sth once they reach its size, which fails if sth
+// isn't consistent with verifiedSTH.
+func verifySTHConsistency(ctx context.Context, config *Config, ctlog *loglist.Log, logClient logClient, verifiedSTH *ct.SignedTreeHead, sth *ct.SignedTreeHead, inconsistent map[sthPair]bool) (bool, error) {
+	if sth.TreeSize == verifiedSTH.TreeSize {
+		// Conflicting STHs of the same size are detected by removeConflictingSTHs
+		return true, nil
+	}
+	oldSTH, newSTH := verifiedSTH, sth
+	if oldSTH.TreeSize > newSTH.TreeSize {
+		oldSTH, newSTH = newSTH, oldSTH
+	}
+	pair := sthPair{oldSize: oldSTH.TreeSize, newSize: newSTH.TreeSize, oldRoot: oldSTH.SHA256RootHash, newRoot: newSTH.SHA256RootHash}
+	if inconsistent[pair] {
+		return false, nil
+	}
+	rawProof, err := logClient.GetConsistencyProof(ctx, int64(oldSTH.TreeSize), int64(newSTH.TreeSize))
+	if isFatalLogError(err) {
+		return false, err
+	} else if err != nil {
+		recordError(ctx, config, ctlog, fmt.Errorf("error fetching consistency proof between tree sizes %d and %d (the STH will be verified when its entries are downloaded instead): %w", oldSTH.TreeSize, newSTH.TreeSize, err))
+		return true, nil
+	}
+	proof := make([]merkletree.Hash, len(rawProof))
+	for i := range proof {
+		if err := proof[i].UnmarshalBinary(rawProof[i]); err != nil {
+			recordError(ctx, config, ctlog, fmt.Errorf("log returned malformed consistency proof between tree sizes %d and %d (the STH will be verified when its entries are downloaded instead): %w", oldSTH.TreeSize, newSTH.TreeSize, err))
+			return true, nil
+		}
+	}
+	err = merkletree.VerifyConsistencyProof(oldSTH.TreeSize, newSTH.TreeSize, merkletree.Hash(oldSTH.SHA256RootHash), merkletree.Hash(newSTH.SHA256RootHash), proof)
+	if err == nil {
+		return true, nil
+	}
+	inconsistent[pair] = true
+	info := &ConsistencyFailureInfo{
+		Log:    ctlog,
+		OldSTH: oldSTH,
+		NewSTH: newSTH,
+		Error:  err.Error(),
+	}
+	if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
+		return false, fmt.Errorf("error notifying about inconsistent STHs: %w", err)
+	}
+	return false, nil
 }
 
-func downloadEntries(ctx context.Context, logClient *client.LogClient, entriesChan chan<- client.GetEntriesItem, begin, end uint64) error {
+// removeConflictingSTHs looks for STHs which have the same tree size as another
+// STH (or the verified STH) but a different root hash.  Such STHs are evidence
+// of a split view, so certspotter stores them with StoreConflictingSTH (if
+// supported), notifies about them, and removes them from consideration.  If
+// the verified STH has the same tree size, the STHs which agree with it are
+// kept; otherwise there's no way to tell which STH is correct, so none of the
+// STHs with that tree size are kept.  sths must be sorted by tree size.
+func removeConflictingSTHs(ctx context.Context, config *Config, ctlog *loglist.Log, verifiedSTH *ct.SignedTreeHead, sths []*ct.SignedTreeHead) ([]*ct.SignedTreeHead, error) {
+	filtered := make([]*ct.SignedTreeHead, 0, len(sths))
+	for len(sths) > 0 {
+		n := 1
+		for n < len(sths) && sths[n].TreeSize == sths[0].TreeSize {
+			n++
+		}
+		sameSize := sths[:n]
+		sths = sths[n:]
+
+		reference := sameSize[0]
+		if verifiedSTH != nil && verifiedSTH.TreeSize == reference.TreeSize {
+			reference = verifiedSTH
+		}
+		var consistent, conflicting []*ct.SignedTreeHead
+		for _, sth := range sameSize {
+			if sth.SHA256RootHash == reference.SHA256RootHash {
+				consistent = append(consistent, sth)
+			} else {
+				conflicting = append(conflicting, sth)
+			}
+		}
+		if len(conflicting) == 0 {
+			filtered = append(filtered, consistent...)
+			continue
+		}
+
+		var removed []*ct.SignedTreeHead
+		if reference == verifiedSTH {
+			filtered = append(filtered, consistent...)
+			removed = conflicting
+		} else {
+			removed = sameSize
+		}
+		for _, sth := range append([]*ct.SignedTreeHead{reference}, conflicting...) {
+			if err := storeConflictingSTH(ctx, config.State, ctlog.LogID, sth); err != nil {
+				return nil, fmt.Errorf("error storing conflicting STH: %w", err)
+			}
+		}
+		for _, sth := range conflicting {
+			info := &ConflictingSTHInfo{
+				Log:  ctlog,
+				STH1: reference,
+				STH2: sth,
+			}
+			if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
+				return nil, fmt.Errorf("error notifying about conflicting STHs: %w", err)
+			}
+		}
+		for _, sth := range removed {
+			if err := config.State.RemoveSTH(ctx, ctlog.LogID, sth); err != nil {
+				return nil, fmt.Errorf("error removing conflicting STH: %w", err)
+			}
+		}
+	}
+	return filtered, nil
+}
+
+func downloadEntries(ctx context.Context, logClient logClient, entriesChan chan<- client.GetEntriesItem, begin, end uint64, batchSize uint64) error {
 	for begin < end && ctx.Err() == nil {
 		size := end - begin
-		if size > maxGetEntriesSize {
-			size = maxGetEntriesSize
+		if size > batchSize {
+			size = batchSize
 		}
 		entries, err := logClient.GetRawEntries(ctx, begin, begin+size-1)
 		if err != nil {
 			return err
 		}
+		if size == batchSize && uint64(len(entries)) < batchSize {
+			// The log returns at most len(entries) entries per request,
+			// so don't ask for more than that in the future
+			batchSize = uint64(len(entries))
+		}
 		for _, entry := range entries {
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -246,20 +619,115 @@ func downloadEntries(ctx context.Context, logClient *client.LogClient, entriesCh
 	return ctx.Err()
 }
 
-func reconstructTree(ctx context.Context, logClient *client.LogClient, sth *ct.SignedTreeHead) (*merkletree.CollapsedTree, error) {
-	if sth.TreeSize == 0 {
+// downloadEntriesConcurrently is like downloadEntries, but splits [begin, end)
+// into batches of batchSize entries, and downloads up to workers batches at
+// the same time.  Entries are still sent to entriesChan in index order, so
+// the caller can add them to the Merkle tree sequentially.
+func downloadEntriesConcurrently(ctx context.Context, logClient logClient, entriesChan chan<- client.GetEntriesItem, begin, end uint64, batchSize uint64, workers int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type batch struct {
+		entries []client.GetEntriesItem
+		err     error
+	}
+	// Each batch is downloaded by its own goroutine.  The capacity of
+	// pending, plus the batch being sent to entriesChan, limits the number
+	// of goroutines to workers.
+	pending := make(chan chan batch, workers-1)
+	go func() {
+		defer close(pending)
+		for batchBegin := begin; batchBegin < end; batchBegin += batchSize {
+			result := make(chan batch, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case pending <- result:
+			}
+			go func(begin, end uint64) {
+				entries, err := downloadRange(ctx, logClient, begin, end)
+				result <- batch{entries: entries, err: err}
+			}(batchBegin, min(batchBegin+batchSize, end))
+		}
+	}()
+
+	for result := range pending {
+		batch := <-result
+		for _, entry := range batch.entries {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case entriesChan <- entry:
+			}
+		}
+		if batch.err != nil {
+			return batch.err
+		}
+	}
+	return ctx.Err()
+}
+
+// downloadRange returns the entries in [begin, end), making as many requests
+// as necessary.  If an error occurs, it also returns the entries which were
+// downloaded before the error.
+func downloadRange(ctx context.Context, logClient logClient, begin, end uint64) ([]client.GetEntriesItem, error) {
+	entries := make([]client.GetEntriesItem, 0, end-begin)
+	for begin < end {
+		batch, err := logClient.GetRawEntries(ctx, begin, end-1)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, batch...)
+		begin += uint64(len(batch))
+	}
+	return entries, nil
+}
+
+func reconstructTree(ctx context.Context, logClient logClient, sth *ct.SignedTreeHead) (*merkletree.CollapsedTree, error) {
+	tree, err := collapsedTreeAt(ctx, logClient, sth.TreeSize)
+	if err != nil {
+		return nil, err
+	}
+	if rootHash := tree.CalculateRoot(); rootHash != merkletree.Hash(sth.SHA256RootHash) {
+		return nil, fmt.Errorf("calculated root hash (%x) does not match signed tree head (%x) at size %d", rootHash, sth.SHA256RootHash, sth.TreeSize)
+	}
+	return tree, nil
+}
+
+// collapsedTreeClient is implemented by log clients which can compute the
+// collapsed Merkle tree of any size directly, such as static CT API clients,
+// which compute it from the log's hash tiles.
+type collapsedTreeClient interface {
+	GetCollapsedTree(ctx context.Context, treeSize uint64) (*merkletree.CollapsedTree, error)
+}
+
+// collapsedTreeAt retrieves the collapsed Merkle tree of the given size from
+// the log, using either the log's hash tiles or an audit proof for the last
+// entry in the tree.  The caller is responsible for verifying it against an
+// STH.
+func collapsedTreeAt(ctx context.Context, logClient logClient, treeSize uint64) (*merkletree.CollapsedTree, error) {
+	if treeSize == 0 {
 		return merkletree.EmptyCollapsedTree(), nil
 	}
-	entries, err := logClient.GetRawEntries(ctx, sth.TreeSize-1, sth.TreeSize-1)
+	var proofClient auditProofClient
+	switch client := logClient.(type) {
+	case collapsedTreeClient:
+		return client.GetCollapsedTree(ctx, treeSize)
+	case auditProofClient:
+		proofClient = client
+	default:
+		return nil, errors.New("log client supports neither tiles nor audit proofs")
+	}
+	entries, err := logClient.GetRawEntries(ctx, treeSize-1, treeSize-1)
 	if err != nil {
 		return nil, err
 	}
 	leafHash := merkletree.HashLeaf(entries[0].LeafInput)
 
 	var tree *merkletree.CollapsedTree
-	if sth.TreeSize > 1 {
+	if treeSize > 1 {
 		// XXX: if leafHash is in the tree in more than one place, this might not return the proof that we need ... get-entry-and-proof avoids this problem but not all logs support it
-		auditPath, _, err := logClient.GetAuditProof(ctx, leafHash[:], sth.TreeSize)
+		auditPath, _, err := proofClient.GetAuditProof(ctx, leafHash[:], treeSize)
 		if err != nil {
 			return nil, err
 		}
@@ -267,19 +735,14 @@ func reconstructTree(ctx context.Context, logClient *client.LogClient, sth *ct.S
 		for i := range hashes {
 			copy(hashes[i][:], auditPath[len(auditPath)-i-1])
 		}
-		tree, err = merkletree.NewCollapsedTree(hashes, sth.TreeSize-1)
+		tree, err = merkletree.NewCollapsedTree(hashes, treeSize-1)
 		if err != nil {
-			return nil, fmt.Errorf("log returned invalid audit proof for %x to %d: %w", leafHash, sth.TreeSize, err)
+			return nil, fmt.Errorf("log returned invalid audit proof for %x to %d: %w", leafHash, treeSize, err)
 		}
 	} else {
 		tree = merkletree.EmptyCollapsedTree()
 	}
 
 	tree.Add(leafHash)
-	rootHash := tree.CalculateRoot()
-	if rootHash != merkletree.Hash(sth.SHA256RootHash) {
-		return nil, fmt.Errorf("calculated root hash (%x) does not match signed tree head (%x) at size %d", rootHash, sth.SHA256RootHash, sth.TreeSize)
-	}
-
 	return tree, nil
 }
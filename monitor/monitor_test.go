@@ -0,0 +1,380 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// fakeLogClient returns at most maxEntries entries per request, after a
+// random delay, and fails requests which include failAt.  The leaf input of
+// each entry is its big-endian index.  GetSTH returns sth, or fails if it's
+// nil.
+type fakeLogClient struct {
+	maxEntries       uint64
+	failAt           uint64
+	sth              *ct.SignedTreeHead
+	consistencyProof ct.ConsistencyProof
+}
+
+func (c *fakeLogClient) GetSTH(context.Context) (*ct.SignedTreeHead, error) {
+	if c.sth == nil {
+		return nil, errors.New("not implemented")
+	}
+	sth := *c.sth
+	return &sth, nil
+}
+
+func (c *fakeLogClient) GetRawEntries(ctx context.Context, start, end uint64) ([]client.GetEntriesItem, error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	end = min(end, start+c.maxEntries-1)
+	if start <= c.failAt && c.failAt <= end {
+		return nil, errors.New("injected failure")
+	}
+	var entries []client.GetEntriesItem
+	for index := start; index <= end; index++ {
+		entries = append(entries, client.GetEntriesItem{LeafInput: binary.BigEndian.AppendUint64(nil, index)})
+	}
+	return entries, nil
+}
+
+func (c *fakeLogClient) GetConsistencyProof(context.Context, int64, int64) (ct.ConsistencyProof, error) {
+	if c.consistencyProof == nil {
+		return nil, errors.New("not implemented")
+	}
+	return c.consistencyProof, nil
+}
+
+func (c *fakeLogClient) SetRetryPolicy(client.RetryPolicy)                       {}
+func (c *fakeLogClient) SetProxy(*url.URL)                                       {}
+func (c *fakeLogClient) SetUserAgent(string)                                     {}
+func (c *fakeLogClient) SetHeader(http.Header)                                   {}
+func (c *fakeLogClient) SetMaxResponseSize(int64)                                {}
+func (c *fakeLogClient) SetRateLimiter(*rate.Limiter)                            {}
+func (c *fakeLogClient) SetGzipObserver(func(context.Context, string, int, int)) {}
+
+func TestDownloadEntriesConcurrently(t *testing.T) {
+	const begin, end = 5, 1000
+	// With a batch size of 50, a request starts at 497, so every entry
+	// before it should be received before the error.
+	for _, failAt := range []uint64{end, 497} {
+		logClient := &fakeLogClient{maxEntries: 7, failAt: failAt}
+		entries := make(chan client.GetEntriesItem)
+		var err error
+		go func() {
+			defer close(entries)
+			err = downloadEntriesConcurrently(context.Background(), logClient, entries, begin, end, 50, 4)
+		}()
+		next := uint64(begin)
+		for entry := range entries {
+			if index := binary.BigEndian.Uint64(entry.LeafInput); index != next {
+				t.Fatalf("failAt=%d: received entry %d, want %d", failAt, index, next)
+			}
+			next++
+		}
+		if failAt == end && (err != nil || next != end) {
+			t.Errorf("failAt=%d: downloaded up to %d with error %v, want %d with no error", failAt, next, err, end)
+		} else if failAt < end && (err == nil || next != failAt) {
+			t.Errorf("failAt=%d: downloaded up to %d with error %v, want %d with an error", failAt, next, err, failAt)
+		}
+	}
+}
+
+// timestampLogClient returns entries with the given timestamps (in
+// milliseconds), or an unparseable entry if the timestamp is 0.
+type timestampLogClient struct {
+	fakeLogClient
+	timestamps []uint64
+}
+
+func (c *timestampLogClient) GetRawEntries(ctx context.Context, start, end uint64) ([]client.GetEntriesItem, error) {
+	var entries []client.GetEntriesItem
+	for index := start; index <= end && index < uint64(len(c.timestamps)); index++ {
+		var leafInput []byte
+		if c.timestamps[index] != 0 {
+			leafInput = []byte{0, 0}
+			leafInput = binary.BigEndian.AppendUint64(leafInput, c.timestamps[index])
+			leafInput = append(leafInput, 0, 0, 0, 0, 1, 0x30, 0, 0)
+		}
+		entries = append(entries, client.GetEntriesItem{LeafInput: leafInput})
+	}
+	return entries, nil
+}
+
+func TestFindEntryAtTime(t *testing.T) {
+	logClient := &timestampLogClient{timestamps: []uint64{1000, 2000, 2000, 3000, 5000, 4000, 6000}}
+	tests := []struct {
+		millis uint64
+		want   uint64
+	}{
+		{0, 0},
+		{1000, 0},
+		{1500, 1},
+		{2000, 1},
+		{2001, 3},
+		{3500, 4},
+		{6000, 6},
+		{7000, 7},
+	}
+	for _, test := range tests {
+		got, err := findEntryAtTime(context.Background(), logClient, uint64(len(logClient.timestamps)), time.UnixMilli(int64(test.millis)))
+		if err != nil {
+			t.Errorf("findEntryAtTime(%d): unexpected error: %s", test.millis, err)
+		} else if got != test.want {
+			t.Errorf("findEntryAtTime(%d) = %d, want %d", test.millis, got, test.want)
+		}
+	}
+
+	logClient.timestamps[3] = 0
+	var searchErr *errSearchDidNotConverge
+	if _, err := findEntryAtTime(context.Background(), logClient, uint64(len(logClient.timestamps)), time.UnixMilli(1500)); !errors.As(err, &searchErr) {
+		t.Errorf("findEntryAtTime with unparseable entry: got error %v, want *errSearchDidNotConverge", err)
+	}
+}
+
+// fakeSTH returns an STH for the first treeSize entries returned by
+// fakeLogClient.
+func fakeSTH(treeSize uint64) *ct.SignedTreeHead {
+	tree := merkletree.EmptyCollapsedTree()
+	for index := uint64(0); index < treeSize; index++ {
+		tree.Add(merkletree.HashLeaf(binary.BigEndian.AppendUint64(nil, index)))
+	}
+	return &ct.SignedTreeHead{TreeSize: treeSize, SHA256RootHash: ct.SHA256Hash(tree.CalculateRoot())}
+}
+
+func TestMonitorLogCaughtUp(t *testing.T) {
+	ctx := context.Background()
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+	badRootSTH := fakeSTH(100)
+	badRootSTH.SHA256RootHash[0] ^= 1
+	tests := []struct {
+		name     string
+		client   *fakeLogClient
+		caughtUp bool
+	}{
+		{"success", &fakeLogClient{maxEntries: 30, failAt: 1000, sth: fakeSTH(100)}, true},
+		{"get-sth failure", &fakeLogClient{maxEntries: 30, failAt: 1000}, false},
+		{"download failure", &fakeLogClient{maxEntries: 30, failAt: 50, sth: fakeSTH(100)}, false},
+		{"root hash mismatch", &fakeLogClient{maxEntries: 30, failAt: 1000, sth: badRootSTH}, false},
+	}
+	for _, test := range tests {
+		var errs []error
+		config := &Config{
+			State:     &MemoryState{OnError: func(_ *loglist.Log, err error) { errs = append(errs, err) }},
+			BatchSize: 30,
+		}
+		var contacted bool
+		caughtUp, err := monitorLog(ctx, config, ctlog, test.client, &contacted, make(map[sthPair]bool))
+		if err != nil {
+			t.Errorf("%s: monitorLog returned error: %s", test.name, err)
+		} else if caughtUp != test.caughtUp {
+			t.Errorf("%s: monitorLog returned caughtUp=%v, want %v (recorded errors: %v)", test.name, caughtUp, test.caughtUp, errs)
+		} else if !caughtUp && len(errs) == 0 {
+			t.Errorf("%s: monitorLog didn't record an error", test.name)
+		}
+	}
+}
+
+func TestVerifySTHConsistency(t *testing.T) {
+	ctx := context.Background()
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+	var (
+		errs     []error
+		notified []HealthCheckFailure
+	)
+	config := &Config{State: &MemoryState{
+		OnError:              func(_ *loglist.Log, err error) { errs = append(errs, err) },
+		OnHealthCheckFailure: func(_ *loglist.Log, info HealthCheckFailure) { notified = append(notified, info) },
+	}}
+	inconsistent := make(map[sthPair]bool)
+
+	// A proof which can't be fetched must not prevent the STH from being used
+	consistent, err := verifySTHConsistency(ctx, config, ctlog, &fakeLogClient{}, fakeSTH(10), fakeSTH(20), inconsistent)
+	if err != nil {
+		t.Fatal(err)
+	} else if !consistent || len(errs) != 1 || len(notified) != 0 {
+		t.Fatalf("proof fetch failure: consistent=%v, %d errors, %d notifications; want true, 1, 0", consistent, len(errs), len(notified))
+	}
+
+	// An invalid proof must be notified about once per pair of STHs
+	badClient := &fakeLogClient{consistencyProof: ct.ConsistencyProof{make([]byte, merkletree.HashLen)}}
+	for i := 0; i < 3; i++ {
+		consistent, err := verifySTHConsistency(ctx, config, ctlog, badClient, fakeSTH(10), fakeSTH(20), inconsistent)
+		if err != nil {
+			t.Fatal(err)
+		} else if consistent {
+			t.Fatalf("invalid proof accepted")
+		}
+	}
+	if len(notified) != 1 {
+		t.Errorf("inconsistent STHs notified %d times; want 1", len(notified))
+	}
+	if _, err := verifySTHConsistency(ctx, config, ctlog, badClient, fakeSTH(10), fakeSTH(30), inconsistent); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 2 {
+		t.Errorf("different pair of inconsistent STHs notified %d times in total; want 2", len(notified))
+	}
+}
+
+func TestRemoveConflictingSTHsRetainsEvidence(t *testing.T) {
+	ctx := context.Background()
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+	sth1, sth2 := fakeSTH(10), fakeSTH(10)
+	sth2.Timestamp = 1
+	sth2.SHA256RootHash = fakeSTH(11).SHA256RootHash
+
+	tests := []struct {
+		name        string
+		verifiedSTH *ct.SignedTreeHead
+		kept        []*ct.SignedTreeHead
+	}{
+		// Without a verified STH of the same size, there's no way to
+		// tell which STH is correct, so neither is kept
+		{"no verified STH", nil, nil},
+		{"verified STH", sth1, []*ct.SignedTreeHead{sth1}},
+	}
+	for _, test := range tests {
+		state := &FilesystemState{StateDir: filepath.Join(t.TempDir(), "state")}
+		if err := state.Prepare(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.PrepareLog(ctx, ctlog.LogID); err != nil {
+			t.Fatal(err)
+		}
+		for _, sth := range []*ct.SignedTreeHead{sth1, sth2} {
+			if err := state.StoreSTH(ctx, ctlog.LogID, sth); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sths, err := state.LoadSTHs(ctx, ctlog.LogID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filtered, err := removeConflictingSTHs(ctx, &Config{State: state}, ctlog, test.verifiedSTH, sths)
+		if err != nil {
+			t.Fatal(err)
+		} else if !slices.EqualFunc(filtered, test.kept, func(a, b *ct.SignedTreeHead) bool { return a.SHA256RootHash == b.SHA256RootHash }) {
+			t.Errorf("%s: %d STHs remain after removing conflicting STHs; want %d", test.name, len(filtered), len(test.kept))
+		}
+		if notifications, err := os.ReadDir(state.healthCheckDir(ctlog)); err != nil {
+			t.Fatal(err)
+		} else if len(notifications) != 1 {
+			t.Errorf("%s: %d health check failures were saved; want 1", test.name, len(notifications))
+		}
+		if remaining, err := state.LoadSTHs(ctx, ctlog.LogID); err != nil {
+			t.Fatal(err)
+		} else if len(remaining) != len(test.kept) {
+			t.Errorf("%s: %d STHs remain in the state directory; want %d", test.name, len(remaining), len(test.kept))
+		}
+		conflicting, err := loadSTHsFromDir(filepath.Join(state.logStateDir(ctlog.LogID), "conflicting_sths"))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(conflicting) != 2 {
+			t.Fatalf("%s: %d conflicting STHs were retained; want 2", test.name, len(conflicting))
+		}
+		for _, sth := range []*ct.SignedTreeHead{sth1, sth2} {
+			if !slices.ContainsFunc(conflicting, func(retained *ct.SignedTreeHead) bool { return retained.SHA256RootHash == sth.SHA256RootHash }) {
+				t.Errorf("%s: STH with root hash %x was not retained", test.name, sth.SHA256RootHash)
+			}
+		}
+	}
+}
+
+// consistencyProof computes the consistency proof between the first m leaves
+// and all of leaves, as in section 2.1.4.1 of RFC 9162
+func consistencyProof(m uint64, leaves []merkletree.Hash, complete bool) []merkletree.Hash {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []merkletree.Hash{treeHash(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(consistencyProof(m, leaves[:k], complete), treeHash(leaves[k:]))
+	}
+	return append(consistencyProof(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
+
+// provingLogClient is a timestampLogClient which also serves audit and
+// consistency proofs.  If corrupt is set, the audit proofs are wrong.
+type provingLogClient struct {
+	timestampLogClient
+	leaves  []merkletree.Hash
+	corrupt bool
+}
+
+func newProvingLogClient(t *testing.T, timestamps []uint64) *provingLogClient {
+	c := &provingLogClient{timestampLogClient: timestampLogClient{timestamps: timestamps}}
+	entries, err := c.GetRawEntries(context.Background(), 0, uint64(len(timestamps)-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		c.leaves = append(c.leaves, merkletree.HashLeaf(entry.LeafInput))
+	}
+	return c
+}
+
+func (c *provingLogClient) GetAuditProof(ctx context.Context, hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	return (&fakeAuditProofClient{leaves: c.leaves, corrupt: c.corrupt}).GetAuditProof(ctx, hash, treeSize)
+}
+
+func (c *provingLogClient) GetConsistencyProof(ctx context.Context, first, second int64) (ct.ConsistencyProof, error) {
+	var proof ct.ConsistencyProof
+	for _, node := range consistencyProof(uint64(first), c.leaves[:second], true) {
+		node := node
+		proof = append(proof, node[:])
+	}
+	return proof, nil
+}
+
+func TestInitialLogStateStartAtTime(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{StartAtTime: time.UnixMilli(3500)}
+	logClient := newProvingLogClient(t, []uint64{1000, 2000, 2000, 3000, 5000, 4000, 6000, 7000, 8000})
+	sth := &ct.SignedTreeHead{TreeSize: uint64(len(logClient.leaves)), SHA256RootHash: ct.SHA256Hash(treeHash(logClient.leaves))}
+
+	state, err := initialLogState(ctx, config, nil, logClient, sth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := state.DownloadPosition.Size(); size != 4 {
+		t.Errorf("starting at %d, want 4", size)
+	}
+	if root := state.VerifiedPosition.CalculateRoot(); state.VerifiedPosition.Size() != 4 || root != treeHash(logClient.leaves[:4]) {
+		t.Errorf("verified position has size %d and root %x, want the tree of the first 4 entries", state.VerifiedPosition.Size(), root)
+	}
+
+	// A tree reconstructed from a bad audit proof must not be trusted
+	logClient.corrupt = true
+	if state, err := initialLogState(ctx, config, nil, logClient, sth); err == nil {
+		t.Errorf("initialLogState with a corrupt audit proof succeeded with verified position of size %d", state.VerifiedPosition.Size())
+	}
+}
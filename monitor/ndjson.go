@@ -0,0 +1,111 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ndjsonVersion is the version of the NDJSON record schema.  It must be
+// incremented if a field is removed or its meaning changes; adding a field
+// does not require a new version.
+const ndjsonVersion = 1
+
+// ndjsonCert is the NDJSON record written for each discovered certificate
+// (see certspotter-script(8) for a description of the fields).
+type ndjsonCert struct {
+	Version           int       `json:"version"`
+	Event             string    `json:"event"`
+	WatchItem         string    `json:"watch_item"`
+	MatchedIdentifier string    `json:"matched_identifier,omitempty"`
+	MatchSource       string    `json:"match_source,omitempty"`
+	LogURI            string    `json:"log_uri"`
+	EntryIndex        uint64    `json:"entry_index"`
+	SCTTimestamp      time.Time `json:"sct_timestamp"`
+	ObservedAt        time.Time `json:"observed_at"`
+	IsPrecert         bool      `json:"is_precert"`
+	CertSHA256        string    `json:"cert_sha256"`
+	TBSSHA256         string    `json:"tbs_sha256"`
+	PubkeySHA256      string    `json:"pubkey_sha256"`
+	DNSNames          []string  `json:"dns_names"`
+	IPAddresses       []string  `json:"ip_addresses"`
+	Issuer            *string   `json:"issuer"`     // null if unparseable
+	Serial            *string   `json:"serial"`     // null if unparseable
+	NotBefore         *string   `json:"not_before"` // null if unparseable
+	NotAfter          *string   `json:"not_after"`  // null if unparseable
+	Anomalies         []string  `json:"anomalies,omitempty"`
+}
+
+func makeNDJSONCert(cert *DiscoveredCert) *ndjsonCert {
+	record := &ndjsonCert{
+		Version:           ndjsonVersion,
+		Event:             "discovered_cert",
+		WatchItem:         cert.WatchItem.String(),
+		MatchedIdentifier: cert.MatchedIdentifier,
+		MatchSource:       cert.MatchSource,
+		LogURI:            cert.LogEntry.Log.URL,
+		EntryIndex:        cert.LogEntry.Index,
+		SCTTimestamp:      cert.SCTTimestamp,
+		ObservedAt:        cert.ObservedAt,
+		IsPrecert:         cert.IsPrecert,
+		CertSHA256:        hex.EncodeToString(cert.SHA256[:]),
+		TBSSHA256:         hex.EncodeToString(cert.TBSSHA256[:]),
+		PubkeySHA256:      hex.EncodeToString(cert.PubkeySHA256[:]),
+		DNSNames:          cert.Identifiers.DNSNames,
+		IPAddresses:       ips(cert.Identifiers.IPAddrs),
+		Anomalies:         cert.Anomalies,
+	}
+	if record.DNSNames == nil {
+		record.DNSNames = []string{}
+	}
+	if cert.Info.IssuerParseError == nil {
+		issuer := cert.Info.Issuer.String()
+		record.Issuer = &issuer
+	}
+	if cert.Info.SerialNumberParseError == nil {
+		serial := fmt.Sprintf("%x", cert.Info.SerialNumber)
+		record.Serial = &serial
+	}
+	if cert.Info.ValidityParseError == nil {
+		notBefore := cert.Info.Validity.NotBefore.UTC().Format(time.RFC3339)
+		notAfter := cert.Info.Validity.NotAfter.UTC().Format(time.RFC3339)
+		record.NotBefore, record.NotAfter = &notBefore, &notAfter
+	}
+	return record
+}
+
+// writeNDJSONToStdout writes a record to w (normally stdout) for each
+// certificate in the notification, which may be about one certificate or a
+// digest.  Other notifications are not written.
+func writeNDJSONToStdout(w io.Writer, notif *notification) error {
+	certs := notif.digest
+	if notif.cert != nil {
+		certs = []*DiscoveredCert{notif.cert}
+	}
+	var buf []byte
+	for _, cert := range certs {
+		line, err := json.Marshal(makeNDJSONCert(cert))
+		if err != nil {
+			return fmt.Errorf("error encoding NDJSON record: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, err := w.Write(buf)
+	return err
+}
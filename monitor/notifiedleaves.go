@@ -0,0 +1,148 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+// notifiedLeaf identifies a log entry in the in-memory implementations of
+// MarkNotified.
+type notifiedLeaf struct {
+	logID    LogID
+	leafHash merkletree.Hash
+}
+
+// maxNotifiedLeaves is the maximum number of leaf hashes remembered per log.
+// When exceeded, the oldest half are forgotten.  This only needs to cover
+// the entries which could be processed again after the download position
+// is rolled back, which is rarely more than a few thousand.
+const maxNotifiedLeaves = 100000
+
+// notifiedLeafSet is the set of leaf hashes in a log whose certificates were
+// notified about.  It is stored in the log's state directory in the
+// notified_leaves file, which contains the hashes in the order they were
+// added, each 32 bytes long.
+type notifiedLeafSet struct {
+	hashes []merkletree.Hash
+	set    map[merkletree.Hash]struct{}
+}
+
+func loadNotifiedLeafSet(filename string) (*notifiedLeafSet, error) {
+	leaves := &notifiedLeafSet{set: make(map[merkletree.Hash]struct{})}
+	fileBytes, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return leaves, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// Ignore a partial hash at the end, which was written by an interrupted append
+	for len(fileBytes) >= merkletree.HashLen {
+		leaves.add(merkletree.Hash(fileBytes[:merkletree.HashLen]))
+		fileBytes = fileBytes[merkletree.HashLen:]
+	}
+	return leaves, nil
+}
+
+func (leaves *notifiedLeafSet) add(leafHash merkletree.Hash) {
+	leaves.hashes = append(leaves.hashes, leafHash)
+	leaves.set[leafHash] = struct{}{}
+}
+
+func (leaves *notifiedLeafSet) contains(leafHash merkletree.Hash) bool {
+	_, ok := leaves.set[leafHash]
+	return ok
+}
+
+// trim forgets the oldest half of the hashes if there are more than
+// maxNotifiedLeaves, and returns true if it did.
+func (leaves *notifiedLeafSet) trim() bool {
+	if len(leaves.hashes) <= maxNotifiedLeaves {
+		return false
+	}
+	for _, leafHash := range leaves.hashes[:len(leaves.hashes)-maxNotifiedLeaves/2] {
+		delete(leaves.set, leafHash)
+	}
+	leaves.hashes = append([]merkletree.Hash(nil), leaves.hashes[len(leaves.hashes)-maxNotifiedLeaves/2:]...)
+	return true
+}
+
+func (leaves *notifiedLeafSet) bytes() []byte {
+	buf := make([]byte, 0, len(leaves.hashes)*merkletree.HashLen)
+	for _, leafHash := range leaves.hashes {
+		buf = append(buf, leafHash[:]...)
+	}
+	return buf
+}
+
+func (s *FilesystemState) notifiedLeavesPath(logID LogID) string {
+	return filepath.Join(s.logStateDir(logID), "notified_leaves")
+}
+
+// loadNotifiedLeaves returns the notified leaf set for the log, loading it
+// from disk the first time.  s.notifiedLeavesMu must be held.
+func (s *FilesystemState) loadNotifiedLeaves(logID LogID) (*notifiedLeafSet, error) {
+	if leaves, ok := s.notifiedLeaves[logID]; ok {
+		return leaves, nil
+	}
+	leaves, err := loadNotifiedLeafSet(s.notifiedLeavesPath(logID))
+	if err != nil {
+		return nil, fmt.Errorf("error loading notified leaf hashes: %w", err)
+	}
+	if s.notifiedLeaves == nil {
+		s.notifiedLeaves = make(map[LogID]*notifiedLeafSet)
+	}
+	s.notifiedLeaves[logID] = leaves
+	return leaves, nil
+}
+
+func (s *FilesystemState) MarkNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) error {
+	s.notifiedLeavesMu.Lock()
+	defer s.notifiedLeavesMu.Unlock()
+
+	leaves, err := s.loadNotifiedLeaves(logID)
+	if err != nil {
+		return err
+	}
+	if leaves.contains(leafHash) {
+		return nil
+	}
+	leaves.add(leafHash)
+	if leaves.trim() {
+		return writeFile(s.notifiedLeavesPath(logID), leaves.bytes(), 0666)
+	}
+	file, err := os.OpenFile(s.notifiedLeavesPath(logID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(leafHash[:]); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (s *FilesystemState) WasNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) (bool, error) {
+	s.notifiedLeavesMu.Lock()
+	defer s.notifiedLeavesMu.Unlock()
+
+	leaves, err := s.loadNotifiedLeaves(logID)
+	if err != nil {
+		return false, err
+	}
+	return leaves.contains(leafHash), nil
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/merkletree"
+)
+
+func TestFilesystemStateMarkNotified(t *testing.T) {
+	ctx := context.Background()
+	state := &FilesystemState{StateDir: t.TempDir()}
+	logID := LogID{1}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.PrepareLog(ctx, logID); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.MarkNotified(ctx, logID, merkletree.Hash{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.MarkNotified(ctx, logID, merkletree.Hash{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an append which was interrupted after writing part of a hash
+	file, err := os.OpenFile(state.notifiedLeavesPath(logID), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{3, 3, 3})
+	file.Close()
+
+	// A new FilesystemState must load the set from disk
+	state = &FilesystemState{StateDir: state.StateDir}
+	for _, test := range []struct {
+		logID    LogID
+		leafHash merkletree.Hash
+		want     bool
+	}{
+		{logID, merkletree.Hash{1}, true},
+		{logID, merkletree.Hash{2}, true},
+		{logID, merkletree.Hash{3, 3, 3}, false},
+		{LogID{}, merkletree.Hash{1}, false},
+	} {
+		if got, err := state.WasNotified(ctx, test.logID, test.leafHash); err != nil {
+			t.Errorf("WasNotified(%x, %x) returned error: %s", test.logID, test.leafHash, err)
+		} else if got != test.want {
+			t.Errorf("WasNotified(%x, %x) = %v, want %v", test.logID, test.leafHash, got, test.want)
+		}
+	}
+}
+
+func TestNotifiedLeafSetTrim(t *testing.T) {
+	leaves := &notifiedLeafSet{set: make(map[merkletree.Hash]struct{})}
+	leafHash := func(i int) (hash merkletree.Hash) {
+		binary.BigEndian.PutUint64(hash[:], uint64(i))
+		return
+	}
+	for i := 0; i <= maxNotifiedLeaves; i++ {
+		leaves.add(leafHash(i))
+	}
+	if !leaves.trim() {
+		t.Fatal("trim did nothing")
+	}
+	if len(leaves.hashes) != maxNotifiedLeaves/2 || len(leaves.set) != maxNotifiedLeaves/2 {
+		t.Errorf("trim left %d hashes (%d in set), want %d", len(leaves.hashes), len(leaves.set), maxNotifiedLeaves/2)
+	}
+	if leaves.contains(leafHash(0)) {
+		t.Error("trim kept the oldest hash")
+	}
+	if !leaves.contains(leafHash(maxNotifiedLeaves)) {
+		t.Error("trim removed the newest hash")
+	}
+	if leaves.trim() {
+		t.Error("second trim did something")
+	}
+}
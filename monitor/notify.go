@@ -14,6 +14,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
@@ -27,70 +28,158 @@ import (
 
 var stdoutMu sync.Mutex
 
+// How long to wait for a killed script's output to be closed, in case the
+// script left behind child processes which hold it open.
+const scriptWaitDelay = 5 * time.Second
+
 type notification struct {
-	environ []string
-	summary string
-	text    string
-	json    []zap.Field
+	environ   []string
+	summary   string
+	text      string
+	json      []zap.Field
+	cert      *DiscoveredCert   // nil unless the notification is about a discovered certificate
+	digest    []*DiscoveredCert // nil unless the notification is a digest of discovered certificates
+	pagerDuty *pagerDutyEvent   // nil unless the notification is about a health check failure
+}
+
+// event returns the notification's EVENT (see certspotter-script(8)).
+func (notif *notification) event() string {
+	for _, env := range notif.environ {
+		if event, found := strings.CutPrefix(env, "EVENT="); found {
+			return event
+		}
+	}
+	return ""
+}
+
+// jsonMessage returns the message with which the notification is logged by
+// writeJsonToStdout.  It depends only on the kind of notification, so that it
+// can be used to filter the JSON output.
+func (notif *notification) jsonMessage() string {
+	switch event := notif.event(); event {
+	case "discovered_cert":
+		return "New certificate detected"
+	case "discovered_cert_digest":
+		return "New certificates detected"
+	case "malformed_cert":
+		return "Unable to parse log entry"
+	case "key_reused_across_issuers":
+		return "Key reused across issuers"
+	case "precert_anomaly":
+		return "Precertificate anomaly detected"
+	case "log_contacted":
+		return "Log contacted"
+	case "log_list_changed":
+		return "Log list changed"
+	case EventStarted:
+		return "certspotter started"
+	case EventStopped:
+		return "certspotter stopped"
+	case "error":
+		return "Health check failed"
+	default:
+		return event
+	}
 }
 
 func (s *FilesystemState) notify(ctx context.Context, notif *notification) error {
 	if s.Stdout && !s.Json {
-		writeToStdout(notif)
+		writeToStdout(s.stdout(), notif)
 	} else if s.Json {
-		writeJsonToStdout(notif)
+		writeJsonToStdout(s.JsonLogger, notif)
 	}
-
-	if len(s.Email) > 0 {
-		if err := sendEmail(ctx, s.Email, notif); err != nil {
-			return err
+	if s.NDJSON {
+		if err := writeNDJSONToStdout(s.stdout(), notif); err != nil {
+			return classifyError(err, ErrNotification)
 		}
 	}
 
-	if s.Script != "" {
-		if err := execScript(ctx, s.Script, notif); err != nil {
-			return err
+	var failures deliveryFailures
+	for _, method := range notificationMethods {
+		if method.configured(s) {
+			failures.add(method.name, method.send(ctx, s, notif))
 		}
 	}
 
-	if s.ScriptDir != "" {
-		if err := execScriptDir(ctx, s.ScriptDir, notif); err != nil {
-			return err
+	if len(failures.methods) > 0 {
+		err := failures.err()
+		if queueErr := s.enqueueNotification(notif, failures.methods); queueErr != nil {
+			return classifyError(fmt.Errorf("%w (and queueing the notification for retry failed: %w)", err, queueErr), ErrNotification)
 		}
+		s.NotifyError(ctx, nil, fmt.Errorf("%w (the notification was queued and will be retried)", err))
 	}
 
 	return nil
 }
-func writeJsonToStdout(notif *notification) {
+
+func (s *FilesystemState) stdout() io.Writer {
+	if s.StdoutWriter != nil {
+		return s.StdoutWriter
+	}
+	return os.Stdout
+}
+
+func writeJsonToStdout(logger *zap.Logger, notif *notification) {
+	if logger == nil {
+		logger = zap.L()
+	}
 	stdoutMu.Lock()
 	defer stdoutMu.Unlock()
-	zap.L().Info("New certificate detected", notif.json...)
+	logger.Info(notif.jsonMessage(), notif.json...)
 }
 
-func writeToStdout(notif *notification) {
+func writeToStdout(w io.Writer, notif *notification) {
 	stdoutMu.Lock()
 	defer stdoutMu.Unlock()
-	os.Stdout.WriteString(notif.text + "\n")
+	io.WriteString(w, notif.text+"\n")
+}
+
+// buildEmail returns the message to send to the recipients.  Lines are
+// terminated by LF; the caller is responsible for converting them if needed.
+// If s.EmailHTML is set, the message contains both the text and an HTML
+// version of the notification, as alternatives.
+func buildEmail(s *FilesystemState, from string, notif *notification) []byte {
+	message := new(bytes.Buffer)
+	if from != "" {
+		fmt.Fprintf(message, "From: %s\n", from)
+	}
+	fmt.Fprintf(message, "To: %s\n", strings.Join(s.Email, ", "))
+	fmt.Fprintf(message, "Subject: %s\n", encodeEmailHeader(s.EmailCharset, "[certspotter] "+notif.summary))
+	fmt.Fprintf(message, "Date: %s\n", time.Now().Format(mailDateFormat))
+	fmt.Fprintf(message, "Message-ID: <%s>\n", generateMessageID())
+	fmt.Fprintf(message, "Mime-Version: 1.0\n")
+	if s.EmailHTML {
+		boundary := generateMIMEBoundary()
+		fmt.Fprintf(message, "Content-Type: multipart/alternative; boundary=\"%s\"\n", boundary)
+		fmt.Fprintf(message, "X-Mailer: certspotter\n")
+		fmt.Fprintf(message, "\n")
+		fmt.Fprintf(message, "This is a multi-part message in MIME format.\n")
+		for _, part := range [][2]string{{"text/plain", notif.text}, {"text/html", makeEmailHTML(notif)}} {
+			contentType, transferEncoding, body := encodeEmailBody(s.EmailCharset, part[0], part[1])
+			fmt.Fprintf(message, "\n--%s\n", boundary)
+			fmt.Fprintf(message, "Content-Type: %s\n", contentType)
+			fmt.Fprintf(message, "Content-Transfer-Encoding: %s\n", transferEncoding)
+			fmt.Fprintf(message, "\n")
+			fmt.Fprint(message, strings.TrimSuffix(body, "\n")+"\n")
+		}
+		fmt.Fprintf(message, "\n--%s--\n", boundary)
+	} else {
+		contentType, transferEncoding, body := encodeEmailBody(s.EmailCharset, "text/plain", notif.text)
+		fmt.Fprintf(message, "Content-Type: %s\n", contentType)
+		fmt.Fprintf(message, "Content-Transfer-Encoding: %s\n", transferEncoding)
+		fmt.Fprintf(message, "X-Mailer: certspotter\n")
+		fmt.Fprintf(message, "\n")
+		fmt.Fprint(message, body)
+	}
+	return message.Bytes()
 }
 
-func sendEmail(ctx context.Context, to []string, notif *notification) error {
-	stdin := new(bytes.Buffer)
+func sendEmail(ctx context.Context, s *FilesystemState, notif *notification) error {
 	stderr := new(bytes.Buffer)
 
+	to := s.Email
 	from := os.Getenv("EMAIL")
-
-	if from != "" {
-		fmt.Fprintf(stdin, "From: %s\n", from)
-	}
-	fmt.Fprintf(stdin, "To: %s\n", strings.Join(to, ", "))
-	fmt.Fprintf(stdin, "Subject: [certspotter] %s\n", notif.summary)
-	fmt.Fprintf(stdin, "Date: %s\n", time.Now().Format(mailDateFormat))
-	fmt.Fprintf(stdin, "Message-ID: <%s>\n", generateMessageID())
-	fmt.Fprintf(stdin, "Mime-Version: 1.0\n")
-	fmt.Fprintf(stdin, "Content-Type: text/plain; charset=US-ASCII\n")
-	fmt.Fprintf(stdin, "X-Mailer: certspotter\n")
-	fmt.Fprintf(stdin, "\n")
-	fmt.Fprint(stdin, notif.text)
+	stdin := bytes.NewReader(buildEmail(s, from, notif))
 
 	args := []string{"-i"}
 	if from != "" {
@@ -121,6 +210,7 @@ func execScript(ctx context.Context, scriptName string, notif *notification) err
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, notif.environ...)
 	cmd.Stderr = stderr
+	cmd.WaitDelay = scriptWaitDelay
 
 	if err := cmd.Run(); err == nil {
 		return nil
@@ -135,7 +225,47 @@ func execScript(ctx context.Context, scriptName string, notif *notification) err
 	}
 }
 
-func execScriptDir(ctx context.Context, dirPath string, notif *notification) error {
+// scriptSemaphore returns a channel with room for s.ScriptConcurrency
+// values, or nil if the number of concurrent scripts is unlimited.
+func (s *FilesystemState) scriptSemaphore() chan struct{} {
+	s.scriptSemOnce.Do(func() {
+		if s.ScriptConcurrency > 0 {
+			s.scriptSem = make(chan struct{}, s.ScriptConcurrency)
+		}
+	})
+	return s.scriptSem
+}
+
+// runScript executes the script, first waiting for fewer than
+// s.ScriptConcurrency scripts to be running, and kills it if it runs for
+// longer than s.ScriptTimeout.  A timeout is reported using NotifyError
+// instead of being returned, so that a hung script doesn't cause the
+// notification to be retried (and the script to hang again).
+func (s *FilesystemState) runScript(ctx context.Context, scriptName string, notif *notification) error {
+	if sem := s.scriptSemaphore(); sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	scriptCtx := ctx
+	if s.ScriptTimeout > 0 {
+		var cancel context.CancelFunc
+		scriptCtx, cancel = context.WithTimeout(ctx, s.ScriptTimeout)
+		defer cancel()
+	}
+	err := execScript(scriptCtx, scriptName, notif)
+	if err != nil && ctx.Err() == nil && errors.Is(scriptCtx.Err(), context.DeadlineExceeded) {
+		s.NotifyError(ctx, nil, fmt.Errorf("script %q timed out after %s and was killed", scriptName, s.ScriptTimeout))
+		return nil
+	}
+	return err
+}
+
+func (s *FilesystemState) runScriptDir(ctx context.Context, dirPath string, notif *notification) error {
 	dirents, err := os.ReadDir(dirPath)
 	if errors.Is(err, fs.ErrNotExist) {
 		return nil
@@ -153,7 +283,7 @@ func execScriptDir(ctx context.Context, dirPath string, notif *notification) err
 		} else if err != nil {
 			return fmt.Errorf("error executing %q in directory %q: %w", dirent.Name(), dirPath, err)
 		} else if info.Mode().IsRegular() && isExecutable(info.Mode()) {
-			if err := execScript(ctx, scriptPath, notif); err != nil {
+			if err := s.runScript(ctx, scriptPath, notif); err != nil {
 				return err
 			}
 		}
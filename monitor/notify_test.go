@@ -0,0 +1,419 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+type recordedRequest struct {
+	method string
+	url    *url.URL
+	header http.Header
+	body   []byte
+}
+
+// newRecordingServer returns a server which responds to every request with
+// the given status, and sends the requests to the returned channel.
+func newRecordingServer(t *testing.T, status int) (*httptest.Server, <-chan *recordedRequest) {
+	t.Helper()
+	requests := make(chan *recordedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- &recordedRequest{method: r.Method, url: r.URL, header: r.Header, body: body}
+		w.WriteHeader(status)
+		io.WriteString(w, "{}")
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func makeTestCertNotification(t *testing.T) *notification {
+	t.Helper()
+	cert := makeTestCert(t, "www.example.com", "www.example.com", "example.com")
+	cert.SHA256 = sha256.Sum256(cert.Chain[0])
+	identifiers, err := cert.Info.ParseIdentifiers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Identifiers = identifiers
+	return &notification{
+		environ: []string{"EVENT=discovered_cert"},
+		summary: "Certificate Discovered for www.example.com",
+		text:    "DNS Name = www.example.com\nDNS Name = example.com\n",
+		cert:    cert,
+	}
+}
+
+func decodeJSONBody(t *testing.T, request *recordedRequest, v any) {
+	t.Helper()
+	if err := json.Unmarshal(request.body, v); err != nil {
+		t.Fatalf("request body is not JSON: %s: %q", err, request.body)
+	}
+}
+
+func TestNotificationMethodPayloads(t *testing.T) {
+	ctx := context.Background()
+	notif := makeTestCertNotification(t)
+	fingerprint := hex.EncodeToString(notif.cert.SHA256[:])
+
+	tests := []struct {
+		method    string
+		configure func(s *FilesystemState, serverURL string)
+		check     func(t *testing.T, request *recordedRequest)
+	}{
+		{
+			method: methodSlack,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.SlackWebhookURL = serverURL + "/services/hook"
+				s.SlackChannel = "#alerts"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message slackMessage
+				decodeJSONBody(t, request, &message)
+				if request.url.Path != "/services/hook" || message.Channel != "#alerts" || message.Text != notif.summary {
+					t.Errorf("wrong request: %s %#v", request.url, message)
+				}
+				if !strings.Contains(string(request.body), "www.example.com") || !strings.Contains(string(request.body), "https://crt.sh/?sha256="+fingerprint) {
+					t.Errorf("message doesn't describe the certificate: %s", request.body)
+				}
+			},
+		},
+		{
+			method: methodDiscord,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.DiscordWebhookURL = serverURL + "/api/webhooks/1/token"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message discordMessage
+				decodeJSONBody(t, request, &message)
+				if message.Content != notif.summary || len(message.Embeds) != 1 {
+					t.Fatalf("wrong message: %#v", message)
+				}
+				if embed := message.Embeds[0]; embed.Title != "www.example.com" || embed.URL != "https://crt.sh/?sha256="+fingerprint {
+					t.Errorf("wrong embed: %#v", embed)
+				}
+			},
+		},
+		{
+			method: methodTeams,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.TeamsWebhookURL = serverURL + "/webhook"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message teamsMessage
+				decodeJSONBody(t, request, &message)
+				if message.Type != "message" || len(message.Attachments) != 1 || message.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+					t.Fatalf("wrong message: %#v", message)
+				}
+				card := message.Attachments[0].Content
+				if card.Type != "AdaptiveCard" || len(card.Actions) != 1 || card.Actions[0].URL != "https://crt.sh/?sha256="+fingerprint {
+					t.Errorf("wrong card: %#v", card)
+				}
+			},
+		},
+		{
+			method: methodTelegram,
+			configure: func(s *FilesystemState, serverURL string) {
+				telegramAPIURL = serverURL
+				s.TelegramBotToken = "123:secret"
+				s.TelegramChatID = "@alerts"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message telegramMessage
+				decodeJSONBody(t, request, &message)
+				if request.url.Path != "/bot123:secret/sendMessage" {
+					t.Errorf("wrong path %q", request.url.Path)
+				}
+				if message.ChatID != "@alerts" || message.ParseMode != "MarkdownV2" || !strings.Contains(message.Text, `www\.example\.com`) {
+					t.Errorf("wrong message: %#v", message)
+				}
+			},
+		},
+		{
+			method: methodNtfy,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.NtfyURL = serverURL
+				s.NtfyTopic = "certspotter"
+				s.NtfyToken = "ntfy-token"
+				s.NtfyPriority = "high"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				if request.url.Path != "/certspotter" || string(request.body) != notif.text {
+					t.Errorf("wrong request: %s %q", request.url, request.body)
+				}
+				if request.header.Get("X-Title") != notif.summary || request.header.Get("X-Priority") != "high" || request.header.Get("Authorization") != "Bearer ntfy-token" {
+					t.Errorf("wrong headers: %v", request.header)
+				}
+			},
+		},
+		{
+			method: methodGotify,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.GotifyURL = serverURL + "/"
+				s.GotifyToken = "gotify token"
+				s.GotifyPriority = 4
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message gotifyMessage
+				decodeJSONBody(t, request, &message)
+				if request.url.Path != "/message" || request.url.Query().Get("token") != "gotify token" {
+					t.Errorf("wrong URL %s", request.url)
+				}
+				if message != (gotifyMessage{Title: notif.summary, Message: notif.text, Priority: 4}) {
+					t.Errorf("wrong message: %#v", message)
+				}
+			},
+		},
+		{
+			method: methodMatrix,
+			configure: func(s *FilesystemState, serverURL string) {
+				s.MatrixHomeserver = serverURL
+				s.MatrixRoomID = "!room:example.org"
+				s.MatrixAccessToken = "matrix-token"
+			},
+			check: func(t *testing.T, request *recordedRequest) {
+				var message matrixMessage
+				decodeJSONBody(t, request, &message)
+				if request.method != http.MethodPut || !strings.HasPrefix(request.url.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/certspotter-") {
+					t.Errorf("wrong request: %s %s", request.method, request.url)
+				}
+				if request.header.Get("Authorization") != "Bearer matrix-token" {
+					t.Errorf("wrong Authorization header %q", request.header.Get("Authorization"))
+				}
+				if message.MsgType != "m.text" || !strings.Contains(message.Body, notif.summary) {
+					t.Errorf("wrong message: %#v", message)
+				}
+			},
+		},
+	}
+	defer func(url string) { telegramAPIURL = url }(telegramAPIURL)
+	for _, test := range tests {
+		server, requests := newRecordingServer(t, http.StatusOK)
+		state := &FilesystemState{}
+		test.configure(state, server.URL)
+		if err := state.deliver(ctx, test.method, notif); err != nil {
+			t.Errorf("%s: %s", test.method, err)
+			continue
+		}
+		select {
+		case request := <-requests:
+			t.Run(test.method, func(t *testing.T) { test.check(t, request) })
+		default:
+			t.Errorf("%s: no request was sent", test.method)
+		}
+	}
+}
+
+func TestNotifyQueuesFailedChatMethods(t *testing.T) {
+	ctx := context.Background()
+	failing, _ := newRecordingServer(t, http.StatusBadRequest)
+	working, requests := newRecordingServer(t, http.StatusOK)
+	state := &FilesystemState{
+		StateDir:          filepath.Join(t.TempDir(), "state"),
+		SlackWebhookURL:   failing.URL,
+		DiscordWebhookURL: working.URL,
+	}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.notify(ctx, makeHeldTestCertNotification(t)); err != nil {
+		t.Fatalf("notify returned error instead of queueing the notification: %s", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("%d requests were sent to the working method; expected 1", len(requests))
+	}
+	pendings, err := state.LoadPendingNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 || len(pendings[0].Methods) != 1 || pendings[0].Methods[0] != methodSlack {
+		t.Fatalf("pending notifications are %#v; expected one to be retried using Slack", pendings)
+	}
+
+	// The retry must only use the failed method
+	state.SlackWebhookURL = working.URL
+	if err := state.SendPendingNotification(ctx, pendings[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("%d requests were sent in total; expected 2", len(requests))
+	}
+	if pendings, err := state.LoadPendingNotifications(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 0 {
+		t.Errorf("%d notifications are still pending", len(pendings))
+	}
+}
+
+// makeHeldTestCertNotification returns a notification about a certificate
+// which can be stored with a pending notification.
+func makeHeldTestCertNotification(t *testing.T) *notification {
+	t.Helper()
+	cert := makeHeldTestCert(t, "www.example.com")
+	return &notification{
+		environ: []string{"EVENT=discovered_cert"},
+		summary: "Certificate Discovered for www.example.com",
+		text:    "DNS Name = www.example.com\n",
+		json:    cert.Json(),
+		cert:    cert,
+	}
+}
+
+func TestRetriedNotificationMatchesOriginal(t *testing.T) {
+	ctx := context.Background()
+	failing, failedRequests := newRecordingServer(t, http.StatusBadRequest)
+	working, requests := newRecordingServer(t, http.StatusOK)
+	state := &FilesystemState{
+		StateDir:        filepath.Join(t.TempDir(), "state"),
+		Webhook:         failing.URL + "/webhook",
+		SlackWebhookURL: failing.URL + "/slack",
+	}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.notify(ctx, makeHeldTestCertNotification(t)); err != nil {
+		t.Fatalf("notify returned error instead of queueing the notification: %s", err)
+	}
+	original := map[string][]byte{}
+	for i := 0; i < 2; i++ {
+		request := <-failedRequests
+		original[request.url.Path] = request.body
+	}
+
+	// A new FilesystemState must load the queue from disk
+	state = &FilesystemState{StateDir: state.StateDir, Webhook: working.URL + "/webhook", SlackWebhookURL: working.URL + "/slack"}
+	pendings, err := state.LoadPendingNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 {
+		t.Fatalf("%d notifications are pending; expected 1", len(pendings))
+	}
+	if err := state.SendPendingNotification(ctx, pendings[0]); err != nil {
+		t.Fatal(err)
+	}
+	retried := map[string][]byte{}
+	for i := 0; i < 2; i++ {
+		request := <-requests
+		retried[request.url.Path] = request.body
+	}
+	for _, path := range []string{"/webhook", "/slack"} {
+		if string(retried[path]) != string(original[path]) {
+			t.Errorf("retried %s payload differs from the original:\noriginal: %s\n retried: %s", path, original[path], retried[path])
+		}
+	}
+	if !strings.Contains(string(retried["/slack"]), "crt.sh") {
+		t.Errorf("retried Slack message doesn't describe the certificate: %s", retried["/slack"])
+	}
+}
+
+func TestFailedPagerDutyTriggerIsRetried(t *testing.T) {
+	ctx := context.Background()
+	failing, _ := newRecordingServer(t, http.StatusBadRequest)
+	working, requests := newRecordingServer(t, http.StatusOK)
+	state := &FilesystemState{
+		StateDir:            filepath.Join(t.TempDir(), "state"),
+		PagerDutyRoutingKey: "routing-key",
+		PagerDutyURL:        failing.URL,
+	}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ctlog := &loglist.Log{URL: "https://log.example/"}
+	info := &BacklogInfo{Log: ctlog, LatestSTH: &ct.SignedTreeHead{TreeSize: 100}, Position: 40}
+	if err := os.MkdirAll(state.healthCheckDir(ctlog), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
+		t.Fatal(err)
+	}
+	pendings, err := state.LoadPendingNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 || len(pendings[0].Methods) != 1 || pendings[0].Methods[0] != methodPagerDuty {
+		t.Fatalf("pending notifications are %#v; expected one to be retried using PagerDuty", pendings)
+	}
+	if strings.Contains(string(mustReadPendingNotification(t, state, pendings[0])), "routing-key") {
+		t.Error("the routing key was saved with the pending notification")
+	}
+
+	state.PagerDutyURL = working.URL
+	if err := state.SendPendingNotification(ctx, pendings[0]); err != nil {
+		t.Fatal(err)
+	}
+	var event pagerDutyEvent
+	decodeJSONBody(t, <-requests, &event)
+	if event.RoutingKey != "routing-key" || event.EventAction != "trigger" || event.DedupKey != pagerDutyDedupKey(ctlog, info) || event.Payload == nil || event.Payload.Summary != info.Summary() {
+		t.Errorf("retried event is %#v", event)
+	}
+}
+
+func mustReadPendingNotification(t *testing.T, state *FilesystemState, pending *PendingNotification) []byte {
+	t.Helper()
+	contents, err := os.ReadFile(filepath.Join(state.pendingNotificationsDir(), pending.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestJsonOutputIdentifiesNotification(t *testing.T) {
+	ctx := context.Background()
+	output := new(bytes.Buffer)
+	encoderConfig := zap.NewProductionEncoderConfig()
+	state := &FilesystemState{
+		StateDir:   filepath.Join(t.TempDir(), "state"),
+		Json:       true,
+		JsonLogger: zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(output), zapcore.InfoLevel)),
+	}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.NotifyHealthCheckFailure(ctx, nil, &StaleLogListInfo{Source: "https://loglist.example/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.notify(ctx, makeHeldTestCertNotification(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("output is not JSON: %s: %q", err, line)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records were written; expected 2", len(records))
+	}
+	if records[0][encoderConfig.MessageKey] != "Health check failed" || records[0]["type"] != "stale_log_list" {
+		t.Errorf("health check failure was logged as %v", records[0])
+	}
+	if records[1][encoderConfig.MessageKey] != "New certificate detected" || records[1]["type"] != "certificate" {
+		t.Errorf("certificate was logged as %v", records[1])
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// isWarning reports whether the notification is about a problem, such as a
+// health check failure, rather than an ordinary event, such as a discovered
+// certificate.
+func (notif *notification) isWarning() bool {
+	switch notif.event() {
+	case "error", "malformed_cert", "precert_anomaly", "key_reused_across_issuers":
+		return true
+	default:
+		return false
+	}
+}
+
+// ntfyTags returns the tags for the notification.  The first tag is an emoji
+// shortcode, which ntfy displays in front of the title.
+func ntfyTags(notif *notification) string {
+	event := notif.event()
+	switch {
+	case notif.isWarning():
+		return "warning," + event
+	case strings.HasPrefix(event, "discovered_cert"):
+		return "lock," + event
+	default:
+		return "information_source," + event
+	}
+}
+
+func ntfyEndpoint(s *FilesystemState) string {
+	if s.NtfyTopic == "" {
+		return s.NtfyURL
+	}
+	return strings.TrimRight(s.NtfyURL, "/") + "/" + s.NtfyTopic
+}
+
+// sendNtfy publishes the notification to the ntfy topic at s.NtfyURL (and
+// s.NtfyTopic), using the summary as the title and the text as the message.
+func sendNtfy(ctx context.Context, s *FilesystemState, notif *notification) error {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("X-Title", notif.summary)
+	header.Set("X-Tags", ntfyTags(notif))
+	priority := s.NtfyPriority
+	if notif.isWarning() {
+		priority = s.NtfyWarnPriority
+	}
+	if priority != "" {
+		header.Set("X-Priority", priority)
+	}
+	if s.NtfyToken != "" {
+		header.Set("Authorization", "Bearer "+s.NtfyToken)
+	}
+	if err := postWithRetries(ctx, ntfyEndpoint(s), []byte(notif.text), header); err != nil {
+		return fmt.Errorf("error publishing to ntfy topic %s: %w", redactURL(ntfyEndpoint(s)), err)
+	}
+	return nil
+}
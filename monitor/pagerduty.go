@@ -0,0 +1,109 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+const (
+	pagerDutyEventsURL        = "https://events.pagerduty.com/v2/enqueue"
+	pagerDutyMaxSummaryLength = 1024
+)
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`  // filled in when the event is sent, so it isn't saved with pending notifications
+	EventAction string            `json:"event_action"` // "trigger" or "resolve"
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"` // only for "trigger"
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// pagerDutyDedupKey returns the key which identifies the PagerDuty alert for
+// the failure, so that repeated failures of the same type for the same log
+// are grouped into one alert, and a recovery resolves it.
+func pagerDutyDedupKey(ctlog *loglist.Log, info HealthCheckFailure) string {
+	if ctlog == nil {
+		return "certspotter/" + info.Type()
+	}
+	return "certspotter/" + ctlog.LogID.Base64URLString() + "/" + info.Type()
+}
+
+func pagerDutyEndpoint(s *FilesystemState) string {
+	if s.PagerDutyURL != "" {
+		return s.PagerDutyURL
+	}
+	return pagerDutyEventsURL
+}
+
+// makePagerDutyTrigger returns the trigger event about the health check
+// failure, without a routing key.
+func makePagerDutyTrigger(ctlog *loglist.Log, info HealthCheckFailure) *pagerDutyEvent {
+	payload := &pagerDutyPayload{
+		Summary:       truncate(info.Summary(), pagerDutyMaxSummaryLength),
+		Source:        "certspotter",
+		Severity:      "error",
+		CustomDetails: map[string]string{"text": info.Text()},
+	}
+	if ctlog != nil {
+		payload.Component = ctlog.URL
+	}
+	return &pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(ctlog, info),
+		Payload:     payload,
+	}
+}
+
+// triggerPagerDuty sends the notification's trigger event, if it has one, to
+// the PagerDuty Events API v2, using s.PagerDutyRoutingKey.
+func triggerPagerDuty(ctx context.Context, s *FilesystemState, notif *notification) error {
+	if notif.pagerDuty == nil {
+		return nil
+	}
+	event := *notif.pagerDuty
+	event.RoutingKey = s.PagerDutyRoutingKey
+	return sendPagerDuty(ctx, s, &event)
+}
+
+// resolvePagerDuty sends a resolve event for the alert triggered by
+// triggerPagerDuty about the health check failure.
+func resolvePagerDuty(ctx context.Context, s *FilesystemState, ctlog *loglist.Log, info HealthCheckFailure) error {
+	return sendPagerDuty(ctx, s, &pagerDutyEvent{
+		RoutingKey:  s.PagerDutyRoutingKey,
+		EventAction: "resolve",
+		DedupKey:    pagerDutyDedupKey(ctlog, info),
+	})
+}
+
+func sendPagerDuty(ctx context.Context, s *FilesystemState, event *pagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding PagerDuty event: %w", err)
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if err := postWithRetries(ctx, pagerDutyEndpoint(s), body, header); err != nil {
+		return fmt.Errorf("error sending PagerDuty %s event for %s: %w", event.EventAction, event.DedupKey, err)
+	}
+	return nil
+}
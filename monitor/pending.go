@@ -0,0 +1,363 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// How often to retry notifications which could not be delivered
+const retryNotificationsInterval = 5 * time.Minute
+
+// How many times to retry a notification before giving up on it (a day's
+// worth of retries when running as a daemon)
+const maxNotificationAttempts = 288
+
+// Delivery methods, as recorded in PendingNotification.Methods
+const (
+	methodEmail     = "email"
+	methodWebhook   = "webhook"
+	methodSlack     = "slack"
+	methodDiscord   = "discord"
+	methodTeams     = "teams"
+	methodTelegram  = "telegram"
+	methodNtfy      = "ntfy"
+	methodGotify    = "gotify"
+	methodSNS       = "sns"
+	methodMatrix    = "matrix"
+	methodPagerDuty = "pagerduty"
+	methodSyslog    = "syslog"
+	methodScript    = "script"
+	methodScriptDir = "script_dir"
+)
+
+type notificationMethod struct {
+	name       string
+	configured func(*FilesystemState) bool
+	send       func(context.Context, *FilesystemState, *notification) error
+}
+
+// notificationMethods lists the delivery methods in the order in which they
+// are used.  A failure of one method doesn't prevent the others from being
+// used; the notification is queued and retried using just the methods which
+// failed.
+var notificationMethods = []notificationMethod{
+	{methodEmail, func(s *FilesystemState) bool { return len(s.Email) > 0 }, func(ctx context.Context, s *FilesystemState, notif *notification) error {
+		if s.SMTP != nil {
+			return sendEmailSMTP(ctx, s, notif)
+		}
+		return sendEmail(ctx, s, notif)
+	}},
+	{methodWebhook, func(s *FilesystemState) bool { return s.Webhook != "" }, sendWebhook},
+	{methodSlack, func(s *FilesystemState) bool { return s.SlackWebhookURL != "" }, sendSlack},
+	{methodDiscord, func(s *FilesystemState) bool { return s.DiscordWebhookURL != "" }, sendDiscord},
+	{methodTeams, func(s *FilesystemState) bool { return s.TeamsWebhookURL != "" }, sendTeams},
+	{methodTelegram, func(s *FilesystemState) bool { return s.TelegramBotToken != "" }, sendTelegram},
+	{methodNtfy, func(s *FilesystemState) bool { return s.NtfyURL != "" }, sendNtfy},
+	{methodGotify, func(s *FilesystemState) bool { return s.GotifyURL != "" }, sendGotify},
+	{methodSNS, func(s *FilesystemState) bool { return s.SNSTopicARN != "" }, sendSNS},
+	{methodMatrix, func(s *FilesystemState) bool { return s.MatrixHomeserver != "" }, sendMatrix},
+	{methodPagerDuty, func(s *FilesystemState) bool { return s.PagerDutyRoutingKey != "" }, triggerPagerDuty},
+	{methodSyslog, func(s *FilesystemState) bool { return s.Syslog != nil }, func(_ context.Context, s *FilesystemState, notif *notification) error {
+		return sendSyslog(s, notif)
+	}},
+	{methodScript, func(s *FilesystemState) bool { return s.Script != "" }, func(ctx context.Context, s *FilesystemState, notif *notification) error {
+		return s.runScript(ctx, s.Script, notif)
+	}},
+	{methodScriptDir, func(s *FilesystemState) bool { return s.ScriptDir != "" }, func(ctx context.Context, s *FilesystemState, notif *notification) error {
+		return s.runScriptDir(ctx, s.ScriptDir, notif)
+	}},
+}
+
+// PendingNotification is a notification which could not be delivered by
+// some of its delivery methods, and is waiting to be retried.  The
+// certificates which the notification is about are stored along with it, so
+// that the retried notification is formatted the same way as the original.
+type PendingNotification struct {
+	ID        string                     `json:"-"`         // identifies the notification within the queue
+	QueuedAt  time.Time                  `json:"queued_at"` // when delivery first failed
+	Attempts  int                        `json:"attempts"`  // how many times delivery has been retried
+	Methods   []string                   `json:"methods"`   // the delivery methods which failed
+	Summary   string                     `json:"summary"`
+	Environ   []string                   `json:"environ"`
+	Text      string                     `json:"text"`
+	Fields    map[string]json.RawMessage `json:"fields"`              // the notification's JSON fields, as encoded in webhook payloads
+	Cert      *heldCert                  `json:"cert,omitempty"`      // the discovered certificate, if any
+	Digest    []*heldCert                `json:"digest,omitempty"`    // the certificates in the digest, if any
+	PagerDuty *pagerDutyEvent            `json:"pagerduty,omitempty"` // the PagerDuty trigger event, if any
+}
+
+func makePendingNotification(notif *notification, methods []string) (*PendingNotification, error) {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range notif.json {
+		field.AddTo(encoder)
+	}
+	fields := make(map[string]json.RawMessage, len(encoder.Fields))
+	for key, value := range encoder.Fields {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding field %q: %w", key, err)
+		}
+		fields[key] = encoded
+	}
+	pending := &PendingNotification{
+		QueuedAt:  time.Now(),
+		Methods:   methods,
+		Summary:   notif.summary,
+		Environ:   notif.environ,
+		Text:      notif.text,
+		Fields:    fields,
+		PagerDuty: notif.pagerDuty,
+	}
+	if notif.cert != nil {
+		pending.Cert = makeHeldCert(notif.cert)
+	}
+	if notif.digest != nil {
+		pending.Digest = make([]*heldCert, len(notif.digest))
+		for i, cert := range notif.digest {
+			pending.Digest[i] = makeHeldCert(cert)
+		}
+	}
+	return pending, nil
+}
+
+// encodedField is the JSON encoding of a notification field, which is
+// reproduced verbatim when the notification is retried.
+type encodedField json.RawMessage
+
+func (field encodedField) MarshalJSON() ([]byte, error) {
+	return field, nil
+}
+
+func (pending *PendingNotification) notification() (*notification, error) {
+	keys := make([]string, 0, len(pending.Fields))
+	for key := range pending.Fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	fields := make([]zap.Field, len(keys))
+	for i, key := range keys {
+		fields[i] = zap.Reflect(key, encodedField(pending.Fields[key]))
+	}
+	notif := &notification{
+		environ:   pending.Environ,
+		summary:   pending.Summary,
+		text:      pending.Text,
+		json:      fields,
+		pagerDuty: pending.PagerDuty,
+	}
+	if pending.Cert != nil {
+		cert, err := pending.Cert.discoveredCert()
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate: %w", err)
+		}
+		notif.cert = cert
+	}
+	if pending.Digest != nil {
+		notif.digest = make([]*DiscoveredCert, len(pending.Digest))
+		for i, held := range pending.Digest {
+			cert, err := held.discoveredCert()
+			if err != nil {
+				return nil, fmt.Errorf("error loading certificate in digest: %w", err)
+			}
+			notif.digest[i] = cert
+		}
+	}
+	return notif, nil
+}
+
+// deliveryFailures collects the delivery methods which failed to deliver a
+// notification, and their errors.
+type deliveryFailures struct {
+	methods []string
+	errs    []error
+}
+
+func (failures *deliveryFailures) add(method string, err error) {
+	if err != nil {
+		failures.methods = append(failures.methods, method)
+		failures.errs = append(failures.errs, err)
+	}
+}
+
+func (failures *deliveryFailures) err() error {
+	return errors.Join(failures.errs...)
+}
+
+// deliver sends notif using the named delivery method.  It does nothing if
+// the method is no longer configured.
+func (s *FilesystemState) deliver(ctx context.Context, method string, notif *notification) error {
+	for _, m := range notificationMethods {
+		if m.name != method {
+			continue
+		} else if !m.configured(s) {
+			return nil
+		}
+		return m.send(ctx, s, notif)
+	}
+	return fmt.Errorf("unknown notification method %q", method)
+}
+
+func (s *FilesystemState) pendingNotificationsDir() string {
+	return filepath.Join(s.StateDir, "pending_notifications")
+}
+
+func (s *FilesystemState) failedNotificationsDir() string {
+	return filepath.Join(s.StateDir, "failed_notifications")
+}
+
+// abandonPendingNotification moves the file of the queued notification with
+// the given ID to the failed_notifications directory, where it is kept for
+// inspection but no longer retried, and reports reason using NotifyError.
+func (s *FilesystemState) abandonPendingNotification(ctx context.Context, id string, reason error) error {
+	dirPath := s.failedNotificationsDir()
+	if err := os.Mkdir(dirPath, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	failedPath := filepath.Join(dirPath, id+".json")
+	if err := os.Rename(filepath.Join(s.pendingNotificationsDir(), id+".json"), failedPath); err != nil {
+		return err
+	}
+	return s.NotifyError(ctx, nil, fmt.Errorf("%w (giving up on the notification; it was moved to %s)", reason, failedPath))
+}
+
+// enqueueNotification saves notif so that it is returned by
+// LoadPendingNotifications and retried using the given methods.
+func (s *FilesystemState) enqueueNotification(notif *notification, methods []string) error {
+	pending, err := makePendingNotification(notif, methods)
+	if err != nil {
+		return err
+	}
+	pending.ID = fmt.Sprintf("%019d-%s", pending.QueuedAt.UnixNano(), randomFileSuffix())
+	return s.storePendingNotification(pending)
+}
+
+func (s *FilesystemState) storePendingNotification(pending *PendingNotification) error {
+	dirPath := s.pendingNotificationsDir()
+	if err := os.Mkdir(dirPath, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dirPath, pending.ID+".json"), pending, 0666)
+}
+
+func (s *FilesystemState) LoadPendingNotifications(ctx context.Context) ([]*PendingNotification, error) {
+	dirPath := s.pendingNotificationsDir()
+	entries, err := os.ReadDir(dirPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pendings []*PendingNotification
+	for _, entry := range entries {
+		id, isJSON := strings.CutSuffix(entry.Name(), ".json")
+		if strings.HasPrefix(id, ".") || !isJSON {
+			continue
+		}
+		filePath := filepath.Join(dirPath, entry.Name())
+		fileBytes, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		pending := &PendingNotification{ID: id}
+		if err := json.Unmarshal(fileBytes, pending); err != nil {
+			if err := s.abandonPendingNotification(ctx, id, fmt.Errorf("error parsing queued notification %s: %w", filePath, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pendings = append(pendings, pending)
+	}
+	slices.SortStableFunc(pendings, func(a, b *PendingNotification) int { return cmp.Compare(a.ID, b.ID) })
+	return pendings, nil
+}
+
+func (s *FilesystemState) SendPendingNotification(ctx context.Context, pending *PendingNotification) error {
+	notif, err := pending.notification()
+	if err != nil {
+		return s.abandonPendingNotification(ctx, pending.ID, fmt.Errorf("error loading queued notification %q (queued at %s): %w", pending.Summary, pending.QueuedAt.Format(time.RFC3339), err))
+	}
+	var failures deliveryFailures
+	for _, method := range pending.Methods {
+		failures.add(method, s.deliver(ctx, method, notif))
+	}
+	filePath := filepath.Join(s.pendingNotificationsDir(), pending.ID+".json")
+	if len(failures.methods) == 0 {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	// Don't deliver the notification again using the methods that succeeded
+	pending.Methods = failures.methods
+	pending.Attempts++
+	if err := s.storePendingNotification(pending); err != nil {
+		return err
+	}
+	err = fmt.Errorf("error retrying notification %q (queued at %s): %w", pending.Summary, pending.QueuedAt.Format(time.RFC3339), failures.err())
+	if pending.Attempts >= maxNotificationAttempts {
+		return s.abandonPendingNotification(ctx, pending.ID, fmt.Errorf("%w (after %d attempts)", err, pending.Attempts))
+	}
+	return classifyError(err, ErrNotification)
+}
+
+// retryPendingNotifications tries again to deliver every notification which
+// previously failed, oldest first.  A notification which still can't be
+// delivered doesn't stop the later ones from being retried.  The returned
+// error counts the failures by delivery method.
+func retryPendingNotifications(ctx context.Context, config *Config) error {
+	pendings, err := loadPendingNotifications(ctx, config.State)
+	if err != nil {
+		return fmt.Errorf("error loading pending notifications: %w", err)
+	}
+	var (
+		numFailed int
+		lastErr   error
+		byMethod  = make(map[string]int)
+	)
+	for _, pending := range pendings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := sendPendingNotification(ctx, config.State, pending); err != nil {
+			numFailed++
+			lastErr = err
+			for _, method := range pending.Methods {
+				byMethod[method]++
+			}
+			continue
+		}
+		if config.Verbose {
+			zap.S().Infof("finished retrying notification %q which was queued at %s", pending.Summary, pending.QueuedAt.Format(time.RFC3339))
+		}
+	}
+	if numFailed == 0 {
+		return nil
+	}
+	counts := make([]string, 0, len(byMethod))
+	for method, count := range byMethod {
+		counts = append(counts, fmt.Sprintf("%s: %d", method, count))
+	}
+	slices.Sort(counts)
+	return fmt.Errorf("%d of %d queued notifications could not be delivered (failures by method: %s); the last error was: %w", numFailed, len(pendings), strings.Join(counts, ", "), lastErr)
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+//go:build !windows
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestFilesystemStatePendingNotifications(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var (
+		okPath     = filepath.Join(dir, "ok")
+		outPath    = filepath.Join(dir, "out")
+		scriptPath = filepath.Join(dir, "script")
+	)
+	script := "#!/bin/sh\n[ -e " + okPath + " ] || exit 1\necho \"$SUMMARY $COUNT\" >> " + outPath + "\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	state := &FilesystemState{StateDir: filepath.Join(dir, "state"), Script: scriptPath}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	notif := &notification{
+		summary: "test notification",
+		environ: []string{"EVENT=test", "SUMMARY=test notification", "COUNT=3"},
+		text:    "test",
+		json:    []zap.Field{zap.Int("count", 3)},
+	}
+	if err := state.notify(ctx, notif); err != nil {
+		t.Fatalf("notify returned error instead of queueing the notification: %s", err)
+	}
+
+	// A new FilesystemState must load the queue from disk
+	state = &FilesystemState{StateDir: state.StateDir, Script: scriptPath}
+	pendings, err := state.LoadPendingNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 {
+		t.Fatalf("%d notifications are pending; expected 1", len(pendings))
+	}
+	pending := pendings[0]
+	if pending.Summary != notif.summary || len(pending.Methods) != 1 || pending.Methods[0] != methodScript {
+		t.Fatalf("pending notification is %#v", pending)
+	}
+
+	if err := state.SendPendingNotification(ctx, pending); err == nil {
+		t.Fatal("SendPendingNotification succeeded even though the script still fails")
+	}
+	if pendings, err := state.LoadPendingNotifications(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 {
+		t.Fatalf("%d notifications are pending after a failed retry; expected 1", len(pendings))
+	}
+
+	if err := os.WriteFile(okPath, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := retryPendingNotifications(ctx, &Config{State: state}); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := os.ReadFile(outPath); err != nil {
+		t.Fatal(err)
+	} else if string(out) != "test notification 3\n" {
+		t.Errorf("script output is %q", out)
+	}
+	if pendings, err := state.LoadPendingNotifications(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 0 {
+		t.Errorf("%d notifications are still pending after a successful retry", len(pendings))
+	}
+}
+
+func TestRetryPendingNotificationsSkipsFailures(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var (
+		okPath     = filepath.Join(dir, "ok")
+		outPath    = filepath.Join(dir, "out")
+		scriptPath = filepath.Join(dir, "script")
+	)
+	script := "#!/bin/sh\n[ -e " + okPath + " ] && [ \"$SUMMARY\" != bad ] || exit 1\necho \"$SUMMARY\" >> " + outPath + "\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	state := &FilesystemState{StateDir: filepath.Join(dir, "state"), Script: scriptPath}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, summary := range []string{"bad", "good"} {
+		notif := &notification{summary: summary, environ: []string{"SUMMARY=" + summary}}
+		if err := state.notify(ctx, notif); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A corrupt file, which sorts first, must not stop the others from being retried
+	corruptPath := filepath.Join(state.pendingNotificationsDir(), "0-corrupt.json")
+	if err := os.WriteFile(corruptPath, []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(okPath, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	err := retryPendingNotifications(ctx, &Config{State: state})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 queued notifications could not be delivered (failures by method: script: 1)") {
+		t.Errorf("retryPendingNotifications returned %v", err)
+	}
+	if out, err := os.ReadFile(outPath); err != nil {
+		t.Fatal(err)
+	} else if string(out) != "good\n" {
+		t.Errorf("script output is %q; expected the notification after the failing one to be delivered", out)
+	}
+	if _, err := os.Stat(filepath.Join(state.failedNotificationsDir(), "0-corrupt.json")); err != nil {
+		t.Errorf("corrupt notification was not moved aside: %s", err)
+	}
+
+	pendings, err := state.LoadPendingNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 1 || pendings[0].Summary != "bad" || pendings[0].Attempts != 1 {
+		t.Fatalf("pending notifications are %v; expected just the failing one, after 1 attempt", pendings)
+	}
+	pendings[0].Attempts = maxNotificationAttempts - 1
+	if err := state.SendPendingNotification(ctx, pendings[0]); err != nil {
+		t.Errorf("SendPendingNotification returned %v after giving up on the notification", err)
+	}
+	if pendings, err := state.LoadPendingNotifications(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(pendings) != 0 {
+		t.Errorf("%d notifications are still pending after the attempt limit", len(pendings))
+	}
+	if _, err := os.Stat(filepath.Join(state.failedNotificationsDir(), pendings[0].ID+".json")); err != nil {
+		t.Errorf("notification was not moved aside after the attempt limit: %s", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// checkPrecert checks that a precertificate is valid, and that a certificate
+// with embedded SCTs corresponds to a previously-seen precertificate (i.e. one
+// with the same TBSCertificate, once the SCT list and poison extensions are
+// removed), notifying about any inconsistencies.  Since only certificates
+// matching the watch list are tracked, and the precertificate may be logged
+// in a log which certspotter does not monitor (or have processed yet), the
+// second check is advisory.
+func checkPrecert(ctx context.Context, config *Config, cert *DiscoveredCert) error {
+	store, hasStore := optionalState[precertTBSStore](config.State)
+	if cert.IsPrecert {
+		if cert.PrecertError != nil {
+			if err := notifyPrecertAnomaly(ctx, config.State, cert, cert.PrecertError.Error()); err != nil {
+				return fmt.Errorf("error notifying about precertificate anomaly in %x: %w", cert.SHA256, err)
+			}
+		}
+		if hasStore {
+			if err := store.StorePrecertTBS(ctx, cert.TBSSHA256); err != nil {
+				return fmt.Errorf("error storing precertificate TBS %x: %w", cert.TBSSHA256, err)
+			}
+		}
+		return nil
+	}
+
+	if len(cert.EmbeddedSCTs) == 0 || !hasStore {
+		return nil
+	}
+	seen, err := store.HasPrecertTBS(ctx, cert.TBSSHA256)
+	if err != nil {
+		return fmt.Errorf("error loading precertificate TBS %x: %w", cert.TBSSHA256, err)
+	}
+	if !seen {
+		anomaly := fmt.Sprintf("certificate contains %d embedded SCTs, but no precertificate with a corresponding TBSCertificate (%x) has been seen", len(cert.EmbeddedSCTs), cert.TBSSHA256)
+		if err := notifyPrecertAnomaly(ctx, config.State, cert, anomaly); err != nil {
+			return fmt.Errorf("error notifying about precertificate anomaly in %x: %w", cert.SHA256, err)
+		}
+	}
+	return nil
+}
+
+func precertsPath(stateDir string, hexHash string) string {
+	return filepath.Join(stateDir, "precerts", hexHash[0:2], hexHash)
+}
+
+func notifiedTBSPath(stateDir string, hexHash string) string {
+	return filepath.Join(stateDir, "tbs", hexHash[0:2], hexHash+".notified")
+}
+
+func precertAnomalySummary(cert *DiscoveredCert) string {
+	return fmt.Sprintf("Precertificate Anomaly for %s", cert.WatchItem)
+}
+
+func precertAnomalyText(cert *DiscoveredCert, anomaly string) string {
+	text := new(strings.Builder)
+	writeField := func(name string, value any) { fmt.Fprintf(text, "\t%13s = %s\n", name, value) }
+
+	if cert.IsPrecert {
+		fmt.Fprintf(text, "A precertificate for %s does not satisfy the requirements of RFC 6962.\n", cert.WatchItem)
+	} else {
+		fmt.Fprintf(text, "A certificate for %s may not correspond to a precertificate, as required by RFC 6962.\n", cert.WatchItem)
+	}
+	fmt.Fprintf(text, "\n")
+	writeField("Anomaly", anomaly)
+	writeField("Certificate", hex.EncodeToString(cert.SHA256[:]))
+	writeField("TBS", hex.EncodeToString(cert.TBSSHA256[:]))
+	writeField("Log Entry", fmt.Sprintf("%d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL))
+	writeField("crt.sh", "https://crt.sh/?sha256="+hex.EncodeToString(cert.SHA256[:]))
+	return text.String()
+}
+
+func precertAnomalyJson(cert *DiscoveredCert, anomaly string) []zap.Field {
+	return []zap.Field{
+		zap.String("event", "precert_anomaly"),
+		zap.String("anomaly", anomaly),
+		zap.Bool("precert", cert.IsPrecert),
+		zap.String("sha256", hex.EncodeToString(cert.SHA256[:])),
+		zap.String("tbsSha256", hex.EncodeToString(cert.TBSSHA256[:])),
+		zap.Strings("dnsNames", cert.Identifiers.DNSNames),
+	}
+}
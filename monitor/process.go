@@ -14,7 +14,10 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"slices"
+	"time"
 
+	"go.uber.org/zap"
 	"software.sslmate.com/src/certspotter"
 	"software.sslmate.com/src/certspotter/ct"
 	"software.sslmate.com/src/certspotter/loglist"
@@ -27,6 +30,11 @@ type LogEntry struct {
 	LeafInput []byte
 	ExtraData []byte
 	LeafHash  merkletree.Hash
+
+	// Used to verify the entry's inclusion proof; only set if
+	// Config.VerifyInclusion is set
+	client logClient
+	sth    *ct.SignedTreeHead
 }
 
 func processLogEntry(ctx context.Context, config *Config, entry *LogEntry) error {
@@ -34,17 +42,18 @@ func processLogEntry(ctx context.Context, config *Config, entry *LogEntry) error
 	if err != nil {
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("error parsing Merkle Tree Leaf: %w", err))
 	}
+	timestamp := time.UnixMilli(int64(leaf.TimestampedEntry.Timestamp)).UTC()
 	switch leaf.TimestampedEntry.EntryType {
 	case ct.X509LogEntryType:
-		return processX509LogEntry(ctx, config, entry, leaf.TimestampedEntry.X509Entry)
+		return processX509LogEntry(ctx, config, entry, timestamp, leaf.TimestampedEntry.X509Entry)
 	case ct.PrecertLogEntryType:
-		return processPrecertLogEntry(ctx, config, entry, leaf.TimestampedEntry.PrecertEntry)
+		return processPrecertLogEntry(ctx, config, entry, timestamp, leaf.TimestampedEntry.PrecertEntry)
 	default:
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("unknown log entry type %d", leaf.TimestampedEntry.EntryType))
 	}
 }
 
-func processX509LogEntry(ctx context.Context, config *Config, entry *LogEntry, cert ct.ASN1Cert) error {
+func processX509LogEntry(ctx context.Context, config *Config, entry *LogEntry, timestamp time.Time, cert ct.ASN1Cert) error {
 	certInfo, err := certspotter.MakeCertInfoFromRawCert(cert)
 	if err != nil {
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("error parsing X.509 certificate: %w", err))
@@ -56,16 +65,25 @@ func processX509LogEntry(ctx context.Context, config *Config, entry *LogEntry, c
 	}
 	chain = append([]ct.ASN1Cert{cert}, chain...)
 
+	embeddedSCTs, embeddedSCTsErr := certInfo.TBS.ParseSCTList()
+
 	if precertTBS, err := certspotter.ReconstructPrecertTBS(certInfo.TBS); err == nil {
 		certInfo.TBS = precertTBS
 	} else {
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("error reconstructing precertificate TBSCertificate: %w", err))
 	}
 
-	return processCertificate(ctx, config, entry, certInfo, chain)
+	return processCertificate(ctx, config, &DiscoveredCert{
+		LogEntry:               entry,
+		SCTTimestamp:           timestamp,
+		Info:                   certInfo,
+		Chain:                  chain,
+		EmbeddedSCTs:           embeddedSCTs,
+		EmbeddedSCTsParseError: embeddedSCTsErr,
+	})
 }
 
-func processPrecertLogEntry(ctx context.Context, config *Config, entry *LogEntry, precert ct.PreCert) error {
+func processPrecertLogEntry(ctx context.Context, config *Config, entry *LogEntry, timestamp time.Time, precert ct.PreCert) error {
 	certInfo, err := certspotter.MakeCertInfoFromRawTBS(precert.TBSCertificate)
 	if err != nil {
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("error parsing precert TBSCertificate: %w", err))
@@ -76,38 +94,179 @@ func processPrecertLogEntry(ctx context.Context, config *Config, entry *LogEntry
 		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("error parsing extra_data for precert entry: %w", err))
 	}
 
+	var precertErr error
 	if _, err := certspotter.ValidatePrecert(chain[0], precert.TBSCertificate); err != nil {
-		return processMalformedLogEntry(ctx, config, entry, fmt.Errorf("precertificate in extra_data does not match TBSCertificate in leaf_input: %w", err))
+		precertErr = fmt.Errorf("precertificate in extra_data does not match TBSCertificate in leaf_input: %w", err)
+		if !config.CheckPrecerts {
+			return processMalformedLogEntry(ctx, config, entry, precertErr)
+		}
 	}
 
-	return processCertificate(ctx, config, entry, certInfo, chain)
+	return processCertificate(ctx, config, &DiscoveredCert{
+		LogEntry:     entry,
+		SCTTimestamp: timestamp,
+		Info:         certInfo,
+		Chain:        chain,
+		IsPrecert:    true,
+		PrecertError: precertErr,
+	})
 }
 
-func processCertificate(ctx context.Context, config *Config, entry *LogEntry, certInfo *certspotter.CertInfo, chain []ct.ASN1Cert) error {
-	identifiers, err := certInfo.ParseIdentifiers()
+// processCertificate matches the certificate against the watch list and
+// notifies about it if it matches.  cert must contain the log entry, info,
+// chain, and the fields which depend on the type of entry; processCertificate
+// fills in the rest.
+func processCertificate(ctx context.Context, config *Config, cert *DiscoveredCert) error {
+	sanIdentifiers, err := cert.Info.ParseSANIdentifiers()
 	if err != nil {
-		return processMalformedLogEntry(ctx, config, entry, err)
+		return processMalformedLogEntry(ctx, config, cert.LogEntry, err)
 	}
-	matched, watchItem := config.WatchList.Matches(identifiers)
+	identifiers := sanIdentifiers
+	if config.MatchCommonName {
+		identifiers, err = cert.Info.ParseIdentifiers()
+		if err != nil {
+			return processMalformedLogEntry(ctx, config, cert.LogEntry, err)
+		}
+	}
+	matched, watchItem, matchedIdentifier := config.WatchList.Match(identifiers, cert.Info)
 	if !matched {
+		if cert.PrecertError != nil {
+			return processMalformedLogEntry(ctx, config, cert.LogEntry, cert.PrecertError)
+		}
 		return nil
 	}
 
-	cert := &DiscoveredCert{
-		WatchItem:    watchItem,
-		LogEntry:     entry,
-		Info:         certInfo,
-		Chain:        chain,
-		TBSSHA256:    sha256.Sum256(certInfo.TBS.Raw),
-		SHA256:       sha256.Sum256(chain[0]),
-		PubkeySHA256: sha256.Sum256(certInfo.TBS.PublicKey.FullBytes),
-		Identifiers:  identifiers,
+	cert.ObservedAt = time.Now().UTC()
+	cert.WatchItem = watchItem
+	cert.MatchedIdentifier = matchedIdentifier
+	cert.MatchSource = matchSource(sanIdentifiers, matchedIdentifier)
+	cert.TBSSHA256 = sha256.Sum256(cert.Info.TBS.Raw)
+	cert.SHA256 = sha256.Sum256(cert.Chain[0])
+	cert.PubkeySHA256 = sha256.Sum256(cert.Info.TBS.PublicKey.FullBytes)
+	cert.Identifiers = identifiers
+
+	if config.MinSCTs > 0 && !cert.IsPrecert {
+		cert.InsufficientSCTs = len(cert.EmbeddedSCTs) < config.MinSCTs
+	}
+	if config.MaxValidityDays > 0 && cert.Info.ValidityParseError == nil {
+		cert.OverlongValidity = exceedsMaxValidity(cert.Info.Validity, config.MaxValidityDays)
+	}
+	if config.CheckAnomalies {
+		cert.Anomalies = cert.Info.Anomalies()
+	}
+	if config.MatchFilter != nil && !config.MatchFilter(cert) {
+		if config.Verbose {
+			zap.S().Debugf("ignoring %x (entry %d in %s) because it was rejected by the match filter", cert.SHA256, cert.LogEntry.Index, cert.LogEntry.Log.URL)
+		}
+		if cert.PrecertError != nil {
+			return processMalformedLogEntry(ctx, config, cert.LogEntry, cert.PrecertError)
+		}
+		return nil
+	}
+	if config.VerifyInclusion && cert.LogEntry.sth != nil {
+		if err := verifyInclusion(ctx, cert.LogEntry); err != errInclusionUnsupported {
+			cert.InclusionTreeSize = cert.LogEntry.sth.TreeSize
+			cert.InclusionError = err
+		}
+		if cert.InclusionError != nil {
+			recordError(ctx, config, cert.LogEntry.Log, fmt.Errorf("error verifying inclusion of entry %d: %w", cert.LogEntry.Index, cert.InclusionError))
+		}
+	}
+
+	if err := notifyCert(ctx, config, cert); err != nil {
+		return err
+	}
+
+	if config.TrackKeyReuse {
+		if err := checkKeyReuse(ctx, config, cert); err != nil {
+			return err
+		}
+	}
+
+	if config.CheckPrecerts {
+		if err := checkPrecert(ctx, config, cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchSource returns where the matched identifier was found: MatchSourceSAN
+// if it is one of sanIdentifiers, and MatchSourceCN otherwise.  It returns
+// "" if no identifier matched.
+func matchSource(sanIdentifiers *certspotter.Identifiers, matchedIdentifier string) string {
+	if matchedIdentifier == "" {
+		return ""
+	}
+	if slices.Contains(sanIdentifiers.DNSNames, matchedIdentifier) {
+		return MatchSourceSAN
+	}
+	for _, ipaddr := range sanIdentifiers.IPAddrs {
+		if ipaddr.String() == matchedIdentifier {
+			return MatchSourceSAN
+		}
+	}
+	return MatchSourceCN
+}
+
+// notifyCert notifies about cert, unless Config.DedupePrecerts is set and a
+// precertificate or certificate with the same TBSCertificate (e.g. the
+// precertificate corresponding to a final certificate, or vice-versa) has
+// already been notified about.  Since logs are processed concurrently, a
+// pair seen at the same time in different logs might still produce two
+// notifications.  DNS names are resolved (if Config.ResolveDNS is set) only
+// once cert is known to need a notification, since lookups are slow.
+func notifyCert(ctx context.Context, config *Config, cert *DiscoveredCert) error {
+	logID, leafHash := cert.LogEntry.Log.LogID, cert.LogEntry.LeafHash
+	leafStore, hasLeafStore := optionalState[notifiedLeafStore](config.State)
+	if hasLeafStore {
+		if notified, err := leafStore.WasNotified(ctx, logID, leafHash); err != nil {
+			return fmt.Errorf("error checking if entry %d was notified about: %w", cert.LogEntry.Index, err)
+		} else if notified {
+			if config.Verbose {
+				zap.S().Debugf("not notifying about %x because entry %d in %s was already notified about", cert.SHA256, cert.LogEntry.Index, cert.LogEntry.Log.URL)
+			}
+			return nil
+		}
+	}
+
+	var tbsStore notifiedTBSStore
+	if config.DedupePrecerts {
+		tbsStore, _ = optionalState[notifiedTBSStore](config.State)
+	}
+	if tbsStore != nil {
+		notified, err := tbsStore.HasNotifiedTBS(ctx, cert.TBSSHA256)
+		if err != nil {
+			return fmt.Errorf("error loading notified TBS %x: %w", cert.TBSSHA256, err)
+		}
+		if notified {
+			if config.Verbose {
+				zap.S().Debugf("not notifying about %x because a certificate with the same TBSCertificate (%x) was already notified about", cert.SHA256, cert.TBSSHA256)
+			}
+			return nil
+		}
+	}
+
+	if config.ResolveDNS {
+		cert.DNSResolutions = resolveDNSNames(ctx, cert.Identifiers.DNSNames, config.ResolveTimeout, config.ResolveCNAME)
 	}
 
 	if err := config.State.NotifyCert(ctx, cert); err != nil {
 		return fmt.Errorf("error notifying about certificate %x: %w", cert.SHA256, err)
 	}
 
+	if hasLeafStore {
+		if err := leafStore.MarkNotified(ctx, logID, leafHash); err != nil {
+			return fmt.Errorf("error marking entry %d as notified: %w", cert.LogEntry.Index, err)
+		}
+	}
+
+	if tbsStore != nil {
+		if err := tbsStore.StoreNotifiedTBS(ctx, cert.TBSSHA256); err != nil {
+			return fmt.Errorf("error storing notified TBS %x: %w", cert.TBSSHA256, err)
+		}
+	}
 	return nil
 }
 
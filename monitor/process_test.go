@@ -0,0 +1,197 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+func makeTestCert(t *testing.T, commonName string, dnsNames ...string) *DiscoveredCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := certspotter.MakeCertInfoFromRawCert(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DiscoveredCert{
+		LogEntry: &LogEntry{Log: &loglist.Log{URL: "https://log.example/"}},
+		Info:     info,
+		Chain:    []ct.ASN1Cert{der},
+	}
+}
+
+func TestProcessCertificateMatchCommonName(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		commonName      string
+		dnsNames        []string
+		matchCommonName bool
+		wantMatch       bool
+		wantSource      string
+	}{
+		{"www.example.com", nil, true, true, MatchSourceCN},
+		{"www.example.com", nil, false, false, ""},
+		{"www.example.com", []string{"www.example.com"}, true, true, MatchSourceSAN},
+		{"www.example.com", []string{"www.example.com"}, false, true, MatchSourceSAN},
+		{"other.example.net", []string{"www.example.com"}, true, true, MatchSourceSAN},
+		{"Example Inc.", nil, true, false, ""},
+	} {
+		var notified *DiscoveredCert
+		config := &Config{
+			WatchList:       mustReadWatchList(t, ".example.com"),
+			MatchCommonName: test.matchCommonName,
+			State:           &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = cert }},
+		}
+		if err := processCertificate(ctx, config, makeTestCert(t, test.commonName, test.dnsNames...)); err != nil {
+			t.Fatalf("%q %q %v: processCertificate returned error: %s", test.commonName, test.dnsNames, test.matchCommonName, err)
+		}
+		if !test.wantMatch {
+			if notified != nil {
+				t.Errorf("%q %q %v: certificate unexpectedly matched %q", test.commonName, test.dnsNames, test.matchCommonName, notified.MatchedIdentifier)
+			}
+			continue
+		}
+		if notified == nil {
+			t.Errorf("%q %q %v: certificate didn't match", test.commonName, test.dnsNames, test.matchCommonName)
+		} else if notified.MatchedIdentifier != "www.example.com" || notified.MatchSource != test.wantSource {
+			t.Errorf("%q %q %v: matched %q in %q; want www.example.com in %q", test.commonName, test.dnsNames, test.matchCommonName, notified.MatchedIdentifier, notified.MatchSource, test.wantSource)
+		}
+	}
+}
+
+func TestProcessCertificateMatchFilter(t *testing.T) {
+	ctx := context.Background()
+	for _, accept := range []bool{false, true} {
+		var filtered, notified *DiscoveredCert
+		config := &Config{
+			WatchList: mustReadWatchList(t, ".example.com"),
+			MatchFilter: func(cert *DiscoveredCert) bool {
+				filtered = cert
+				return accept
+			},
+			State: &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = cert }},
+		}
+		if err := processCertificate(ctx, config, makeTestCert(t, "", "www.example.com")); err != nil {
+			t.Fatalf("processCertificate returned error: %s", err)
+		}
+		if filtered == nil {
+			t.Fatalf("filter wasn't called")
+		} else if filtered.WatchItem.String() != ".example.com" || filtered.Identifiers == nil {
+			t.Errorf("filter was called before the certificate's fields were filled in: %#v", filtered)
+		}
+		if accept && notified == nil {
+			t.Errorf("certificate accepted by the filter wasn't notified about")
+		} else if !accept && notified != nil {
+			t.Errorf("certificate rejected by the filter was notified about")
+		}
+	}
+
+	config := &Config{
+		WatchList: mustReadWatchList(t, ".example.com"),
+		MatchFilter: func(cert *DiscoveredCert) bool {
+			t.Errorf("filter was called for a certificate which doesn't match the watch list")
+			return true
+		},
+		State: &MemoryState{},
+	}
+	if err := processCertificate(ctx, config, makeTestCert(t, "", "www.example.net")); err != nil {
+		t.Fatalf("processCertificate returned error: %s", err)
+	}
+}
+
+func TestProcessCertificateResolveDNSAfterDedup(t *testing.T) {
+	ctx := context.Background()
+	var notified []*DiscoveredCert
+	config := &Config{
+		WatchList:      mustReadWatchList(t, ".example.com"),
+		ResolveDNS:     true,
+		ResolveTimeout: time.Nanosecond,
+		State:          &MemoryState{OnCert: func(cert *DiscoveredCert) { notified = append(notified, cert) }},
+	}
+	first := makeTestCert(t, "", "www.example.com", "*.example.com")
+	if err := processCertificate(ctx, config, first); err != nil {
+		t.Fatalf("processCertificate returned error: %s", err)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("certificate was notified about %d times; expected 1", len(notified))
+	} else if len(first.DNSResolutions) != 1 || first.DNSResolutions[0].Name != "www.example.com" || first.DNSResolutions[0].Error == "" {
+		t.Errorf("DNS resolutions are %v; expected a failed lookup of www.example.com", first.DNSResolutions)
+	}
+
+	// The same log entry must not be resolved again
+	second := makeTestCert(t, "", "www.example.com", "*.example.com")
+	if err := processCertificate(ctx, config, second); err != nil {
+		t.Fatalf("processCertificate returned error: %s", err)
+	}
+	if len(notified) != 1 {
+		t.Errorf("certificate was notified about %d times; expected 1", len(notified))
+	}
+	if second.DNSResolutions != nil {
+		t.Errorf("DNS names of an already-notified certificate were resolved")
+	}
+}
+
+func TestProcessCertificateNotifiedLeafStore(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name          string
+		wrap          func(StateProvider) StateProvider
+		notifications int
+	}{
+		{"found through a wrapper", func(state StateProvider) StateProvider {
+			return &metricsState{StateProvider: state, metrics: NewMetrics()}
+		}, 1},
+		// Embedding hides every method not in StateProvider, so
+		// the same log entry is notified about twice
+		{"not implemented", func(state StateProvider) StateProvider {
+			return struct{ StateProvider }{state}
+		}, 2},
+	} {
+		var notified int
+		config := &Config{
+			WatchList: mustReadWatchList(t, ".example.com"),
+			State:     test.wrap(&MemoryState{OnCert: func(*DiscoveredCert) { notified++ }}),
+		}
+		for i := 0; i < 2; i++ {
+			if err := processCertificate(ctx, config, makeTestCert(t, "", "www.example.com")); err != nil {
+				t.Fatalf("%s: processCertificate returned error: %s", test.name, err)
+			}
+		}
+		if notified != test.notifications {
+			t.Errorf("%s: certificate was notified about %d times; expected %d", test.name, notified, test.notifications)
+		}
+	}
+}
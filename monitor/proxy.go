@@ -0,0 +1,147 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// NotificationProxy, if non-nil, is the http://, https://, or socks5://
+// proxy through which notifications (webhooks, chat messages, email sent
+// via SMTP, and health check pings) are sent.  If nil, HTTP requests use the
+// proxy specified by the environment (e.g. $HTTPS_PROXY), and SMTP
+// connections are made directly.  It must be set before notifications are
+// sent.
+var NotificationProxy *url.URL
+
+func notificationProxyFunc(request *http.Request) (*url.URL, error) {
+	if NotificationProxy != nil {
+		return NotificationProxy, nil
+	}
+	return http.ProxyFromEnvironment(request)
+}
+
+// newNotificationTransport returns an http.Transport like
+// http.DefaultTransport which uses NotificationProxy.
+func newNotificationTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = notificationProxyFunc
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// dialNotification opens a TCP connection to address, through
+// NotificationProxy if it is set.
+func dialNotification(ctx context.Context, address string) (net.Conn, error) {
+	if NotificationProxy == nil {
+		return new(net.Dialer).DialContext(ctx, "tcp", address)
+	}
+	switch NotificationProxy.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(NotificationProxy, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("SOCKS5 dialer does not support contexts")
+		}
+		return contextDialer.DialContext(ctx, "tcp", address)
+	case "http", "https":
+		return dialHTTPConnect(ctx, NotificationProxy, address)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", NotificationProxy.Scheme)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to address through the HTTP(S) proxy at
+// proxyURL using the CONNECT method.
+func dialHTTPConnect(ctx context.Context, proxyURL *url.URL, address string) (net.Conn, error) {
+	proxyAddress := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddress = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddress = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+	var conn net.Conn
+	var err error
+	if proxyURL.Scheme == "https" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: proxyURL.Hostname()}}
+		conn, err = dialer.DialContext(ctx, "tcp", proxyAddress)
+	} else {
+		conn, err = new(net.Dialer).DialContext(ctx, "tcp", proxyAddress)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to proxy: %w", err)
+	}
+
+	// Abort the CONNECT request if the context is canceled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	reader, err := sendHTTPConnect(conn, proxyURL, address)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+func sendHTTPConnect(conn net.Conn, proxyURL *url.URL, address string) (*bufio.Reader, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, fmt.Errorf("error sending CONNECT request to proxy: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CONNECT response from proxy: %w", err)
+	}
+	// The response body is not closed, since it would be read until the
+	// tunnel is closed
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", address, response.Status)
+	}
+	return reader, nil
+}
+
+// bufferedConn is a net.Conn which reads through a bufio.Reader, which may
+// contain data the server sent right after the proxy's CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
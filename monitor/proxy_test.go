@@ -0,0 +1,134 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func setNotificationProxy(t *testing.T, proxyURL string) {
+	t.Helper()
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NotificationProxy = parsed
+	t.Cleanup(func() { NotificationProxy = nil })
+}
+
+func TestNotificationProxyHTTP(t *testing.T) {
+	proxy, requests := newRecordingServer(t, http.StatusOK)
+	setNotificationProxy(t, proxy.URL)
+
+	if err := postWithRetries(context.Background(), "http://webhook.example/hook", []byte("{}"), nil); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	if request.url.Host != "webhook.example" || request.url.Path != "/hook" {
+		t.Errorf("proxy received request for %s; expected http://webhook.example/hook", request.url)
+	}
+}
+
+// serveSMTPOverConnect accepts one CONNECT request on listener, and then acts
+// as the SMTP server at the other end of the tunnel, sending the CONNECT
+// request and the message it receives to the returned channels.
+func serveSMTPOverConnect(t *testing.T, listener net.Listener) (<-chan *http.Request, <-chan string) {
+	connects := make(chan *http.Request, 1)
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			t.Errorf("error reading CONNECT request: %s", err)
+			return
+		}
+		connects <- request
+		// The greeting is sent along with the CONNECT response, so the
+		// client must not lose data buffered while reading the response
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n220 smtp.example ESMTP\r\n")
+
+		text := textproto.NewConn(&bufferedConn{Conn: conn, reader: reader})
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command, _, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(command) {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 smtp.example")
+			case "MAIL", "RCPT":
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				lines, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				messages <- strings.Join(lines, "\n")
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("502 Unrecognized command")
+			}
+		}
+	}()
+	return connects, messages
+}
+
+func TestNotificationProxySMTP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connects, messages := serveSMTPOverConnect(t, listener)
+	setNotificationProxy(t, "http://user:secret@"+listener.Addr().String())
+
+	config := &SMTPConfig{Host: "smtp.example", Port: "587"}
+	if err := sendSMTPMessage(context.Background(), config, "from@example.com", []string{"to@example.com"}, []byte("Subject: test\r\n\r\nhello\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := <-connects
+	if connect.Method != http.MethodConnect || connect.Host != "smtp.example:587" {
+		t.Errorf("proxy received %s %s; expected CONNECT smtp.example:587", connect.Method, connect.Host)
+	}
+	if username, password, ok := (&http.Request{Header: http.Header{"Authorization": connect.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || username != "user" || password != "secret" {
+		t.Errorf("proxy received credentials %q", connect.Header.Get("Proxy-Authorization"))
+	}
+	if message := <-messages; message != "Subject: test\n\nhello" {
+		t.Errorf("SMTP server received message %q", message)
+	}
+}
+
+func TestNotificationProxyConnectRefused(t *testing.T) {
+	proxy, _ := newRecordingServer(t, http.StatusForbidden)
+	setNotificationProxy(t, proxy.URL)
+
+	if _, err := dialNotification(context.Background(), "smtp.example:587"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("dialNotification returned %v; expected the proxy to refuse the CONNECT", err)
+	}
+}
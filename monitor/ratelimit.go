@@ -0,0 +1,62 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"net/url"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"software.sslmate.com/src/certspotter/ct/client"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+type rateLimiterKey struct {
+	host string
+	rate float64
+}
+
+// rateLimiters contains the rate limiter for each log host, so that logs which
+// share a host (as is common for logs sharded by year) share a limit, and so
+// that the limit carries over when a log's client is recreated.
+var rateLimiters = struct {
+	mu     sync.Mutex
+	byHost map[rateLimiterKey]*rate.Limiter
+}{byHost: make(map[rateLimiterKey]*rate.Limiter)}
+
+// rateLimit returns the maximum number of requests per second to send to
+// the log, or zero if unlimited.
+func (config *Config) rateLimit(ctlog *loglist.Log) float64 {
+	if rate, ok := config.OperatorRateLimits[ctlog.OperatorName]; ok {
+		return rate
+	}
+	return config.RateLimit
+}
+
+// getRateLimiter returns the rate limiter shared by every log on the host
+// of logURL, or nil if requests to the log are unlimited.
+func getRateLimiter(config *Config, ctlog *loglist.Log, logURL string) *rate.Limiter {
+	limit := config.rateLimit(ctlog)
+	if limit <= 0 {
+		return nil
+	}
+	key := rateLimiterKey{host: logURL, rate: limit}
+	if parsed, err := url.Parse(logURL); err == nil {
+		key.host = parsed.Host
+	}
+	rateLimiters.mu.Lock()
+	defer rateLimiters.mu.Unlock()
+	limiter, ok := rateLimiters.byHost[key]
+	if !ok {
+		limiter = client.NewRateLimiter(limit)
+		rateLimiters.byHost[key] = limiter
+	}
+	return limiter
+}
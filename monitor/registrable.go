@@ -0,0 +1,102 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// registrableDomain returns the registrable domain (the public suffix plus
+// one label, e.g. example.co.uk) of dnsName, ignoring any wildcard label.  If
+// dnsName is itself a public suffix, it is returned unchanged.
+func registrableDomain(dnsName string) string {
+	dnsName = strings.TrimPrefix(dnsName, "*.")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(dnsName)
+	if err != nil {
+		return dnsName
+	}
+	return domain
+}
+
+// registrableDomains returns the number of DNS names under each registrable domain.
+func registrableDomains(dnsNames []string) map[string]int {
+	domains := make(map[string]int)
+	for _, dnsName := range dnsNames {
+		domains[registrableDomain(dnsName)]++
+	}
+	return domains
+}
+
+// registrableDomainStrings returns "DOMAIN (COUNT)" for each registrable
+// domain of dnsNames, sorted by domain.
+func registrableDomainStrings(dnsNames []string) []string {
+	domains := registrableDomains(dnsNames)
+	strs := make([]string, 0, len(domains))
+	for domain, count := range domains {
+		strs = append(strs, fmt.Sprintf("%s (%d)", domain, count))
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// certRegistrableDomain returns the registrable domain under which cert is
+// grouped in digests: that of the identifier which matched the watch list,
+// or else of the certificate's first DNS name.  IP addresses are returned
+// unchanged, and "" is returned if the certificate has no identifiers.
+func certRegistrableDomain(cert *DiscoveredCert) string {
+	identifier := cert.MatchedIdentifier
+	if identifier == "" && cert.Identifiers != nil {
+		if len(cert.Identifiers.DNSNames) > 0 {
+			identifier = cert.Identifiers.DNSNames[0]
+		} else if len(cert.Identifiers.IPAddrs) > 0 {
+			identifier = cert.Identifiers.IPAddrs[0].String()
+		}
+	}
+	if identifier == "" || net.ParseIP(identifier) != nil {
+		return identifier
+	}
+	return registrableDomain(identifier)
+}
+
+// registrableDomainGroup is a group of certificates in a digest with the
+// same registrable domain.
+type registrableDomainGroup struct {
+	domain  string
+	indexes []int // indexes of the certificates in the digest
+}
+
+// groupByRegistrableDomain groups certs by certRegistrableDomain, with the
+// largest groups first, and groups of the same size sorted by domain.
+func groupByRegistrableDomain(certs []*DiscoveredCert) []*registrableDomainGroup {
+	var groups []*registrableDomainGroup
+	byDomain := make(map[string]*registrableDomainGroup)
+	for i, cert := range certs {
+		domain := certRegistrableDomain(cert)
+		group, ok := byDomain[domain]
+		if !ok {
+			group = &registrableDomainGroup{domain: domain}
+			byDomain[domain] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, i)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].indexes) != len(groups[j].indexes) {
+			return len(groups[i].indexes) > len(groups[j].indexes)
+		}
+		return groups[i].domain < groups[j].domain
+	})
+	return groups
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func TestGroupByRegistrableDomain(t *testing.T) {
+	certs := []*DiscoveredCert{
+		{MatchedIdentifier: "www.example.net"},
+		{MatchedIdentifier: "a.example.com"},
+		{MatchedIdentifier: "*.b.example.com"},
+		{Identifiers: &certspotter.Identifiers{DNSNames: []string{"www.example.co.uk", "example.com"}}}, // matched by issuer
+		{MatchedIdentifier: "192.0.2.1"},
+		{MatchedIdentifier: "c.example.com"},
+		{Identifiers: &certspotter.Identifiers{IPAddrs: []net.IP{net.ParseIP("192.0.2.1")}}},
+		{Identifiers: &certspotter.Identifiers{}},
+	}
+	var got []string
+	for _, group := range groupByRegistrableDomain(certs) {
+		got = append(got, registrableDomainGroupHeading(group))
+		if group.domain == "example.com" && (len(group.indexes) != 3 || group.indexes[0] != 1 || group.indexes[2] != 5) {
+			t.Errorf("example.com group contains certificates %v; expected 1, 2, and 5 in order", group.indexes)
+		}
+	}
+	expected := []string{
+		"example.com: 3 certificates",
+		"192.0.2.1: 2 certificates",
+		"[no DNS names]: 1 certificate",
+		"example.co.uk: 1 certificate",
+		"example.net: 1 certificate",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("groups are %q; expected %q", got, expected)
+	}
+}
+
+func TestCertDigestGroupedByRegistrableDomain(t *testing.T) {
+	server, requests := newRecordingServer(t, http.StatusOK)
+	state := &FilesystemState{StateDir: t.TempDir(), Webhook: server.URL}
+	ctx := context.Background()
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var certs []*DiscoveredCert
+	for _, dnsName := range []string{"a.example.com", "www.example.org", "b.example.com"} {
+		certs = append(certs, makeHeldTestCert(t, dnsName))
+	}
+	if err := state.NotifyCertDigest(ctx, certs); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload webhookPayload
+	decodeJSONBody(t, <-requests, &payload)
+	if payload.Summary != "3 Certificates Discovered for 2 Domains" {
+		t.Errorf("summary is %q", payload.Summary)
+	}
+	header := "certspotter discovered 3 certificates matching your watch list:\n\n\texample.com: 2 certificates\n\texample.org: 1 certificate\n\n== example.com: 2 certificates ==\n\n"
+	if !strings.HasPrefix(payload.Text, header) {
+		t.Errorf("text does not begin with the domain counts:\n%s", payload.Text)
+	}
+	comIndex := strings.Index(payload.Text, "== example.com")
+	orgIndex := strings.Index(payload.Text, "== example.org: 1 certificate ==")
+	for _, dnsName := range []string{"a.example.com", "b.example.com"} {
+		if i := strings.Index(payload.Text, "DNS Name = "+dnsName); i < comIndex || i > orgIndex {
+			t.Errorf("%s is not listed under example.com", dnsName)
+		}
+	}
+	if i := strings.Index(payload.Text, "DNS Name = www.example.org"); i < orgIndex {
+		t.Errorf("www.example.org is not listed under example.org")
+	}
+	domains, _ := payload.Fields["registrableDomains"].(map[string]any)
+	if len(domains) != 2 || domains["example.com"] != float64(2) || domains["example.org"] != float64(1) {
+		t.Errorf("registrableDomains field is %v", payload.Fields["registrableDomains"])
+	}
+}
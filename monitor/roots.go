@@ -0,0 +1,205 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+// rootsClient is implemented by log clients which support get-roots.
+// Static CT API logs only serve get-roots from their submission URL, so
+// their roots aren't checked.
+type rootsClient interface {
+	GetRoots(ctx context.Context) ([]ct.ASN1Cert, error)
+}
+
+// RootsChangedInfo describes a change to the set of root certificates
+// which a log accepts, as returned by get-roots.  Roots are identified by
+// the SHA-256 fingerprint of the certificate.
+type RootsChangedInfo struct {
+	Log      *loglist.Log
+	NumRoots int                 // number of roots the log now accepts
+	Added    [][32]byte          // roots which the log now accepts
+	Removed  [][32]byte          // roots which the log no longer accepts
+	Subjects map[[32]byte]string // subjects of the added roots which could be parsed
+}
+
+func (e *RootsChangedInfo) Summary() string {
+	return fmt.Sprintf("Accepted roots of %s changed (%d added, %d removed)", e.Log.URL, len(e.Added), len(e.Removed))
+}
+
+func (e *RootsChangedInfo) Type() string { return "roots_changed" }
+
+func (e *RootsChangedInfo) Json() []zap.Field {
+	return []zap.Field{
+		zap.String("type", e.Type()),
+		zap.String("log", e.Log.URL),
+		zap.Int("numRoots", e.NumRoots),
+		zap.Strings("added", hexFingerprints(e.Added)),
+		zap.Strings("removed", hexFingerprints(e.Removed)),
+	}
+}
+
+func (e *RootsChangedInfo) Text() string {
+	text := new(strings.Builder)
+	fmt.Fprintf(text, "The set of root certificates accepted by %s has changed. It now accepts %d roots.\n", e.Log.URL, e.NumRoots)
+	fmt.Fprintf(text, "\n")
+	fmt.Fprintf(text, "This may indicate a change in the log's policy, which could affect which certificates can be logged to it.\n")
+	if len(e.Added) > 0 {
+		fmt.Fprintf(text, "\n")
+		fmt.Fprintf(text, "Added roots (SHA-256 fingerprints):\n")
+		for _, fingerprint := range e.Added {
+			if subject, ok := e.Subjects[fingerprint]; ok {
+				fmt.Fprintf(text, "\t%x %s\n", fingerprint, subject)
+			} else {
+				fmt.Fprintf(text, "\t%x\n", fingerprint)
+			}
+		}
+	}
+	if len(e.Removed) > 0 {
+		fmt.Fprintf(text, "\n")
+		fmt.Fprintf(text, "Removed roots (SHA-256 fingerprints):\n")
+		for _, fingerprint := range e.Removed {
+			fmt.Fprintf(text, "\t%x\n", fingerprint)
+		}
+	}
+	return text.String()
+}
+
+func hexFingerprints(fingerprints [][32]byte) []string {
+	strs := make([]string, len(fingerprints))
+	for i, fingerprint := range fingerprints {
+		strs[i] = hex.EncodeToString(fingerprint[:])
+	}
+	return strs
+}
+
+func compareFingerprints(a, b [32]byte) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// diffFingerprints returns the fingerprints in new but not old, and in old
+// but not new.  Both must be sorted.
+func diffFingerprints(old, new [][32]byte) (added, removed [][32]byte) {
+	for _, fingerprint := range new {
+		if _, found := slices.BinarySearchFunc(old, fingerprint, compareFingerprints); !found {
+			added = append(added, fingerprint)
+		}
+	}
+	for _, fingerprint := range old {
+		if _, found := slices.BinarySearchFunc(new, fingerprint, compareFingerprints); !found {
+			removed = append(removed, fingerprint)
+		}
+	}
+	return added, removed
+}
+
+// checkRoots fetches the roots accepted by the log and compares them to the
+// roots stored by the previous check, notifying if they changed.  The first
+// time a log is checked, the roots are stored without notifying.
+func checkRoots(ctx context.Context, config *Config, ctlog *loglist.Log, logClient logClient) error {
+	client, ok := logClient.(rootsClient)
+	if !ok {
+		return nil
+	}
+	store, ok := optionalState[rootsStore](config.State)
+	if !ok {
+		return nil
+	}
+	roots, err := client.GetRoots(ctx)
+	if err != nil {
+		recordError(ctx, config, ctlog, fmt.Errorf("error fetching accepted roots: %w", err))
+		return nil
+	}
+	subjects := make(map[[32]byte]string)
+	fingerprints := make([][32]byte, 0, len(roots))
+	for _, root := range roots {
+		fingerprint := sha256.Sum256(root)
+		fingerprints = append(fingerprints, fingerprint)
+		if info, err := certspotter.MakeCertInfoFromRawCert(root); err == nil && info.SubjectParseError == nil {
+			subjects[fingerprint] = info.Subject.String()
+		}
+	}
+	slices.SortFunc(fingerprints, compareFingerprints)
+	fingerprints = slices.Compact(fingerprints)
+
+	previous, err := store.LoadRoots(ctx, ctlog.LogID)
+	if err != nil {
+		return fmt.Errorf("error loading accepted roots: %w", err)
+	}
+	if previous != nil {
+		slices.SortFunc(previous, compareFingerprints)
+		added, removed := diffFingerprints(previous, fingerprints)
+		if len(added) == 0 && len(removed) == 0 {
+			return nil
+		}
+		info := &RootsChangedInfo{
+			Log:      ctlog,
+			NumRoots: len(fingerprints),
+			Added:    added,
+			Removed:  removed,
+			Subjects: subjects,
+		}
+		if err := config.State.NotifyHealthCheckFailure(ctx, ctlog, info); err != nil {
+			return fmt.Errorf("error notifying about changed roots: %w", err)
+		}
+	}
+	if err := store.StoreRoots(ctx, ctlog.LogID, fingerprints); err != nil {
+		return fmt.Errorf("error storing accepted roots: %w", err)
+	}
+	return nil
+}
+
+func (s *FilesystemState) rootsPath(logID LogID) string {
+	return filepath.Join(s.logStateDir(logID), "roots.json")
+}
+
+func (s *FilesystemState) LoadRoots(ctx context.Context, logID LogID) ([][32]byte, error) {
+	filePath := s.rootsPath(logID)
+	fileBytes, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var strs []string
+	if err := json.Unmarshal(fileBytes, &strs); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filePath, err)
+	}
+	fingerprints := make([][32]byte, len(strs))
+	for i, str := range strs {
+		if len(str) != hex.EncodedLen(len(fingerprints[i])) {
+			return nil, fmt.Errorf("error parsing %s: invalid fingerprint %q", filePath, str)
+		} else if _, err := hex.Decode(fingerprints[i][:], []byte(str)); err != nil {
+			return nil, fmt.Errorf("error parsing %s: invalid fingerprint %q: %w", filePath, str, err)
+		}
+	}
+	return fingerprints, nil
+}
+
+func (s *FilesystemState) StoreRoots(ctx context.Context, logID LogID, fingerprints [][32]byte) error {
+	return writeJSONFile(s.rootsPath(logID), hexFingerprints(fingerprints), 0666)
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+type fakeRootsClient struct {
+	fakeLogClient
+	roots []ct.ASN1Cert
+}
+
+func (c *fakeRootsClient) GetRoots(context.Context) ([]ct.ASN1Cert, error) {
+	return c.roots, nil
+}
+
+func TestCheckRoots(t *testing.T) {
+	ctx := context.Background()
+	var notified []HealthCheckFailure
+	state := &MemoryState{OnHealthCheckFailure: func(ctlog *loglist.Log, info HealthCheckFailure) { notified = append(notified, info) }}
+	config := &Config{State: state}
+	ctlog := &loglist.Log{URL: "https://ct.example.com/"}
+	client := &fakeRootsClient{roots: []ct.ASN1Cert{[]byte("root 1"), []byte("root 2")}}
+
+	// The first check only records the roots
+	if err := checkRoots(ctx, config, ctlog, client); err != nil {
+		t.Fatal(err)
+	} else if len(notified) != 0 {
+		t.Fatalf("first check notified about %v", notified)
+	}
+
+	client.roots = []ct.ASN1Cert{[]byte("root 2"), []byte("root 1")}
+	if err := checkRoots(ctx, config, ctlog, client); err != nil {
+		t.Fatal(err)
+	} else if len(notified) != 0 {
+		t.Fatalf("check notified about %v even though only the order of the roots changed", notified)
+	}
+
+	client.roots = []ct.ASN1Cert{[]byte("root 2"), []byte("root 3")}
+	if err := checkRoots(ctx, config, ctlog, client); err != nil {
+		t.Fatal(err)
+	} else if len(notified) != 1 {
+		t.Fatalf("check notified %d times, want once", len(notified))
+	}
+	info, ok := notified[0].(*RootsChangedInfo)
+	if !ok {
+		t.Fatalf("check notified about %T, want *RootsChangedInfo", notified[0])
+	}
+	if info.NumRoots != 2 || len(info.Added) != 1 || info.Added[0] != sha256.Sum256([]byte("root 3")) || len(info.Removed) != 1 || info.Removed[0] != sha256.Sum256([]byte("root 1")) {
+		t.Errorf("check notified about %d roots, added %x, removed %x", info.NumRoots, info.Added, info.Removed)
+	}
+
+	if err := checkRoots(ctx, config, ctlog, client); err != nil {
+		t.Fatal(err)
+	} else if len(notified) != 1 {
+		t.Errorf("check notified again even though the roots didn't change")
+	}
+}
+
+func TestFilesystemStateRoots(t *testing.T) {
+	ctx := context.Background()
+	state := &FilesystemState{StateDir: t.TempDir()}
+	logID := LogID{1}
+	if err := state.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.PrepareLog(ctx, logID); err != nil {
+		t.Fatal(err)
+	}
+	if roots, err := state.LoadRoots(ctx, logID); err != nil {
+		t.Fatal(err)
+	} else if roots != nil {
+		t.Fatalf("LoadRoots returned %x before StoreRoots was called", roots)
+	}
+	for _, want := range [][][32]byte{{}, {{1}, {2}}} {
+		if err := state.StoreRoots(ctx, logID, want); err != nil {
+			t.Fatal(err)
+		}
+		roots, err := state.LoadRoots(ctx, logID)
+		if err != nil {
+			t.Fatal(err)
+		} else if roots == nil || len(roots) != len(want) {
+			t.Fatalf("LoadRoots returned %x, want %x", roots, want)
+		}
+		for i := range want {
+			if roots[i] != want[i] {
+				t.Errorf("LoadRoots returned %x, want %x", roots, want)
+			}
+		}
+	}
+}
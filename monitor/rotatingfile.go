@@ -0,0 +1,131 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RotationInterval int
+
+const (
+	RotateNever RotationInterval = iota
+	RotateDaily
+	RotateHourly
+)
+
+func ParseRotationInterval(str string) (RotationInterval, error) {
+	switch str {
+	case "", "never":
+		return RotateNever, nil
+	case "daily":
+		return RotateDaily, nil
+	case "hourly":
+		return RotateHourly, nil
+	default:
+		return RotateNever, fmt.Errorf("invalid rotation interval %q (must be never, daily, or hourly)", str)
+	}
+}
+
+// RotatingFile is an io.Writer which appends to a file whose name contains
+// the current date (and hour, for hourly rotation), switching to a new file
+// when the day (or hour) changes.  It is safe for concurrent use.
+type RotatingFile struct {
+	path     string
+	interval RotationInterval
+	location *time.Location
+	now      func() time.Time // replaced by tests
+
+	mu          sync.Mutex
+	file        *os.File
+	periodStart time.Time
+}
+
+// NewRotatingFile returns a RotatingFile which writes to path, with a date
+// stamp inserted before the file extension unless interval is RotateNever.
+// Periods begin at midnight (or on the hour) in the given location.
+func NewRotatingFile(path string, interval RotationInterval, location *time.Location) *RotatingFile {
+	return &RotatingFile{
+		path:     path,
+		interval: interval,
+		location: location,
+		now:      time.Now,
+	}
+}
+
+func (f *RotatingFile) currentPeriod(now time.Time) time.Time {
+	now = now.In(f.location)
+	switch f.interval {
+	case RotateDaily:
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, f.location)
+	case RotateHourly:
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, f.location)
+	default:
+		return time.Time{}
+	}
+}
+
+func (f *RotatingFile) filename(period time.Time) string {
+	var stamp string
+	switch f.interval {
+	case RotateDaily:
+		stamp = period.Format("2006-01-02")
+	case RotateHourly:
+		stamp = period.Format("2006-01-02T15")
+	default:
+		return f.path
+	}
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + stamp + ext
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if period := f.currentPeriod(f.now()); f.file == nil || !period.Equal(f.periodStart) {
+		if f.file != nil {
+			f.file.Close()
+			f.file = nil
+		}
+		file, err := os.OpenFile(f.filename(period), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return 0, err
+		}
+		f.file = file
+		f.periodStart = period
+	}
+	return f.file.Write(p)
+}
+
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRotatingFile(t *testing.T, f *RotatingFile, now time.Time, data string) {
+	t.Helper()
+	f.now = func() time.Time { return now }
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFileContents(t *testing.T, filename string, expected string) {
+	t.Helper()
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != expected {
+		t.Errorf("%s contains %q; expected %q", filepath.Base(filename), contents, expected)
+	}
+}
+
+func TestRotatingFileDaily(t *testing.T) {
+	dir := t.TempDir()
+	location := time.FixedZone("UTC+10", 10*60*60)
+	f := NewRotatingFile(filepath.Join(dir, "certs.json"), RotateDaily, location)
+	defer f.Close()
+
+	// Midnight in the file's location is 14:00 UTC
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 2, 0, 0, 0, time.UTC), "a\n")
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 13, 59, 0, 0, time.UTC), "b\n")
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 14, 0, 0, 0, time.UTC), "c\n")
+	writeRotatingFile(t, f, time.Date(2024, time.February, 2, 13, 0, 0, 0, time.UTC), "d\n")
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFileContents(t, filepath.Join(dir, "certs-2024-02-01.json"), "a\nb\n")
+	checkFileContents(t, filepath.Join(dir, "certs-2024-02-02.json"), "c\nd\n")
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Errorf("directory contains %d files; expected 2", len(entries))
+	}
+}
+
+func TestRotatingFileHourly(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "certs.json"), RotateHourly, time.UTC)
+	defer f.Close()
+
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 23, 0, 0, 0, time.UTC), "a\n")
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 23, 59, 59, 0, time.UTC), "b\n")
+	writeRotatingFile(t, f, time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC), "c\n")
+
+	checkFileContents(t, filepath.Join(dir, "certs-2024-02-01T23.json"), "a\nb\n")
+	checkFileContents(t, filepath.Join(dir, "certs-2024-02-02T00.json"), "c\n")
+}
+
+func TestRotatingFileNever(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "certs.json"), RotateNever, time.UTC)
+	defer f.Close()
+
+	writeRotatingFile(t, f, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), "a\n")
+	writeRotatingFile(t, f, time.Date(2025, time.March, 2, 0, 0, 0, 0, time.UTC), "b\n")
+
+	checkFileContents(t, filepath.Join(dir, "certs.json"), "a\nb\n")
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "certs.json")
+	now := time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC)
+
+	f := NewRotatingFile(path, RotateDaily, time.UTC)
+	writeRotatingFile(t, f, now, "a\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("second Close failed: %s", err)
+	}
+
+	// Writing after Close reopens the file and appends to it
+	writeRotatingFile(t, f, now, "b\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// So does a new RotatingFile, as when certspotter is restarted
+	f = NewRotatingFile(path, RotateDaily, time.UTC)
+	defer f.Close()
+	writeRotatingFile(t, f, now.Add(time.Hour), "c\n")
+
+	checkFileContents(t, filepath.Join(dir, "certs-2024-02-01.json"), "a\nb\nc\n")
+}
+
+func TestParseRotationInterval(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected RotationInterval
+	}{
+		{"", RotateNever},
+		{"never", RotateNever},
+		{"daily", RotateDaily},
+		{"hourly", RotateHourly},
+	}
+	for _, test := range tests {
+		if interval, err := ParseRotationInterval(test.in); err != nil {
+			t.Errorf("ParseRotationInterval(%q) failed: %s", test.in, err)
+		} else if interval != test.expected {
+			t.Errorf("ParseRotationInterval(%q) = %d; expected %d", test.in, interval, test.expected)
+		}
+	}
+	if _, err := ParseRotationInterval("weekly"); err == nil {
+		t.Errorf("ParseRotationInterval(\"weekly\") succeeded")
+	}
+}
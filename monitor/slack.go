@@ -0,0 +1,126 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	slackMaxHeaderLength  = 150
+	slackMaxSectionLength = 3000
+)
+
+type slackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	Username string       `json:"username,omitempty"`
+	Text     string       `json:"text"` // shown in notifications and by clients which can't display blocks
+	Blocks   []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string       `json:"type"`
+	Text   *slackText   `json:"text,omitempty"`
+	Fields []*slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func slackPlainText(text string) *slackText {
+	return &slackText{Type: "plain_text", Text: truncate(text, slackMaxHeaderLength)}
+}
+
+func slackMarkdown(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: truncate(text, slackMaxSectionLength)}
+}
+
+// slackEscape escapes the characters which have special meaning in Slack's
+// mrkdwn format.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+func truncate(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	return strings.ToValidUTF8(text[:maxLength-3], "") + "..."
+}
+
+func makeSlackMessage(s *FilesystemState, notif *notification) *slackMessage {
+	message := &slackMessage{
+		Channel:  s.SlackChannel,
+		Username: s.SlackUsername,
+		Text:     notif.summary,
+		Blocks:   []slackBlock{{Type: "header", Text: slackPlainText(notif.summary)}},
+	}
+	if notif.cert != nil {
+		message.Blocks = append(message.Blocks, slackCertBlocks(notif.cert)...)
+	} else {
+		message.Blocks = append(message.Blocks, slackBlock{Type: "section", Text: slackMarkdown("```" + slackEscape(notif.text) + "```")})
+	}
+	return message
+}
+
+func slackCertBlocks(cert *DiscoveredCert) []slackBlock {
+	field := func(name string, value string) *slackText {
+		return slackMarkdown("*" + name + "*\n" + slackEscape(value))
+	}
+
+	var issuer string
+	if cert.Info.IssuerParseError == nil {
+		issuer = cert.Info.Issuer.String()
+	} else {
+		issuer = fmt.Sprintf("[unable to parse: %s]", cert.Info.IssuerParseError)
+	}
+	var names []string
+	names = append(names, cert.Identifiers.DNSNames...)
+	for _, ipaddr := range cert.Identifiers.IPAddrs {
+		names = append(names, ipaddr.String())
+	}
+	crtshURL := "https://crt.sh/?sha256=" + hex.EncodeToString(cert.SHA256[:])
+
+	return []slackBlock{
+		{
+			Type: "section",
+			Fields: []*slackText{
+				field("Domain", strings.Join(names, "\n")),
+				field("Issuer", issuer),
+				field("Discovered", cert.ObservedAt.UTC().Format(time.RFC3339)),
+				field("Log Entry", fmt.Sprintf("%d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL)),
+			},
+		},
+		{
+			Type: "section",
+			Text: slackMarkdown(fmt.Sprintf("<%s|View %x on crt.sh>", crtshURL, cert.SHA256)),
+		},
+	}
+}
+
+// sendSlack posts the notification to the Slack incoming webhook at
+// s.SlackWebhookURL.
+func sendSlack(ctx context.Context, s *FilesystemState, notif *notification) error {
+	body, err := json.Marshal(makeSlackMessage(s, notif))
+	if err != nil {
+		return fmt.Errorf("error encoding Slack message: %w", err)
+	}
+	if err := postWithRetries(ctx, s.SlackWebhookURL, body, nil); err != nil {
+		return fmt.Errorf("error posting to Slack: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// SMTPConfig configures sending email directly to an SMTP server, instead of
+// using sendmail.
+type SMTPConfig struct {
+	Host        string
+	Port        string
+	Username    string // if empty, don't authenticate
+	Password    string
+	ImplicitTLS bool // connect using TLS (typically port 465) instead of STARTTLS
+}
+
+// ParseSMTPServer parses a server address of the form HOST or HOST:PORT.  The
+// port defaults to 465 if implicitTLS is true, and 587 otherwise.
+func ParseSMTPServer(server string, implicitTLS bool) (*SMTPConfig, error) {
+	config := &SMTPConfig{ImplicitTLS: implicitTLS}
+	if host, port, err := net.SplitHostPort(server); err == nil {
+		config.Host, config.Port = host, port
+	} else {
+		config.Host = strings.Trim(server, "[]")
+		if implicitTLS {
+			config.Port = "465"
+		} else {
+			config.Port = "587"
+		}
+	}
+	if config.Host == "" {
+		return nil, fmt.Errorf("invalid SMTP server %q: empty host", server)
+	}
+	return config, nil
+}
+
+// dial connects to the server.  The returned function must be called when
+// done with the client.
+func (config *SMTPConfig) dial(ctx context.Context) (*smtp.Client, func(), error) {
+	conn, err := dialNotification(ctx, net.JoinHostPort(config.Host, config.Port))
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.ImplicitTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: config.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	// net/smtp does not support contexts, so close the connection if the
+	// context is canceled to abort any operation in progress
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, nil, err
+	}
+	return client, func() { stop(); client.Close() }, nil
+}
+
+func sendEmailSMTP(ctx context.Context, s *FilesystemState, notif *notification) error {
+	config, to := s.SMTP, s.Email
+	from := os.Getenv("EMAIL")
+	if from == "" && strings.Contains(config.Username, "@") {
+		from = config.Username
+	}
+	if from == "" {
+		return fmt.Errorf("error sending email to %v: the EMAIL environment variable must be set to the sender address when using SMTP", to)
+	}
+
+	if err := sendSMTPMessage(ctx, config, from, to, buildEmail(s, from, notif)); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error sending email to %v via SMTP server %s: %w", to, config.Host, err)
+	}
+	return nil
+}
+
+func sendSMTPMessage(ctx context.Context, config *SMTPConfig, from string, to []string, message []byte) error {
+	client, closeClient, err := config.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	if !config.ImplicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+				return fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		} else if config.Username != "" {
+			return errors.New("server does not support STARTTLS, so refusing to send credentials")
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
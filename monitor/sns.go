@@ -0,0 +1,94 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+const (
+	snsMaxSubjectLength = 100
+	snsMaxMessageLength = 256 * 1024
+)
+
+// SNSRegionFromARN returns the region in an SNS topic ARN
+// (arn:aws:sns:REGION:ACCOUNT:TOPIC), or "" if the ARN is malformed.
+func SNSRegionFromARN(arn string) string {
+	fields := strings.Split(arn, ":")
+	if len(fields) != 6 || fields[0] != "arn" || fields[2] != "sns" {
+		return ""
+	}
+	return fields[3]
+}
+
+func snsRegion(s *FilesystemState) string {
+	if s.SNSRegion != "" {
+		return s.SNSRegion
+	}
+	return SNSRegionFromARN(s.SNSTopicARN)
+}
+
+// snsSubject converts the summary to a valid SNS subject, which must consist
+// of printable ASCII characters and be at most 100 characters long.
+func snsSubject(summary string) string {
+	subject := strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, summary)
+	return truncate(subject, snsMaxSubjectLength)
+}
+
+// getSNSClient returns the client used to publish to s.SNSTopicARN.  The AWS
+// configuration is loaded the first time it is needed, in the same way as
+// the AWS CLI, and credentials are refreshed by the SDK as they expire.
+func (s *FilesystemState) getSNSClient(ctx context.Context) (*sns.Client, error) {
+	s.snsClientMu.Lock()
+	defer s.snsClientMu.Unlock()
+	if s.snsClient != nil {
+		return s.snsClient, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(snsRegion(s)))
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %w", err)
+	}
+	s.snsClient = sns.NewFromConfig(cfg, func(o *sns.Options) {
+		o.HTTPClient = webhookClient
+		if s.SNSEndpoint != "" {
+			o.BaseEndpoint = aws.String(s.SNSEndpoint)
+		}
+	})
+	return s.snsClient, nil
+}
+
+// sendSNS publishes the notification to the SNS topic s.SNSTopicARN, using
+// the summary as the subject and the text as the message.
+func sendSNS(ctx context.Context, s *FilesystemState, notif *notification) error {
+	client, err := s.getSNSClient(ctx)
+	if err != nil {
+		return fmt.Errorf("error publishing to SNS topic %s: %w", s.SNSTopicARN, err)
+	}
+	_, err = client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.SNSTopicARN),
+		Subject:  aws.String(snsSubject(notif.summary)),
+		Message:  aws.String(truncate(notif.text, snsMaxMessageLength)),
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing to SNS topic %s: %w", s.SNSTopicARN, err)
+	}
+	return nil
+}
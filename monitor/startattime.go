@@ -0,0 +1,73 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// errSearchDidNotConverge is returned by findEntryAtTime when the log's
+// entries can't be searched by timestamp.
+type errSearchDidNotConverge struct {
+	index uint64
+	err   error
+}
+
+func (e *errSearchDidNotConverge) Error() string {
+	return fmt.Sprintf("unable to determine timestamp of entry %d: %s", e.index, e.err)
+}
+
+func (e *errSearchDidNotConverge) Unwrap() error {
+	return e.err
+}
+
+// findEntryAtTime binary searches the first treeSize entries of the log for
+// the first entry whose timestamp is at or after t, and returns its index,
+// or treeSize if there is no such entry.  Logs only approximately order
+// their entries by timestamp (entries can be incorporated up to the log's
+// maximum merge delay after they are timestamped), so entries near the
+// returned index may be slightly before or after t.  If an entry's timestamp
+// can't be determined, findEntryAtTime returns an *errSearchDidNotConverge.
+func findEntryAtTime(ctx context.Context, logClient logClient, treeSize uint64, t time.Time) (uint64, error) {
+	begin, end := uint64(0), treeSize
+	for begin < end {
+		middle := begin + (end-begin)/2
+		timestamp, err := entryTimestamp(ctx, logClient, middle)
+		if err != nil {
+			return 0, err
+		}
+		if timestamp.Before(t) {
+			begin = middle + 1
+		} else {
+			end = middle
+		}
+	}
+	return begin, nil
+}
+
+func entryTimestamp(ctx context.Context, logClient logClient, index uint64) (time.Time, error) {
+	entries, err := logClient.GetRawEntries(ctx, index, index)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting entry %d: %w", index, err)
+	} else if len(entries) == 0 {
+		return time.Time{}, &errSearchDidNotConverge{index: index, err: fmt.Errorf("log returned no entries")}
+	}
+	leaf, err := ct.ReadMerkleTreeLeaf(bytes.NewReader(entries[0].LeafInput))
+	if err != nil {
+		return time.Time{}, &errSearchDidNotConverge{index: index, err: fmt.Errorf("error parsing Merkle Tree Leaf: %w", err)}
+	}
+	timestamp := leaf.TimestampedEntry.Timestamp
+	return time.Unix(int64(timestamp/1000), int64(timestamp%1000)*1_000_000).UTC(), nil
+}
@@ -67,3 +67,332 @@ type StateProvider interface {
 	// certspotter will retry the failed operation later.
 	NotifyError(context.Context, *loglist.Log, error) error
 }
+
+// A StateProvider may also implement the optional interfaces below, which
+// provide the state needed by features that were added after
+// StateProvider.  certspotter detects them with a type assertion, so
+// existing implementations keep working; if one isn't implemented, the
+// feature falls back to the behavior described by the interface.
+
+// stateWrapper is implemented by the StateProviders which certspotter wraps
+// around Config.State (e.g. to update metrics), so that the optional
+// interfaces implemented by the wrapped StateProvider can be found.
+type stateWrapper interface {
+	unwrapState() StateProvider
+}
+
+// optionalState returns state, or the StateProvider which it wraps, if it
+// implements the optional interface T.
+func optionalState[T any](state StateProvider) (T, bool) {
+	for {
+		if impl, ok := state.(T); ok {
+			return impl, true
+		}
+		wrapper, ok := state.(stateWrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		state = wrapper.unwrapState()
+	}
+}
+
+// conflictingSTHStore is implemented by StateProviders which keep STHs that
+// conflict with another STH from the same log.  Without it, conflicting
+// STHs are only reported, not kept.
+type conflictingSTHStore interface {
+	// Durably store an STH which conflicts with another STH from the
+	// same log, as evidence that the log presented a split view.  STHs
+	// stored this way are not returned by LoadSTHs.
+	StoreConflictingSTH(context.Context, LogID, *ct.SignedTreeHead) error
+}
+
+func storeConflictingSTH(ctx context.Context, state StateProvider, logID LogID, sth *ct.SignedTreeHead) error {
+	if store, ok := optionalState[conflictingSTHStore](state); ok {
+		return store.StoreConflictingSTH(ctx, logID, sth)
+	}
+	return nil
+}
+
+// rootsStore is implemented by StateProviders which keep the roots accepted
+// by each log.  Without it, Config.CheckRoots has no effect.
+type rootsStore interface {
+	// Load the SHA-256 fingerprints of the root certificates which the
+	// log accepted when StoreRoots was last called.  Returns nil, nil if
+	// StoreRoots has not been called yet for this log.
+	LoadRoots(ctx context.Context, logID LogID) ([][32]byte, error)
+
+	// Store the SHA-256 fingerprints of the root certificates which the
+	// log accepts, if Config.CheckRoots is set.
+	StoreRoots(ctx context.Context, logID LogID, fingerprints [][32]byte) error
+}
+
+// keyIssuerStore is implemented by StateProviders which keep the issuers of
+// the public keys in discovered certificates.  Without it,
+// Config.TrackKeyReuse has no effect.
+type keyIssuerStore interface {
+	// Load the distinct issuer DNs of certificates previously seen with the
+	// given public key (identified by the SHA-256 hash of its SPKI).
+	LoadKeyIssuers(ctx context.Context, pubkeySHA256 [32]byte) ([]string, error)
+
+	// Record that a certificate with the given public key was issued
+	// by issuer.
+	StoreKeyIssuer(ctx context.Context, pubkeySHA256 [32]byte, issuer string) error
+}
+
+// keyReuseNotifier is implemented by StateProviders which notify about
+// public keys reused across issuers.  Without it, key reuse is not notified
+// about.
+type keyReuseNotifier interface {
+	// Called when a certificate matching the watch list has a public key
+	// which was previously seen in certificates from otherIssuers, if
+	// Config.TrackKeyReuse is set.
+	NotifyKeyReusedAcrossIssuers(ctx context.Context, cert *DiscoveredCert, otherIssuers []string) error
+}
+
+func notifyKeyReusedAcrossIssuers(ctx context.Context, state StateProvider, cert *DiscoveredCert, otherIssuers []string) error {
+	if notifier, ok := optionalState[keyReuseNotifier](state); ok {
+		return notifier.NotifyKeyReusedAcrossIssuers(ctx, cert, otherIssuers)
+	}
+	return nil
+}
+
+// precertTBSStore is implemented by StateProviders which keep the
+// TBSCertificates of the precertificates which have been seen.  Without it,
+// Config.CheckPrecerts only checks that precertificates are valid, and not
+// that certificates correspond to a precertificate.
+type precertTBSStore interface {
+	// Record that a precertificate with the given TBSCertificate hash
+	// (computed over the TBSCertificate without the poison extension)
+	// has been seen.
+	StorePrecertTBS(ctx context.Context, tbsSHA256 [32]byte) error
+
+	// Returns true if StorePrecertTBS was previously called with the hash.
+	HasPrecertTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error)
+}
+
+// precertAnomalyNotifier is implemented by StateProviders which notify about
+// precertificate anomalies.  Without it, anomalies are not notified about.
+type precertAnomalyNotifier interface {
+	// Called when a precertificate matching the watch list is invalid, or
+	// a certificate matching the watch list does not correspond to a
+	// precertificate, if Config.CheckPrecerts is set.  Implementations
+	// should notify at most once per certificate.
+	NotifyPrecertAnomaly(ctx context.Context, cert *DiscoveredCert, anomaly string) error
+}
+
+func notifyPrecertAnomaly(ctx context.Context, state StateProvider, cert *DiscoveredCert, anomaly string) error {
+	if notifier, ok := optionalState[precertAnomalyNotifier](state); ok {
+		return notifier.NotifyPrecertAnomaly(ctx, cert, anomaly)
+	}
+	return nil
+}
+
+// notifiedTBSStore is implemented by StateProviders which keep the
+// TBSCertificates of the certificates which have been notified about.
+// Without it, Config.DedupePrecerts has no effect.
+type notifiedTBSStore interface {
+	// Record that a certificate or precertificate with the given
+	// TBSCertificate hash (computed as for StorePrecertTBS) was notified
+	// about, if Config.DedupePrecerts is set.
+	StoreNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) error
+
+	// Returns true if StoreNotifiedTBS was previously called with the hash.
+	HasNotifiedTBS(ctx context.Context, tbsSHA256 [32]byte) (bool, error)
+}
+
+// notifiedLeafStore is implemented by StateProviders which keep the log
+// entries which have been notified about.  Without it, an entry which is
+// processed again (e.g. because the download position was rolled back) is
+// notified about again.
+type notifiedLeafStore interface {
+	// Record that the certificate in the log entry with the given leaf
+	// hash was notified about, so that it isn't notified about again if
+	// the entry is processed again.  Implementations may forget old leaf
+	// hashes, in which case WasNotified returns false.
+	MarkNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) error
+
+	// Returns true if MarkNotified was previously called with the log ID
+	// and leaf hash.
+	WasNotified(ctx context.Context, logID LogID, leafHash merkletree.Hash) (bool, error)
+}
+
+// digestNotifier is implemented by StateProviders which can notify about
+// several certificates at once.  Without it, a digest is sent as one
+// NotifyCert call per certificate.
+type digestNotifier interface {
+	// Called instead of NotifyCert when Config.DigestInterval is set, with
+	// the certificates matching the watch list which were discovered
+	// during the interval.  Implementations should skip certificates
+	// which were previously notified about, and send one notification
+	// about the rest.
+	NotifyCertDigest(ctx context.Context, certs []*DiscoveredCert) error
+}
+
+func notifyCertDigest(ctx context.Context, state StateProvider, certs []*DiscoveredCert) error {
+	if notifier, ok := optionalState[digestNotifier](state); ok {
+		return notifier.NotifyCertDigest(ctx, certs)
+	}
+	for _, cert := range certs {
+		if err := state.NotifyCert(ctx, cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heldCertStore is implemented by StateProviders which durably store the
+// certificates whose notifications are being held back (e.g. until a digest
+// is sent).  Without it, held certificates are only kept in memory, and are
+// not notified about if certspotter exits before sending them.
+type heldCertStore interface {
+	// Durably store a certificate whose notification is being held back
+	// in the named queue, replacing any certificate in the queue with the
+	// same SHA-256 fingerprint.
+	StoreHeldCert(ctx context.Context, queue string, cert *DiscoveredCert) error
+
+	// Load the certificates stored in the named queue by StoreHeldCert.
+	LoadHeldCerts(ctx context.Context, queue string) ([]*DiscoveredCert, error)
+
+	// Remove the certificate with the given SHA-256 fingerprint from the
+	// named queue, once it has been notified about.
+	RemoveHeldCert(ctx context.Context, queue string, fingerprint [32]byte) error
+}
+
+func storeHeldCert(ctx context.Context, state StateProvider, queue string, cert *DiscoveredCert) error {
+	if store, ok := optionalState[heldCertStore](state); ok {
+		return store.StoreHeldCert(ctx, queue, cert)
+	}
+	return nil
+}
+
+func loadHeldCerts(ctx context.Context, state StateProvider, queue string) ([]*DiscoveredCert, error) {
+	if store, ok := optionalState[heldCertStore](state); ok {
+		return store.LoadHeldCerts(ctx, queue)
+	}
+	return nil, nil
+}
+
+func removeHeldCert(ctx context.Context, state StateProvider, queue string, fingerprint [32]byte) error {
+	if store, ok := optionalState[heldCertStore](state); ok {
+		return store.RemoveHeldCert(ctx, queue, fingerprint)
+	}
+	return nil
+}
+
+// pendingNotificationQueue is implemented by StateProviders which queue the
+// notifications which could not be delivered, so they can be retried.
+// Without it, nothing is retried.
+type pendingNotificationQueue interface {
+	// Load the notifications which could not be delivered by some of
+	// their delivery methods and are waiting to be retried, oldest first.
+	LoadPendingNotifications(context.Context) ([]*PendingNotification, error)
+
+	// Try again to deliver a notification returned by
+	// LoadPendingNotifications, using the delivery methods which failed.
+	// Once it has been delivered by all of them, the notification is
+	// removed so it is no longer returned by LoadPendingNotifications.
+	// Implementations may also give up on a notification which can't be
+	// delivered, reporting this with NotifyError and removing it.  An
+	// error is returned if, and only if, the notification is still
+	// pending.
+	SendPendingNotification(context.Context, *PendingNotification) error
+}
+
+func loadPendingNotifications(ctx context.Context, state StateProvider) ([]*PendingNotification, error) {
+	if queue, ok := optionalState[pendingNotificationQueue](state); ok {
+		return queue.LoadPendingNotifications(ctx)
+	}
+	return nil, nil
+}
+
+func sendPendingNotification(ctx context.Context, state StateProvider, pending *PendingNotification) error {
+	if queue, ok := optionalState[pendingNotificationQueue](state); ok {
+		return queue.SendPendingNotification(ctx, pending)
+	}
+	return nil
+}
+
+// logContactNotifier is implemented by StateProviders which notify when a log
+// is first contacted.  Without it, Config.NotifyLogContact has no effect.
+type logContactNotifier interface {
+	// Called the first time an STH is successfully retrieved from a log
+	// after startup, if Config.NotifyLogContact is set.
+	NotifyLogContacted(context.Context, *loglist.Log, *ct.SignedTreeHead) error
+}
+
+func notifyLogContacted(ctx context.Context, state StateProvider, ctlog *loglist.Log, sth *ct.SignedTreeHead) error {
+	if notifier, ok := optionalState[logContactNotifier](state); ok {
+		return notifier.NotifyLogContacted(ctx, ctlog, sth)
+	}
+	return nil
+}
+
+// logListChangeNotifier is implemented by StateProviders which notify when
+// a reloaded log list adds or removes logs.  Without it,
+// Config.NotifyLogListChanges has no effect.
+type logListChangeNotifier interface {
+	// Called when a reloaded log list adds or removes logs, if
+	// Config.NotifyLogListChanges is set.  certspotter starts or stops
+	// monitoring the logs accordingly; the state of removed logs is kept.
+	NotifyLogListChanged(ctx context.Context, added []*loglist.Log, removed []*loglist.Log) error
+}
+
+func notifyLogListChanged(ctx context.Context, state StateProvider, added []*loglist.Log, removed []*loglist.Log) error {
+	if notifier, ok := optionalState[logListChangeNotifier](state); ok {
+		return notifier.NotifyLogListChanged(ctx, added, removed)
+	}
+	return nil
+}
+
+// lifecycleNotifier is implemented by StateProviders which notify when
+// certspotter starts or stops.  Without it, Config.NotifyLifecycle has no
+// effect.
+type lifecycleNotifier interface {
+	// Called when certspotter starts or stops, if Config.NotifyLifecycle
+	// is set.
+	NotifyLifecycleEvent(context.Context, *LifecycleEvent) error
+}
+
+func notifyLifecycleEvent(ctx context.Context, state StateProvider, event *LifecycleEvent) error {
+	if notifier, ok := optionalState[lifecycleNotifier](state); ok {
+		return notifier.NotifyLifecycleEvent(ctx, event)
+	}
+	return nil
+}
+
+// healthCheckRecoveryNotifier is implemented by StateProviders which notify
+// when a log recovers from a health check failure.  Without it, recoveries
+// are not notified about.
+type healthCheckRecoveryNotifier interface {
+	// Called when a health check finds that a log is healthy again, or is
+	// failing in a different way, after the previous health check of the
+	// log failed with the given failure.
+	// Only failures found by the periodic health check of each log (stale
+	// STHs and backlogs) are followed by this call.
+	NotifyHealthCheckRecovered(context.Context, *loglist.Log, HealthCheckFailure) error
+}
+
+func notifyHealthCheckRecovered(ctx context.Context, state StateProvider, ctlog *loglist.Log, info HealthCheckFailure) error {
+	if notifier, ok := optionalState[healthCheckRecoveryNotifier](state); ok {
+		return notifier.NotifyHealthCheckRecovered(ctx, ctlog, info)
+	}
+	return nil
+}
+
+// healthCheckResultStore is implemented by StateProviders which make the
+// result of the latest health check available to external monitoring.
+// Without it, the result is not kept.
+type healthCheckResultStore interface {
+	// Called after each periodic health check, with the failures that it
+	// found (none if everything is healthy), so that the current health
+	// status can be made available to external monitoring.
+	StoreHealthCheckResult(context.Context, []HealthCheckFailure) error
+}
+
+func storeHealthCheckResult(ctx context.Context, state StateProvider, failures []HealthCheckFailure) error {
+	if store, ok := optionalState[healthCheckResultStore](state); ok {
+		return store.StoreHealthCheckResult(ctx, failures)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+// LogStatus describes how far certspotter has gotten in monitoring a log,
+// according to its saved state.
+type LogStatus struct {
+	Log       *loglist.Log
+	State     *LogState          // nil if the log has never been monitored
+	LatestSTH *ct.SignedTreeHead // newest STH retrieved from the log; nil if none
+}
+
+// Position returns the number of entries which have been downloaded.
+func (status *LogStatus) Position() uint64 {
+	if status.State == nil {
+		return 0
+	}
+	return status.State.DownloadPosition.Size()
+}
+
+// Backlog returns the number of entries in LatestSTH which have not been
+// downloaded yet.
+func (status *LogStatus) Backlog() uint64 {
+	if status.LatestSTH == nil || status.LatestSTH.TreeSize < status.Position() {
+		return 0
+	}
+	return (&BacklogInfo{LatestSTH: status.LatestSTH, Position: status.Position()}).Backlog()
+}
+
+// LoadStatus returns the status of every log in the log list at
+// logListSource, sorted by URL.  It only reads from state.
+func LoadStatus(ctx context.Context, state StateProvider, logListSource string) ([]*LogStatus, error) {
+	logs, _, _, err := getLogList(ctx, logListSource, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error loading log list: %w", err)
+	}
+	statuses := make([]*LogStatus, 0, len(logs))
+	for logID, ctlog := range logs {
+		status := &LogStatus{Log: ctlog}
+		if status.State, err = state.LoadLogState(ctx, logID); err != nil {
+			return nil, fmt.Errorf("error loading state of %s: %w", ctlog.URL, err)
+		}
+		if status.State != nil {
+			sths, err := state.LoadSTHs(ctx, logID)
+			if err != nil {
+				return nil, fmt.Errorf("error loading STHs of %s: %w", ctlog.URL, err)
+			}
+			status.LatestSTH = status.State.VerifiedSTH
+			if len(sths) > 0 && (status.LatestSTH == nil || sths[len(sths)-1].TreeSize > status.LatestSTH.TreeSize) {
+				status.LatestSTH = sths[len(sths)-1]
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b *LogStatus) int { return strings.Compare(a.Log.URL, b.Log.URL) })
+	return statuses, nil
+}
@@ -0,0 +1,26 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+// SyslogWriter is implemented by *syslog.Writer from the log/syslog package.
+type SyslogWriter interface {
+	Warning(message string) error
+	Notice(message string) error
+}
+
+// sendSyslog sends the notification's summary to s.Syslog, at warning
+// priority if it's about a problem (such as a health check failure) and at
+// notice priority otherwise (such as a discovered certificate).
+func sendSyslog(s *FilesystemState, notif *notification) error {
+	if notif.isWarning() {
+		return s.Syslog.Warning(notif.summary)
+	}
+	return s.Syslog.Notice(notif.summary)
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Teams rejects messages larger than 28KB, so keep well under that
+	teamsMaxTextLength = 16000
+	teamsMaxNames      = 50
+)
+
+// teamsMessage is a message containing an Adaptive Card, which is accepted
+// by both Teams incoming webhooks and Workflows webhooks.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Body    []teamsElement `json:"body"`
+	Actions []teamsAction  `json:"actions,omitempty"`
+	MSTeams map[string]any `json:"msteams,omitempty"`
+}
+
+type teamsElement struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	Size     string      `json:"size,omitempty"`
+	Weight   string      `json:"weight,omitempty"`
+	FontType string      `json:"fontType,omitempty"`
+	Wrap     bool        `json:"wrap,omitempty"`
+	Facts    []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type teamsAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func makeTeamsMessage(notif *notification) *teamsMessage {
+	card := teamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    []teamsElement{{Type: "TextBlock", Text: truncate(notif.summary, teamsMaxTextLength), Size: "Medium", Weight: "Bolder", Wrap: true}},
+		MSTeams: map[string]any{"width": "Full"},
+	}
+	if notif.cert != nil {
+		card.Body = append(card.Body, teamsElement{Type: "FactSet", Facts: teamsCertFacts(notif.cert)})
+		card.Actions = []teamsAction{{Type: "Action.OpenUrl", Title: "View on crt.sh", URL: "https://crt.sh/?sha256=" + hex.EncodeToString(notif.cert.SHA256[:])}}
+	} else {
+		card.Body = append(card.Body, teamsElement{Type: "TextBlock", Text: truncate(notif.text, teamsMaxTextLength), FontType: "Monospace", Wrap: true})
+	}
+	return &teamsMessage{
+		Type:        "message",
+		Attachments: []teamsAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}},
+	}
+}
+
+// teamsNames returns the certificate's DNS names and IP addresses, one per
+// line, listing at most teamsMaxNames of them followed by a "+N more"
+// summary, so that certificates with huge SAN lists fit in a message.
+func teamsNames(cert *DiscoveredCert) string {
+	var names []string
+	names = append(names, cert.Identifiers.DNSNames...)
+	for _, ipaddr := range cert.Identifiers.IPAddrs {
+		names = append(names, ipaddr.String())
+	}
+	if len(names) > teamsMaxNames {
+		more := len(names) - teamsMaxNames
+		names = append(names[:teamsMaxNames], fmt.Sprintf("+%d more", more))
+	}
+	return truncate(strings.Join(names, "\n\n"), teamsMaxTextLength)
+}
+
+func teamsCertFacts(cert *DiscoveredCert) []teamsFact {
+	var issuer, notBefore, notAfter string
+	if cert.Info.IssuerParseError == nil {
+		issuer = cert.Info.Issuer.String()
+	} else {
+		issuer = fmt.Sprintf("[unable to parse: %s]", cert.Info.IssuerParseError)
+	}
+	if cert.Info.ValidityParseError == nil {
+		notBefore = cert.Info.Validity.NotBefore.UTC().Format(time.RFC3339)
+		notAfter = cert.Info.Validity.NotAfter.UTC().Format(time.RFC3339)
+	} else {
+		notBefore = fmt.Sprintf("[unable to parse: %s]", cert.Info.ValidityParseError)
+		notAfter = notBefore
+	}
+	return []teamsFact{
+		{Title: "Domains", Value: teamsNames(cert)},
+		{Title: "Issuer", Value: issuer},
+		{Title: "Not Before", Value: notBefore},
+		{Title: "Not After", Value: notAfter},
+		{Title: "SHA-256", Value: hex.EncodeToString(cert.SHA256[:])},
+	}
+}
+
+// sendTeams posts the notification to the Microsoft Teams webhook at
+// s.TeamsWebhookURL.
+func sendTeams(ctx context.Context, s *FilesystemState, notif *notification) error {
+	body, err := json.Marshal(makeTeamsMessage(notif))
+	if err != nil {
+		return fmt.Errorf("error encoding Teams message: %w", err)
+	}
+	if err := postWithRetries(ctx, s.TeamsWebhookURL, body, nil); err != nil {
+		return fmt.Errorf("error posting to Teams: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const telegramMaxMessageLength = 4096
+
+// telegramAPIURL is the base URL of the Telegram Bot API; tests change it.
+var telegramAPIURL = "https://api.telegram.org"
+
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// telegramEscape escapes the characters which have special meaning in
+// Telegram's MarkdownV2 format.
+func telegramEscape(text string) string {
+	var escaped strings.Builder
+	for _, c := range text {
+		if strings.ContainsRune("\\_*[]()~`>#+-=|{}.!", c) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
+// telegramEscapeCode escapes the characters which have special meaning in a
+// MarkdownV2 code block.
+func telegramEscapeCode(text string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+}
+
+// makeTelegramMessages formats the notification as one or more MarkdownV2
+// messages, each no longer than telegramMaxMessageLength.
+func makeTelegramMessages(notif *notification) []string {
+	header := "*" + telegramEscape(notif.summary) + "*\n"
+	if notif.cert == nil {
+		return splitTelegramMessage(header, strings.Split(strings.TrimRight(notif.text, "\n"), "\n"), telegramEscapeCode, "```\n", "\n```")
+	}
+	cert := notif.cert
+
+	var issuer string
+	if cert.Info.IssuerParseError == nil {
+		issuer = cert.Info.Issuer.String()
+	} else {
+		issuer = fmt.Sprintf("[unable to parse: %s]", cert.Info.IssuerParseError)
+	}
+	var lines []string
+	lines = append(lines, cert.Identifiers.DNSNames...)
+	for _, ipaddr := range cert.Identifiers.IPAddrs {
+		lines = append(lines, ipaddr.String())
+	}
+	lines = append(lines,
+		"",
+		"Issuer: "+issuer,
+		fmt.Sprintf("Log Entry: %d @ %s", cert.LogEntry.Index, cert.LogEntry.Log.URL),
+	)
+	crtshURL := "https://crt.sh/?sha256=" + hex.EncodeToString(cert.SHA256[:])
+	return splitTelegramMessage(header, lines, telegramEscape, "", "\n["+telegramEscape("View on crt.sh")+"]("+crtshURL+")")
+}
+
+// splitTelegramMessage packs lines into as few messages as possible, each of
+// which begins with header and encloses its lines in prefix and suffix.
+// Lines are escaped using escape; lines which are too long to fit in a
+// message by themselves are truncated.
+func splitTelegramMessage(header string, lines []string, escape func(string) string, prefix string, suffix string) []string {
+	overhead := len(header) + len(prefix) + len(suffix)
+	// Escaping at most doubles the length of a line
+	maxLineLength := (telegramMaxMessageLength - overhead) / 2
+
+	var messages []string
+	var body strings.Builder
+	flush := func() {
+		messages = append(messages, header+prefix+body.String()+suffix)
+		body.Reset()
+	}
+	for _, line := range lines {
+		escaped := escape(truncate(line, maxLineLength))
+		if body.Len() > 0 && overhead+body.Len()+1+len(escaped) > telegramMaxMessageLength {
+			flush()
+		}
+		if body.Len() > 0 {
+			body.WriteByte('\n')
+		}
+		body.WriteString(escaped)
+	}
+	flush()
+	return messages
+}
+
+// sendTelegram sends the notification to s.TelegramChatID using the Telegram
+// Bot API with the bot token s.TelegramBotToken.
+func sendTelegram(ctx context.Context, s *FilesystemState, notif *notification) error {
+	endpoint := telegramAPIURL + "/bot" + s.TelegramBotToken + "/sendMessage"
+	for _, text := range makeTelegramMessages(notif) {
+		body, err := json.Marshal(&telegramMessage{
+			ChatID:                s.TelegramChatID,
+			Text:                  text,
+			ParseMode:             "MarkdownV2",
+			DisableWebPagePreview: true,
+		})
+		if err != nil {
+			return fmt.Errorf("error encoding Telegram message: %w", err)
+		}
+		if err := postWithRetries(ctx, endpoint, body, nil); err != nil {
+			// Don't include the URL, which contains the bot token
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("error sending Telegram message: %w", err)
+		}
+	}
+	return nil
+}
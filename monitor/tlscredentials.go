@@ -0,0 +1,147 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+)
+
+// TLSCredentials are a TLS client certificate and a bundle of trusted CA
+// certificates, loaded from files, which can be reloaded while connections
+// are being made, so that short-lived certificates can be rotated without
+// restarting certspotter.  Connections made after Reload returns use the
+// new credentials; connections in progress are unaffected.  The server's
+// public key may also be pinned.
+type TLSCredentials struct {
+	certFile string // empty if no client certificate is presented
+	keyFile  string
+	caFile   string     // empty if the system roots are trusted
+	pins     [][32]byte // SHA-256 hashes of the SPKIs which the server's certificate may have; empty if any
+
+	current atomic.Pointer[loadedTLSCredentials]
+}
+
+type loadedTLSCredentials struct {
+	cert  *tls.Certificate // nil if certFile is empty
+	roots *x509.CertPool   // nil if caFile is empty
+}
+
+// LoadTLSCredentials loads a PEM-encoded client certificate and private key
+// from certFile and keyFile, and a PEM-encoded CA bundle from caFile.
+// certFile and keyFile must both be empty if no client certificate is to be
+// presented, and caFile may be empty to trust the system roots.  If pins is
+// non-empty, the SHA-256 hash of the server certificate's
+// SubjectPublicKeyInfo must be one of them, in addition to the certificate
+// being trusted.
+func LoadTLSCredentials(certFile, keyFile, caFile string, pins [][32]byte) (*TLSCredentials, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("the client certificate and key must be specified together")
+	}
+	creds := &TLSCredentials{certFile: certFile, keyFile: keyFile, caFile: caFile, pins: pins}
+	if err := creds.Reload(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// Reload loads the credentials from their files again.  If any of them
+// can't be loaded, the previous credentials remain in use.
+func (creds *TLSCredentials) Reload() error {
+	loaded := new(loadedTLSCredentials)
+	if creds.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(creds.certFile, creds.keyFile)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate: %w", err)
+		}
+		loaded.cert = &cert
+	}
+	if creds.caFile != "" {
+		pem, err := os.ReadFile(creds.caFile)
+		if err != nil {
+			return fmt.Errorf("error loading CA bundle: %w", err)
+		}
+		loaded.roots = x509.NewCertPool()
+		if !loaded.roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("error loading CA bundle: %s contains no PEM-encoded certificates", creds.caFile)
+		}
+	}
+	creds.current.Store(loaded)
+	return nil
+}
+
+// tlsConfig returns a TLS configuration which uses the credentials that are
+// current when each connection is made.  The server's certificate is
+// verified against the name to which each connection is made, or against
+// defaultServerName if the connection is made to an IP address.
+func (creds *TLSCredentials) tlsConfig(defaultServerName string) *tls.Config {
+	config := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := creds.current.Load().cert; cert != nil {
+				return cert, nil
+			}
+			return new(tls.Certificate), nil // no certificate
+		},
+	}
+	if creds.caFile != "" || len(creds.pins) > 0 {
+		// The server's certificate is verified by VerifyConnection
+		// instead, so that the CA bundle can be reloaded
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			serverName := state.ServerName
+			if serverName == "" {
+				serverName = defaultServerName
+			}
+			if err := verifyServerCertificate(state, serverName, creds.current.Load().roots); err != nil {
+				return err
+			}
+			return verifyServerPin(state, creds.pins)
+		}
+	}
+	return config
+}
+
+// verifyServerCertificate verifies the server's certificate chain and name
+// the way crypto/tls does when InsecureSkipVerify is false, but using the
+// given roots (or the system roots if nil).
+func verifyServerCertificate(state tls.ConnectionState, serverName string, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// verifyServerPin checks that the SHA-256 hash of the SubjectPublicKeyInfo
+// of the server's certificate is one of pins, unless pins is empty.
+func verifyServerPin(state tls.ConnectionState, pins [][32]byte) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	pin := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	if !slices.Contains(pins, pin) {
+		return fmt.Errorf("server's public key (SHA-256 %x) is not pinned", pin)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// validityPeriod returns the length of the certificate's validity period.
+// As in the CA/Browser Forum Baseline Requirements, the period includes both
+// the notBefore and notAfter times, so it is one second longer than the
+// difference between them.
+func validityPeriod(validity *certspotter.CertValidity) time.Duration {
+	return validity.NotAfter.Sub(validity.NotBefore) + time.Second
+}
+
+// validityDays returns the length of the certificate's validity period in
+// days, rounded up.
+func validityDays(validity *certspotter.CertValidity) int {
+	const day = 24 * time.Hour
+	return int((validityPeriod(validity) + day - 1) / day)
+}
+
+// exceedsMaxValidity reports whether the validity period is longer than
+// maxDays days.  Days are always 86400 seconds, regardless of leap years.
+func exceedsMaxValidity(validity *certspotter.CertValidity, maxDays int) bool {
+	return validityPeriod(validity) > time.Duration(maxDays)*24*time.Hour
+}
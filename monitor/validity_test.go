@@ -0,0 +1,66 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func TestExceedsMaxValidity(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	}
+	tests := []struct {
+		notBefore, notAfter time.Time
+		days                int
+		exceeds             bool
+	}{
+		// Exactly 398 days, including both endpoints
+		{date(2023, 1, 1, 0, 0, 0), date(2024, 2, 2, 23, 59, 59), 398, false},
+		// One second longer
+		{date(2023, 1, 1, 0, 0, 0), date(2024, 2, 3, 0, 0, 0), 398, true},
+		// The same calendar dates span 398 days in a non-leap year, but
+		// 399 days when the period includes February 29
+		{date(2022, 2, 1, 0, 0, 0), date(2023, 3, 5, 23, 59, 59), 398, false},
+		{date(2024, 2, 1, 0, 0, 0), date(2025, 3, 5, 23, 59, 59), 398, true},
+		{date(2024, 2, 1, 0, 0, 0), date(2025, 3, 4, 23, 59, 59), 398, false},
+		// A common CA practice: notAfter is notBefore plus 398 days, without
+		// subtracting a second, which exceeds the maximum
+		{date(2024, 2, 28, 12, 0, 0), date(2025, 4, 1, 12, 0, 0), 398, true},
+		// 90-day certificate, as issued by Let's Encrypt
+		{date(2024, 2, 28, 12, 0, 0), date(2024, 5, 28, 11, 59, 59), 90, false},
+		{date(2024, 2, 28, 12, 0, 0), date(2024, 5, 28, 11, 59, 59), 89, true},
+		// notAfter before notBefore
+		{date(2024, 1, 1, 0, 0, 0), date(2023, 1, 1, 0, 0, 0), 1, false},
+	}
+	for _, test := range tests {
+		validity := &certspotter.CertValidity{NotBefore: test.notBefore, NotAfter: test.notAfter}
+		if got := exceedsMaxValidity(validity, test.days); got != test.exceeds {
+			t.Errorf("exceedsMaxValidity(%s to %s, %d) = %v, want %v", test.notBefore, test.notAfter, test.days, got, test.exceeds)
+		}
+	}
+}
+
+func TestValidityDays(t *testing.T) {
+	validity := &certspotter.CertValidity{
+		NotBefore: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2025, 3, 4, 23, 59, 59, 0, time.UTC),
+	}
+	if got := validityDays(validity); got != 398 {
+		t.Errorf("validityDays = %d, want 398", got)
+	}
+	validity.NotAfter = validity.NotAfter.Add(time.Second)
+	if got := validityDays(validity); got != 399 {
+		t.Errorf("validityDays = %d, want 399", got)
+	}
+}
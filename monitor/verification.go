@@ -0,0 +1,60 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+)
+
+// VerificationMode controls how downloaded entries are verified against the
+// log's signed tree heads.
+type VerificationMode int
+
+const (
+	// Verify the downloaded entries against every STH.
+	VerifyFull VerificationMode = iota
+
+	// Verify the downloaded entries against only the newest STH in each
+	// batch of downloaded entries.  Since the newest STH covers every
+	// entry, a log which returns incorrect entries is still detected, but
+	// a log which presents inconsistent intermediate STHs is not.
+	VerifySTH
+
+	// Do not verify downloaded entries at all.  A log (or proxy) could
+	// return entries which are not in the log, or omit entries which are,
+	// and certspotter would not detect it.
+	VerifyNone
+)
+
+func ParseVerificationMode(str string) (VerificationMode, error) {
+	switch str {
+	case "", "full":
+		return VerifyFull, nil
+	case "sth":
+		return VerifySTH, nil
+	case "none":
+		return VerifyNone, nil
+	default:
+		return VerifyFull, fmt.Errorf("invalid verification mode %q (must be full, sth, or none)", str)
+	}
+}
+
+// verifies reports whether an STH should be verified; newest is true if the
+// STH is the newest in the current batch.
+func (mode VerificationMode) verifies(newest bool) bool {
+	switch mode {
+	case VerifyFull:
+		return true
+	case VerifySTH:
+		return newest
+	default:
+		return false
+	}
+}
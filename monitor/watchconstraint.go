@@ -0,0 +1,179 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// A watchConstraint is an additional condition, besides the domain, which a
+// certificate must satisfy to match a watch list item.  issuer and subject
+// constraints match a case-insensitive substring of the whole DN, so they use
+// the ~ ("contains") and !~ ("does not contain") operators rather than = and
+// !=.  A value containing whitespace can be enclosed in double quotes.
+//
+// For fail-safe behavior, a constraint of an item which reports certificates
+// is satisfied if the part of the certificate that it examines cannot be
+// parsed, while a constraint of an exclusion is not.
+type watchConstraint struct {
+	field string // "issuer", "subject", or "validity"
+	op    string // "~" or "!~" for issuer and subject; "<" or ">" for validity
+	value string // lower-cased and unquoted, for issuer and subject
+	dur   time.Duration
+	str   string // original text of the constraint
+}
+
+var constraintOps = []string{"!~", "~", "!=", "=", "<", ">"}
+
+func parseWatchConstraint(str string) (watchConstraint, bool, error) {
+	for _, op := range constraintOps {
+		field, value, found := strings.Cut(str, op)
+		if !found {
+			continue
+		}
+		c := watchConstraint{field: field, op: op, str: str}
+		switch field {
+		case "issuer", "subject":
+			if op == "=" || op == "!=" {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: %s matches a substring of the DN, so use %s~ (contains) or %s!~ (does not contain)", str, field, field, field)
+			} else if op != "~" && op != "!~" {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: %s supports only ~ and !~", str, field)
+			}
+			value, err := unquoteConstraintValue(value)
+			if err != nil {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: %w", str, err)
+			}
+			if value == "" {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: empty value", str)
+			}
+			c.value = strings.ToLower(value)
+		case "validity":
+			if op != "<" && op != ">" {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: validity supports only < and >", str)
+			}
+			dur, err := parseConstraintDuration(value)
+			if err != nil {
+				return watchConstraint{}, true, fmt.Errorf("invalid constraint %q: %w", str, err)
+			}
+			c.dur = dur
+		default:
+			return watchConstraint{}, false, nil
+		}
+		return c, true, nil
+	}
+	return watchConstraint{}, false, nil
+}
+
+// unquoteConstraintValue removes the double quotes, if any, enclosing value.
+func unquoteConstraintValue(value string) (string, error) {
+	if !strings.Contains(value, `"`) {
+		return value, nil
+	}
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) || strings.Contains(value[1:len(value)-1], `"`) {
+		return "", fmt.Errorf("value must be enclosed in a single pair of double quotes")
+	}
+	return value[1 : len(value)-1], nil
+}
+
+// splitWatchItemFields splits str around whitespace, like strings.Fields,
+// except that whitespace between double quotes does not split fields.  The
+// quotes are kept.
+func splitWatchItemFields(str string) ([]string, error) {
+	var (
+		fields  []string
+		field   strings.Builder
+		inField bool
+		quoted  bool
+	)
+	for _, r := range str {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			field.WriteRune(r)
+			inField = true
+		case unicode.IsSpace(r) && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated double quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// parseConstraintDuration parses a number of days (e.g. "90d") or a Go
+// duration (e.g. "36h").
+func parseConstraintDuration(str string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(str, "d"); found {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
+
+func (c watchConstraint) String() string {
+	return c.str
+}
+
+// satisfiedBy reports whether info satisfies the constraint.  unparsable is
+// returned if the part of info that the constraint examines cannot be parsed.
+func (c watchConstraint) satisfiedBy(info *certspotter.CertInfo, unparsable bool) bool {
+	switch c.field {
+	case "issuer":
+		if info.IssuerParseError != nil {
+			return unparsable
+		}
+		return c.matchesDN(info.Issuer)
+	case "subject":
+		if info.SubjectParseError != nil {
+			return unparsable
+		}
+		return c.matchesDN(info.Subject)
+	case "validity":
+		if info.ValidityParseError != nil {
+			return unparsable
+		}
+		validity := info.Validity.NotAfter.Sub(info.Validity.NotBefore)
+		if c.op == "<" {
+			return validity < c.dur
+		} else {
+			return validity > c.dur
+		}
+	default:
+		return true
+	}
+}
+
+func (c watchConstraint) matchesDN(dn certspotter.RDNSequence) bool {
+	contains := strings.Contains(strings.ToLower(dn.String()), c.value)
+	if c.op == "!~" {
+		return !contains
+	}
+	return contains
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const exclusionPrefix = "!"
+
+func parseExclusion(str string) (WatchItem, error) {
+	item, err := ParseWatchItem(str)
+	if err != nil {
+		return WatchItem{}, err
+	} else if item.Type() != "dns_name" || item.exclude {
+		return WatchItem{}, fmt.Errorf("invalid exclusion %q: only DNS names can be excluded", exclusionPrefix+str)
+	}
+	item.exclude = true
+	return item, nil
+}
+
+// coversDNSName reports whether every name which dnsName could represent is
+// within the item's domain.  Unlike matchesDNSName, which is fail-safe in
+// favor of matching, this is fail-safe in favor of not excluding: a wildcard,
+// redacted, or unparsable label only counts as covered if it's to the left
+// of a suffix item's domain.  For example, .dev.example.com covers
+// *.dev.example.com, but not *.example.com.  A wildcard item covers only
+// names whose left-most label contains a wildcard, so *.dev.example.com
+// covers f*.dev.example.com, but not ?.dev.example.com.
+func (item WatchItem) coversDNSName(dnsName []string) bool {
+	if item.wildcard {
+		if len(dnsName) == 0 || !strings.Contains(dnsName[0], "*") {
+			return false
+		}
+		dnsName = dnsName[1:]
+	}
+	if len(dnsName) < len(item.domain) {
+		return false
+	}
+	if !item.acceptSuffix && !item.wildcard && len(dnsName) != len(item.domain) {
+		return false
+	}
+	return slices.Equal(dnsName[len(dnsName)-len(item.domain):], item.domain)
+}
+
+// excluded reports whether the DNS name is covered by an exclusion whose
+// constraints are all satisfied by info.
+func (list *WatchList) excluded(labels []string, info *certspotter.CertInfo) bool {
+	for _, item := range list.exclusions {
+		if item.coversDNSName(labels) && item.satisfiedBy(info) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// parseIPPrefix parses an IP address watch item, which is either a CIDR
+// range (e.g. 192.0.2.0/24 or 2001:db8::/32) or a single IP address.  ok is
+// false if str is neither, in which case it should be parsed as a domain.
+// Since domains can't contain slashes or colons, an error is returned if str
+// contains one but isn't a valid range or address.
+func parseIPPrefix(str string) (prefix netip.Prefix, ok bool, err error) {
+	if strings.Contains(str, "/") {
+		prefix, err := netip.ParsePrefix(str)
+		if err != nil {
+			return netip.Prefix{}, false, fmt.Errorf("invalid IP address range %q: %w", str, err)
+		}
+		return prefix.Masked(), true, nil
+	}
+	addr, err := netip.ParseAddr(str)
+	if err == nil && addr.Zone() != "" {
+		err = fmt.Errorf("zones are not allowed")
+	}
+	if err != nil {
+		if strings.Contains(str, ":") {
+			return netip.Prefix{}, false, fmt.Errorf("invalid IP address %q: %w", str, err)
+		}
+		return netip.Prefix{}, false, nil
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), true, nil
+}
+
+func (item WatchItem) isIPItem() bool {
+	return item.ipPrefix.IsValid()
+}
+
+func (item WatchItem) ipPrefixString() string {
+	if item.ipPrefix.IsSingleIP() {
+		return item.ipPrefix.Addr().String()
+	}
+	return item.ipPrefix.String()
+}
+
+// matchesIPAddr reports whether ipaddr is within the item's range.  IPv4
+// addresses match IPv4 ranges even if the certificate encodes them as
+// IPv4-mapped IPv6 addresses.
+func (item WatchItem) matchesIPAddr(ipaddr net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ipaddr)
+	return ok && item.ipPrefix.Contains(addr.Unmap())
+}
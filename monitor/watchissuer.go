@@ -0,0 +1,74 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const issuerItemPrefix = "issuer:"
+
+// An issuerAttribute is one attribute (e.g. CN=Some CA) of an issuer watch
+// item, which matches certificates whose issuer DN contains every one of the
+// item's attributes, regardless of the certificate's DNS names.
+type issuerAttribute struct {
+	label string // e.g. "CN", as used by certspotter.RDNSequence.String
+	value string
+}
+
+func (attr issuerAttribute) String() string {
+	return attr.label + "=" + attr.value
+}
+
+// parseIssuerItem parses the part of an issuer watch item after "issuer:",
+// which is a comma-separated list of LABEL=VALUE attributes.
+func parseIssuerItem(str string) (WatchItem, error) {
+	var attrs []issuerAttribute
+	for _, field := range strings.Split(str, ",") {
+		label, value, found := strings.Cut(field, "=")
+		label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		if !found || label == "" || value == "" {
+			return WatchItem{}, fmt.Errorf("invalid issuer %q: each attribute must have the form LABEL=VALUE", str)
+		}
+		attrs = append(attrs, issuerAttribute{label: label, value: value})
+	}
+	return WatchItem{issuer: attrs}, nil
+}
+
+// matchesIssuer reports whether the issuer DN contains all of the item's
+// attributes (ignoring case).  For fail-safe behavior, an issuer that can't
+// be parsed matches.
+func (item WatchItem) matchesIssuer(info *certspotter.CertInfo) bool {
+	if info.IssuerParseError != nil {
+		return true
+	}
+	for _, attr := range item.issuer {
+		values, err := info.Issuer.ParseAttributes(attr.label)
+		if err != nil {
+			return true
+		}
+		if !containsFold(values, attr.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
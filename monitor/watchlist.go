@@ -12,33 +12,233 @@ package monitor
 import (
 	"bufio"
 	"fmt"
-	"golang.org/x/net/idna"
 	"io"
-	"software.sslmate.com/src/certspotter"
+	"net/netip"
 	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+	"software.sslmate.com/src/certspotter"
 )
 
 type WatchItem struct {
 	domain       []string
 	acceptSuffix bool
+	wildcard     bool              // the item matches only wildcard DNS names for domain or its sub-domains
+	constraints  []watchConstraint // all must be satisfied for the item to match
+	issuer       []issuerAttribute // if non-nil, the item matches by issuer instead of domain
+	exclude      bool              // the item excludes DNS names which would otherwise match
+	ipPrefix     netip.Prefix      // if valid, the item matches IP addresses in this range instead of a domain
+	spki         *[32]byte         // if non-nil, the item matches by SubjectPublicKeyInfo SHA-256 instead of domain
+	serial       string            // if non-empty, the item matches by serial number (formatted by serialString) instead of domain
+}
+
+// WatchList is a list of WatchItems, indexed for fast matching.  Exact items
+// are kept in a hash set and suffix items in a trie, so matching an ordinary
+// DNS name takes time proportional to the number of labels in the name rather
+// than the size of the list.  DNS names containing wildcards, redacted labels,
+// or unparsable labels fall back to a linear scan of the list, which is also
+// the only way wildcard items (e.g. *.example.com) are matched, since they
+// never match other DNS names.  Issuer items are checked against every
+// certificate, IP address items against every IP address, SPKI items are
+// kept in a hash set keyed by SubjectPublicKeyInfo SHA-256, and serial number
+// items in a hash set keyed by serial number.  Exclusions are kept separately
+// from the items and are checked only for DNS names which match an item.
+//
+// A nil *WatchList is an empty list, which matches nothing.
+//
+// WatchList used to be a []WatchItem; use NewWatchList to create a
+// WatchList from a slice of items, and Items to get them back.
+type WatchList struct {
+	mu          sync.RWMutex // protects the following fields, which Replace swaps
+	items       []WatchItem
+	exact       map[string][]int // maps DNS name to indices of exact items, in ascending order
+	suffix      *suffixTrie
+	issuers     []int              // indices of issuer items, in ascending order
+	ipItems     []int              // indices of IP address items, in ascending order
+	spkiItems   map[[32]byte][]int // maps SPKI hash to indices of SPKI items, in ascending order
+	serialItems map[string][]int   // maps serial number to indices of serial number items, in ascending order
+	exclusions  []WatchItem
+}
+
+type suffixTrie struct {
+	items    []int // indices of suffix items ending at this node, in ascending order
+	children map[string]*suffixTrie
+}
+
+func newSuffixTrie() *suffixTrie {
+	return &suffixTrie{}
+}
+
+// firstAccepted returns the first index in indices for which accept returns
+// true, or -1.  A nil accept accepts every index.
+func firstAccepted(indices []int, accept func(int) bool) int {
+	for _, index := range indices {
+		if accept == nil || accept(index) {
+			return index
+		}
+	}
+	return -1
+}
+
+func (trie *suffixTrie) insert(domain []string, index int) {
+	node := trie
+	for i := len(domain) - 1; i >= 0; i-- {
+		child, ok := node.children[domain[i]]
+		if !ok {
+			child = newSuffixTrie()
+			if node.children == nil {
+				node.children = make(map[string]*suffixTrie)
+			}
+			node.children[domain[i]] = child
+		}
+		node = child
+	}
+	node.items = append(node.items, index)
+}
+
+// lookup returns the lowest index of any accepted suffix item matching dnsName, or -1
+func (trie *suffixTrie) lookup(dnsName []string, accept func(int) bool) int {
+	best := firstAccepted(trie.items, accept)
+	node := trie
+	for i := len(dnsName) - 1; i >= 0; i-- {
+		node = node.children[dnsName[i]]
+		if node == nil {
+			break
+		}
+		if index := firstAccepted(node.items, accept); index != -1 && (best == -1 || index < best) {
+			best = index
+		}
+	}
+	return best
+}
+
+// NewWatchList returns a WatchList containing the given items and exclusions.
+func NewWatchList(items []WatchItem) *WatchList {
+	list := &WatchList{
+		exact:       make(map[string][]int),
+		suffix:      newSuffixTrie(),
+		spkiItems:   make(map[[32]byte][]int),
+		serialItems: make(map[string][]int),
+	}
+	for _, item := range items {
+		if item.exclude {
+			list.exclusions = append(list.exclusions, item)
+		} else {
+			list.items = append(list.items, item)
+		}
+	}
+	for i, item := range list.items {
+		if item.issuer != nil {
+			list.issuers = append(list.issuers, i)
+		} else if item.isIPItem() {
+			list.ipItems = append(list.ipItems, i)
+		} else if item.spki != nil {
+			list.spkiItems[*item.spki] = append(list.spkiItems[*item.spki], i)
+		} else if item.serial != "" {
+			list.serialItems[item.serial] = append(list.serialItems[item.serial], i)
+		} else if item.wildcard {
+			// Matched only by linearMatch
+		} else if item.acceptSuffix {
+			list.suffix.insert(item.domain, i)
+		} else {
+			list.exact[item.String()] = append(list.exact[item.String()], i)
+		}
+	}
+	return list
+}
+
+// MergeWatchLists returns a WatchList containing the items and exclusions of
+// every list, in order.  Items which are identical to an earlier item,
+// including their constraints, are omitted.
+func MergeWatchLists(lists ...*WatchList) *WatchList {
+	var items []WatchItem
+	seen := make(map[string]bool)
+	add := func(item WatchItem) {
+		key := strings.Join(append([]string{item.String()}, item.Constraints()...), " ")
+		if !seen[key] {
+			seen[key] = true
+			items = append(items, item)
+		}
+	}
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		list.mu.RLock()
+		for _, item := range list.items {
+			add(item)
+		}
+		for _, item := range list.exclusions {
+			add(item)
+		}
+		list.mu.RUnlock()
+	}
+	return NewWatchList(items)
 }
 
-type WatchList []WatchItem
+// Items returns the items in the watch list, not including exclusions.  The
+// slice must not be modified.
+func (list *WatchList) Items() []WatchItem {
+	if list == nil {
+		return nil
+	}
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	return list.items
+}
+
+// Replace atomically replaces the contents of list with the contents of
+// other, which must not be used afterwards.  Concurrent calls to Matches see
+// either the old or the new contents, never a mix.
+func (list *WatchList) Replace(other *WatchList) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.items, list.exact, list.suffix, list.issuers, list.ipItems, list.spkiItems, list.serialItems, list.exclusions = other.items, other.exact, other.suffix, other.issuers, other.ipItems, other.spkiItems, other.serialItems, other.exclusions
+}
 
 func ParseWatchItem(str string) (WatchItem, error) {
-	fields := strings.Fields(str)
+	if excluded, found := strings.CutPrefix(str, exclusionPrefix); found {
+		return parseExclusion(excluded)
+	} else if issuer, found := strings.CutPrefix(str, issuerItemPrefix); found {
+		return parseIssuerItem(issuer)
+	} else if spki, found := strings.CutPrefix(str, spkiItemPrefix); found {
+		return parseSPKIItem(spki)
+	} else if serial, found := strings.CutPrefix(str, serialItemPrefix); found {
+		return parseSerialItem(serial)
+	}
+	fields, err := splitWatchItemFields(str)
+	if err != nil {
+		return WatchItem{}, err
+	}
 	if len(fields) == 0 {
 		return WatchItem{}, fmt.Errorf("empty domain")
 	}
 	domain := fields[0]
 
+	var constraints []watchConstraint
 	for _, field := range fields[1:] {
-		switch {
-		case strings.HasPrefix(field, "valid_at:"):
+		if strings.HasPrefix(field, "valid_at:") {
 			// Ignore for backwards compatibility
-		default:
+			continue
+		}
+		constraint, ok, err := parseWatchConstraint(field)
+		if err != nil {
+			return WatchItem{}, err
+		} else if !ok {
 			return WatchItem{}, fmt.Errorf("unknown parameter %q", field)
 		}
+		constraints = append(constraints, constraint)
+	}
+
+	if prefix, ok, err := parseIPPrefix(domain); err != nil {
+		return WatchItem{}, err
+	} else if ok {
+		return WatchItem{
+			ipPrefix:    prefix,
+			constraints: constraints,
+		}, nil
 	}
 
 	if domain == "." {
@@ -46,27 +246,51 @@ func ParseWatchItem(str string) (WatchItem, error) {
 		return WatchItem{
 			domain:       []string{},
 			acceptSuffix: true,
+			constraints:  constraints,
 		}, nil
 	}
 
 	acceptSuffix := false
+	wildcard := false
 	if strings.HasPrefix(domain, ".") {
 		acceptSuffix = true
 		domain = domain[1:]
+	} else if strings.HasPrefix(domain, "*.") {
+		wildcard = true
+		domain = domain[2:]
 	}
 
-	asciiDomain, err := idna.ToASCII(strings.ToLower(strings.TrimRight(domain, ".")))
+	asciiDomain, err := domainToASCII(strings.TrimRight(domain, "."))
 	if err != nil {
-		return WatchItem{}, fmt.Errorf("invalid domain %q (%w)", domain, err)
+		return WatchItem{}, fmt.Errorf("invalid domain %q: %w", domain, err)
 	}
 	return WatchItem{
 		domain:       strings.Split(asciiDomain, "."),
 		acceptSuffix: acceptSuffix,
+		wildcard:     wildcard,
+		constraints:  constraints,
 	}, nil
 }
 
-func ReadWatchList(reader io.Reader) (WatchList, error) {
-	items := make(WatchList, 0, 50)
+// domainToASCII converts domain to lower case, with IDN labels converted to
+// A-labels (Punycode), so that it can be compared to the DNS names in
+// certificates.  Unicode is mapped as by a web browser, so e.g. "Bücher",
+// "bücher" (in either Unicode normalization form), and "xn--bcher-kva" are all
+// converted to "xn--bcher-kva".  If domain isn't a valid IDN because it
+// contains characters which are valid in DNS but not in host names (such as
+// underscores), each Unicode label is converted without mapping instead.
+// An error is returned if that fails too (e.g. because of invalid Punycode).
+func domainToASCII(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err == nil {
+		return ascii, nil
+	}
+	zap.L().Debug("watch list domain is not a valid IDN; comparing it without IDNA mapping", zap.String("domain", domain), zap.Error(err))
+	return idna.ToASCII(strings.ToLower(domain))
+}
+
+func ReadWatchList(reader io.Reader) (*WatchList, error) {
+	items := make([]WatchItem, 0, 50)
 	scanner := bufio.NewScanner(reader)
 	lineNo := 0
 	for scanner.Scan() {
@@ -81,18 +305,88 @@ func ReadWatchList(reader io.Reader) (WatchList, error) {
 		}
 		items = append(items, item)
 	}
-	return items, scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWatchList(items), nil
 }
 
 func (item WatchItem) String() string {
-	if item.acceptSuffix {
+	if item.exclude {
+		included := item
+		included.exclude = false
+		return exclusionPrefix + included.String()
+	} else if item.issuer != nil {
+		attrs := make([]string, len(item.issuer))
+		for i, attr := range item.issuer {
+			attrs[i] = attr.String()
+		}
+		return issuerItemPrefix + strings.Join(attrs, ", ")
+	} else if item.isIPItem() {
+		return item.ipPrefixString()
+	} else if item.spki != nil {
+		return item.spkiString()
+	} else if item.serial != "" {
+		return item.serialString()
+	} else if item.acceptSuffix {
 		return "." + strings.Join(item.domain, ".")
+	} else if item.wildcard {
+		return "*." + strings.Join(item.domain, ".")
 	} else {
 		return strings.Join(item.domain, ".")
 	}
 }
 
+// Type returns what the item matches: "dns_name", "ip_address", "issuer",
+// "spki" (the certificate's public key), or "serial" (the certificate's serial
+// number).
+func (item WatchItem) Type() string {
+	switch {
+	case item.issuer != nil:
+		return "issuer"
+	case item.isIPItem():
+		return "ip_address"
+	case item.spki != nil:
+		return "spki"
+	case item.serial != "":
+		return "serial"
+	default:
+		return "dns_name"
+	}
+}
+
+// Constraints returns the item's constraints (e.g. "issuer!~DigiCert"), all of
+// which were satisfied if the item matched a certificate.
+func (item WatchItem) Constraints() []string {
+	strs := make([]string, len(item.constraints))
+	for i, constraint := range item.constraints {
+		strs[i] = constraint.String()
+	}
+	return strs
+}
+
+// satisfiedBy reports whether info satisfies all of the item's constraints.
+// A constraint examining a part of info which cannot be parsed is satisfied,
+// unless the item is an exclusion, so that no certificate that might be of
+// interest goes unreported.
+func (item WatchItem) satisfiedBy(info *certspotter.CertInfo) bool {
+	for _, constraint := range item.constraints {
+		if !constraint.satisfiedBy(info, !item.exclude) {
+			return false
+		}
+	}
+	return true
+}
+
 func (item WatchItem) matchesDNSName(dnsName []string) bool {
+	if item.wildcard {
+		// A wildcard item matches *.DOMAIN and more specific wildcards,
+		// such as f*.DOMAIN and *.sub.DOMAIN
+		if len(dnsName) == 0 || !isWildcardLabel(dnsName[0]) {
+			return false
+		}
+		dnsName = dnsName[1:]
+	}
 	watchDomain := item.domain
 	for len(dnsName) > 0 && len(watchDomain) > 0 {
 		certLabel := dnsName[len(dnsName)-1]
@@ -105,7 +399,16 @@ func (item WatchItem) matchesDNSName(dnsName []string) bool {
 		dnsName = dnsName[:len(dnsName)-1]
 		watchDomain = watchDomain[:len(watchDomain)-1]
 	}
-	return len(watchDomain) == 0 && (item.acceptSuffix || len(dnsName) == 0)
+	return len(watchDomain) == 0 && (item.acceptSuffix || item.wildcard || len(dnsName) == 0)
+}
+
+// isWildcardLabel reports whether a DNS name with label as its left-most label
+// is, or might be, a wildcard.  For fail-safe behavior, redacted and
+// unparsable labels might be wildcards.
+func isWildcardLabel(label string) bool {
+	return strings.Contains(label, "*") ||
+		label == "?" ||
+		label == certspotter.UnparsableDNSLabelPlaceholder
 }
 
 func dnsLabelMatches(certLabel string, watchLabel string) bool {
@@ -119,17 +422,97 @@ func dnsLabelMatches(certLabel string, watchLabel string) bool {
 		certspotter.MatchesWildcard(watchLabel, certLabel)
 }
 
-func (list WatchList) Matches(identifiers *certspotter.Identifiers) (bool, WatchItem) {
-	dnsNames := make([][]string, len(identifiers.DNSNames))
-	for i, dnsName := range identifiers.DNSNames {
-		dnsNames[i] = strings.Split(dnsName, ".")
+// hasPatternLabel reports whether dnsName contains a label which can match
+// more than one watch label, necessitating a linear scan of the watch list.
+func hasPatternLabel(dnsName []string) bool {
+	for _, label := range dnsName {
+		if label == "?" || label == certspotter.UnparsableDNSLabelPlaceholder || strings.Contains(label, "*") {
+			return true
+		}
 	}
-	for _, item := range list {
-		for _, dnsName := range dnsNames {
-			if item.matchesDNSName(dnsName) {
-				return true, item
-			}
+	return false
+}
+
+// Matches returns the first item which matches one of the identifiers
+// (DNS names or IP addresses), or the issuer, public key, or serial number in
+// info, and whose constraints are all satisfied by info.  DNS names which are
+// covered by an exclusion are ignored, even if they match an item which
+// appears before the exclusion.  Exclusions don't apply to issuer, IP address,
+// SPKI, or serial number items.
+func (list *WatchList) Matches(identifiers *certspotter.Identifiers, info *certspotter.CertInfo) (bool, WatchItem) {
+	matched, item, _ := list.Match(identifiers, info)
+	return matched, item
+}
+
+// Match is like Matches, but also returns the DNS name or IP address which
+// matched the item, or "" if the item matched by issuer, public key, or serial
+// number.  If the item matches more than one identifier, the first is returned.
+func (list *WatchList) Match(identifiers *certspotter.Identifiers, info *certspotter.CertInfo) (bool, WatchItem, string) {
+	if list == nil {
+		return false, WatchItem{}, ""
+	}
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	accept := func(index int) bool { return list.items[index].satisfiedBy(info) }
+	best := -1
+	bestIdentifier := ""
+	for _, dnsName := range identifiers.DNSNames {
+		labels := strings.Split(dnsName, ".")
+		var index int
+		if hasPatternLabel(labels) {
+			index = list.linearMatch(labels, accept)
+		} else {
+			index = list.indexedMatch(dnsName, labels, accept)
+		}
+		if index != -1 && list.excluded(labels, info) {
+			continue
+		}
+		if index != -1 && (best == -1 || index < best) {
+			best, bestIdentifier = index, dnsName
+		}
+	}
+	for _, ipaddr := range identifiers.IPAddrs {
+		matchesIPAddr := func(index int) bool { return list.items[index].matchesIPAddr(ipaddr) && accept(index) }
+		if index := firstAccepted(list.ipItems, matchesIPAddr); index != -1 && (best == -1 || index < best) {
+			best, bestIdentifier = index, ipaddr.String()
+		}
+	}
+	matchesIssuer := func(index int) bool { return list.items[index].matchesIssuer(info) && accept(index) }
+	if index := firstAccepted(list.issuers, matchesIssuer); index != -1 && (best == -1 || index < best) {
+		best, bestIdentifier = index, ""
+	}
+	if hash, ok := spkiSHA256(info); ok && len(list.spkiItems) > 0 {
+		if index := firstAccepted(list.spkiItems[hash], accept); index != -1 && (best == -1 || index < best) {
+			best, bestIdentifier = index, ""
+		}
+	}
+	if serial, ok := certSerial(info); ok && len(list.serialItems) > 0 {
+		if index := firstAccepted(list.serialItems[serial], accept); index != -1 && (best == -1 || index < best) {
+			best, bestIdentifier = index, ""
+		}
+	}
+	if best == -1 {
+		return false, WatchItem{}, ""
+	}
+	return true, list.items[best], bestIdentifier
+}
+
+func (list *WatchList) indexedMatch(dnsName string, labels []string, accept func(int) bool) int {
+	best := -1
+	if list.suffix != nil {
+		best = list.suffix.lookup(labels, accept)
+	}
+	if index := firstAccepted(list.exact[dnsName], accept); index != -1 && (best == -1 || index < best) {
+		best = index
+	}
+	return best
+}
+
+func (list *WatchList) linearMatch(labels []string, accept func(int) bool) int {
+	for i, item := range list.items {
+		if item.Type() == "dns_name" && item.matchesDNSName(labels) && (accept == nil || accept(i)) {
+			return i
 		}
 	}
-	return false, WatchItem{}
+	return -1
 }
@@ -0,0 +1,507 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func mustReadWatchList(t testing.TB, lines ...string) *WatchList {
+	list, err := ReadWatchList(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("ReadWatchList failed: %s", err)
+	}
+	return list
+}
+
+type watchListTest struct {
+	dnsNames []string
+	matched  bool
+	item     string
+}
+
+var watchListTests = []watchListTest{
+	{[]string{"example.com"}, true, "example.com"},
+	{[]string{"www.example.com"}, true, ".www.example.com"},
+	{[]string{"a.b.www.example.com"}, true, ".www.example.com"},
+	{[]string{"example.net"}, false, ""},
+	{[]string{"www.example.org"}, false, ""},
+	{[]string{"example.org"}, true, "example.org"},
+	{[]string{"sub.example.org"}, true, ".sub.example.org"},
+	{[]string{"*.example.com"}, true, ".www.example.com"},
+	{[]string{"*.example.org"}, true, ".sub.example.org"},
+	{[]string{"?.example.net"}, false, ""},
+	{[]string{"www.*.com"}, true, ".www.example.com"},
+	{[]string{"example.net", "sub.example.org", "example.com"}, true, "example.com"},
+}
+
+func TestWatchListMatches(t *testing.T) {
+	list := mustReadWatchList(t, "example.com", ".www.example.com", "# comment", "", "example.org", ".sub.example.org")
+	for i, test := range watchListTests {
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: test.dnsNames}, &certspotter.CertInfo{})
+		if matched != test.matched {
+			t.Errorf("#%d: Matches(%v) = %v, want %v", i, test.dnsNames, matched, test.matched)
+		} else if matched && item.String() != test.item {
+			t.Errorf("#%d: Matches(%v) matched %q, want %q", i, test.dnsNames, item, test.item)
+		}
+	}
+}
+
+// Wildcard DNS names match any watched name that they could cover, including
+// an exact (non-suffix) item one label below the wildcard.
+func TestWatchListWildcardCoversWatchedName(t *testing.T) {
+	list := mustReadWatchList(t, "foo.example.com", "a.b.example.net")
+	tests := []struct {
+		dnsName string
+		matched bool
+	}{
+		{"*.example.com", true},
+		{"f*.example.com", true},
+		{"b*.example.com", false},
+		{"*.*.com", true},
+		{"*.com", false}, // a wildcard covers only one label
+		{"*.foo.example.com", false},
+		{"*.b.example.net", true},
+		{"*.*.example.net", true},
+		{"*.example.net", false},
+		{"*.*", false},
+		{"*.*.*", true},
+	}
+	for _, test := range tests {
+		matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{test.dnsName}}, &certspotter.CertInfo{})
+		if matched != test.matched {
+			t.Errorf("Matches(%q) = %v, want %v", test.dnsName, matched, test.matched)
+		}
+	}
+}
+
+func TestWatchListWildcardItem(t *testing.T) {
+	list := mustReadWatchList(t, "*.example.com", "!*.dev.example.com")
+	tests := []struct {
+		dnsName string
+		matched bool
+	}{
+		{"*.example.com", true},
+		{"f*.example.com", true},
+		{"*.sub.example.com", true},
+		{"?.example.com", true},
+		{"*.dev.example.com", false}, // excluded
+		{"f*.dev.example.com", false},
+		{"?.dev.example.com", true}, // a redacted label isn't covered by the exclusion
+		{"example.com", false},
+		{"www.example.com", false},
+		{"www.sub.example.com", false},
+		{"*.example.net", false},
+		{"*.com", false},
+	}
+	for _, test := range tests {
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: []string{test.dnsName}}, &certspotter.CertInfo{})
+		if matched != test.matched {
+			t.Errorf("Matches(%q) = %v, want %v", test.dnsName, matched, test.matched)
+		} else if matched && item.String() != "*.example.com" {
+			t.Errorf("Matches(%q) matched %q, want *.example.com", test.dnsName, item)
+		}
+	}
+}
+
+func TestWatchListIndexAgreesWithLinearScan(t *testing.T) {
+	list := mustReadWatchList(t, ".example.com", "www.example.com", ".", "example.net")
+	for _, dnsName := range []string{"www.example.com", "example.com", "foo.example.net", "example.net"} {
+		labels := strings.Split(dnsName, ".")
+		if indexed, linear := list.indexedMatch(dnsName, labels, nil), list.linearMatch(labels, nil); indexed != linear {
+			t.Errorf("%s: indexed match %d disagrees with linear match %d", dnsName, indexed, linear)
+		}
+	}
+}
+
+func TestWatchListReplace(t *testing.T) {
+	list := mustReadWatchList(t, "example.com")
+	list.Replace(mustReadWatchList(t, ".example.org"))
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.com"}}, &certspotter.CertInfo{}); matched {
+		t.Errorf("replaced watch list still matches example.com")
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"www.example.org"}}, &certspotter.CertInfo{}); !matched {
+		t.Errorf("replaced watch list does not match www.example.org")
+	}
+}
+
+func makeCertInfo(issuerCN string, validity time.Duration) *certspotter.CertInfo {
+	notBefore := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return &certspotter.CertInfo{
+		Issuer: certspotter.RDNSequence{{{
+			Type:  asn1.ObjectIdentifier{2, 5, 4, 3},
+			Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(issuerCN)},
+		}}},
+		Validity: &certspotter.CertValidity{NotBefore: notBefore, NotAfter: notBefore.Add(validity)},
+	}
+}
+
+func TestWatchListConstraints(t *testing.T) {
+	list := mustReadWatchList(t, "example.com issuer!~digicert validity>90d", ".example.com issuer~DigiCert")
+	tests := []struct {
+		info    *certspotter.CertInfo
+		matched bool
+		item    int
+	}{
+		{makeCertInfo("Let's Encrypt R3", 365*24*time.Hour), true, 0},
+		{makeCertInfo("Let's Encrypt R3", 90*24*time.Hour), false, -1},
+		{makeCertInfo("DigiCert TLS RSA SHA256 2020 CA1", 365*24*time.Hour), true, 1},
+		{&certspotter.CertInfo{IssuerParseError: fmt.Errorf("bad issuer"), ValidityParseError: fmt.Errorf("bad validity")}, true, 0},
+	}
+	for i, test := range tests {
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.com"}}, test.info)
+		if matched != test.matched {
+			t.Errorf("#%d: matched = %v, want %v", i, matched, test.matched)
+		} else if matched && !slices.Equal(item.Constraints(), list.Items()[test.item].Constraints()) {
+			t.Errorf("#%d: matched %s %v, want item %d", i, item, item.Constraints(), test.item)
+		}
+	}
+}
+
+func TestParseWatchItemConstraints(t *testing.T) {
+	for _, str := range []string{"example.com validity=90d", "example.com issuer>foo", "example.com issuer~", "example.com issuer=DigiCert", "example.com issuer~\"DigiCert", "example.com validity>ninety", "example.com foo=bar"} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+	item, err := ParseWatchItem("example.com issuer!~DigiCert validity>90d valid_at:2024-01-01")
+	if err != nil {
+		t.Fatalf("ParseWatchItem failed: %s", err)
+	}
+	if want := []string{"issuer!~DigiCert", "validity>90d"}; !slices.Equal(item.Constraints(), want) {
+		t.Errorf("Constraints() = %v, want %v", item.Constraints(), want)
+	}
+}
+
+func TestWatchConstraintSubstring(t *testing.T) {
+	list := mustReadWatchList(t, `example.com issuer~"let's encrypt r3" subject!~Test`)
+	tests := []struct {
+		info    *certspotter.CertInfo
+		matched bool
+	}{
+		{makeCertInfo("Let's Encrypt R3", 90*24*time.Hour), true},
+		{makeCertInfo("Not Let's Encrypt R3 Either", 90*24*time.Hour), true}, // a substring of the DN
+		{makeCertInfo("Let's Encrypt R10", 90*24*time.Hour), false},
+		{&certspotter.CertInfo{IssuerParseError: fmt.Errorf("bad issuer"), SubjectParseError: fmt.Errorf("bad subject")}, true},
+	}
+	for i, test := range tests {
+		if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.com"}}, test.info); matched != test.matched {
+			t.Errorf("#%d: matched = %v, want %v", i, matched, test.matched)
+		}
+	}
+	if want := []string{`issuer~"let's encrypt r3"`, "subject!~Test"}; !slices.Equal(list.Items()[0].Constraints(), want) {
+		t.Errorf("Constraints() = %v, want %v", list.Items()[0].Constraints(), want)
+	}
+}
+
+func TestWatchListIssuers(t *testing.T) {
+	list := mustReadWatchList(t, "example.com", "issuer:CN=Some CA", "issuer: cn = some ca, O=Other")
+	tests := []struct {
+		dnsNames []string
+		issuerCN string
+		matched  bool
+		item     string
+	}{
+		{[]string{"example.com"}, "Some CA", true, "example.com"},
+		{[]string{"www.example.org"}, "Some CA", true, "issuer:CN=Some CA"},
+		{[]string{"www.example.org"}, "some ca", true, "issuer:CN=Some CA"},
+		{nil, "Some CA", true, "issuer:CN=Some CA"},
+		{[]string{"www.example.org"}, "Some CA 2", false, ""},
+	}
+	for i, test := range tests {
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: test.dnsNames}, makeCertInfo(test.issuerCN, 90*24*time.Hour))
+		if matched != test.matched {
+			t.Errorf("#%d: matched = %v, want %v", i, matched, test.matched)
+		} else if matched && item.String() != test.item {
+			t.Errorf("#%d: matched %q, want %q", i, item, test.item)
+		}
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{}, &certspotter.CertInfo{IssuerParseError: fmt.Errorf("bad issuer")}); !matched {
+		t.Errorf("unparsable issuer did not match issuer item")
+	}
+	for _, str := range []string{"issuer:", "issuer:Some CA", "issuer:CN=", "issuer:CN=Some CA,"} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+}
+
+func TestWatchListExclusions(t *testing.T) {
+	list := mustReadWatchList(t, "!.dev.example.com", ".example.com", "!api.example.com", "!.test.example.com issuer~Test")
+	tests := []struct {
+		dnsNames []string
+		matched  bool
+	}{
+		{[]string{"www.example.com"}, true},
+		{[]string{"dev.example.com"}, false},
+		{[]string{"foo.dev.example.com"}, false},
+		{[]string{"*.dev.example.com"}, false},
+		{[]string{"*.foo.dev.example.com"}, false},
+		{[]string{"foo.dev.example.com", "*.dev.example.com"}, false},
+		{[]string{"foo.dev.example.com", "www.example.com"}, true},
+		{[]string{"foo.dev.example.com", "www.example.net"}, false},
+		{[]string{"*.example.com"}, true}, // might be for a name which isn't excluded
+		{[]string{"*.com"}, true},
+		{[]string{"dev.*.com"}, true},
+		{[]string{"?.dev.example.com"}, false},
+		{[]string{"api.example.com"}, false},
+		{[]string{"www.api.example.com"}, true},
+		{[]string{"foo.test.example.com"}, true}, // the exclusion's constraint is not satisfied
+	}
+	for i, test := range tests {
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: test.dnsNames}, makeCertInfo("Some CA", 90*24*time.Hour))
+		if matched != test.matched {
+			t.Errorf("#%d: Matches(%v) = %v, want %v", i, test.dnsNames, matched, test.matched)
+		} else if matched && item.String() != ".example.com" {
+			t.Errorf("#%d: Matches(%v) matched %q", i, test.dnsNames, item)
+		}
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"foo.test.example.com"}}, makeCertInfo("Test CA", 90*24*time.Hour)); matched {
+		t.Errorf("foo.test.example.com issued by Test CA was not excluded")
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"foo.test.example.com"}}, &certspotter.CertInfo{IssuerParseError: fmt.Errorf("bad issuer")}); !matched {
+		t.Errorf("foo.test.example.com with an unparsable issuer was excluded")
+	}
+	if n := len(list.Items()); n != 1 {
+		t.Errorf("len(Items()) = %d, want 1", n)
+	}
+	for _, str := range []string{"!", "!!example.com", "!issuer:CN=Some CA"} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+}
+
+func TestWatchListIPAddresses(t *testing.T) {
+	list := mustReadWatchList(t, "example.com", "192.0.2.0/24", "2001:db8::/32", "198.51.100.7", "2001:db8:1::1 issuer~Some")
+	tests := []struct {
+		dnsNames []string
+		ipAddrs  []string
+		matched  bool
+		item     string
+	}{
+		{nil, []string{"192.0.2.55"}, true, "192.0.2.0/24"},
+		{nil, []string{"192.0.3.1"}, false, ""},
+		{nil, []string{"198.51.100.7"}, true, "198.51.100.7"},
+		{nil, []string{"198.51.100.8"}, false, ""},
+		{nil, []string{"::ffff:192.0.2.1"}, true, "192.0.2.0/24"},
+		{nil, []string{"2001:db8:ffff::1"}, true, "2001:db8::/32"},
+		{nil, []string{"2001:db9::1"}, false, ""},
+		{[]string{"www.example.net"}, []string{"192.0.2.1"}, true, "192.0.2.0/24"},
+		{[]string{"example.com"}, []string{"192.0.2.1"}, true, "example.com"},
+		{[]string{"www.example.net"}, []string{"203.0.113.1"}, false, ""},
+	}
+	for i, test := range tests {
+		identifiers := &certspotter.Identifiers{DNSNames: test.dnsNames}
+		for _, ipaddr := range test.ipAddrs {
+			identifiers.IPAddrs = append(identifiers.IPAddrs, net.ParseIP(ipaddr))
+		}
+		matched, item := list.Matches(identifiers, makeCertInfo("Other CA", 90*24*time.Hour))
+		if matched != test.matched {
+			t.Errorf("#%d: Matches(%v, %v) = %v, want %v", i, test.dnsNames, test.ipAddrs, matched, test.matched)
+		} else if matched && item.String() != test.item {
+			t.Errorf("#%d: Matches(%v, %v) matched %q, want %q", i, test.dnsNames, test.ipAddrs, item, test.item)
+		}
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"2001.db8.1..1"}}, &certspotter.CertInfo{}); matched {
+		t.Errorf("IP address item matched a DNS name")
+	}
+	if matched, item := list.Matches(&certspotter.Identifiers{IPAddrs: []net.IP{net.ParseIP("2001:db8:1::1")}}, makeCertInfo("Some CA", 90*24*time.Hour)); !matched || item.String() != "2001:db8::/32" {
+		t.Errorf("Matches(2001:db8:1::1) = %v, %q, want 2001:db8::/32", matched, item)
+	}
+	for _, str := range []string{"192.0.2.0/33", "!192.0.2.0/24", "fe80::1%eth0"} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+}
+
+func TestWatchListSPKIItems(t *testing.T) {
+	info := makeCertInfo("Some CA", 90*24*time.Hour)
+	info.TBS = &certspotter.TBSCertificate{PublicKey: asn1.RawValue{FullBytes: []byte("public key")}}
+	hash := sha256.Sum256([]byte("public key"))
+	hexHash := hex.EncodeToString(hash[:])
+
+	for _, encoded := range []string{hexHash, base64.StdEncoding.EncodeToString(hash[:]), base64.RawURLEncoding.EncodeToString(hash[:])} {
+		list := mustReadWatchList(t, "example.com", "spki:"+encoded)
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.net"}}, info)
+		if !matched || item.String() != "spki:"+hexHash || item.Type() != "spki" {
+			t.Errorf("spki:%s: Matches = %v, %q, want spki:%s", encoded, matched, item, hexHash)
+		}
+	}
+
+	list := mustReadWatchList(t, "spki:"+strings.Repeat("00", 32))
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.net"}}, info); matched {
+		t.Errorf("Matches unexpectedly matched a different key")
+	}
+	for _, str := range []string{"spki:", "spki:abcd", "!spki:" + hexHash} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+}
+
+func TestWatchListSerialItems(t *testing.T) {
+	info := makeCertInfo("Some CA", 90*24*time.Hour)
+	info.SerialNumber = big.NewInt(0x049f0a)
+
+	for _, str := range []string{"serial:49f0a", "serial:00049F0A", "serial:04:9f:0a"} {
+		list := mustReadWatchList(t, "example.com", str)
+		matched, item := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.net"}}, info)
+		if !matched || item.String() != "serial:49f0a" || item.Type() != "serial" {
+			t.Errorf("%s: Matches = %v, %q, want serial:49f0a", str, matched, item)
+		}
+	}
+
+	list := mustReadWatchList(t, "serial:49f0b")
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"example.net"}}, info); matched {
+		t.Errorf("Matches unexpectedly matched a different serial number")
+	}
+	info.SerialNumber, info.SerialNumberParseError = nil, errors.New("unparsable")
+	if matched, _ := mustReadWatchList(t, "serial:49f0a").Matches(&certspotter.Identifiers{}, info); matched {
+		t.Errorf("Matches unexpectedly matched an unparsable serial number")
+	}
+	for _, str := range []string{"serial:", "serial:xyz", "serial:-1", "!serial:49f0a"} {
+		if _, err := ParseWatchItem(str); err == nil {
+			t.Errorf("ParseWatchItem(%q) unexpectedly succeeded", str)
+		}
+	}
+}
+
+func TestWatchListMatchIdentifier(t *testing.T) {
+	list := mustReadWatchList(t, ".example.com", "!dev.example.com", "192.0.2.0/24", "issuer:CN=Some CA")
+	info := makeCertInfo("Other CA", 90*24*time.Hour)
+	tests := []struct {
+		identifiers certspotter.Identifiers
+		want        string
+	}{
+		{certspotter.Identifiers{DNSNames: []string{"example.net", "dev.example.com", "www.example.com", "example.com"}}, "www.example.com"},
+		{certspotter.Identifiers{DNSNames: []string{"example.net"}, IPAddrs: []net.IP{net.ParseIP("192.0.2.7")}}, "192.0.2.7"},
+	}
+	for _, test := range tests {
+		if matched, _, identifier := list.Match(&test.identifiers, info); !matched || identifier != test.want {
+			t.Errorf("Match(%v) = %v, %q, want %q", test.identifiers, matched, identifier, test.want)
+		}
+	}
+	if matched, item, identifier := list.Match(&certspotter.Identifiers{DNSNames: []string{"example.net"}}, makeCertInfo("Some CA", 90*24*time.Hour)); !matched || item.Type() != "issuer" || identifier != "" {
+		t.Errorf("Match by issuer = %v, %q, %q, want issuer item and no identifier", matched, item, identifier)
+	}
+}
+
+func TestWatchListIDN(t *testing.T) {
+	for _, str := range []string{"bücher.example", "BÜCHER.example", "bu\u0308cher.example", "bücher\u3002example", "xn--bcher-kva.example", "XN--BCHER-KVA.example"} {
+		item, err := ParseWatchItem(str)
+		if err != nil {
+			t.Errorf("ParseWatchItem(%q) failed: %s", str, err)
+			continue
+		} else if item.String() != "xn--bcher-kva.example" {
+			t.Errorf("ParseWatchItem(%q) = %q, want xn--bcher-kva.example", str, item)
+		}
+		list := NewWatchList([]WatchItem{item})
+		for _, san := range []string{"xn--bcher-kva.example", "bücher.example", "bu\u0308cher.example"} {
+			var identifiers certspotter.Identifiers
+			identifiers.AddDnsSAN([]byte(san))
+			if matched, _ := list.Matches(&identifiers, &certspotter.CertInfo{}); !matched {
+				t.Errorf("%q does not match SAN %q (%q)", str, san, identifiers.DNSNames)
+			}
+		}
+	}
+
+	// Names with underscores are converted without IDNA mapping
+	if item, err := ParseWatchItem("_dmarc.bücher.example"); err != nil {
+		t.Errorf("ParseWatchItem(%q) failed: %s", "_dmarc.bücher.example", err)
+	} else if want := "_dmarc.xn--bcher-kva.example"; item.String() != want {
+		t.Errorf("ParseWatchItem(%q) = %q, want %q", "_dmarc.bücher.example", item, want)
+	}
+
+	// Invalid Punycode is rejected, rather than never matching anything
+	if item, err := ParseWatchItem("xn--zz.example"); err == nil {
+		t.Errorf("ParseWatchItem(%q) = %q; expected an error", "xn--zz.example", item)
+	}
+}
+
+func TestMergeWatchLists(t *testing.T) {
+	list := MergeWatchLists(
+		mustReadWatchList(t, ".example.com", "!.dev.example.com", "example.org issuer!~DigiCert"),
+		mustReadWatchList(t, ".example.com", "example.org", "example.org issuer!~DigiCert", "!.dev.example.com"),
+	)
+	var items []string
+	for _, item := range list.Items() {
+		items = append(items, strings.Join(append([]string{item.String()}, item.Constraints()...), " "))
+	}
+	if want := []string{".example.com", "example.org issuer!~DigiCert", "example.org"}; !slices.Equal(items, want) {
+		t.Errorf("MergeWatchLists items = %q, want %q", items, want)
+	}
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"www.dev.example.com"}}, &certspotter.CertInfo{}); matched {
+		t.Errorf("MergeWatchLists did not keep exclusion")
+	}
+}
+
+func makeLargeWatchList(b *testing.B, size int) *WatchList {
+	lines := make([]string, size)
+	for i := range lines {
+		if i%2 == 0 {
+			lines[i] = fmt.Sprintf("host%d.example.com", i)
+		} else {
+			lines[i] = fmt.Sprintf(".sub%d.example.org", i)
+		}
+	}
+	return mustReadWatchList(b, lines...)
+}
+
+func BenchmarkWatchListMatches(b *testing.B) {
+	for _, size := range []int{100, 50000} {
+		list := makeLargeWatchList(b, size)
+		dnsName := "www.not-watched.example.net"
+		labels := strings.Split(dnsName, ".")
+
+		b.Run(fmt.Sprintf("linear/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				list.linearMatch(labels, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("indexed/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				list.indexedMatch(dnsName, labels, nil)
+			}
+		})
+	}
+}
+
+func TestNilWatchList(t *testing.T) {
+	var list *WatchList
+	if matched, _ := list.Matches(&certspotter.Identifiers{DNSNames: []string{"www.example.com"}}, nil); matched {
+		t.Errorf("nil watch list matched")
+	}
+	if items := list.Items(); len(items) != 0 {
+		t.Errorf("nil watch list has items %v", items)
+	}
+	item, err := ParseWatchItem(".example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := MergeWatchLists(list, NewWatchList([]WatchItem{item}))
+	if items := merged.Items(); len(items) != 1 || items[0].String() != ".example.com" {
+		t.Errorf("merging a nil watch list returned %v", items)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const serialItemPrefix = "serial:"
+
+// parseSerialItem parses the part of a serial number watch item after
+// "serial:", which is a serial number in hex, optionally with colons between
+// bytes (e.g. "04:9f:0a" as printed by openssl).
+func parseSerialItem(str string) (WatchItem, error) {
+	digits := strings.ReplaceAll(strings.TrimSpace(str), ":", "")
+	serial, ok := new(big.Int).SetString(digits, 16)
+	if !ok || digits == "" || digits[0] == '-' || digits[0] == '+' {
+		return WatchItem{}, fmt.Errorf("invalid serial number %q: must be hex", str)
+	}
+	return WatchItem{serial: serialString(serial)}, nil
+}
+
+func (item WatchItem) serialString() string {
+	return serialItemPrefix + item.serial
+}
+
+// serialString formats serial the same way as the SERIAL script variable:
+// lowercase hex without leading zeros.
+func serialString(serial *big.Int) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+// certSerial returns the certificate's serial number formatted by
+// serialString, or false if it wasn't parsed.
+func certSerial(info *certspotter.CertInfo) (string, bool) {
+	if info.SerialNumberParseError != nil || info.SerialNumber == nil {
+		return "", false
+	}
+	return serialString(info.SerialNumber), true
+}
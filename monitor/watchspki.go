@@ -0,0 +1,57 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const spkiItemPrefix = "spki:"
+
+// parseSPKIItem parses the part of an SPKI watch item after "spki:", which is
+// the SHA-256 hash of a DER-encoded SubjectPublicKeyInfo, encoded as hex or
+// base64 (standard or URL-safe, with or without padding).
+func parseSPKIItem(str string) (WatchItem, error) {
+	str = strings.TrimSpace(str)
+	decoders := []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	for _, decode := range decoders {
+		if hash, err := decode(str); err == nil && len(hash) == sha256.Size {
+			item := WatchItem{spki: new([32]byte)}
+			copy(item.spki[:], hash)
+			return item, nil
+		}
+	}
+	return WatchItem{}, fmt.Errorf("invalid SPKI hash %q: must be a hex or base64 SHA-256 hash", str)
+}
+
+func (item WatchItem) spkiString() string {
+	return spkiItemPrefix + hex.EncodeToString(item.spki[:])
+}
+
+// spkiSHA256 returns the SHA-256 hash of the certificate's SubjectPublicKeyInfo,
+// or false if the TBSCertificate wasn't parsed.
+func spkiSHA256(info *certspotter.CertInfo) ([32]byte, bool) {
+	if info.TBS == nil {
+		return [32]byte{}, false
+	}
+	return sha256.Sum256(info.TBS.PublicKey.FullBytes), true
+}
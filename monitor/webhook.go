@@ -0,0 +1,198 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	webhookTimeout    = 30 * time.Second
+	webhookMaxRetries = 4
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout, Transport: newNotificationTransport(nil)}
+
+type webhookPayload struct {
+	Event   string         `json:"event"`
+	Summary string         `json:"summary"`
+	Text    string         `json:"text"`
+	Fields  map[string]any `json:"fields"`
+}
+
+func makeWebhookPayload(notif *notification) *webhookPayload {
+	payload := &webhookPayload{
+		Event:   notif.event(),
+		Summary: notif.summary,
+		Text:    notif.text,
+	}
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range notif.json {
+		field.AddTo(encoder)
+	}
+	payload.Fields = encoder.Fields
+	return payload
+}
+
+// sendWebhook POSTs the notification as JSON to s.Webhook.
+func sendWebhook(ctx context.Context, s *FilesystemState, notif *notification) error {
+	body, err := json.Marshal(makeWebhookPayload(notif))
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+	header := make(http.Header)
+	if s.WebhookContentType != "" {
+		header.Set("Content-Type", s.WebhookContentType)
+	}
+	if s.WebhookToken != "" {
+		header.Set("Authorization", "Bearer "+s.WebhookToken)
+	}
+	if err := sendWithRetries(ctx, s.getWebhookClient(), http.MethodPost, s.Webhook, body, header); err != nil {
+		return fmt.Errorf("error sending webhook to %s: %w", redactURL(s.Webhook), err)
+	}
+	return nil
+}
+
+// getWebhookClient returns the client used to send to s.Webhook, which
+// uses s.WebhookTLS if it is set.
+func (s *FilesystemState) getWebhookClient() *http.Client {
+	if s.WebhookTLS == nil {
+		return webhookClient
+	}
+	s.webhookClientOnce.Do(func() {
+		var serverName string
+		if webhookURL, err := url.Parse(s.Webhook); err == nil {
+			serverName = webhookURL.Hostname()
+		}
+		s.webhookClient = &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: newNotificationTransport(s.WebhookTLS.tlsConfig(serverName)),
+		}
+	})
+	return s.webhookClient
+}
+
+// ReloadWebhookTLS reloads s.WebhookTLS from its files.  If they can't be
+// loaded, the previous credentials remain in use.  Idle connections to the
+// webhook server are closed so that subsequent requests use the new
+// credentials; requests in progress are unaffected.
+func (s *FilesystemState) ReloadWebhookTLS() error {
+	if s.WebhookTLS == nil {
+		return nil
+	}
+	if err := s.WebhookTLS.Reload(); err != nil {
+		return err
+	}
+	s.getWebhookClient().CloseIdleConnections()
+	return nil
+}
+
+// postWithRetries POSTs body to url, retrying with exponential backoff if the
+// server returns a 5xx status or the request fails.  If the server rate
+// limits the request with a 429 status, it retries after the delay requested
+// by the server.  The Content-Type defaults to application/json.
+func postWithRetries(ctx context.Context, url string, body []byte, header http.Header) error {
+	return sendWithRetries(ctx, webhookClient, http.MethodPost, url, body, header)
+}
+
+// sendWithRetries is like postWithRetries, but sends the request using the
+// given client and method.
+func sendWithRetries(ctx context.Context, client *http.Client, method string, url string, body []byte, header http.Header) error {
+	backoff := 1 * time.Second
+	for numRetries := 0; ; numRetries++ {
+		retryAfter, retryable, err := send(ctx, client, method, url, body, header)
+		if err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if !retryable || numRetries == webhookMaxRetries {
+			return err
+		}
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// getRetryAfter returns how long a server which responded with a 429 status
+// wants us to wait, as specified by the retry_after field of a JSON body (as
+// sent by Discord) or of its parameters object (as sent by Telegram), the
+// retry_after_ms field (as sent by Matrix), or else by the Retry-After header.
+// It returns 0 if none are present.
+func getRetryAfter(response *http.Response, responseBody []byte) time.Duration {
+	var rateLimit struct {
+		RetryAfter   float64 `json:"retry_after"`    // seconds
+		RetryAfterMS int64   `json:"retry_after_ms"` // milliseconds
+		Parameters   struct {
+			RetryAfter float64 `json:"retry_after"` // seconds
+		} `json:"parameters"`
+	}
+	if json.Unmarshal(responseBody, &rateLimit) == nil {
+		if rateLimit.RetryAfter > 0 {
+			return time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		} else if rateLimit.Parameters.RetryAfter > 0 {
+			return time.Duration(rateLimit.Parameters.RetryAfter * float64(time.Second))
+		} else if rateLimit.RetryAfterMS > 0 {
+			return time.Duration(rateLimit.RetryAfterMS) * time.Millisecond
+		}
+	}
+	if seconds, err := strconv.ParseUint(response.Header.Get("Retry-After"), 10, 16); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+func send(ctx context.Context, client *http.Client, method string, url string, body []byte, header http.Header) (retryAfter time.Duration, retryable bool, err error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("User-Agent", "certspotter")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, true, err
+	}
+	defer response.Body.Close()
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return 0, false, nil
+	}
+	err = fmt.Errorf("server returned %s: %q", response.Status, strings.TrimSpace(string(responseBody)))
+	if response.StatusCode == http.StatusTooManyRequests {
+		return getRetryAfter(response, responseBody), true, err
+	}
+	return 0, response.StatusCode >= 500, err
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package monitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTestClientCertificate(t *testing.T, commonName string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeTestClientCertificate writes cert as PEM-encoded certificate and key
+// files named cert.pem and key.pem in dir.
+func writeTestClientCertificate(t *testing.T, dir string, cert *tls.Certificate) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookClientCertificate(t *testing.T) {
+	clientNames := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientNames <- r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestClientCertificate(t, dir, makeTestClientCertificate(t, "certspotter client"))
+	creds, err := LoadTLSCredentials(certFile, keyFile, caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &FilesystemState{Webhook: server.URL, WebhookTLS: creds}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	send := func(want string) {
+		t.Helper()
+		if err := sendWebhook(ctx, s, makeTestCertNotification(t)); err != nil {
+			t.Fatal(err)
+		}
+		if name := <-clientNames; name != want {
+			t.Errorf("server received client certificate for %q; expected %q", name, want)
+		}
+	}
+	send("certspotter client")
+	if s.getWebhookClient() == webhookClient {
+		t.Errorf("webhook with a client certificate uses the shared client")
+	}
+
+	// Rotate the certificate, as a short-lived certificate would be
+	writeTestClientCertificate(t, dir, makeTestClientCertificate(t, "rotated client"))
+	if err := s.ReloadWebhookTLS(); err != nil {
+		t.Fatal(err)
+	}
+	send("rotated client")
+
+	// A failed reload keeps the previous credentials
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadWebhookTLS(); err == nil {
+		t.Errorf("reloading a malformed key succeeded")
+	}
+	send("rotated client")
+
+	if (&FilesystemState{Webhook: server.URL}).getWebhookClient() != webhookClient {
+		t.Errorf("webhook without a client certificate doesn't use the shared client")
+	}
+}
+
+func TestWebhookCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	otherCA := makeTestClientCertificate(t, "other CA")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := LoadTLSCredentials("", "", caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &FilesystemState{Webhook: server.URL, WebhookTLS: creds}
+
+	// Use the client directly, since sendWebhook retries failed requests
+	get := func() error {
+		resp, err := s.getWebhookClient().Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(); err == nil {
+		t.Errorf("webhook server was trusted without being in the CA file")
+	}
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadWebhookTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(); err != nil {
+		t.Errorf("webhook server in the reloaded CA file wasn't trusted: %s", err)
+	}
+}
+
+func TestWebhookPin(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	serverPin := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	otherPin := sha256.Sum256([]byte("some other key"))
+	tests := []struct {
+		pins [][32]byte
+		ok   bool
+	}{
+		{nil, true},
+		{[][32]byte{serverPin}, true},
+		{[][32]byte{otherPin, serverPin}, true},
+		{[][32]byte{otherPin}, false},
+	}
+	for _, test := range tests {
+		creds, err := LoadTLSCredentials("", "", caFile, test.pins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &FilesystemState{Webhook: server.URL, WebhookTLS: creds}
+		resp, err := s.getWebhookClient().Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("pins %x: request returned %v; expected ok=%v", test.pins, err, test.ok)
+		}
+	}
+}
+
+func TestWebhookTLSServerName(t *testing.T) {
+	// The certificate of httptest servers is valid for example.com
+	// and 127.0.0.1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := LoadTLSCredentials("", "", caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		serverName string // as set by http.Transport for the host of each request, including after a redirect
+		ok         bool
+	}{
+		{"", true}, // the host is an IP address, so the webhook's host is used
+		{"example.com", true},
+		{"other.example", false},
+	}
+	for _, test := range tests {
+		config := creds.tlsConfig("127.0.0.1")
+		config.ServerName = test.serverName
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("server name %q: connection returned %v; expected ok=%v", test.serverName, err, test.ok)
+		}
+	}
+}
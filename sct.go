@@ -10,9 +10,50 @@
 package certspotter
 
 import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
 	"software.sslmate.com/src/certspotter/ct"
 )
 
+// ParseSCTList returns the SCTs embedded in the certificate's
+// SignedCertificateTimestampList extension (RFC 6962 Section 3.3).  Note
+// that ReconstructPrecertTBS removes this extension.
+func (tbs *TBSCertificate) ParseSCTList() ([]*ct.SignedCertificateTimestamp, error) {
+	scts := []*ct.SignedCertificateTimestamp{}
+	for _, ext := range tbs.GetExtension(oidExtensionSCT) {
+		var listBytes []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &listBytes); err != nil {
+			return nil, errors.New("failed to parse SCT list extension: " + err.Error())
+		} else if len(rest) > 0 {
+			return nil, fmt.Errorf("trailing data after SCT list extension: %v", rest)
+		}
+		if len(listBytes) < 2 || int(binary.BigEndian.Uint16(listBytes)) != len(listBytes)-2 {
+			return nil, errors.New("SCT list has invalid length")
+		}
+		listBytes = listBytes[2:]
+		for len(listBytes) > 0 {
+			if len(listBytes) < 2 {
+				return nil, errors.New("SCT list is truncated")
+			}
+			sctLen := int(binary.BigEndian.Uint16(listBytes))
+			if len(listBytes) < 2+sctLen {
+				return nil, errors.New("SCT list is truncated")
+			}
+			sct, err := ct.DeserializeSCT(bytes.NewReader(listBytes[2 : 2+sctLen]))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse SCT: %w", err)
+			}
+			scts = append(scts, sct)
+			listBytes = listBytes[2+sctLen:]
+		}
+	}
+	return scts, nil
+}
+
 func VerifyX509SCT(sct *ct.SignedCertificateTimestamp, cert []byte, verify *ct.SignatureVerifier) error {
 	entry := ct.LogEntry{
 		Leaf: ct.MerkleTreeLeaf{
@@ -0,0 +1,96 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func makeTestSCT(t *testing.T, logID byte, timestamp uint64) []byte {
+	t.Helper()
+	sct, err := ct.SerializeSCT(ct.SignedCertificateTimestamp{
+		SCTVersion: ct.V1,
+		LogID:      ct.SHA256Hash{logID},
+		Timestamp:  timestamp,
+		Signature:  ct.DigitallySigned{HashAlgorithm: ct.SHA256, SignatureAlgorithm: ct.ECDSA, Signature: []byte{1, 2, 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sct
+}
+
+// makeSCTListExtension returns an SCT list extension whose list contains
+// the given serialized SCTs, with the list's length adjusted by lengthDelta.
+func makeSCTListExtension(t *testing.T, lengthDelta int, scts ...[]byte) pkix.Extension {
+	t.Helper()
+	var list []byte
+	for _, sct := range scts {
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append(binary.BigEndian.AppendUint16(nil, uint16(len(list)+lengthDelta)), list...)
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidExtensionSCT, Value: value}
+}
+
+func TestParseSCTList(t *testing.T) {
+	sct1 := makeTestSCT(t, 1, 1700000000000)
+	sct2 := makeTestSCT(t, 2, 1700000001000)
+
+	tbs := makeTestTBS(t, &x509.Certificate{}, makeSCTListExtension(t, 0, sct1, sct2))
+	scts, err := tbs.ParseSCTList()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(scts) != 2 {
+		t.Fatalf("got %d SCTs, want 2", len(scts))
+	}
+	for i, want := range []struct {
+		logID     byte
+		timestamp uint64
+	}{{1, 1700000000000}, {2, 1700000001000}} {
+		if scts[i].LogID != (ct.SHA256Hash{want.logID}) || scts[i].Timestamp != want.timestamp {
+			t.Errorf("SCT %d: got log ID %x and timestamp %d", i, scts[i].LogID, scts[i].Timestamp)
+		}
+	}
+
+	// The SCT list is removed when reconstructing the precertificate
+	// TBSCertificate, so it must be parsed from the original
+	precertTBS, err := ReconstructPrecertTBS(tbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scts, err := precertTBS.ParseSCTList(); err != nil || len(scts) != 0 {
+		t.Errorf("reconstructed precert TBS: got %d SCTs and error %v, want none", len(scts), err)
+	}
+
+	if scts, err := makeTestTBS(t, &x509.Certificate{}).ParseSCTList(); err != nil || len(scts) != 0 {
+		t.Errorf("no extension: got %d SCTs and error %v, want none", len(scts), err)
+	}
+
+	for name, ext := range map[string]pkix.Extension{
+		"wrong list length":   makeSCTListExtension(t, 1, sct1),
+		"truncated SCT":       makeSCTListExtension(t, 0, sct1[:len(sct1)-1]),
+		"not an OCTET STRING": {Id: oidExtensionSCT, Value: []byte{0x05, 0x00}},
+	} {
+		if scts, err := makeTestTBS(t, &x509.Certificate{}, ext).ParseSCTList(); err == nil {
+			t.Errorf("%s: got %d SCTs, want error", name, len(scts))
+		}
+	}
+}
@@ -17,12 +17,14 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"strings"
 	"time"
 )
 
 var (
 	oidExtensionSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
 	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionCRLDistPoints    = asn1.ObjectIdentifier{2, 5, 29, 31}
 	oidCountry                   = asn1.ObjectIdentifier{2, 5, 4, 6}
 	oidOrganization              = asn1.ObjectIdentifier{2, 5, 4, 10}
 	oidOrganizationalUnit        = asn1.ObjectIdentifier{2, 5, 4, 11}
@@ -131,6 +133,27 @@ func (rdns RDNSequence) ParseCNs() ([]string, error) {
 	return cns, nil
 }
 
+// ParseAttributes returns the values of every attribute whose label
+// (e.g. "CN", "O", "OU", as used by String) equals label, ignoring case.
+func (rdns RDNSequence) ParseAttributes(label string) ([]string, error) {
+	var values []string
+
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if !strings.EqualFold(rdnLabel(atv.Type), label) {
+				continue
+			}
+			value, err := decodeASN1String(&atv.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Error decoding %s: %s", label, err)
+			}
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
 func rdnLabel(oid asn1.ObjectIdentifier) string {
 	switch {
 	case oid.Equal(oidCountry):
@@ -329,6 +352,39 @@ func (tbs *TBSCertificate) ParseSubjectAltNames() ([]SubjectAltName, error) {
 	return sans, nil
 }
 
+// ParseCRLDistributionPoints returns the URIs listed in the certificate's
+// CRL Distribution Points extension.  Distribution points which are not URIs
+// are ignored.
+func (tbs *TBSCertificate) ParseCRLDistributionPoints() ([]string, error) {
+	type distributionPointName struct {
+		FullName     []asn1.RawValue `asn1:"optional,tag:0"`
+		RelativeName asn1.RawValue   `asn1:"optional,tag:1"`
+	}
+	type distributionPoint struct {
+		DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+		Reason            asn1.BitString        `asn1:"optional,tag:1"`
+		CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+	}
+
+	uris := []string{}
+	for _, ext := range tbs.GetExtension(oidExtensionCRLDistPoints) {
+		var points []distributionPoint
+		if rest, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil, errors.New("failed to parse CRL Distribution Points: " + err.Error())
+		} else if len(rest) > 0 {
+			return nil, fmt.Errorf("trailing data after CRL Distribution Points: %v", rest)
+		}
+		for _, point := range points {
+			for _, name := range point.DistributionPoint.FullName {
+				if name.Class == asn1.ClassContextSpecific && name.Tag == sanURI {
+					uris = append(uris, string(name.Bytes))
+				}
+			}
+		}
+	}
+	return uris, nil
+}
+
 func (tbs *TBSCertificate) GetExtension(id asn1.ObjectIdentifier) []Extension {
 	var exts []Extension
 	for _, ext := range tbs.Extensions {
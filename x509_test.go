@@ -0,0 +1,71 @@
+// Copyright (C) 2024 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+// makeTestTBS returns the TBSCertificate of a self-signed certificate, with
+// the fields from template which are set, and the given extra extensions.
+func makeTestTBS(t *testing.T, template *x509.Certificate, extensions ...pkix.Extension) *TBSCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.Subject = pkix.Name{CommonName: "www.example.com"}
+	template.NotBefore = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	template.NotAfter = time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	template.ExtraExtensions = extensions
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := MakeCertInfoFromRawCert(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.TBS
+}
+
+func TestParseCRLDistributionPoints(t *testing.T) {
+	tests := []struct {
+		uris []string
+		want []string
+	}{
+		{nil, []string{}},
+		{[]string{"http://crl.example.com/ca.crl"}, []string{"http://crl.example.com/ca.crl"}},
+		{[]string{"http://crl.example.com/ca.crl", "ldap://ldap.example.com/cn=CA"}, []string{"http://crl.example.com/ca.crl", "ldap://ldap.example.com/cn=CA"}},
+	}
+	for _, test := range tests {
+		tbs := makeTestTBS(t, &x509.Certificate{CRLDistributionPoints: test.uris})
+		got, err := tbs.ParseCRLDistributionPoints()
+		if err != nil {
+			t.Errorf("%v: error: %s", test.uris, err)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.uris, got, test.want)
+		}
+	}
+
+	malformed := makeTestTBS(t, &x509.Certificate{}, pkix.Extension{Id: oidExtensionCRLDistPoints, Value: []byte{0x30, 0x05, 0x30}})
+	if uris, err := malformed.ParseCRLDistributionPoints(); err == nil {
+		t.Errorf("malformed extension: got %v, want error", uris)
+	}
+}